@@ -64,7 +64,7 @@ struct ProgramBlockView: View {
         HStack(spacing: 12) {
             // Poster thumbnail
             if let posterPath = item.posterPath {
-                AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w92\(posterPath)")) { image in
+                AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w92")) { image in
                     image.resizable().aspectRatio(contentMode: .fill)
                 } placeholder: {
                     Color.gray.opacity(0.3)
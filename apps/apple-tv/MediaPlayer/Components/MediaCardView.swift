@@ -27,7 +27,7 @@ struct MediaCardView: View {
             // Poster
             ZStack(alignment: .bottomLeading) {
                 if let posterPath = media.posterPath {
-                    AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w342\(posterPath)")) { phase in
+                    AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w342")) { phase in
                         switch phase {
                         case .success(let image):
                             image
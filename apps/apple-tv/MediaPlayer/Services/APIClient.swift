@@ -196,6 +196,17 @@ class APIClient: ObservableObject {
         return URL(string: "\(baseURL)/api/stream/\(mediaId)/direct?token=\(token)\(typeParam)")
     }
 
+    /// URL of an image at a TMDB size. The server stores cached images as
+    /// /api/images URLs; older items still hold bare TMDB paths.
+    func getImageURL(path: String, size: String) -> URL? {
+        if path.hasPrefix("/api/images/") {
+            guard let token = authToken else { return nil }
+            let file = (path as NSString).lastPathComponent
+            return URL(string: "\(baseURL)/api/images/\(size)/\(file)?token=\(token)")
+        }
+        return URL(string: "https://image.tmdb.org/t/p/\(size)\(path)")
+    }
+
     func getSubtitleURL(mediaId: Int64, language: String) -> URL? {
         guard let token = authToken else { return nil }
         return URL(string: "\(baseURL)/api/stream/\(mediaId)/subtitles/\(language).vtt?token=\(token)")
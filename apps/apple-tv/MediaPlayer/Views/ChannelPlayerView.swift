@@ -233,7 +233,7 @@ struct UpNextItemRow: View {
     var body: some View {
         HStack(spacing: 12) {
             if let posterPath = item.posterPath {
-                AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w92\(posterPath)")) { image in
+                AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w92")) { image in
                     image.resizable().aspectRatio(contentMode: .fill)
                 } placeholder: {
                     Color.gray.opacity(0.3)
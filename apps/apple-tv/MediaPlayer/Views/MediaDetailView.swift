@@ -42,7 +42,7 @@ struct MediaDetailView: View {
                 ZStack(alignment: .bottomLeading) {
                     // Backdrop image or placeholder
                     if let backdropPath = media.backdropPath {
-                        AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w1280\(backdropPath)")) { phase in
+                        AsyncImage(url: APIClient.shared.getImageURL(path: backdropPath, size: "w1280")) { phase in
                             switch phase {
                             case .success(let image):
                                 image
@@ -72,7 +72,7 @@ struct MediaDetailView: View {
                     HStack(alignment: .bottom, spacing: 40) {
                         // Poster
                         if let posterPath = media.posterPath {
-                            AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w342\(posterPath)")) { phase in
+                            AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w342")) { phase in
                                 switch phase {
                                 case .success(let image):
                                     image
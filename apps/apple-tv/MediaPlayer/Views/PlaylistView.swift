@@ -266,7 +266,7 @@ struct PlaylistItemRow: View {
                 .frame(width: 30)
 
             if let posterPath = item.posterPath {
-                AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w92\(posterPath)")) { image in
+                AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w92")) { image in
                     image.resizable().aspectRatio(contentMode: .fill)
                 } placeholder: {
                     Color.gray.opacity(0.3)
@@ -353,7 +353,7 @@ struct PlaylistPlayerView: View {
 
                             HStack(spacing: 16) {
                                 if let posterPath = nextItem.posterPath {
-                                    AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w92\(posterPath)")) { image in
+                                    AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w92")) { image in
                                         image.resizable().aspectRatio(contentMode: .fill)
                                     } placeholder: {
                                         Color.gray.opacity(0.3)
@@ -18,7 +18,7 @@ struct TVShowDetailView: View {
                 ZStack(alignment: .bottomLeading) {
                     // Backdrop image or placeholder
                     if let backdropPath = show.backdropPath {
-                        AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w1280\(backdropPath)")) { phase in
+                        AsyncImage(url: APIClient.shared.getImageURL(path: backdropPath, size: "w1280")) { phase in
                             switch phase {
                             case .success(let image):
                                 image
@@ -48,7 +48,7 @@ struct TVShowDetailView: View {
                     HStack(alignment: .bottom, spacing: 40) {
                         // Poster
                         if let posterPath = show.posterPath {
-                            AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w342\(posterPath)")) { phase in
+                            AsyncImage(url: APIClient.shared.getImageURL(path: posterPath, size: "w342")) { phase in
                                 switch phase {
                                 case .success(let image):
                                     image
@@ -271,7 +271,7 @@ struct EpisodeRowContent: View {
         HStack(spacing: 20) {
             // Episode thumbnail
             if let stillPath = episode.stillPath {
-                AsyncImage(url: URL(string: "https://image.tmdb.org/t/p/w300\(stillPath)")) { phase in
+                AsyncImage(url: APIClient.shared.getImageURL(path: stillPath, size: "w300")) { phase in
                     switch phase {
                     case .success(let image):
                         image
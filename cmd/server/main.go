@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stephencjuliano/media-server/internal/api"
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/internal/library"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
 )
 
 func main() {
@@ -29,20 +37,72 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Forget revoked tokens once they've expired
+	database.StartTokenCleanup()
+
 	// Set Gin mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Check ffmpeg can do what the config asks before any transcode runs
+	ffmpegCaps := ffmpeg.ProbeCapabilities(cfg.FFmpegPath)
+	if !ffmpegCaps.Available {
+		log.Printf("Warning: ffmpeg not found at %q, transcoding is unavailable", cfg.FFmpegPath)
+	} else if cfg.EnableHWAccel {
+		if err := ffmpegCaps.CheckHWAccel(cfg.HWAccelType); err != nil {
+			log.Printf("Hardware acceleration disabled, using software encoding: %v", err)
+			cfg.EnableHWAccel = false
+		}
+	}
+
+	// Single scanner instance so manual scans and the watcher share one scan state
+	scanner := library.NewScanner(database, cfg)
+
 	// Initialize router
-	router := api.NewRouter(database, cfg)
+	router := api.NewRouter(database, cfg, scanner, ffmpegCaps)
+
+	// Start file watcher
+	var watcher *library.Watcher
+	if cfg.EnableWatcher {
+		watcher, err = library.NewWatcher(database, cfg, scanner)
+		if err != nil {
+			log.Fatalf("Failed to create file watcher: %v", err)
+		}
+		if err := watcher.Start(); err != nil {
+			log.Fatalf("Failed to start file watcher: %v", err)
+		}
+		log.Println("File watcher enabled")
+	}
 
 	// Start server
 	addr := cfg.Host + ":" + cfg.Port
-	log.Printf("Starting media server on %s", addr)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: router,
+	}
+
+	go func() {
+		log.Printf("Starting media server on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for interrupt, then shut down gracefully
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down...")
+
+	if watcher != nil {
+		watcher.Stop()
+	}
+	scanner.Close()
 
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-		os.Exit(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
 }
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// adaptiveProfileNames are the adaptive variants, lowest first
+var adaptiveProfileNames = []string{"480p", "720p", "1080p"}
+
+// adaptiveKey returns the transcode key of a media item's adaptive session
+func adaptiveKey(ref db.MediaRef) string {
+	return transcodeKey(ref) + "-abr"
+}
+
+// adaptiveProfiles returns the variants for a source resolution: every
+// profile up to the source height, and always the lowest one
+func (h *StreamHandler) adaptiveProfiles(resolution string) []ffmpeg.TranscodeProfile {
+	height := 0
+	if parts := strings.Split(resolution, "x"); len(parts) == 2 {
+		height, _ = strconv.Atoi(parts[1])
+	}
+
+	var profiles []ffmpeg.TranscodeProfile
+	for i, name := range adaptiveProfileNames {
+		profile := h.profiles[name]
+		if i > 0 && height > 0 && profile.Height > height {
+			break
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
+// GetMasterManifest returns an adaptive master playlist with a variant per
+// profile up to the source resolution, starting the multi-bitrate transcode.
+// Players switch between variants as bandwidth changes; the single-profile
+// manifest.m3u8 (with direct play) remains the default.
+// GET /api/stream/:id/master.m3u8
+func (h *StreamHandler) GetMasterManifest(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+
+	if !ffmpeg.InputExists(file.FilePath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+
+	profiles := h.adaptiveProfiles(file.Resolution)
+	_, err := h.sessionManager.StartAdaptiveSession(adaptiveKey(ref), file.FilePath, profiles)
+	if errors.Is(err, ffmpeg.ErrTranscodeBusy) {
+		transcodeBusy(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, profile := range profiles {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"\n/api/stream/%s/adaptive/%s/manifest.m3u8\n",
+			profile.Bandwidth(), profile.Width, profile.Height, profile.Name, ref, profile.Name)
+	}
+
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.Header("Cache-Control", "no-cache")
+	c.String(http.StatusOK, b.String())
+}
+
+// GetAdaptiveVariant serves a variant playlist or segment of the adaptive
+// transcode, restarting the transcode if it was stopped
+// GET /api/stream/:id/adaptive/:variant/:file
+func (h *StreamHandler) GetAdaptiveVariant(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+	key := adaptiveKey(ref)
+
+	variant := c.Param("variant")
+	profile, ok := h.profiles[variant]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	outputDir := h.sessionManager.VariantOutputDir(key, variant)
+	name := c.Param("file")
+
+	// Segments are referenced relative to the variant playlist
+	if name != "manifest.m3u8" {
+		if !renditionSegmentPattern.MatchString(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+			return
+		}
+
+		segmentPath := filepath.Join(outputDir, name)
+		if session := h.sessionManager.GetSession(key); session != nil {
+			session.Touch()
+			h.sessionManager.WaitForFile(segmentPath, h.segmentWaitTimeout(profile, 1))
+		}
+
+		if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+			return
+		}
+
+		c.Header("Content-Type", "video/MP2T")
+		c.Header("Cache-Control", "max-age=86400")
+		c.File(segmentPath)
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+
+	profiles := h.adaptiveProfiles(file.Resolution)
+	if !hasProfile(profiles, variant) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return
+	}
+
+	_, err := h.sessionManager.StartAdaptiveSession(key, file.FilePath, profiles)
+	if errors.Is(err, ffmpeg.ErrTranscodeBusy) {
+		transcodeBusy(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
+		return
+	}
+
+	// Every variant is encoded together, so the top one sets the pace
+	if err = h.sessionManager.WaitForVariantSegments(key, variant, 2, h.segmentWaitTimeout(profiles[len(profiles)-1], 2)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transcoding timeout - " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.Header("Cache-Control", "no-cache")
+	c.File(filepath.Join(outputDir, "manifest.m3u8"))
+}
+
+// hasProfile reports whether profiles includes the named profile
+func hasProfile(profiles []ffmpeg.TranscodeProfile, name string) bool {
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognize
+const apiKeyPrefix = "msk_"
+
+// apiKeyShownLength is how much of a key is kept in the clear, to tell keys
+// apart in listings
+const apiKeyShownLength = len(apiKeyPrefix) + 6
+
+type CreateAPIKeyRequest struct {
+	Name  string `json:"name" binding:"max=100"`
+	Scope string `json:"scope" binding:"omitempty,oneof=full read"`
+}
+
+type CreateAPIKeyResponse struct {
+	Key    string     `json:"key"` // only ever returned here
+	APIKey *db.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey mints an API key for the current user. The key itself is only
+// in this response; the server keeps a hash.
+// POST /api/auth/api-keys
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = db.APIKeyScopeFull
+	}
+
+	key := apiKeyPrefix + rand.Text()
+	created, err := h.db.CreateAPIKey(&db.APIKey{
+		UserID: c.GetInt64("user_id"),
+		Name:   req.Name,
+		Scope:  req.Scope,
+		Prefix: key[:apiKeyShownLength],
+	}, db.HashToken(key))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{Key: key, APIKey: created})
+}
+
+// ListAPIKeys returns the current user's API keys, without the keys themselves
+// GET /api/auth/api-keys
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.db.GetUserAPIKeys(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": keys})
+}
+
+// DeleteAPIKey revokes one of the current user's API keys
+// DELETE /api/auth/api-keys/:id
+func (h *AuthHandler) DeleteAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	err = h.db.DeleteAPIKey(c.GetInt64("user_id"), id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
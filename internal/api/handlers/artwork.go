@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/tmdb"
+)
+
+// posterSizes are the TMDB poster widths clients may ask for
+var posterSizes = map[string]bool{
+	"w92": true, "w154": true, "w185": true, "w342": true, "w500": true, "w780": true, "original": true,
+}
+
+// placeholderColors are the backgrounds of generated posters, picked by
+// title hash. Dark enough for white initials to stay readable.
+var placeholderColors = []string{
+	"#8e3b46", "#a0522d", "#7a6a1f", "#3f6e34", "#2e6f62", "#2d6187",
+	"#3c4f8f", "#5e3f8f", "#873c7a", "#4f5b66", "#6b4e3d", "#355c4b",
+}
+
+type ArtworkHandler struct {
+	db     *db.DB
+	cfg    *config.Config
+	images *tmdb.ImageStore
+}
+
+func NewArtworkHandler(database *db.DB, cfg *config.Config) *ArtworkHandler {
+	return &ArtworkHandler{db: database, cfg: cfg, images: tmdb.NewImageStore(cfg.ImageCacheDir)}
+}
+
+// GetImage serves the local copy of a TMDB image, downloading it first if
+// the scanner hasn't. file is the TMDB path without its leading slash; the
+// scanner stores these URLs in place of TMDB paths (see tmdb.LocalImageURL).
+// GET /api/images/:size/:file
+func (h *ArtworkHandler) GetImage(c *gin.Context) {
+	size, remotePath := c.Param("size"), "/"+c.Param("file")
+	if _, ok := h.images.Path(size, remotePath); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image"})
+		return
+	}
+
+	path, err := h.images.Fetch(c.Request.Context(), size, remotePath)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Image unavailable"})
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=86400")
+	fileWithETag(c, path)
+}
+
+// GetPoster serves an item's TMDB poster, from the image cache or else by
+// redirecting to TMDB. Items without one get a generated placeholder when
+// poster_placeholders is set, and a 404 otherwise. Episodes use their
+// show's poster.
+// GET /api/artwork/:id/poster?size=w342
+func (h *ArtworkHandler) GetPoster(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+	size := c.DefaultQuery("size", "w500")
+	if !posterSizes[size] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poster size"})
+		return
+	}
+
+	title, posterPath, err := h.posterFor(ref)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch media"})
+		return
+	}
+
+	if posterPath != "" {
+		posterPath = tmdb.RemoteImagePath(posterPath)
+		if path, err := h.images.Fetch(c.Request.Context(), size, posterPath); err == nil {
+			c.Header("Cache-Control", "max-age=86400")
+			fileWithETag(c, path)
+			return
+		}
+		c.Redirect(http.StatusFound, tmdb.ImageBaseURL+size+posterPath)
+		return
+	}
+	if !h.cfg.PosterPlaceholders {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No poster available"})
+		return
+	}
+
+	svg := placeholderPoster(title)
+	sum := fnv.New64a()
+	sum.Write([]byte(svg))
+	c.Header("Cache-Control", "private, no-cache")
+	if notModified(c, fmt.Sprintf(`"%x"`, sum.Sum64())) {
+		return
+	}
+	c.Data(http.StatusOK, "image/svg+xml", []byte(svg))
+}
+
+// posterFor returns the title and TMDB poster path of a ref
+func (h *ArtworkHandler) posterFor(ref db.MediaRef) (string, string, error) {
+	switch ref.Type {
+	case db.MediaTypeTVShow:
+		show, err := h.db.GetTVShowByID(ref.ID)
+		if err != nil {
+			return "", "", err
+		}
+		return show.Title, show.PosterPath, nil
+	case db.MediaTypeEpisode:
+		episode, err := h.db.GetEpisodeByID(ref.ID)
+		if err != nil {
+			return "", "", err
+		}
+		show, err := h.db.GetTVShowByID(episode.TVShowID)
+		if err != nil {
+			return "", "", err
+		}
+		return show.Title, show.PosterPath, nil
+	case db.MediaTypeExtra:
+		extra, err := h.db.GetExtraByID(ref.ID)
+		if err != nil {
+			return "", "", err
+		}
+		return extra.Title, "", nil
+	default:
+		media, err := h.db.GetMediaByID(ref.ID)
+		if err != nil {
+			return "", "", err
+		}
+		return media.Title, media.PosterPath, nil
+	}
+}
+
+// placeholderPoster draws a 2:3 poster with the title's initials on a
+// background color derived from the title, so it's the same every time
+func placeholderPoster(title string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.ToLower(title)))
+	color := placeholderColors[hash.Sum32()%uint32(len(placeholderColors))]
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="500" height="750" viewBox="0 0 500 750">`+
+		`<rect width="500" height="750" fill="%s"/>`+
+		`<text x="250" y="375" fill="#ffffff" font-family="Helvetica, Arial, sans-serif" font-size="180" font-weight="bold" text-anchor="middle" dominant-baseline="central">%s</text>`+
+		`</svg>`, color, html.EscapeString(titleInitials(title)))
+}
+
+// titleInitials returns the first letter or digit of the title's first two
+// words, e.g. "TM" for "The Matrix", or "?" for an empty title
+func titleInitials(title string) string {
+	var initials []rune
+	for _, word := range strings.Fields(title) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				initials = append(initials, unicode.ToUpper(r))
+				break
+			}
+		}
+		if len(initials) == 2 {
+			break
+		}
+	}
+	if len(initials) == 0 {
+		return "?"
+	}
+	return string(initials)
+}
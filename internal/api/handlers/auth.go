@@ -1,23 +1,33 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/stephencjuliano/media-server/internal/api/middleware"
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// streamTokenLifetime is how long stream tokens last, long enough to finish
+// anything started before the access token they came with expired
+const streamTokenLifetime = 24 * time.Hour
+
 type AuthHandler struct {
-	db  *db.DB
-	cfg *config.Config
+	db     *db.DB
+	cfg    *config.Config
+	logins *loginLimiter
 }
 
 func NewAuthHandler(database *db.DB, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{db: database, cfg: cfg}
+	return &AuthHandler{db: database, cfg: cfg, logins: newLoginLimiter()}
 }
 
 type RegisterRequest struct {
@@ -31,21 +41,38 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type TokenResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expires_at"`
-	User      struct {
+	Token            string `json:"token"`
+	ExpiresAt        int64  `json:"expires_at"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at"`
+	StreamToken      string `json:"stream_token"` // for media URLs, see middleware.StreamTokenScope
+	StreamExpiresAt  int64  `json:"stream_expires_at"`
+	User             struct {
 		ID       int64  `json:"id"`
 		Username string `json:"username"`
 		Email    string `json:"email"`
+		IsAdmin  bool   `json:"is_admin"`
 	} `json:"user"`
 }
 
 // Register creates a new user account
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -85,26 +112,39 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
-// Login authenticates a user and returns a JWT token
+// Login authenticates a user and returns a JWT token. After too many failed
+// attempts for a username, or from one IP, it answers 429 until they age out.
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	ip := c.ClientIP()
+	if wait := h.logins.retryAfter(req.Username, ip); wait > 0 {
+		minutes := int(math.Ceil(wait.Minutes()))
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": fmt.Sprintf("Too many failed login attempts, try again in %d minute(s)", minutes),
+		})
 		return
 	}
 
 	// Find user
 	user, err := h.db.GetUserByUsername(req.Username)
 	if err != nil {
+		h.logins.fail(req.Username, ip)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		h.logins.fail(req.Username, ip)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	h.logins.succeed(req.Username)
 
 	// Generate token
 	response, err := h.generateTokenResponse(user)
@@ -116,15 +156,27 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// RefreshToken generates a new token from an existing valid token
+// RefreshToken exchanges a refresh token for a new access token. The refresh
+// token is used up and a new one returned in its place, so a stolen one
+// stops working once either party uses it.
+// POST /api/auth/refresh
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+	var req RefreshRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID, err := h.db.ConsumeRefreshToken(db.HashToken(req.RefreshToken))
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
 		return
 	}
 
-	user, err := h.db.GetUserByID(userID.(int64))
+	user, err := h.db.GetUserByID(userID)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
@@ -139,8 +191,97 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// ChangePassword replaces the current user's password after checking their
+// current one. New passwords follow the same rules as at registration.
+// POST /api/auth/change-password
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	user, err := h.db.GetUserByID(c.GetInt64("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.db.UpdateUserPassword(user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// Logout revokes the token the request was made with and, if the body has
+// one, the refresh token that goes with it. Other sessions of the user stay
+// logged in.
+// POST /api/auth/logout
+func (h *AuthHandler) Logout(c *gin.Context) {
+	// The body is optional, for clients that only hold an access token
+	var req LogoutRequest
+	_ = c.ShouldBindJSON(&req)
+
+	userID := c.GetInt64("user_id")
+	if tokenID := c.GetString("token_id"); tokenID != "" {
+		// The stream token issued with this one shares its ID and may
+		// outlive it, so keep the revocation until the stream token expires
+		expiresAt := time.Now().Add(max(streamTokenLifetime, time.Duration(h.cfg.JWTExpiration)*time.Hour))
+		if err := h.db.RevokeToken(tokenID, userID, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	if req.RefreshToken != "" {
+		if err := h.db.DeleteRefreshToken(userID, db.HashToken(req.RefreshToken)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// RevokeUserTokens revokes every token issued to a user, logging them out on
+// all devices
+// POST /api/admin/users/:id/revoke-tokens
+func (h *AuthHandler) RevokeUserTokens(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	err = h.db.RevokeUserTokens(userID)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tokens revoked"})
+}
+
+// generateTokenResponse issues an access token and a new refresh token
 func (h *AuthHandler) generateTokenResponse(user *db.User) (*TokenResponse, error) {
 	expiresAt := time.Now().Add(time.Duration(h.cfg.JWTExpiration) * time.Hour)
+	tokenID := rand.Text() // so the token can be revoked on its own
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id":  user.ID,
@@ -148,6 +289,7 @@ func (h *AuthHandler) generateTokenResponse(user *db.User) (*TokenResponse, erro
 		"email":    user.Email,
 		"exp":      expiresAt.Unix(),
 		"iat":      time.Now().Unix(),
+		"jti":      tokenID,
 	})
 
 	tokenString, err := token.SignedString([]byte(h.cfg.JWTSecret))
@@ -155,13 +297,39 @@ func (h *AuthHandler) generateTokenResponse(user *db.User) (*TokenResponse, erro
 		return nil, err
 	}
 
+	// The stream token shares the access token's ID, so logging out
+	// revokes both
+	streamExpiresAt := time.Now().Add(max(streamTokenLifetime, time.Duration(h.cfg.JWTExpiration)*time.Hour))
+	streamToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"scope":    middleware.StreamTokenScope,
+		"exp":      streamExpiresAt.Unix(),
+		"iat":      time.Now().Unix(),
+		"jti":      tokenID,
+	}).SignedString([]byte(h.cfg.JWTSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := rand.Text()
+	refreshExpiresAt := time.Now().AddDate(0, 0, h.cfg.RefreshTokenExpiration)
+	if err := h.db.CreateRefreshToken(user.ID, db.HashToken(refreshToken), refreshExpiresAt); err != nil {
+		return nil, err
+	}
+
 	response := &TokenResponse{
-		Token:     tokenString,
-		ExpiresAt: expiresAt.Unix(),
+		Token:            tokenString,
+		ExpiresAt:        expiresAt.Unix(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt.Unix(),
+		StreamToken:      streamToken,
+		StreamExpiresAt:  streamExpiresAt.Unix(),
 	}
 	response.User.ID = user.ID
 	response.User.Username = user.Username
 	response.User.Email = user.Email
+	response.User.IsAdmin = user.IsAdmin
 
 	return response, nil
 }
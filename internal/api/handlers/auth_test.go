@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/stephencjuliano/media-server/internal/config"
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return database
+}
+
+// newTestUser adds a user with password
+func newTestUser(t *testing.T, database *db.DB, username, password string) *db.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	user, err := database.CreateUser(username, username+"@example.com", string(hash))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// changePassword calls ChangePassword as user with a JSON body
+func changePassword(h *AuthHandler, user *db.User, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/change-password", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", user.ID)
+	h.ChangePassword(c)
+	return w
+}
+
+func TestChangePassword(t *testing.T) {
+	database := newTestDB(t)
+	h := NewAuthHandler(database, &config.Config{})
+	user := newTestUser(t, database, "alice", "old-password")
+
+	w := changePassword(h, user, `{"current_password": "old-password", "new_password": "new-password"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	updated, err := database.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(updated.PasswordHash), []byte("new-password")); err != nil {
+		t.Errorf("new password doesn't match the stored hash: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(updated.PasswordHash), []byte("old-password")); err == nil {
+		t.Error("old password still matches the stored hash")
+	}
+}
+
+func TestChangePasswordWrongCurrentPassword(t *testing.T) {
+	database := newTestDB(t)
+	h := NewAuthHandler(database, &config.Config{})
+	user := newTestUser(t, database, "alice", "old-password")
+
+	w := changePassword(h, user, `{"current_password": "wrong-password", "new_password": "new-password"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+
+	unchanged, err := database.GetUserByID(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if unchanged.PasswordHash != user.PasswordHash {
+		t.Error("password hash changed after a wrong current password")
+	}
+}
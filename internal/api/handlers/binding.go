@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name, dotted for nested fields
+	Rule    string `json:"rule"`    // failed binding rule, e.g. required or min
+	Message string `json:"message"` // human-readable, for showing next to the field
+}
+
+var jsonFieldNamesOnce sync.Once
+
+// useJSONFieldNames makes validation errors report fields by their JSON
+// names, which is what clients send
+func useJSONFieldNames() {
+	jsonFieldNamesOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			return
+		}
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	})
+}
+
+// bindJSON binds the request body into obj. On failure it writes a 400 with
+// an "error" summary and per-field "fields" details, and returns false.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	useJSONFieldNames()
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	fields := bindingFieldErrors(err)
+	message := "Invalid request body"
+	if len(fields) > 0 {
+		messages := make([]string, len(fields))
+		for i, f := range fields {
+			messages[i] = f.Message
+		}
+		message = strings.Join(messages, "; ")
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": message, "fields": fields})
+	return false
+}
+
+// bindingFieldErrors translates binding errors into field errors. Malformed
+// JSON has no field and yields none.
+func bindingFieldErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   jsonFieldPath(fe.Namespace()),
+				Rule:    fe.Tag(),
+				Message: validationMessage(fe),
+			})
+		}
+		return fields
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		}}
+	}
+
+	return []FieldError{}
+}
+
+// jsonFieldPath drops the struct name from a validator namespace
+// ("RegisterRequest.password" becomes "password")
+func jsonFieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
+
+// validationMessage describes a failed rule in words
+func validationMessage(fe validator.FieldError) string {
+	field := fe.Field()
+	sized := fe.Kind() == reflect.String || fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+	switch fe.Tag() {
+	case "required":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at least %s characters", field, fe.Param())
+		}
+		if sized {
+			return fmt.Sprintf("%s must have at least %s items", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at least %s", field, fe.Param())
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at most %s characters", field, fe.Param())
+		}
+		if sized {
+			return fmt.Sprintf("%s must have at most %s items", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at most %s", field, fe.Param())
+	}
+	return fmt.Sprintf("%s failed the %s rule", field, fe.Tag())
+}
+
+// jsonTypeName names a Go type the way a JSON client thinks of it
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	}
+	return "object"
+}
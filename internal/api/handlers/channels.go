@@ -5,22 +5,25 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
 )
 
 type ChannelHandler struct {
-	db *db.DB
+	db  *db.DB
+	cfg *config.Config
 }
 
-func NewChannelHandler(database *db.DB) *ChannelHandler {
-	return &ChannelHandler{db: database}
+func NewChannelHandler(database *db.DB, cfg *config.Config) *ChannelHandler {
+	return &ChannelHandler{db: database, cfg: cfg}
 }
 
 // CreateChannelRequest represents the request body for creating a channel
 type CreateChannelRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
-	Icon        string `json:"icon"`
+	Name            string `json:"name" binding:"required"`
+	Description     string `json:"description"`
+	Icon            string `json:"icon"`
+	BumperFrequency *int   `json:"bumper_frequency" binding:"omitempty,min=0"` // Items between bumpers; unchanged if not provided
 }
 
 // AddSourceRequest represents the request body for adding a source
@@ -55,8 +58,7 @@ func (h *ChannelHandler) CreateChannel(c *gin.Context) {
 	userID := c.GetInt64("user_id")
 
 	var req CreateChannelRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -65,6 +67,13 @@ func (h *ChannelHandler) CreateChannel(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
 		return
 	}
+	if req.BumperFrequency != nil {
+		if err := h.db.SetChannelBumperFrequency(channel.ID, *req.BumperFrequency); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create channel"})
+			return
+		}
+		channel.BumperFrequency = *req.BumperFrequency
+	}
 
 	c.JSON(http.StatusCreated, channel)
 }
@@ -122,11 +131,16 @@ func (h *ChannelHandler) UpdateChannel(c *gin.Context) {
 	}
 
 	var req CreateChannelRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
+	if req.BumperFrequency != nil {
+		if err := h.db.SetChannelBumperFrequency(channelID, *req.BumperFrequency); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
+			return
+		}
+	}
 	channel, err := h.db.UpdateChannel(channelID, req.Name, req.Description, req.Icon)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update channel"})
@@ -193,8 +207,7 @@ func (h *ChannelHandler) AddSource(c *gin.Context) {
 	}
 
 	var req AddSourceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -205,6 +218,7 @@ func (h *ChannelHandler) AddSource(c *gin.Context) {
 		db.ChannelSourceShow:          true,
 		db.ChannelSourceMovie:         true,
 		db.ChannelSourceExtraCategory: true,
+		db.ChannelSourceBumper:        true,
 	}
 	if !validTypes[req.SourceType] {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source type"})
@@ -375,14 +389,40 @@ func (h *ChannelHandler) GetNowPlaying(c *gin.Context) {
 
 	// Add stream URL if something is playing
 	if nowPlaying.NowPlaying != nil {
-		streamType := string(nowPlaying.NowPlaying.MediaType)
-		nowPlaying.StreamURL = "/api/stream/" + strconv.FormatInt(nowPlaying.NowPlaying.MediaID, 10) +
-			"/direct?type=" + streamType + "&start=" + strconv.Itoa(nowPlaying.Elapsed)
+		nowPlaying.StreamURL = channelStreamURL(nowPlaying.NowPlaying, nowPlaying.Elapsed)
 	}
 
 	c.JSON(http.StatusOK, nowPlaying)
 }
 
+// GetGuide returns what's on now and next across all of the user's channels
+// GET /api/channels/guide
+func (h *ChannelHandler) GetGuide(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+
+	entries, err := h.db.GetChannelGuide(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch channel guide"})
+		return
+	}
+
+	for i := range entries {
+		if entries[i].NowPlaying != nil {
+			entries[i].StreamURL = channelStreamURL(entries[i].NowPlaying, entries[i].Elapsed)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+// channelStreamURL returns the URL that plays a schedule item from elapsed
+// seconds in. The item is a typed ref ("episode:12"), so the URL names it
+// without a ?type= that could be dropped along the way.
+func channelStreamURL(item *db.ChannelScheduleItem, elapsed int) string {
+	ref := db.MediaRef{Type: item.MediaType, ID: item.MediaID}
+	return "/api/stream/" + ref.String() + "/direct?start=" + strconv.Itoa(elapsed)
+}
+
 // GetSchedule returns the full schedule for a channel
 func (h *ChannelHandler) GetSchedule(c *gin.Context) {
 	userID := c.GetInt64("user_id")
@@ -407,11 +447,9 @@ func (h *ChannelHandler) GetSchedule(c *gin.Context) {
 		return
 	}
 
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-
-	if limit > 100 {
-		limit = 100
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
 	}
 
 	items, total, err := h.db.GetChannelSchedule(channelID, limit, offset)
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonWithETag writes v as JSON with an ETag derived from the encoded body,
+// or a bodyless 304 when the client's If-None-Match already has it. Hashing
+// the body rather than updated_at also catches changes to joined data such as
+// a show's seasons or an episode's artwork.
+func jsonWithETag(c *gin.Context, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode response"})
+		return
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	// Cacheable per user, but always revalidated
+	c.Header("Cache-Control", "private, no-cache")
+	if notModified(c, etag) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// fileWithETag serves a cached file (e.g. artwork from the image cache) with
+// a weak ETag built from its size and modification time, so unchanged files
+// are answered with a 304 without reading them
+func fileWithETag(c *gin.Context, path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	etag := fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+	if notModified(c, etag) {
+		return
+	}
+	c.File(path)
+}
+
+// notModified sets the ETag header and, if If-None-Match matches it, responds
+// 304 and returns true. Tags are compared weakly, as RFC 9110 requires for
+// If-None-Match.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+
+	match := c.GetHeader("If-None-Match")
+	if match == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
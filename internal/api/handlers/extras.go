@@ -16,16 +16,36 @@ func NewExtrasHandler(database *db.DB) *ExtrasHandler {
 	return &ExtrasHandler{db: database}
 }
 
+// extraListOptions reads ?sort=duration and ?parent=movie|show|none. It writes
+// a 400 response and returns false for an unknown parent filter.
+func extraListOptions(c *gin.Context) (db.ExtraListOptions, bool) {
+	opts := db.ExtraListOptions{
+		SortByDuration: c.Query("sort") == "duration",
+		Parent:         c.Query("parent"),
+	}
+
+	switch opts.Parent {
+	case "", db.ExtraParentMovie, db.ExtraParentShow, db.ExtraParentNone:
+		return opts, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent filter (use movie, show or none)"})
+	return opts, false
+}
+
 // GetExtras returns all extras with pagination
+// GET /api/extras?sort=duration&parent=movie|show|none
 func (h *ExtrasHandler) GetExtras(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
 
-	if limit > 100 {
-		limit = 100
+	opts, ok := extraListOptions(c)
+	if !ok {
+		return
 	}
 
-	extras, total, err := h.db.GetAllExtras(limit, offset)
+	extras, total, err := h.db.GetAllExtras(opts, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch extras"})
 		return
@@ -74,14 +94,17 @@ func (h *ExtrasHandler) GetExtraCategories(c *gin.Context) {
 // GetExtrasByCategory returns extras filtered by category
 func (h *ExtrasHandler) GetExtrasByCategory(c *gin.Context) {
 	category := db.ExtraCategory(c.Param("category"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
 
-	if limit > 100 {
-		limit = 100
+	opts, ok := extraListOptions(c)
+	if !ok {
+		return
 	}
 
-	extras, total, err := h.db.GetExtrasByCategory(category, limit, offset)
+	extras, total, err := h.db.GetExtrasByCategory(category, opts, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch extras"})
 		return
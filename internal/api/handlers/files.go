@@ -33,21 +33,21 @@ func NewFilesHandler(basePath string) *FilesHandler {
 func (h *FilesHandler) ListDirectory(c *gin.Context) {
 	requestedPath := c.DefaultQuery("path", h.basePath)
 
-	// Security: Clean and validate the path
-	cleanPath := filepath.Clean(requestedPath)
-
-	// Ensure the path is under the base path
-	if !strings.HasPrefix(cleanPath, h.basePath) {
+	// Prevent path traversal attacks
+	if strings.Contains(requestedPath, "..") {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Path must be under " + h.basePath,
+			"error": "Invalid path",
 		})
 		return
 	}
 
-	// Prevent path traversal attacks
-	if strings.Contains(requestedPath, "..") {
+	// Security: Clean and validate the path
+	cleanPath := filepath.Clean(requestedPath)
+
+	// Ensure the path is under the base path
+	if !isPathUnder(cleanPath, h.basePath) {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid path",
+			"error": "Path must be under " + h.basePath,
 		})
 		return
 	}
@@ -145,3 +145,24 @@ func (h *FilesHandler) GetRoots(c *gin.Context) {
 		"roots":    roots,
 	})
 }
+
+// isPathUnder reports whether path is basePath itself or lies inside it.
+// Both paths must be absolute; relative paths and ".." components are rejected
+// so the check can't be escaped with traversal or sibling-prefix tricks
+// (e.g. "/media2" is not under "/media").
+func isPathUnder(path, basePath string) bool {
+	if !filepath.IsAbs(path) || !filepath.IsAbs(basePath) {
+		return false
+	}
+	for _, part := range strings.Split(path, string(filepath.Separator)) {
+		if part == ".." {
+			return false
+		}
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(basePath), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
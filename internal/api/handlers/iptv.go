@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// channelExportMaxItems caps how much of a channel's upcoming schedule its
+// M3U and guide list; the schedule itself reaches about a day ahead
+const channelExportMaxItems = 500
+
+// GetChannelsM3U lists the user's channels as an IPTV playlist, one entry per
+// channel tuning into whatever it's playing now
+// GET /api/channels.m3u
+func (h *ChannelHandler) GetChannelsM3U(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+
+	channels, err := h.db.GetUserChannels(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch channels"})
+		return
+	}
+
+	var m3u strings.Builder
+	m3u.WriteString("#EXTM3U\n")
+	for _, channel := range channels {
+		id := strconv.FormatInt(channel.ID, 10)
+		fmt.Fprintf(&m3u, "#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" tvg-chno=\"%s\",%s\n",
+			channelGuideID(channel.ID), m3uText(channel.Name), id, m3uText(channel.Name))
+		m3u.WriteString(h.playlistURL(c, "/api/channels/"+id+"/live") + "\n")
+	}
+
+	c.Data(http.StatusOK, "audio/x-mpegurl", []byte(m3u.String()))
+}
+
+// GetChannelM3U lists a channel's upcoming schedule as a playlist, starting
+// with the item on air from where it is now
+// GET /api/channels/:id/playlist.m3u8
+func (h *ChannelHandler) GetChannelM3U(c *gin.Context) {
+	channel, ok := h.ownedChannel(c)
+	if !ok {
+		return
+	}
+
+	items, _, err := h.db.GetChannelSchedule(channel.ID, channelExportMaxItems, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+		return
+	}
+
+	var m3u strings.Builder
+	m3u.WriteString("#EXTM3U\n")
+	for i := range items {
+		item := &items[i]
+		elapsed := 0
+		if i == 0 {
+			elapsed = max(int(time.Since(item.StartsAt).Seconds()), 0)
+		}
+		title := item.Title
+		if item.ShowTitle != "" {
+			title = item.ShowTitle + " - " + item.Title
+		}
+		fmt.Fprintf(&m3u, "#EXTINF:%d,%s\n", item.Duration-elapsed, m3uText(title))
+		m3u.WriteString(h.playlistURL(c, channelStreamURL(item, elapsed)) + "\n")
+	}
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(m3u.String()))
+}
+
+// xmltvTimeFormat is the XMLTV date format, with the UTC offset
+const xmltvTimeFormat = "20060102150405 -0700"
+
+type xmltvGuide struct {
+	XMLName    xml.Name         `xml:"tv"`
+	Generator  string           `xml:"generator-info-name,attr"`
+	Channels   []xmltvChannel   `xml:"channel"`
+	Programmes []xmltvProgramme `xml:"programme"`
+}
+
+type xmltvChannel struct {
+	ID          string `xml:"id,attr"`
+	DisplayName string `xml:"display-name"`
+}
+
+type xmltvProgramme struct {
+	Start       string            `xml:"start,attr"`
+	Stop        string            `xml:"stop,attr"`
+	Channel     string            `xml:"channel,attr"`
+	Title       string            `xml:"title"`
+	SubTitle    string            `xml:"sub-title,omitempty"`
+	Desc        string            `xml:"desc,omitempty"`
+	EpisodeNums []xmltvEpisodeNum `xml:"episode-num"`
+}
+
+type xmltvEpisodeNum struct {
+	System string `xml:"system,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// GetChannelsEPG returns the programme guide of the user's channels as
+// XMLTV, matching the channel IDs of GetChannelsM3U
+// GET /api/channels/epg.xml
+func (h *ChannelHandler) GetChannelsEPG(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+
+	channels, err := h.db.GetUserChannels(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch channels"})
+		return
+	}
+
+	guide := xmltvGuide{
+		Generator:  "media-server",
+		Channels:   make([]xmltvChannel, 0, len(channels)),
+		Programmes: make([]xmltvProgramme, 0),
+	}
+	for _, channel := range channels {
+		guideID := channelGuideID(channel.ID)
+		guide.Channels = append(guide.Channels, xmltvChannel{ID: guideID, DisplayName: channel.Name})
+
+		items, _, err := h.db.GetChannelSchedule(channel.ID, channelExportMaxItems, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+			return
+		}
+		for _, item := range items {
+			guide.Programmes = append(guide.Programmes, xmltvProgrammeFor(guideID, item))
+		}
+	}
+
+	data, err := xml.MarshalIndent(guide, "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build guide"})
+		return
+	}
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
+
+// xmltvProgrammeFor describes a schedule item in the guide. Episodes are
+// titled by their show, with the episode as the sub-title and number.
+func xmltvProgrammeFor(guideID string, item db.ChannelScheduleItem) xmltvProgramme {
+	programme := xmltvProgramme{
+		Start:   item.StartsAt.Format(xmltvTimeFormat),
+		Stop:    item.StartsAt.Add(time.Duration(item.Duration) * time.Second).Format(xmltvTimeFormat),
+		Channel: guideID,
+		Title:   item.Title,
+		Desc:    item.Overview,
+	}
+	if item.MediaType == db.MediaTypeEpisode && item.ShowTitle != "" {
+		programme.Title = item.ShowTitle
+		programme.SubTitle = item.Title
+		programme.EpisodeNums = []xmltvEpisodeNum{
+			{System: "onscreen", Value: fmt.Sprintf("S%02dE%02d", item.SeasonNumber, item.EpisodeNumber)},
+			// xmltv_ns numbers from zero
+			{System: "xmltv_ns", Value: fmt.Sprintf("%d.%d.", max(item.SeasonNumber-1, 0), max(item.EpisodeNumber-1, 0))},
+		}
+	}
+	return programme
+}
+
+// GetLiveStream redirects to the stream of what a channel is playing now, from
+// where it is now, so an IPTV "tuner" can point at a fixed URL
+// GET /api/channels/:id/live
+func (h *ChannelHandler) GetLiveStream(c *gin.Context) {
+	channel, ok := h.ownedChannel(c)
+	if !ok {
+		return
+	}
+
+	nowPlaying, err := h.db.GetChannelNowPlaying(channel.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get now playing"})
+		return
+	}
+	if nowPlaying.NowPlaying == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nothing is playing on this channel"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.playlistURL(c, channelStreamURL(nowPlaying.NowPlaying, nowPlaying.Elapsed)))
+}
+
+// ownedChannel loads the channel in the request's :id, responding with an
+// error if it doesn't exist or isn't the user's
+func (h *ChannelHandler) ownedChannel(c *gin.Context) (*db.Channel, bool) {
+	channelID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return nil, false
+	}
+
+	channel, err := h.db.GetChannelByID(channelID)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch channel"})
+		return nil, false
+	}
+	if channel.UserID != c.GetInt64("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return channel, true
+}
+
+// playlistURL makes path absolute and adds the request's credentials to it:
+// IPTV players fetch playlist entries themselves, without the headers the
+// playlist was requested with
+func (h *ChannelHandler) playlistURL(c *gin.Context, path string) string {
+	base := strings.TrimRight(h.cfg.ExternalBaseURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+
+	if auth := requestCredentials(c); auth != "" {
+		if strings.Contains(path, "?") {
+			path += "&" + auth
+		} else {
+			path += "?" + auth
+		}
+	}
+	return base + path
+}
+
+// requestCredentials returns the API key or token a request authenticated
+// with as a query string, or "" for guests
+func requestCredentials(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return "api_key=" + url.QueryEscape(key)
+	}
+	if key := c.Query("api_key"); key != "" {
+		return "api_key=" + url.QueryEscape(key)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && token != "" {
+		return "token=" + url.QueryEscape(token)
+	}
+	if token := c.Query("token"); token != "" {
+		return "token=" + url.QueryEscape(token)
+	}
+	return ""
+}
+
+// channelGuideID identifies a channel across the M3U and the programme guide
+func channelGuideID(channelID int64) string {
+	return "channel-" + strconv.FormatInt(channelID, 10)
+}
+
+// m3uText makes a name safe for an #EXTINF line, which ends at the newline
+// and quotes its attributes
+func m3uText(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ", `"`, "'").Replace(s)
+}
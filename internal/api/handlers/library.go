@@ -1,8 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stephencjuliano/media-server/internal/config"
@@ -16,11 +24,11 @@ type LibraryHandler struct {
 	scanner *library.Scanner
 }
 
-func NewLibraryHandler(database *db.DB, cfg *config.Config) *LibraryHandler {
+func NewLibraryHandler(database *db.DB, cfg *config.Config, scanner *library.Scanner) *LibraryHandler {
 	return &LibraryHandler{
 		db:      database,
 		cfg:     cfg,
-		scanner: library.NewScanner(database, cfg),
+		scanner: scanner,
 	}
 }
 
@@ -31,16 +39,38 @@ type PaginatedResponse struct {
 	Offset int         `json:"offset"`
 }
 
+// mediaListOptions reads ?sort=title|year|rating|added and ?order=asc|desc.
+// It writes a 400 response and returns false for other values.
+func mediaListOptions(c *gin.Context) (db.MediaListOptions, bool) {
+	opts := db.MediaListOptions{Sort: c.DefaultQuery("sort", db.MediaSortTitle)}
+	if !db.ValidMediaSort(opts.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort (use title, year, rating or added)"})
+		return opts, false
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order (use asc or desc)"})
+		return opts, false
+	}
+	return opts, true
+}
+
 // GetMovies returns all movies in the library
+// GET /api/library/movies?sort=title|year|rating|added&order=asc|desc
 func (h *LibraryHandler) GetMovies(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-
-	if limit > 100 {
-		limit = 100
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+	opts, ok := mediaListOptions(c)
+	if !ok {
+		return
 	}
 
-	movies, err := h.db.GetMediaByType(db.MediaTypeMovie, limit, offset)
+	movies, total, err := h.db.GetMediaByType(db.MediaTypeMovie, opts, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movies"})
 		return
@@ -48,21 +78,25 @@ func (h *LibraryHandler) GetMovies(c *gin.Context) {
 
 	c.JSON(http.StatusOK, PaginatedResponse{
 		Items:  movies,
+		Total:  total,
 		Limit:  limit,
 		Offset: offset,
 	})
 }
 
 // GetShows returns all TV shows in the library
+// GET /api/library/shows?sort=title|year|rating|added&order=asc|desc
 func (h *LibraryHandler) GetShows(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-
-	if limit > 100 {
-		limit = 100
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+	opts, ok := mediaListOptions(c)
+	if !ok {
+		return
 	}
 
-	shows, err := h.db.GetMediaByType(db.MediaTypeTVShow, limit, offset)
+	shows, total, err := h.db.GetMediaByType(db.MediaTypeTVShow, opts, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch TV shows"})
 		return
@@ -70,17 +104,72 @@ func (h *LibraryHandler) GetShows(c *gin.Context) {
 
 	c.JSON(http.StatusOK, PaginatedResponse{
 		Items:  shows,
+		Total:  total,
 		Limit:  limit,
 		Offset: offset,
 	})
 }
 
+// LibraryListResponse is a page of the unified library listing with facet
+// counts across the whole library
+type LibraryListResponse struct {
+	PaginatedResponse
+	Facets *db.LibraryFacets `json:"facets"`
+}
+
+// GetAll returns movies and shows as one mixed listing
+// GET /api/library/all?type=movie|tvshow&genre=&resolution=4K|1080p|720p|SD&sort=title|added
+func (h *LibraryHandler) GetAll(c *gin.Context) {
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+
+	opts := db.LibraryListOptions{
+		Type:        db.MediaType(c.Query("type")),
+		Genre:       c.Query("genre"),
+		Resolution:  c.Query("resolution"),
+		SortByAdded: c.Query("sort") == "added",
+	}
+	if opts.Type != "" && opts.Type != db.MediaTypeMovie && opts.Type != db.MediaTypeTVShow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type (use movie or tvshow)"})
+		return
+	}
+	switch opts.Resolution {
+	case "", "4K", "1080p", "720p", "SD":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution (use 4K, 1080p, 720p or SD)"})
+		return
+	}
+
+	items, total, err := h.db.GetLibraryItems(opts, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch library"})
+		return
+	}
+
+	aggregates, err := h.db.GetLibraryAggregates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch library"})
+		return
+	}
+
+	c.JSON(http.StatusOK, LibraryListResponse{
+		PaginatedResponse: PaginatedResponse{
+			Items:  items,
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		},
+		Facets: aggregates.Facets,
+	})
+}
+
 // GetRecent returns recently added media
 func (h *LibraryHandler) GetRecent(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-
-	if limit > 50 {
-		limit = 50
+	limit, ok := parseLimit(c, 20, 50)
+	if !ok {
+		return
 	}
 
 	media, err := h.db.GetRecentMedia(limit)
@@ -92,6 +181,61 @@ func (h *LibraryHandler) GetRecent(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"items": media})
 }
 
+// Search finds movies, shows and episodes by title and overview, best
+// matches first
+// GET /api/search?q=...&limit=
+func (h *LibraryHandler) Search(c *gin.Context) {
+	query := c.Query("q")
+	if strings.TrimSpace(query) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	limit, ok := parseLimit(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+
+	results, err := h.db.Search(query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": results, "total": len(results)})
+}
+
+// GetNewEpisodes returns recently added episodes across all shows
+// GET /api/library/new-episodes?sort=added|aired&filter=watchlist|watching&hide_unaired=true
+func (h *LibraryHandler) GetNewEpisodes(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+
+	filter := c.Query("filter")
+	if filter != "" && filter != db.NewEpisodesFilterWatchlist && filter != db.NewEpisodesFilterWatching {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter (use watchlist or watching)"})
+		return
+	}
+
+	episodes, total, err := h.db.GetNewEpisodes(userID, filter, episodeListOptions(c), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch new episodes"})
+		return
+	}
+	for _, item := range episodes {
+		fillEpisodeRuntimes(item.Episode)
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Items:  episodes,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
 // GetMedia returns a single media item by ID
 func (h *LibraryHandler) GetMedia(c *gin.Context) {
 	idStr := c.Param("id")
@@ -111,12 +255,187 @@ func (h *LibraryHandler) GetMedia(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, media)
+	jsonWithETag(c, media)
 }
 
-// TriggerScan initiates a library scan
+// GetMediaCredits returns a movie's top-billed cast, which is empty until it's
+// been matched on TMDB
+// GET /api/media/:id/credits
+func (h *LibraryHandler) GetMediaCredits(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		return
+	}
+
+	if _, err := h.db.GetMediaByID(id); err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch media"})
+		return
+	}
+
+	credits, err := h.db.GetCredits(db.MediaRef{Type: db.MediaTypeMovie, ID: id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": credits})
+}
+
+// DeleteMedia removes a media item from the library. With ?delete_file=true
+// the underlying file is also removed from disk, provided it lives inside a
+// configured media source; now-empty parent directories are cleaned up too.
+// DELETE /api/media/:id
+func (h *LibraryHandler) DeleteMedia(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		return
+	}
+
+	media, err := h.db.GetMediaByID(id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch media"})
+		return
+	}
+
+	deleteFile := c.Query("delete_file") == "true"
+
+	// Validate the file path before touching the database so a rejected
+	// request leaves everything as it was
+	var sourceRoot string
+	if deleteFile {
+		sources, err := h.db.GetAllMediaSources()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sources"})
+			return
+		}
+		for _, source := range sources {
+			if media.FilePath != filepath.Clean(source.Path) && isPathUnder(media.FilePath, source.Path) {
+				sourceRoot = filepath.Clean(source.Path)
+				break
+			}
+		}
+		if sourceRoot == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "File is not inside a known media source"})
+			return
+		}
+		if info, err := os.Lstat(media.FilePath); err == nil && !info.Mode().IsRegular() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Path is not a regular file"})
+			return
+		}
+		// Re-check with symlinks resolved so a linked directory can't point outside the source
+		if resolved, err := filepath.EvalSymlinks(media.FilePath); err == nil {
+			resolvedRoot, err := filepath.EvalSymlinks(sourceRoot)
+			if err != nil || !isPathUnder(resolved, resolvedRoot) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "File is not inside a known media source"})
+				return
+			}
+		}
+	}
+
+	if err := h.db.DeleteMedia(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete media"})
+		return
+	}
+
+	// Drop any cached transcode output
+	os.RemoveAll(filepath.Join(h.cfg.TranscodeDir, transcodeKey(media.Ref())))
+
+	if !deleteFile {
+		c.JSON(http.StatusOK, gin.H{"message": "Media deleted", "file_deleted": false})
+		return
+	}
+
+	if err := os.Remove(media.FilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to delete file %s: %v", media.FilePath, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Media deleted but file could not be removed"})
+		return
+	}
+	log.Printf("Deleted file %s", media.FilePath)
+
+	// Remove parent directories left empty, stopping at the source root
+	for dir := filepath.Dir(media.FilePath); dir != sourceRoot && isPathUnder(dir, sourceRoot); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break // not empty (or not removable)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Media deleted", "file_deleted": true})
+}
+
+// maxParsePreviewFiles caps how many files one parse preview returns
+const maxParsePreviewFiles = 1000
+
+// ParsePreviewRequest lists files to preview, or a directory to preview the
+// files a scan of it would find
+type ParsePreviewRequest struct {
+	Paths     []string `json:"paths"`
+	Directory string   `json:"directory"`
+}
+
+// ParsePreviewResponse is returned by the parse preview endpoint
+type ParsePreviewResponse struct {
+	Items     []library.ParsePreview `json:"items"`
+	Truncated bool                   `json:"truncated"` // more than maxParsePreviewFiles files matched
+}
+
+// ParsePreview shows how filenames would be parsed by a scan, without
+// probing the files or touching the database
+// POST /api/library/parse-preview
+func (h *LibraryHandler) ParsePreview(c *gin.Context) {
+	var req ParsePreviewRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if len(req.Paths) == 0 && req.Directory == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "paths or directory is required"})
+		return
+	}
+	if len(req.Paths) > maxParsePreviewFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many paths"})
+		return
+	}
+
+	response := ParsePreviewResponse{Items: make([]library.ParsePreview, 0, len(req.Paths))}
+	for _, path := range req.Paths {
+		response.Items = append(response.Items, library.PreviewFilename(path))
+	}
+
+	if req.Directory != "" {
+		info, err := os.Stat(req.Directory)
+		if err != nil || !info.IsDir() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Directory not found"})
+			return
+		}
+		previews, truncated, err := library.PreviewDirectory(req.Directory, maxParsePreviewFiles-len(response.Items))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list directory"})
+			return
+		}
+		response.Items = append(response.Items, previews...)
+		response.Truncated = truncated
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// TriggerScan initiates a library scan. Unchanged files are skipped unless
+// force is set, which re-probes every file.
+// POST /api/library/scan?force=true
 func (h *LibraryHandler) TriggerScan(c *gin.Context) {
-	if h.scanner.IsRunning() {
+	force := c.Query("force") == "true"
+
+	// Run scan asynchronously
+	if !h.scanner.StartScanAll(force) {
 		c.JSON(http.StatusConflict, gin.H{
 			"message": "Scan already in progress",
 			"status":  "scanning",
@@ -124,27 +443,164 @@ func (h *LibraryHandler) TriggerScan(c *gin.Context) {
 		return
 	}
 
-	// Run scan asynchronously
-	go func() {
-		if err := h.scanner.ScanAll(); err != nil {
-			// Log error but don't fail - scan is async
-			println("Scan error:", err.Error())
-		}
-	}()
-
 	c.JSON(http.StatusAccepted, gin.H{
 		"message": "Library scan started",
 		"status":  "scanning",
 	})
 }
 
-// GetStats returns library statistics
+// StopScan stops the running scan or metadata refresh after the file or
+// item in progress
+// POST /api/library/scan/stop
+func (h *LibraryHandler) StopScan(c *gin.Context) {
+	if !h.scanner.StopJob() {
+		c.JSON(http.StatusConflict, gin.H{"error": "No scan in progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scan stopping"})
+}
+
+// RefreshMetadata re-fetches TMDB metadata in the background for the whole
+// library, or for one source or type. Progress is reported by ScanStatus.
+// refresh=true bypasses cached TMDB responses.
+// POST /api/library/refresh-metadata?source_id=&type=movie|tvshow&refresh=true
+func (h *LibraryHandler) RefreshMetadata(c *gin.Context) {
+	if !h.scanner.TMDBConfigured() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TMDB API key is not configured"})
+		return
+	}
+
+	opts := library.RefreshOptions{BypassCache: c.Query("refresh") == "true"}
+	if sourceID := c.Query("source_id"); sourceID != "" {
+		id, err := strconv.ParseInt(sourceID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+			return
+		}
+		if _, err := h.db.GetMediaSourceByID(id); err != nil {
+			if err == db.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Source not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source"})
+			return
+		}
+		opts.SourceID = id
+	}
+	switch mediaType := db.MediaType(c.Query("type")); mediaType {
+	case "", db.MediaTypeMovie, db.MediaTypeTVShow:
+		opts.Type = mediaType
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be movie or tvshow"})
+		return
+	}
+
+	if !h.scanner.StartMetadataRefresh(opts) {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "A scan or refresh is already in progress",
+			"status":  h.scanner.Status(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Metadata refresh started",
+		"status":  "refreshing",
+	})
+}
+
+// CleanupLibrary removes the movies, episodes and extras whose files are
+// gone, with the seasons and shows left empty, and returns what it removed.
+// dry_run=true only reports what would be removed.
+// POST /api/library/cleanup?dry_run=true
+func (h *LibraryHandler) CleanupLibrary(c *gin.Context) {
+	result, err := h.scanner.CleanupOrphans(c.Query("dry_run") == "true")
+	switch {
+	case errors.Is(err, library.ErrJobRunning):
+		c.JSON(http.StatusConflict, gin.H{
+			"message": "A scan or refresh is already in progress",
+			"status":  h.scanner.Status(),
+		})
+		return
+	case errors.Is(err, context.Canceled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cleanup was stopped"})
+		return
+	case err != nil:
+		log.Printf("Library cleanup error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clean up library"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetScanStatus returns the progress of the running scan or metadata
+// refresh, or of the last one
+// GET /api/library/scan/status
+func (h *LibraryHandler) GetScanStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.scanner.Status())
+}
+
+// scanEventInterval is the least time between scan progress events, so
+// scanning a large library doesn't send an event for every file
+const scanEventInterval = 250 * time.Millisecond
+
+// StreamScanStatus sends the scan or metadata refresh progress as
+// Server-Sent "status" events, each with the body of GetScanStatus. The
+// stream ends after the event reporting the job finished, or right after
+// the first one if nothing is running.
+// GET /api/library/scan/events
+func (h *LibraryHandler) StreamScanStatus(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ctx := c.Request.Context()
+	c.Stream(func(w io.Writer) bool {
+		// Subscribe before reading, so a change in between isn't missed
+		changed := h.scanner.StatusChanged()
+		status := h.scanner.Status()
+		c.SSEvent("status", status)
+		if !status.Running {
+			return false
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+		select {
+		case <-time.After(scanEventInterval):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// GetStats returns library counts, total size and runtime, and breakdowns by
+// resolution, video codec and source
+// GET /api/library/stats
 func (h *LibraryHandler) GetStats(c *gin.Context) {
-	stats, err := h.db.GetLibraryStats()
+	aggregates, err := h.db.GetLibraryAggregates()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
 		return
 	}
 
-	c.JSON(http.StatusOK, stats)
+	c.JSON(http.StatusOK, aggregates.LibraryStats)
+}
+
+// GetCounts returns cached library counts by type, genre, resolution and
+// year, for dashboards and browse filters
+// GET /api/library/counts
+func (h *LibraryHandler) GetCounts(c *gin.Context) {
+	aggregates, err := h.db.GetLibraryAggregates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, aggregates)
 }
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed logins allowed within the window, per username and per client IP.
+// Guesses against an account are counted however many addresses they come
+// from; the looser per-IP limit cuts off one client trying many usernames
+// without locking out other users behind the same NAT.
+const (
+	loginMaxFailures   = 5
+	loginMaxIPFailures = 20
+	loginFailureWindow = 15 * time.Minute
+)
+
+// loginLimiter counts failed logins in a sliding window, so password guessing
+// (and the bcrypt work it costs) is cut off before it gets far
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time // oldest first
+}
+
+func newLoginLimiter() *loginLimiter {
+	l := &loginLimiter{failures: make(map[string][]time.Time)}
+	go func() {
+		for range time.Tick(loginFailureWindow) {
+			l.cleanup()
+		}
+	}()
+	return l
+}
+
+func loginUserKey(username string) string {
+	return "user|" + strings.ToLower(username)
+}
+
+func loginIPKey(ip string) string {
+	return "ip|" + ip
+}
+
+// recent drops failures that left the window. The caller holds l.mu.
+func (l *loginLimiter) recent(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	for len(failures) > 0 && now.Sub(failures[0]) >= loginFailureWindow {
+		failures = failures[1:]
+	}
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
+
+// retryAfter returns how long until username may try again from ip, or 0 if
+// it may now
+func (l *loginLimiter) retryAfter(username, ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	return max(l.wait(loginUserKey(username), loginMaxFailures, now), l.wait(loginIPKey(ip), loginMaxIPFailures, now))
+}
+
+// wait returns how long until key is under limit failures again. The caller
+// holds l.mu.
+func (l *loginLimiter) wait(key string, limit int, now time.Time) time.Duration {
+	failures := l.recent(key, now)
+	if len(failures) < limit {
+		return 0
+	}
+	// Allowed again once enough failures have aged out of the window
+	return failures[len(failures)-limit].Add(loginFailureWindow).Sub(now)
+}
+
+// fail records a failed login
+func (l *loginLimiter) fail(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range []string{loginUserKey(username), loginIPKey(ip)} {
+		l.failures[key] = append(l.recent(key, now), now)
+	}
+}
+
+// succeed clears the failures of an account whose password was given right.
+// The IP's failures stay, as they may be guesses at other accounts.
+func (l *loginLimiter) succeed(username string) {
+	l.mu.Lock()
+	delete(l.failures, loginUserKey(username))
+	l.mu.Unlock()
+}
+
+// cleanup forgets keys whose failures have all left the window
+func (l *loginLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key := range l.failures {
+		l.recent(key, now)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// parseMediaRef accepts either a compound ref ("episode:12") or a bare ID.
+// For a bare ID the type comes from mediaType, defaulting to movie; for a
+// compound ref mediaType must be empty or agree with the ref.
+func parseMediaRef(value, mediaType string) (db.MediaRef, error) {
+	if strings.Contains(value, ":") {
+		ref, err := db.ParseMediaRef(value)
+		if err != nil {
+			return db.MediaRef{}, err
+		}
+		if mediaType != "" && db.MediaType(mediaType) != ref.Type {
+			return db.MediaRef{}, fmt.Errorf("media type %q does not match ref %s", mediaType, ref)
+		}
+		return ref, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return db.MediaRef{}, fmt.Errorf("invalid media ID %q", value)
+	}
+	if mediaType == "" {
+		mediaType = string(db.MediaTypeMovie)
+	}
+	if !db.MediaType(mediaType).Valid() {
+		return db.MediaRef{}, fmt.Errorf("%w %q", db.ErrInvalidMediaType, mediaType)
+	}
+	return db.MediaRef{Type: db.MediaType(mediaType), ID: id}, nil
+}
+
+// mediaRefParam reads a media ref from a path param, falling back to ?type=
+// for bare IDs. It writes a 400 response and returns false if invalid.
+func mediaRefParam(c *gin.Context, name string) (db.MediaRef, bool) {
+	ref, err := parseMediaRef(c.Param(name), c.Query("type"))
+	if err != nil {
+		badMediaRef(c, err)
+		return db.MediaRef{}, false
+	}
+	return ref, true
+}
+
+// badMediaRef writes the 400 response for a parseMediaRef error
+func badMediaRef(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrInvalidMediaType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media type"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+}
+
+// mediaTypeQuery reads ?type=, defaulting to movie. It writes a 400 response
+// and returns false for unknown types.
+func mediaTypeQuery(c *gin.Context) (db.MediaType, bool) {
+	mediaType := db.MediaType(c.DefaultQuery("type", string(db.MediaTypeMovie)))
+	if !mediaType.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media type"})
+		return "", false
+	}
+	return mediaType, true
+}
+
+// apiURL prefixes an API path with external_base_url, if configured, so
+// clients outside the server's network get usable absolute URLs
+func apiURL(cfg *config.Config, path string) string {
+	return strings.TrimRight(cfg.ExternalBaseURL, "/") + path
+}
+
+// transcodeKey returns the transcode directory name for a ref. Movies keep
+// the bare ID so existing transcode output stays valid.
+func transcodeKey(ref db.MediaRef) string {
+	if ref.Type == db.MediaTypeMovie {
+		return strconv.FormatInt(ref.ID, 10)
+	}
+	return fmt.Sprintf("%s-%d", ref.Type, ref.ID)
+}
+
+// trackKeySuffix matches the suffix of HLS keys transcoding another audio
+// track or burning in subtitles
+var trackKeySuffix = regexp.MustCompile(`(-a\d+)?(-s\d+)?$`)
+
+// parseTranscodeKey recovers the ref behind a transcode, HLS session or
+// download key
+func parseTranscodeKey(key string) (db.MediaRef, bool) {
+	if i := strings.Index(key, downloadKeyInfix); i >= 0 {
+		key = key[:i]
+	}
+	key = strings.TrimSuffix(strings.TrimSuffix(key, "-norm"), "-abr")
+	key = trackKeySuffix.ReplaceAllString(key, "")
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		return db.MediaRef{Type: db.MediaTypeMovie, ID: id}, true
+	}
+	typePart, idPart, ok := strings.Cut(key, "-")
+	if !ok {
+		return db.MediaRef{}, false
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || !db.MediaType(typePart).Valid() {
+		return db.MediaRef{}, false
+	}
+	return db.MediaRef{Type: db.MediaType(typePart), ID: id}, true
+}
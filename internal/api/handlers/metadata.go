@@ -8,6 +8,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -15,6 +16,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/internal/library"
 	"github.com/stephencjuliano/media-server/pkg/tmdb"
 )
 
@@ -26,7 +28,7 @@ type MetadataHandler struct {
 func NewMetadataHandler(database *db.DB, cfg *config.Config) *MetadataHandler {
 	return &MetadataHandler{
 		db:   database,
-		tmdb: tmdb.NewClient(cfg.TMDbAPIKey),
+		tmdb: library.NewTMDBClient(cfg),
 	}
 }
 
@@ -44,8 +46,7 @@ func (h *MetadataHandler) SearchTMDB(c *gin.Context) {
 		Year  int    `json:"year"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -58,14 +59,14 @@ func (h *MetadataHandler) SearchTMDB(c *gin.Context) {
 
 	// Search TMDB based on media type
 	if media.Type == db.MediaTypeMovie {
-		results, err := h.tmdb.SearchMovieWithResults(req.Title, req.Year)
+		results, err := h.tmdb.SearchMovieWithResultsContext(c.Request.Context(), req.Title, req.Year)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "TMDB search failed"})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"results": results})
 	} else if media.Type == db.MediaTypeTVShow {
-		results, err := h.tmdb.SearchTVWithResults(req.Title, req.Year)
+		results, err := h.tmdb.SearchTVWithResultsContext(c.Request.Context(), req.Title, req.Year)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "TMDB search failed"})
 			return
@@ -89,8 +90,7 @@ func (h *MetadataHandler) ApplyMetadata(c *gin.Context) {
 		TMDbID int `json:"tmdb_id" binding:"required"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -102,8 +102,9 @@ func (h *MetadataHandler) ApplyMetadata(c *gin.Context) {
 	}
 
 	// Fetch metadata from TMDB
+	var credits []db.Credit
 	if media.Type == db.MediaTypeMovie {
-		details, err := h.tmdb.GetMovieDetails(req.TMDbID)
+		details, err := h.tmdb.GetMovieDetailsContext(c.Request.Context(), req.TMDbID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch movie details"})
 			return
@@ -111,8 +112,9 @@ func (h *MetadataHandler) ApplyMetadata(c *gin.Context) {
 
 		// Apply metadata
 		h.applyMovieMetadata(media, details)
+		credits = library.CastCredits(details.Credits)
 	} else if media.Type == db.MediaTypeTVShow {
-		details, err := h.tmdb.GetTVDetails(req.TMDbID)
+		details, err := h.tmdb.GetTVDetailsContext(c.Request.Context(), req.TMDbID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch TV show details"})
 			return
@@ -127,12 +129,14 @@ func (h *MetadataHandler) ApplyMetadata(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update media"})
 		return
 	}
+	h.storeCredits(media, credits)
 
 	c.JSON(http.StatusOK, media)
 }
 
-// POST /api/media/:id/metadata/refresh
-// Force re-lookup metadata from TMDB using existing title/year
+// POST /api/media/:id/metadata/refresh?refresh=true
+// Force re-lookup metadata from TMDB using existing title/year. Cached TMDB
+// responses are used unless refresh is set.
 func (h *MetadataHandler) RefreshMetadata(c *gin.Context) {
 	mediaID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -140,6 +144,11 @@ func (h *MetadataHandler) RefreshMetadata(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	if c.Query("refresh") == "true" {
+		ctx = tmdb.BypassCache(ctx)
+	}
+
 	media, err := h.db.GetMediaByID(mediaID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
@@ -147,30 +156,32 @@ func (h *MetadataHandler) RefreshMetadata(c *gin.Context) {
 	}
 
 	// Search using existing title and year
+	var credits []db.Credit
 	if media.Type == db.MediaTypeMovie {
-		result, err := h.tmdb.SearchMovie(media.Title, media.Year)
+		result, err := h.tmdb.SearchMovieContext(ctx, media.Title, media.Year)
 		if err != nil || result == nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "No match found on TMDB"})
 			return
 		}
 
 		// Get full details
-		details, err := h.tmdb.GetMovieDetails(result.ID)
+		details, err := h.tmdb.GetMovieDetailsContext(ctx, result.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch details"})
 			return
 		}
 
 		h.applyMovieMetadata(media, details)
+		credits = library.CastCredits(details.Credits)
 	} else if media.Type == db.MediaTypeTVShow {
-		result, err := h.tmdb.SearchTV(media.Title, media.Year)
+		result, err := h.tmdb.SearchTVContext(ctx, media.Title, media.Year)
 		if err != nil || result == nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "No match found on TMDB"})
 			return
 		}
 
 		// Get full details
-		details, err := h.tmdb.GetTVDetails(result.ID)
+		details, err := h.tmdb.GetTVDetailsContext(ctx, result.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch details"})
 			return
@@ -184,11 +195,22 @@ func (h *MetadataHandler) RefreshMetadata(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update media"})
 		return
 	}
+	h.storeCredits(media, credits)
 
 	c.JSON(http.StatusOK, media)
 }
 
 // Helper functions
+
+// storeCredits replaces a movie's cast; nil credits leave it alone
+func (h *MetadataHandler) storeCredits(media *db.Media, credits []db.Credit) {
+	if credits == nil {
+		return
+	}
+	if err := h.db.ReplaceCredits(db.MediaRef{Type: db.MediaTypeMovie, ID: media.ID}, credits); err != nil {
+		log.Printf("Failed to store credits for %s: %v", media.Title, err)
+	}
+}
 func (h *MetadataHandler) applyMovieMetadata(media *db.Media, details *tmdb.MovieDetails) {
 	media.Title = details.Title
 	media.OriginalTitle = details.OriginalTitle
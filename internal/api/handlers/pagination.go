@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+
+	// maxOffset rejects offsets no library could reach, which would otherwise
+	// just make SQLite walk the whole table to return nothing
+	maxOffset = 1000000
+)
+
+// parseLimit reads ?limit=, clamping it to [1, maxLimit]. Non-numeric or
+// negative values get a 400 response and ok=false.
+func parseLimit(c *gin.Context, defaultLimit, maxLimit int) (int, bool) {
+	limit := defaultLimit
+	if l := c.Query("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return 0, false
+		}
+		limit = parsed
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, true
+}
+
+// parsePagination reads ?limit= and ?offset= for list endpoints. The limit is
+// clamped as in parseLimit; the offset must be between 0 and maxOffset.
+func parsePagination(c *gin.Context, defaultLimit, maxLimit int) (limit, offset int, ok bool) {
+	limit, ok = parseLimit(c, defaultLimit, maxLimit)
+	if !ok {
+		return 0, 0, false
+	}
+
+	if o := c.Query("offset"); o != "" {
+		parsed, err := strconv.Atoi(o)
+		if err != nil || parsed < 0 || parsed > maxOffset {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return limit, offset, true
+}
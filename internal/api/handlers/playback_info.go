@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// PlaybackInfo describes a media file for handing playback off to an
+// external player (Infuse, VLC) instead of using the HLS stream
+type PlaybackInfo struct {
+	Ref        string                  `json:"ref"`
+	Title      string                  `json:"title"`
+	DirectURL  string                  `json:"direct_url,omitempty"` // empty when the file can't be served as-is
+	StreamURL  string                  `json:"stream_url"`
+	Container  string                  `json:"container"`
+	MimeType   string                  `json:"mime_type"`
+	VideoCodec string                  `json:"video_codec,omitempty"`
+	AudioCodec string                  `json:"audio_codec,omitempty"`
+	Resolution string                  `json:"resolution,omitempty"`
+	Duration   int                     `json:"duration"`
+	FileSize   int64                   `json:"file_size"`
+	Audio      []PlaybackAudioTrack    `json:"audio_tracks"`
+	Subtitles  []PlaybackSubtitleTrack `json:"subtitle_tracks"`
+}
+
+// PlaybackAudioTrack is an audio stream of the file
+type PlaybackAudioTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language,omitempty"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels"`
+	Title    string `json:"title,omitempty"`
+}
+
+// PlaybackSubtitleTrack is a subtitle stream of the file, or a sidecar
+// subtitle file next to it. Text tracks have a WebVTT URL; image-based
+// tracks are only available inside the file.
+type PlaybackSubtitleTrack struct {
+	Index    int    `json:"index"` // stream index, or position among the sidecar files
+	Language string `json:"language,omitempty"`
+	Codec    string `json:"codec"`
+	Title    string `json:"title,omitempty"`
+	Forced   bool   `json:"forced"`
+	External bool   `json:"external"`
+	URL      string `json:"url,omitempty"`
+}
+
+// GetPlaybackInfo returns the direct file URL, format and tracks of a media
+// item. URLs honour external_base_url; external players can't send headers,
+// so clients append ?token= when building the handoff URL.
+// GET /api/media/:id/playback-info
+func (h *StreamHandler) GetPlaybackInfo(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+
+	info := PlaybackInfo{
+		Ref:        ref.String(),
+		Title:      h.downloadName(ref),
+		StreamURL:  apiURL(h.cfg, "/api/stream/"+ref.String()+"/manifest.m3u8"),
+		Container:  strings.TrimPrefix(strings.ToLower(filepath.Ext(file.FilePath)), "."),
+		MimeType:   h.getContentType(file.FilePath),
+		VideoCodec: file.VideoCodec,
+		AudioCodec: file.AudioCodec,
+		Resolution: file.Resolution,
+		Duration:   file.Duration,
+		FileSize:   file.FileSize,
+		Audio:      []PlaybackAudioTrack{},
+		Subtitles:  []PlaybackSubtitleTrack{},
+	}
+	if !ffmpeg.IsConcatInput(file.FilePath) {
+		info.DirectURL = apiURL(h.cfg, "/api/stream/"+ref.String()+"/direct")
+	}
+
+	var audioTracks []ffmpeg.AudioTrack
+	json.Unmarshal([]byte(file.AudioTracks), &audioTracks)
+	for _, track := range audioTracks {
+		info.Audio = append(info.Audio, PlaybackAudioTrack{
+			Index:    track.Index,
+			Language: normalizeLanguage(track.Language),
+			Codec:    track.Codec,
+			Channels: track.Channels,
+			Title:    track.Title,
+		})
+	}
+
+	var subtitleTracks []ffmpeg.SubtitleTrack
+	json.Unmarshal([]byte(file.SubtitleTracks), &subtitleTracks)
+	for i := range subtitleTracks {
+		track := &subtitleTracks[i]
+		subtitle := PlaybackSubtitleTrack{
+			Index:    track.Index,
+			Language: normalizeLanguage(track.Language),
+			Codec:    track.Codec,
+			Title:    track.Title,
+			Forced:   track.Forced,
+		}
+		if textSubtitleCodecs[track.Codec] {
+			subtitle.URL = apiURL(h.cfg, "/api/stream/"+ref.String()+"/subtitles/"+subtitleKey(track)+".vtt")
+		}
+		info.Subtitles = append(info.Subtitles, subtitle)
+	}
+
+	// Sidecar files are served under keys no embedded track or earlier
+	// sidecar uses
+	external, _ := h.db.GetExternalSubtitles(ref)
+	listed := make(map[string]bool)
+	for i, sub := range external {
+		key := externalSubtitleKey(sub)
+		if listed[key] || subtitleTrackByKey(file.SubtitleTracks, key) != nil {
+			continue
+		}
+		listed[key] = true
+		info.Subtitles = append(info.Subtitles, PlaybackSubtitleTrack{
+			Index:    i,
+			Language: normalizeLanguage(sub.Language),
+			Codec:    sub.Format,
+			Title:    filepath.Base(sub.FilePath),
+			Forced:   sub.Forced,
+			External: true,
+			URL:      apiURL(h.cfg, "/api/stream/"+ref.String()+"/subtitles/"+key+".vtt"),
+		})
+	}
+
+	c.JSON(http.StatusOK, info)
+}
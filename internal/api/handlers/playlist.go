@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -16,10 +17,13 @@ func NewPlaylistHandler(database *db.DB) *PlaylistHandler {
 	return &PlaylistHandler{db: database}
 }
 
-// CreatePlaylistRequest represents the request body for creating a playlist
+// CreatePlaylistRequest represents the request body for creating a playlist.
+// Smart playlists hold whatever matches their rules instead of added items.
 type CreatePlaylistRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description"`
+	Smart       bool              `json:"smart"`
+	Rules       []db.PlaylistRule `json:"rules"`
 }
 
 // ReorderRequest represents the request body for reordering playlist items
@@ -83,12 +87,24 @@ func (h *PlaylistHandler) CreatePlaylist(c *gin.Context) {
 	userID := c.GetInt64("user_id")
 
 	var req CreatePlaylistRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !req.Smart && req.Rules != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only smart playlists have rules"})
+		return
+	}
+	if !validatePlaylistRules(c, req.Rules) {
 		return
 	}
 
-	playlist, err := h.db.CreatePlaylist(userID, req.Name, req.Description)
+	var playlist *db.Playlist
+	var err error
+	if req.Smart {
+		playlist, err = h.db.CreateSmartPlaylist(userID, req.Name, req.Description, req.Rules)
+	} else {
+		playlist, err = h.db.CreatePlaylist(userID, req.Name, req.Description)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create playlist"})
 		return
@@ -97,7 +113,8 @@ func (h *PlaylistHandler) CreatePlaylist(c *gin.Context) {
 	c.JSON(http.StatusCreated, playlist)
 }
 
-// UpdatePlaylist updates a playlist's name and description
+// UpdatePlaylist updates a playlist's name and description, and a smart
+// playlist's rules if given
 func (h *PlaylistHandler) UpdatePlaylist(c *gin.Context) {
 	userID := c.GetInt64("user_id")
 	playlistID, err := strconv.ParseInt(c.Param("playlistId"), 10, 64)
@@ -122,8 +139,14 @@ func (h *PlaylistHandler) UpdatePlaylist(c *gin.Context) {
 	}
 
 	var req CreatePlaylistRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !playlist.Smart && req.Rules != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only smart playlists have rules"})
+		return
+	}
+	if !validatePlaylistRules(c, req.Rules) {
 		return
 	}
 
@@ -131,6 +154,12 @@ func (h *PlaylistHandler) UpdatePlaylist(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update playlist"})
 		return
 	}
+	if playlist.Smart && req.Rules != nil {
+		if err := h.db.UpdatePlaylistRules(playlistID, req.Rules); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update playlist"})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Playlist updated"})
 }
@@ -195,16 +224,114 @@ func (h *PlaylistHandler) AddToPlaylist(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
+	if rejectSmartPlaylist(c, playlist) {
+		return
+	}
 
 	// Get media type from query param, default to "movie"
-	mediaType := db.MediaType(c.DefaultQuery("type", "movie"))
+	mediaType, ok := mediaTypeQuery(c)
+	if !ok {
+		return
+	}
 
-	if err := h.db.AddToPlaylist(playlistID, mediaID, mediaType); err != nil {
+	added, err := h.db.AddToPlaylist(playlistID, mediaID, mediaType)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to playlist"})
 		return
 	}
+	if !added {
+		c.JSON(http.StatusConflict, gin.H{"message": "Already in playlist", "added": false})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Added to playlist"})
+	c.JSON(http.StatusOK, gin.H{"message": "Added to playlist", "added": true})
+}
+
+// AddShowToPlaylist appends every episode of a show to a playlist, in
+// season and episode order (or ?sort=aired), skipping ones already in it
+func (h *PlaylistHandler) AddShowToPlaylist(c *gin.Context) {
+	showID, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return
+	}
+	if _, err := h.db.GetTVShowByID(showID); err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Show not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch show"})
+		return
+	}
+
+	h.addEpisodesToPlaylist(c, func() ([]*db.Episode, error) {
+		return h.db.GetEpisodesByShowID(showID, episodeListOptions(c))
+	})
+}
+
+// AddSeasonToPlaylist appends every episode of a season to a playlist, in
+// episode order (or ?sort=aired), skipping ones already in it
+func (h *PlaylistHandler) AddSeasonToPlaylist(c *gin.Context) {
+	seasonID, err := strconv.ParseInt(c.Param("seasonId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid season ID"})
+		return
+	}
+	if _, err := h.db.GetSeasonByID(seasonID); err != nil {
+		if err == db.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch season"})
+		return
+	}
+
+	h.addEpisodesToPlaylist(c, func() ([]*db.Episode, error) {
+		return h.db.GetEpisodesBySeasonID(seasonID, episodeListOptions(c))
+	})
+}
+
+// addEpisodesToPlaylist appends the listed episodes to the user's playlist
+// in the request and responds with how many were added
+func (h *PlaylistHandler) addEpisodesToPlaylist(c *gin.Context, listEpisodes func() ([]*db.Episode, error)) {
+	userID := c.GetInt64("user_id")
+	playlistID, err := strconv.ParseInt(c.Param("playlistId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return
+	}
+
+	// Check playlist ownership
+	playlist, err := h.db.GetPlaylistByID(playlistID)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch playlist"})
+		return
+	}
+	if playlist.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if rejectSmartPlaylist(c, playlist) {
+		return
+	}
+
+	episodes, err := listEpisodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
+		return
+	}
+
+	added, err := h.db.AddEpisodesToPlaylist(playlistID, episodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to playlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Added %d episode(s) to playlist", added), "added": added})
 }
 
 // RemoveFromPlaylist removes a media item from a playlist
@@ -235,9 +362,15 @@ func (h *PlaylistHandler) RemoveFromPlaylist(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
+	if rejectSmartPlaylist(c, playlist) {
+		return
+	}
 
 	// Get media type from query param, default to "movie"
-	mediaType := db.MediaType(c.DefaultQuery("type", "movie"))
+	mediaType, ok := mediaTypeQuery(c)
+	if !ok {
+		return
+	}
 
 	if err := h.db.RemoveFromPlaylist(playlistID, mediaID, mediaType); err != nil {
 		if err == db.ErrNotFound {
@@ -274,10 +407,12 @@ func (h *PlaylistHandler) ReorderPlaylist(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
+	if rejectSmartPlaylist(c, playlist) {
+		return
+	}
 
 	var req ReorderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -288,3 +423,36 @@ func (h *PlaylistHandler) ReorderPlaylist(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Playlist reordered"})
 }
+
+// rejectSmartPlaylist responds with an error, and returns true, if playlist
+// is smart: its items come from its rules, so they can't be edited
+func rejectSmartPlaylist(c *gin.Context, playlist *db.Playlist) bool {
+	if !playlist.Smart {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Smart playlists are filled by their rules"})
+	return true
+}
+
+// validatePlaylistRules checks smart playlist rules like section rules,
+// responding with an error if they're invalid. Regex rules can't be
+// evaluated in SQL, so playlists don't support them.
+func validatePlaylistRules(c *gin.Context, rules []db.PlaylistRule) bool {
+	for _, rule := range rules {
+		if !db.ValidPlaylistRuleField(rule.Field) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid rule field %q", rule.Field)})
+			return false
+		}
+		if rule.Operator == db.OperatorRegex {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Smart playlists don't support regex rules"})
+			return false
+		}
+		switch rule.Conjunction {
+		case "", db.ConjunctionAnd, db.ConjunctionOr:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conjunction (use and or or)"})
+			return false
+		}
+	}
+	return true
+}
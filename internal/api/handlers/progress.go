@@ -2,24 +2,25 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
 )
 
 type ProgressHandler struct {
-	db *db.DB
+	db  *db.DB
+	cfg *config.Config
 }
 
-func NewProgressHandler(database *db.DB) *ProgressHandler {
-	return &ProgressHandler{db: database}
+func NewProgressHandler(database *db.DB, cfg *config.Config) *ProgressHandler {
+	return &ProgressHandler{db: database, cfg: cfg}
 }
 
 type UpdateProgressRequest struct {
 	Position  int    `json:"position" binding:"required,min=0"`
 	Duration  int    `json:"duration" binding:"required,min=0"`
-	MediaType string `json:"media_type" binding:"required,oneof=movie tvshow episode"`
+	MediaType string `json:"media_type" binding:"omitempty,oneof=movie tvshow episode"` // optional when the path holds a ref
 	Completed bool   `json:"completed"`
 }
 
@@ -28,27 +29,29 @@ type ContinueWatchingItem struct {
 	Progress *db.WatchProgress `json:"progress"`
 }
 
+// MostWatchedItem is a played movie or episode with its play count in Progress
+type MostWatchedItem struct {
+	Ref      string            `json:"ref"`
+	Media    *db.Media         `json:"media,omitempty"`
+	Episode  *db.Episode       `json:"episode,omitempty"`
+	Progress *db.WatchProgress `json:"progress"`
+}
+
 // GetProgress returns the watch progress for a media item
 func (h *ProgressHandler) GetProgress(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("mediaId")
-	mediaType := c.Query("type")
 
-	if mediaType == "" {
-		mediaType = "movie"
-	}
-
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	ref, ok := mediaRefParam(c, "mediaId")
+	if !ok {
 		return
 	}
 
-	progress, err := h.db.GetWatchProgress(userID.(int64), mediaID, db.MediaType(mediaType))
+	progress, err := h.db.GetWatchProgress(userID.(int64), ref.ID, ref.Type)
 	if err == db.ErrNotFound {
 		// Return empty progress
 		c.JSON(http.StatusOK, gin.H{
-			"media_id":  mediaID,
+			"media_id":  ref.ID,
+			"ref":       ref.String(),
 			"position":  0,
 			"duration":  0,
 			"completed": false,
@@ -66,30 +69,28 @@ func (h *ProgressHandler) GetProgress(c *gin.Context) {
 // UpdateProgress updates the watch progress for a media item
 func (h *ProgressHandler) UpdateProgress(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("mediaId")
 
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	var req UpdateProgressRequest
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	var req UpdateProgressRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	ref, err := parseMediaRef(c.Param("mediaId"), req.MediaType)
+	if err != nil {
+		badMediaRef(c, err)
 		return
 	}
 
-	// Auto-mark as completed if near the end (95%)
+	// Auto-mark as completed once past the watched threshold
 	completed := req.Completed
-	if req.Duration > 0 && float64(req.Position)/float64(req.Duration) > 0.95 {
+	if req.Duration > 0 && req.Position*100 > req.Duration*h.cfg.WatchedThresholdPercent {
 		completed = true
 	}
 
 	err = h.db.UpsertWatchProgress(
 		userID.(int64),
-		mediaID,
-		db.MediaType(req.MediaType),
+		ref.ID,
+		ref.Type,
 		req.Position,
 		req.Duration,
 		completed,
@@ -100,7 +101,8 @@ func (h *ProgressHandler) UpdateProgress(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"media_id":  mediaID,
+		"media_id":  ref.ID,
+		"ref":       ref.String(),
 		"position":  req.Position,
 		"duration":  req.Duration,
 		"completed": completed,
@@ -110,13 +112,13 @@ func (h *ProgressHandler) UpdateProgress(c *gin.Context) {
 // GetContinueWatching returns in-progress media for the current user
 func (h *ProgressHandler) GetContinueWatching(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-
-	if limit > 20 {
-		limit = 20
+	limit, ok := parseLimit(c, 10, 20)
+	if !ok {
+		return
 	}
 
-	progressItems, err := h.db.GetContinueWatching(userID.(int64), limit)
+	progressItems, err := h.db.GetContinueWatching(userID.(int64), limit,
+		h.cfg.ContinueWatchingMinSeconds, h.cfg.WatchedThresholdPercent)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch continue watching"})
 		return
@@ -137,3 +139,70 @@ func (h *ProgressHandler) GetContinueWatching(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
+
+// RecordPlay counts a play of a media item (a scrobble) for clients that
+// track completion themselves, and marks it watched
+// POST /api/media/:id/played
+func (h *ProgressHandler) RecordPlay(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	playCount, err := h.db.RecordPlay(userID, ref.ID, ref.Type)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record play"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"media_id":   ref.ID,
+		"ref":        ref.String(),
+		"play_count": playCount,
+	})
+}
+
+// GetMostWatched returns the current user's most played items
+// GET /api/library/most-watched?limit=20
+func (h *ProgressHandler) GetMostWatched(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+	limit, ok := parseLimit(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
+	}
+
+	progressItems, err := h.db.GetMostWatched(userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch most watched"})
+		return
+	}
+
+	items := []MostWatchedItem{}
+	for _, p := range progressItems {
+		item := MostWatchedItem{
+			Ref:      db.MediaRef{Type: p.MediaType, ID: p.MediaID}.String(),
+			Progress: p,
+		}
+		switch p.MediaType {
+		case db.MediaTypeEpisode:
+			episode, err := h.db.GetEpisodeByID(p.MediaID)
+			if err != nil {
+				continue
+			}
+			item.Episode = episode
+		case db.MediaTypeMovie:
+			media, err := h.db.GetMediaByID(p.MediaID)
+			if err != nil {
+				continue
+			}
+			item.Media = media
+		default:
+			continue
+		}
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
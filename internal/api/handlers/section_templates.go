@@ -49,8 +49,7 @@ func (h *SectionTemplateHandler) CreateFromTemplate(c *gin.Context) {
 		Variables  map[string]string `json:"variables"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -92,18 +91,30 @@ func (h *SectionTemplateHandler) CreateFromTemplate(c *gin.Context) {
 		return
 	}
 
-	// Create rules with variable substitution
+	// Create rules with variable substitution, using the defaults of
+	// variables left out
+	variables := make(map[string]string)
+	for _, variable := range template.Variables {
+		if variable.Default != "" {
+			variables[variable.Name] = variable.Default
+		}
+	}
+	for name, value := range req.Variables {
+		variables[name] = value
+	}
 	for _, rule := range template.Rules {
 		newRule := db.SectionRule{
-			SectionID: section.ID,
-			Field:     rule.Field,
-			Operator:  rule.Operator,
-			Value:     rule.Value,
+			SectionID:   section.ID,
+			Field:       rule.Field,
+			Operator:    rule.Operator,
+			Value:       rule.Value,
+			Group:       rule.Group,
+			Conjunction: rule.Conjunction,
 		}
 
 		// Apply variable substitutions if provided
-		if len(req.Variables) > 0 {
-			newRule.Value = applyVariables(rule.Value, req.Variables)
+		if len(variables) > 0 {
+			newRule.Value = applyVariables(rule.Value, variables)
 		}
 
 		if err := h.db.CreateSectionRule(&newRule); err != nil {
@@ -153,6 +164,19 @@ func (h *SectionTemplateHandler) getTemplateList() []SectionTemplate {
 				{Name: "min_year", Description: "Minimum year", Type: "number", Default: "2020"},
 			},
 		},
+		{
+			ID:          "added-this-week",
+			Name:        "Added This Week",
+			Description: "Movies and shows added in the last week",
+			Icon:        "clock",
+			SectionType: db.SectionTypeSmart,
+			Rules: []db.SectionRule{
+				{Field: "added", Operator: db.OperatorWithinDays, Value: "{{days}}"},
+			},
+			Variables: []TemplateVariable{
+				{Name: "days", Description: "Days since added", Type: "number", Default: "7"},
+			},
+		},
 		{
 			ID:          "by-genre",
 			Name:        "Genre Collection",
@@ -203,6 +227,20 @@ func (h *SectionTemplateHandler) getTemplateList() []SectionTemplate {
 				{Field: "genres", Operator: db.OperatorContains, Value: "\"Documentary\""},
 			},
 		},
+		{
+			ID:          "exclude-documentaries",
+			Name:        "Exclude Documentaries",
+			Description: "Movies that aren't documentaries",
+			Icon:        "film",
+			SectionType: db.SectionTypeSmart,
+			Rules: []db.SectionRule{
+				{Field: "type", Operator: db.OperatorEquals, Value: "\"movie\""},
+				{Field: "genres", Operator: db.OperatorNotContains, Value: "\"{{genre}}\""},
+			},
+			Variables: []TemplateVariable{
+				{Name: "genre", Description: "Genre to leave out", Type: "string", Default: "Documentary"},
+			},
+		},
 		{
 			ID:          "classics",
 			Name:        "Classic Films",
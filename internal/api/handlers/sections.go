@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"regexp"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -95,8 +98,7 @@ func (h *SectionHandler) GetSectionBySlug(c *gin.Context) {
 func (h *SectionHandler) CreateSection(c *gin.Context) {
 	var section db.Section
 
-	if err := c.ShouldBindJSON(&section); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &section) {
 		return
 	}
 
@@ -133,8 +135,7 @@ func (h *SectionHandler) UpdateSection(c *gin.Context) {
 	}
 
 	var section db.Section
-	if err := c.ShouldBindJSON(&section); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &section) {
 		return
 	}
 
@@ -174,19 +175,9 @@ func (h *SectionHandler) GetSectionMedia(c *gin.Context) {
 		return
 	}
 
-	limit := 50
-	offset := 0
-
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
-	}
-
-	if o := c.Query("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
-		}
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
 	}
 
 	media, total, err := h.db.GetMediaBySectionID(id, limit, offset)
@@ -214,19 +205,9 @@ func (h *SectionHandler) GetSectionMediaBySlug(c *gin.Context) {
 		return
 	}
 
-	limit := 50
-	offset := 0
-
-	if l := c.Query("limit"); l != "" {
-		if parsed, err := strconv.Atoi(l); err == nil {
-			limit = parsed
-		}
-	}
-
-	if o := c.Query("offset"); o != "" {
-		if parsed, err := strconv.Atoi(o); err == nil {
-			offset = parsed
-		}
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
 	}
 
 	media, total, err := h.db.GetMediaBySectionID(section.ID, limit, offset)
@@ -257,8 +238,12 @@ func (h *SectionHandler) AddMediaToSection(c *gin.Context) {
 		MediaType db.MediaType `json:"media_type" binding:"required"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	if !req.MediaType.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media type"})
 		return
 	}
 
@@ -285,9 +270,9 @@ func (h *SectionHandler) RemoveMediaFromSection(c *gin.Context) {
 		return
 	}
 
-	mediaType := db.MediaType(c.Query("type"))
-	if mediaType == "" {
-		mediaType = db.MediaTypeMovie
+	mediaType, ok := mediaTypeQuery(c)
+	if !ok {
+		return
 	}
 
 	if err := h.db.RemoveMediaFromSection(mediaID, mediaType, sectionID); err != nil {
@@ -326,8 +311,25 @@ func (h *SectionHandler) AddSectionRule(c *gin.Context) {
 	}
 
 	var rule db.SectionRule
-	if err := c.ShouldBindJSON(&rule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &rule) {
+		return
+	}
+	if !db.ValidRuleField(rule.Field) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid rule field %q", rule.Field)})
+		return
+	}
+	if rule.Operator == db.OperatorRegex {
+		var pattern string
+		json.Unmarshal([]byte(rule.Value), &pattern)
+		if _, err := regexp.Compile(pattern); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid regex: " + err.Error()})
+			return
+		}
+	}
+	switch rule.Conjunction {
+	case "", db.ConjunctionAnd, db.ConjunctionOr:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conjunction (use and or or)"})
 		return
 	}
 
@@ -365,8 +367,7 @@ func (h *SectionHandler) ReorderSections(c *gin.Context) {
 		SectionIDs []int64 `json:"section_ids" binding:"required"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -1,20 +1,27 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/internal/library"
 )
 
 type ShowsHandler struct {
-	db *db.DB
+	db      *db.DB
+	cfg     *config.Config
+	scanner *library.Scanner
 }
 
-func NewShowsHandler(database *db.DB) *ShowsHandler {
+func NewShowsHandler(database *db.DB, cfg *config.Config, scanner *library.Scanner) *ShowsHandler {
 	return &ShowsHandler{
-		db: database,
+		db:      database,
+		cfg:     cfg,
+		scanner: scanner,
 	}
 }
 
@@ -26,11 +33,9 @@ type ShowDetail struct {
 
 // GetShows returns all TV shows with counts
 func (h *ShowsHandler) GetShows(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-
-	if limit > 100 {
-		limit = 100
+	limit, offset, ok := parsePagination(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
 	}
 
 	shows, total, err := h.db.GetAllTVShows(limit, offset)
@@ -66,13 +71,13 @@ func (h *ShowsHandler) GetShow(c *gin.Context) {
 		return
 	}
 
-	seasons, err := h.db.GetSeasonsByShowID(id)
+	seasons, err := h.db.GetSeasonsByShowID(id, h.cfg.SpecialsLast)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seasons"})
 		return
 	}
 
-	c.JSON(http.StatusOK, ShowDetail{
+	jsonWithETag(c, ShowDetail{
 		TVShow:  show,
 		Seasons: seasons,
 	})
@@ -87,7 +92,7 @@ func (h *ShowsHandler) GetSeasons(c *gin.Context) {
 		return
 	}
 
-	seasons, err := h.db.GetSeasonsByShowID(id)
+	seasons, err := h.db.GetSeasonsByShowID(id, h.cfg.SpecialsLast)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seasons"})
 		return
@@ -125,6 +130,44 @@ func (h *ShowsHandler) GetSeason(c *gin.Context) {
 	c.JSON(http.StatusOK, season)
 }
 
+// prepareEpisodes fills in the show artwork, runtimes and playback URLs on
+// episodes before they're returned
+func (h *ShowsHandler) prepareEpisodes(episodes ...*db.Episode) error {
+	if err := h.db.AttachShowArtwork(episodes...); err != nil {
+		return err
+	}
+	fillEpisodeRuntimes(episodes...)
+	h.setStreamURLs(episodes...)
+	return nil
+}
+
+// fillEpisodeRuntimes falls back to the probed file duration, in minutes,
+// for episodes TMDB has no runtime for
+func fillEpisodeRuntimes(episodes ...*db.Episode) {
+	for _, episode := range episodes {
+		if episode.Runtime == 0 && episode.Duration > 0 {
+			episode.Runtime = (episode.Duration + 30) / 60
+		}
+	}
+}
+
+// setStreamURLs sets the HLS and direct play URLs on episodes
+func (h *ShowsHandler) setStreamURLs(episodes ...*db.Episode) {
+	for _, episode := range episodes {
+		id := strconv.FormatInt(episode.ID, 10)
+		episode.StreamURL = apiURL(h.cfg, "/api/stream/"+id+"/manifest.m3u8?type=episode")
+		episode.DirectURL = apiURL(h.cfg, "/api/stream/"+id+"/direct?type=episode")
+	}
+}
+
+// episodeListOptions reads ?hide_unaired=true and ?sort=aired for episode listings
+func episodeListOptions(c *gin.Context) db.EpisodeListOptions {
+	return db.EpisodeListOptions{
+		HideUnaired:   c.Query("hide_unaired") == "true",
+		SortByAirDate: c.Query("sort") == "aired",
+	}
+}
+
 // GetEpisodes returns all episodes for a season
 func (h *ShowsHandler) GetEpisodes(c *gin.Context) {
 	showIDStr := c.Param("showId")
@@ -152,11 +195,15 @@ func (h *ShowsHandler) GetEpisodes(c *gin.Context) {
 		return
 	}
 
-	episodes, err := h.db.GetEpisodesBySeasonID(season.ID)
+	episodes, err := h.db.GetEpisodesBySeasonID(season.ID, episodeListOptions(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
 		return
 	}
+	if err := h.prepareEpisodes(episodes...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"items": episodes})
 }
@@ -170,11 +217,15 @@ func (h *ShowsHandler) GetAllEpisodes(c *gin.Context) {
 		return
 	}
 
-	episodes, err := h.db.GetEpisodesByShowID(id)
+	episodes, err := h.db.GetEpisodesByShowID(id, episodeListOptions(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
 		return
 	}
+	if err := h.prepareEpisodes(episodes...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episodes"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"items": episodes})
 }
@@ -197,11 +248,15 @@ func (h *ShowsHandler) GetEpisode(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episode"})
 		return
 	}
+	if err := h.prepareEpisodes(episode); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episode"})
+		return
+	}
 
-	c.JSON(http.StatusOK, episode)
+	jsonWithETag(c, episode)
 }
 
-// RandomEpisodeResponse includes show info with the random episode
+// RandomEpisodeResponse includes show info with a random or up-next episode
 type RandomEpisodeResponse struct {
 	Episode   *db.Episode `json:"episode"`
 	ShowTitle string      `json:"show_title"`
@@ -236,6 +291,11 @@ func (h *ShowsHandler) GetRandomEpisode(c *gin.Context) {
 		return
 	}
 
+	episode.ShowPosterPath = show.PosterPath
+	episode.ShowBackdropPath = show.BackdropPath
+	fillEpisodeRuntimes(episode)
+	h.setStreamURLs(episode)
+
 	c.JSON(http.StatusOK, RandomEpisodeResponse{
 		Episode:   episode,
 		ShowTitle: show.Title,
@@ -288,8 +348,223 @@ func (h *ShowsHandler) GetRandomEpisodeFromSeason(c *gin.Context) {
 		return
 	}
 
+	episode.ShowPosterPath = show.PosterPath
+	episode.ShowBackdropPath = show.BackdropPath
+	fillEpisodeRuntimes(episode)
+	h.setStreamURLs(episode)
+
 	c.JSON(http.StatusOK, RandomEpisodeResponse{
 		Episode:   episode,
 		ShowTitle: show.Title,
 	})
 }
+
+// GetUpNext returns the episode after the last one the current user finished,
+// or the first episode if they haven't started the show
+// GET /api/shows/:showId/up-next
+func (h *ShowsHandler) GetUpNext(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return
+	}
+
+	show, err := h.db.GetTVShowByID(id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Show not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch show"})
+		return
+	}
+
+	episode, err := h.db.GetUpNextEpisode(c.GetInt64("user_id"), id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No unwatched episodes"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get next episode"})
+		return
+	}
+
+	episode.ShowPosterPath = show.PosterPath
+	episode.ShowBackdropPath = show.BackdropPath
+	fillEpisodeRuntimes(episode)
+	h.setStreamURLs(episode)
+
+	c.JSON(http.StatusOK, RandomEpisodeResponse{
+		Episode:   episode,
+		ShowTitle: show.Title,
+	})
+}
+
+// GetCredits returns a show's top-billed cast, which is empty until it's been
+// matched on TMDB
+// GET /api/shows/:showId/credits
+func (h *ShowsHandler) GetCredits(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return
+	}
+
+	if _, err := h.db.GetTVShowByID(id); err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Show not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch show"})
+		return
+	}
+
+	credits, err := h.db.GetCredits(db.MediaRef{Type: db.MediaTypeTVShow, ID: id})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": credits})
+}
+
+// seasonParam looks up the season named by the :showId and :seasonNum path
+// params. It writes an error response and returns false if there isn't one.
+func (h *ShowsHandler) seasonParam(c *gin.Context) (*db.Season, bool) {
+	showID, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return nil, false
+	}
+	seasonNum, err := strconv.Atoi(c.Param("seasonNum"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid season number"})
+		return nil, false
+	}
+
+	season, err := h.db.GetSeasonByNumber(showID, seasonNum)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Season not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch season"})
+		return nil, false
+	}
+	return season, true
+}
+
+// MarkSeasonWatched marks every episode of a season as watched for the
+// current user
+// POST /api/shows/:showId/seasons/:seasonNum/watched
+func (h *ShowsHandler) MarkSeasonWatched(c *gin.Context) {
+	season, ok := h.seasonParam(c)
+	if !ok {
+		return
+	}
+
+	marked, err := h.db.MarkSeasonWatched(c.GetInt64("user_id"), season.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark season as watched"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Season marked as watched", "marked": marked})
+}
+
+// MarkSeasonUnwatched clears the current user's progress on every episode
+// of a season
+// POST /api/shows/:showId/seasons/:seasonNum/unwatched
+func (h *ShowsHandler) MarkSeasonUnwatched(c *gin.Context) {
+	season, ok := h.seasonParam(c)
+	if !ok {
+		return
+	}
+
+	cleared, err := h.db.MarkSeasonUnwatched(c.GetInt64("user_id"), season.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark season as unwatched"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Season marked as unwatched", "cleared": cleared})
+}
+
+// EpisodeOrderRequest selects how a show's episode files are numbered
+type EpisodeOrderRequest struct {
+	EpisodeOrder   string `json:"episode_order" binding:"required"` // aired, dvd, absolute, digital, story_arc, production or tv
+	EpisodeGroupID string `json:"episode_group_id"`                 // TMDB episode group; picked automatically if empty
+}
+
+// DeleteShow removes a show with its seasons, episodes and extras from the
+// library. Files on disk are left alone.
+// DELETE /api/shows/:showId
+func (h *ShowsHandler) DeleteShow(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return
+	}
+
+	err = h.db.DeleteTVShow(id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Show not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete show"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Show deleted"})
+}
+
+// SetEpisodeOrder switches a show between aired, DVD, absolute and other
+// TMDB episode orders, then re-fetches its episode metadata in that order
+// PUT /api/shows/:showId/episode-order
+func (h *ShowsHandler) SetEpisodeOrder(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("showId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid show ID"})
+		return
+	}
+
+	var req EpisodeOrderRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !library.ValidEpisodeOrder(req.EpisodeOrder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid episode order"})
+		return
+	}
+
+	if req.EpisodeOrder != db.EpisodeOrderAired && !h.scanner.TMDBConfigured() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TMDB API key is not configured"})
+		return
+	}
+
+	show, err := h.db.GetTVShowByID(id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Show not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch show"})
+		return
+	}
+
+	if err := h.scanner.SetEpisodeOrder(show, req.EpisodeOrder, req.EpisodeGroupID); err != nil {
+		if errors.Is(err, library.ErrNoEpisodeGroup) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "TMDB has no episode group for this order"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply episode order"})
+		return
+	}
+
+	show, err = h.db.GetTVShowByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch show"})
+		return
+	}
+	c.JSON(http.StatusOK, show)
+}
@@ -25,6 +25,14 @@ type CreateSourceRequest struct {
 	Type     string `json:"type" binding:"required,oneof=local smb nfs"`
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+
+	AbsoluteNumbering bool `json:"absolute_numbering"` // episodes named "Show - 1071", no seasons
+}
+
+// UpdateSourceRequest changes a source's settings; omitted fields are kept
+type UpdateSourceRequest struct {
+	Enabled           *bool `json:"enabled"`
+	AbsoluteNumbering *bool `json:"absolute_numbering"`
 }
 
 // GetSources returns all configured media sources
@@ -41,8 +49,7 @@ func (h *SourceHandler) GetSources(c *gin.Context) {
 // CreateSource adds a new media source
 func (h *SourceHandler) CreateSource(c *gin.Context) {
 	var req CreateSourceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -89,6 +96,8 @@ func (h *SourceHandler) CreateSource(c *gin.Context) {
 		Username: req.Username,
 		Password: req.Password,
 		Enabled:  true,
+
+		AbsoluteNumbering: req.AbsoluteNumbering,
 	}
 
 	created, err := h.db.CreateMediaSource(source)
@@ -100,6 +109,45 @@ func (h *SourceHandler) CreateSource(c *gin.Context) {
 	c.JSON(http.StatusCreated, created)
 }
 
+// UpdateSource changes whether a source is scanned and how its episode files
+// are numbered. Numbering changes apply to files scanned afterwards.
+// PATCH /api/sources/:id
+func (h *SourceHandler) UpdateSource(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	var req UpdateSourceRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	source, err := h.db.GetMediaSourceByID(id)
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Source not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source"})
+		return
+	}
+
+	if req.Enabled != nil {
+		source.Enabled = *req.Enabled
+	}
+	if req.AbsoluteNumbering != nil {
+		source.AbsoluteNumbering = *req.AbsoluteNumbering
+	}
+	if err := h.db.UpdateMediaSourceSettings(id, source.Enabled, source.AbsoluteNumbering); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update source"})
+		return
+	}
+
+	c.JSON(http.StatusOK, source)
+}
+
 // DeleteSource removes a media source
 func (h *SourceHandler) DeleteSource(c *gin.Context) {
 	idStr := c.Param("id")
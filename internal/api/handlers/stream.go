@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,10 +22,20 @@ import (
 	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
 )
 
+// renditionSegmentPattern matches segment names ffmpeg writes for audio
+// renditions and adaptive variants
+var renditionSegmentPattern = regexp.MustCompile(`^segment\d+\.ts$`)
+
 type StreamHandler struct {
 	db             *db.DB
 	cfg            *config.Config
 	sessionManager *ffmpeg.SessionManager
+	transcoder     *ffmpeg.Transcoder
+	profiles       map[string]ffmpeg.TranscodeProfile
+	ffprobe        *ffmpeg.FFprobe
+
+	directPlayMu sync.Mutex
+	directPlay   map[string]bool // canDirectPlay decisions by file path and size
 }
 
 func NewStreamHandler(database *db.DB, cfg *config.Config) *StreamHandler {
@@ -28,157 +45,466 @@ func NewStreamHandler(database *db.DB, cfg *config.Config) *StreamHandler {
 		cfg.EnableHWAccel,
 		cfg.HWAccelType,
 	)
+	sm.SetPollInterval(time.Duration(cfg.SegmentPollMillis) * time.Millisecond)
+	sm.SetIdleTimeout(time.Duration(cfg.TranscodeIdleTimeout) * time.Second)
+	sm.SetMaxSessions(cfg.MaxTranscodeSessions)
+	sm.SetDownloadExpiry(time.Duration(cfg.DownloadCacheHours) * time.Hour)
+	sm.StartReaper()
 
 	return &StreamHandler{
 		db:             database,
 		cfg:            cfg,
 		sessionManager: sm,
+		transcoder: ffmpeg.NewTranscoder(
+			cfg.FFmpegPath,
+			cfg.TranscodeDir,
+			cfg.EnableHWAccel,
+			cfg.HWAccelType,
+		),
+		ffprobe:    ffmpeg.NewFFprobe(cfg.FFmpegPath),
+		profiles:   buildTranscodeProfiles(cfg),
+		directPlay: make(map[string]bool),
+	}
+}
+
+// buildTranscodeProfiles applies the configured preset/CRF tuning on top of
+// the built-in profiles
+func buildTranscodeProfiles(cfg *config.Config) map[string]ffmpeg.TranscodeProfile {
+	profiles := make(map[string]ffmpeg.TranscodeProfile, len(ffmpeg.Profiles))
+	for name, profile := range ffmpeg.Profiles {
+		if cfg.TranscodePreset != "" {
+			profile.Preset = cfg.TranscodePreset
+		}
+		if cfg.TranscodeCRF > 0 {
+			profile.CRF = cfg.TranscodeCRF
+		}
+		profile.SampleRate = cfg.AudioSampleRate
+		switch cfg.TranscodePixelFormat {
+		case "":
+		case "source":
+			profile.PixelFormat = ""
+		default:
+			profile.PixelFormat = cfg.TranscodePixelFormat
+		}
+
+		if override, ok := cfg.TranscodeProfiles[name]; ok {
+			if override.Preset != "" {
+				profile.Preset = override.Preset
+			}
+			if override.CRF > 0 {
+				profile.CRF = override.CRF
+			}
+			if override.VideoBitrate != "" {
+				profile.VideoBitrate = override.VideoBitrate
+			}
+			if override.AudioBitrate != "" {
+				profile.AudioBitrate = override.AudioBitrate
+			}
+		}
+
+		profiles[name] = profile
+	}
+	return profiles
+}
+
+// profileForResolution chooses the transcode profile from a resolution
+// string (e.g., "1920x1080")
+func (h *StreamHandler) profileForResolution(resolution string) ffmpeg.TranscodeProfile {
+	profile := h.profiles["1080p"]
+	if resolution != "" && strings.Contains(resolution, "x") {
+		parts := strings.Split(resolution, "x")
+		if len(parts) == 2 {
+			if height, err := strconv.Atoi(parts[1]); err == nil && height <= 720 {
+				profile = h.profiles["720p"]
+			}
+		}
+	}
+	return profile
+}
+
+// segmentStartupAllowance covers ffmpeg probing the input and opening the
+// encoder before the first segment is written
+const segmentStartupAllowance = 15 * time.Second
+
+// segmentWaitTimeout is how long to wait for the next count segments of a
+// transcode. Unless segment_wait_seconds is set, it allows the encoder to run
+// well below realtime, more so for larger outputs; audio-only renditions (zero
+// profile) only need to keep up with realtime.
+func (h *StreamHandler) segmentWaitTimeout(profile ffmpeg.TranscodeProfile, count int) time.Duration {
+	if h.cfg.SegmentWaitSeconds > 0 {
+		return time.Duration(h.cfg.SegmentWaitSeconds) * time.Second
+	}
+
+	slowdown := 1
+	switch {
+	case profile.Height > 1080:
+		slowdown = 8
+	case profile.Height > 720:
+		slowdown = 4
+	case profile.Height > 0:
+		slowdown = 3
 	}
+	return segmentStartupAllowance + time.Duration(count*slowdown)*ffmpeg.HLSSegmentDuration
 }
 
 // GetManifest returns the HLS manifest for a media item
 func (h *StreamHandler) GetManifest(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
 		return
 	}
 
-	mediaType := c.Query("type")
-	var filePath string
-	var duration int
-	var resolution string
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+	audio, ok := audioQuery(c, file)
+	if !ok {
+		return
+	}
+	subtitle, burn := subtitleQuery(c, file)
+	var burnSubtitle *ffmpeg.SubtitleTrack
+	if burn {
+		burnSubtitle = subtitle
+	}
+	normalize := h.normalizeQuery(c)
+	key := hlsKey(ref, normalize, audio, burnSubtitle)
+	filePath := file.FilePath
+	duration := file.Duration
+	resolution := file.Resolution
 
-	switch mediaType {
-	case "episode":
-		episode, err := h.db.GetEpisodeByID(id)
-		if err == db.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
-			return
+	// ?start= (seconds) begins playback there, e.g. when joining a channel
+	start, _ := strconv.Atoi(c.Query("start"))
+	if start < 0 || (duration > 0 && start >= duration) {
+		start = 0
+	}
+
+	// Check if file exists
+	if !ffmpeg.InputExists(filePath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+
+	profile := h.profileForResolution(resolution)
+	profile.Normalize = normalize
+	profile.AudioTrack = audio
+	profile.BurnSubtitle = burnSubtitle
+
+	// Normalizing audio means re-encoding it, direct play always gets the
+	// first audio track and burning in subtitles changes the video, so those
+	// streams are transcoded
+	directPlay := !normalize && audio == 0 && burnSubtitle == nil && h.canDirectPlay(file)
+
+	// Serve the master playlist with audio/subtitle renditions unless the
+	// client is fetching the media playlist it references
+	if c.Query("variant") != "media" {
+		bandwidth := estimateBandwidth(file)
+		if !directPlay {
+			bandwidth = profile.Bandwidth()
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episode"})
-			return
+
+		// Pre-extract the auto-selected subtitle track so it's ready to serve.
+		// A text track asked for with ?subtitle= is selected instead, and
+		// none once subtitles are burned in.
+		preferredLang := c.DefaultQuery("subtitle_lang", h.cfg.SubtitleLanguage)
+		selected := selectSubtitleTrack(file.SubtitleTracks, file.AudioTracks, preferredLang)
+		switch {
+		case burnSubtitle != nil:
+			selected = nil
+		case subtitle != nil && textSubtitleCodecs[subtitle.Codec]:
+			selected = subtitle
 		}
-		filePath = episode.FilePath
-		duration = episode.Duration
-		resolution = episode.Resolution
-	case "extra":
-		extra, err := h.db.GetExtraByID(id)
-		if err == db.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Extra not found"})
-			return
+		if selected != nil {
+			if err := h.ensureSubtitleExtracted(filePath, transcodeKey(ref), selected); err != nil {
+				log.Printf("Subtitle extraction failed for %s: %v", ref, err)
+				selected = nil
+			}
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch extra"})
-			return
+
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		c.Header("Cache-Control", "no-cache")
+		variantQuery := streamQuery(c)
+		variantQuery.Set("variant", "media")
+		if start > 0 {
+			variantQuery.Set("start", strconv.Itoa(start))
 		}
-		filePath = extra.FilePath
-		duration = extra.Duration
-		resolution = extra.Resolution
-	default:
-		media, err := h.db.GetMediaByID(id)
-		if err == db.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+		c.String(http.StatusOK, generateMasterPlaylist(file, ref, bandwidth, selected, audio, variantQuery.Encode()))
+		return
+	}
+
+	// Check if direct play is possible (H.264/HEVC in MP4/MKV)
+	if directPlay {
+		manifest := h.generateDirectPlayManifestForFile(filePath, duration, ref, start)
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		c.String(http.StatusOK, manifest)
+		return
+	}
+
+	// With a known duration, list every segment up front so players can seek
+	// anywhere; GetSegment restarts ffmpeg at segments it won't reach soon
+	if duration > 0 {
+		startSegment := start / hlsSegmentSeconds
+		session, err := h.sessionManager.GetOrStartSession(key, filePath, profile, startSegment)
+		if errors.Is(err, ffmpeg.ErrTranscodeBusy) {
+			transcodeBusy(c)
 			return
 		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch media"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
 			return
 		}
-		filePath = media.FilePath
-		duration = media.Duration
-		resolution = media.Resolution
-	}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
-		return
-	}
+		segmentPath := filepath.Join(h.cfg.TranscodeDir, key, fmt.Sprintf("segment%d.ts", startSegment))
+		if session != nil && !h.sessionManager.WaitForFile(segmentPath, h.segmentWaitTimeout(profile, 1)) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transcoding timeout - timeout waiting for segments"})
+			return
+		}
 
-	// Check if direct play is possible (H.264/HEVC in MP4/MKV)
-	if h.canDirectPlay(filePath) {
-		manifest := h.generateDirectPlayManifestForFile(filePath, duration, id, mediaType)
 		c.Header("Content-Type", "application/vnd.apple.mpegurl")
-		c.String(http.StatusOK, manifest)
+		c.Header("Cache-Control", "no-cache")
+		c.String(http.StatusOK, generateTranscodePlaylist(duration, start, segmentQuery(c)))
 		return
 	}
 
 	// Need to transcode - check for existing manifest
-	transcodeDir := filepath.Join(h.cfg.TranscodeDir, fmt.Sprintf("%d", id))
+	transcodeDir := filepath.Join(h.cfg.TranscodeDir, key)
 	manifestPath := filepath.Join(transcodeDir, "manifest.m3u8")
 
 	// Check if transcode is complete
 	if data, err := os.ReadFile(manifestPath); err == nil {
 		if strings.Contains(string(data), "#EXT-X-ENDLIST") {
 			// Transcode complete, serve the file
-			c.Header("Content-Type", "application/vnd.apple.mpegurl")
-			c.File(manifestPath)
+			h.serveMediaPlaylist(c, manifestPath)
 			return
 		}
 	}
 
 	// Start or get existing transcode session
-	profile := ffmpeg.Profiles["1080p"]
-	// Use resolution string to determine profile (e.g., "1920x1080")
-	if resolution != "" && strings.Contains(resolution, "x") {
-		parts := strings.Split(resolution, "x")
-		if len(parts) == 2 {
-			if height, err := strconv.Atoi(parts[1]); err == nil && height <= 720 {
-				profile = ffmpeg.Profiles["720p"]
-			}
-		}
+	_, err := h.sessionManager.GetOrStartSession(key, filePath, profile, 0)
+	if errors.Is(err, ffmpeg.ErrTranscodeBusy) {
+		transcodeBusy(c)
+		return
 	}
-
-	_, err = h.sessionManager.GetOrStartSession(id, filePath, profile)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
 		return
 	}
 
 	// Wait for initial segments (at least 2 for smooth playback)
-	err = h.sessionManager.WaitForSegments(id, 2, 30*time.Second)
+	err = h.sessionManager.WaitForSegments(key, 2, h.segmentWaitTimeout(profile, 2))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transcoding timeout - " + err.Error()})
 		return
 	}
 
 	// Serve the manifest (now has at least some segments)
-	c.Header("Content-Type", "application/vnd.apple.mpegurl")
 	c.Header("Cache-Control", "no-cache")
-	c.File(manifestPath)
+	h.serveMediaPlaylist(c, manifestPath)
 }
 
-// GetSegment returns an HLS segment
-func (h *StreamHandler) GetSegment(c *gin.Context) {
-	idStr := c.Param("id")
-	numStr := c.Param("num")
+// transcodeBusyRetrySeconds is the Retry-After sent when the transcode
+// limit is reached
+const transcodeBusyRetrySeconds = 10
+
+// transcodeBusy responds that no transcode slot is free
+func transcodeBusy(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(transcodeBusyRetrySeconds))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many streams are transcoding, try again shortly"})
+}
+
+// normalizeQuery reports whether an HLS stream should have its audio
+// loudness-normalized: ?normalize=true|false, defaulting to normalize_audio
+func (h *StreamHandler) normalizeQuery(c *gin.Context) bool {
+	if normalize := c.Query("normalize"); normalize != "" {
+		return normalize == "true"
+	}
+	return h.cfg.NormalizeAudio
+}
+
+// audioQuery reads ?audio=, the AudioTrack.Index of the audio track to
+// transcode, defaulting to the first. It writes a 400 response and returns
+// false unless the file has that track.
+func audioQuery(c *gin.Context, file *db.MediaFile) (int, bool) {
+	value := c.Query("audio")
+	if value == "" {
+		return 0, true
+	}
+
+	index, err := strconv.Atoi(value)
+	if err == nil && index == 0 && file.AudioTracks == "" {
+		return 0, true // not probed; the first track is what ffmpeg picks anyway
+	}
+	var tracks []ffmpeg.AudioTrack
+	json.Unmarshal([]byte(file.AudioTracks), &tracks)
+	for _, track := range tracks {
+		if err == nil && track.Index == index {
+			return index, true
+		}
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid audio track"})
+	return 0, false
+}
+
+// subtitleQuery reads ?subtitle=, the SubtitleTrack.Index of a subtitle
+// track to show, and whether ?burn=true asks for it drawn into the video.
+// A track the file doesn't have is ignored, streaming without subtitles.
+func subtitleQuery(c *gin.Context, file *db.MediaFile) (track *ffmpeg.SubtitleTrack, burn bool) {
+	value := c.Query("subtitle")
+	if value == "" {
+		return nil, false
+	}
+
+	index, err := strconv.Atoi(value)
+	var tracks []ffmpeg.SubtitleTrack
+	json.Unmarshal([]byte(file.SubtitleTracks), &tracks)
+	for i := range tracks {
+		if err == nil && tracks[i].Index == index {
+			return &tracks[i], c.Query("burn") == "true"
+		}
+	}
+
+	log.Printf("Ignoring subtitle track %q, not found in %s", value, file.FilePath)
+	return nil, false
+}
+
+// hlsKey returns the transcode key for an HLS stream. Other audio tracks,
+// burned-in subtitles and normalized audio are separate transcodes so they
+// never mix segments with the plain one.
+func hlsKey(ref db.MediaRef, normalize bool, audio int, burn *ffmpeg.SubtitleTrack) string {
+	key := transcodeKey(ref)
+	if audio > 0 {
+		key += fmt.Sprintf("-a%d", audio)
+	}
+	if burn != nil {
+		key += fmt.Sprintf("-s%d", burn.Index)
+	}
+	if normalize {
+		key += "-norm"
+	}
+	return key
+}
+
+// streamQuery returns the stream options of a manifest request (?audio=,
+// ?subtitle=, ?burn=, ?normalize=) for URIs that have to reach the same
+// transcode
+func streamQuery(c *gin.Context) url.Values {
+	query := url.Values{}
+	for _, name := range []string{"audio", "subtitle", "burn", "normalize"} {
+		if value := c.Query(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query
+}
+
+// segmentQuery returns the query string appended to segment URIs
+func segmentQuery(c *gin.Context) string {
+	if query := streamQuery(c); len(query) > 0 {
+		return "?" + query.Encode()
+	}
+	return ""
+}
 
-	id, err := strconv.ParseInt(idStr, 10, 64)
+// serveMediaPlaylist serves an ffmpeg-written media playlist. ffmpeg names
+// segments "segment<n>.ts" relative to the playlist, so they're rewritten to
+// the segment route, and the stream options are carried over onto them for
+// GetSegment to find the same transcode.
+func (h *StreamHandler) serveMediaPlaylist(c *gin.Context, manifestPath string) {
+	data, err := os.ReadFile(manifestPath)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Manifest not found"})
+		return
+	}
+
+	query := segmentQuery(c)
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = "segment/" + strings.TrimPrefix(line, "segment") + query
+		}
+	}
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.String(http.StatusOK, strings.Join(lines, "\n"))
+}
+
+// hlsSegmentSeconds is the length of a transcoded segment in seconds
+const hlsSegmentSeconds = int(ffmpeg.HLSSegmentDuration / time.Second)
+
+// generateTranscodePlaylist returns a VOD media playlist with every segment
+// a transcode of a duration-second file produces, ending in a shorter last
+// segment. A non-zero start adds #EXT-X-START so players begin there; query
+// is appended to the segment URIs.
+func generateTranscodePlaylist(duration, start int, query string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n",
+		hlsSegmentSeconds)
+	if start > 0 {
+		fmt.Fprintf(&b, "#EXT-X-START:TIME-OFFSET=%d,PRECISE=YES\n", start)
+	}
+
+	for i := 0; i*hlsSegmentSeconds < duration; i++ {
+		length := min(hlsSegmentSeconds, duration-i*hlsSegmentSeconds)
+		fmt.Fprintf(&b, "#EXTINF:%d.0,\nsegment/%d.ts%s\n", length, i, query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// GetSegment returns an HLS segment, waiting for the transcode to write it.
+// Segments the running transcode won't reach soon (after a seek) restart it
+// at that segment.
+func (h *StreamHandler) GetSegment(c *gin.Context) {
+	num, err := strconv.Atoi(strings.TrimSuffix(c.Param("num"), ".ts"))
+	if err != nil || num < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
 		return
 	}
 
-	_, err = h.db.GetMediaByID(id)
-	if err == db.ErrNotFound {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
 		return
 	}
 
-	transcodeDir := filepath.Join(h.cfg.TranscodeDir, fmt.Sprintf("%d", id))
-	segmentPath := filepath.Join(transcodeDir, fmt.Sprintf("segment%s.ts", numStr))
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
 
-	// Wait for segment if transcoding is in progress
-	if h.sessionManager.IsTranscoding(id) {
-		deadline := time.Now().Add(30 * time.Second)
-		for time.Now().Before(deadline) {
-			if _, err := os.Stat(segmentPath); err == nil {
-				break
-			}
-			time.Sleep(500 * time.Millisecond)
+	audio, ok := audioQuery(c, file)
+	if !ok {
+		return
+	}
+	var burnSubtitle *ffmpeg.SubtitleTrack
+	if subtitle, burn := subtitleQuery(c, file); burn {
+		burnSubtitle = subtitle
+	}
+	normalize := h.normalizeQuery(c)
+	key := hlsKey(ref, normalize, audio, burnSubtitle)
+	transcodeDir := filepath.Join(h.cfg.TranscodeDir, key)
+	segmentPath := filepath.Join(transcodeDir, fmt.Sprintf("segment%d.ts", num))
+
+	if _, err := os.Stat(segmentPath); err == nil {
+		if session := h.sessionManager.GetSession(key); session != nil {
+			session.Touch()
+		}
+	} else {
+		profile := h.profileForResolution(file.Resolution)
+		profile.Normalize = normalize
+		profile.AudioTrack = audio
+		profile.BurnSubtitle = burnSubtitle
+
+		session, err := h.sessionManager.GetOrStartSession(key, file.FilePath, profile, num)
+		if errors.Is(err, ffmpeg.ErrTranscodeBusy) {
+			transcodeBusy(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
+			return
+		}
+		if session != nil {
+			h.sessionManager.WaitForFile(segmentPath, h.segmentWaitTimeout(session.Profile, 1))
 		}
 	}
 
@@ -192,128 +518,524 @@ func (h *StreamHandler) GetSegment(c *gin.Context) {
 	c.File(segmentPath)
 }
 
-// GetSubtitle returns a subtitle file in VTT format
+// GetSubtitle returns a subtitle file in VTT format, or a single-segment
+// subtitle playlist wrapping it when requested with a .m3u8 extension
 func (h *StreamHandler) GetSubtitle(c *gin.Context) {
-	idStr := c.Param("id")
 	lang := c.Param("lang")
 
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
 		return
 	}
 
-	_, err = h.db.GetMediaByID(id)
-	if err == db.ErrNotFound {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+	key := transcodeKey(ref)
+
+	if strings.HasSuffix(lang, ".m3u8") {
+		lang = strings.TrimSuffix(lang, ".m3u8")
+		c.Header("Content-Type", "application/vnd.apple.mpegurl")
+		c.String(http.StatusOK, generateSubtitlePlaylist(file.Duration, ref, lang))
 		return
 	}
 
 	// Remove .vtt extension if present
 	lang = strings.TrimSuffix(lang, ".vtt")
+	if strings.ContainsAny(lang, "/\\.") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subtitle language"})
+		return
+	}
 
-	transcodeDir := filepath.Join(h.cfg.TranscodeDir, fmt.Sprintf("%d", id))
+	transcodeDir := filepath.Join(h.cfg.TranscodeDir, key)
 	subtitlePath := filepath.Join(transcodeDir, fmt.Sprintf("subtitle_%s.vtt", lang))
 
+	// Extract on demand for tracks the client switched to from the manifest.
+	// Sidecar files are used when the video has no such track.
 	if _, err := os.Stat(subtitlePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle not found"})
-		return
+		track := subtitleTrackByKey(file.SubtitleTracks, lang)
+		if track == nil {
+			h.serveExternalSubtitle(c, ref, key, lang)
+			return
+		}
+		if err := h.ensureSubtitleExtracted(file.FilePath, key, track); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract subtitle"})
+			return
+		}
 	}
 
 	c.Header("Content-Type", "text/vtt")
 	c.File(subtitlePath)
 }
 
+// serveExternalSubtitle serves a sidecar subtitle as WebVTT. SubRip is
+// converted as it's served; ASS/SSA are converted by ffmpeg into the
+// transcode dir like embedded tracks.
+func (h *StreamHandler) serveExternalSubtitle(c *gin.Context, ref db.MediaRef, transcodeKey, lang string) {
+	subtitles, err := h.db.GetExternalSubtitles(ref)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subtitles"})
+		return
+	}
+	sub := externalSubtitleByKey(subtitles, lang)
+	if sub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle not found"})
+		return
+	}
+
+	switch sub.Format {
+	case "vtt":
+		c.Header("Content-Type", "text/vtt")
+		c.File(sub.FilePath)
+	case "srt":
+		data, err := os.ReadFile(sub.FilePath)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subtitle file not found"})
+			return
+		}
+		c.Data(http.StatusOK, "text/vtt", srtToVTT(data))
+	default:
+		if err := h.transcoder.ExtractSubtitles(sub.FilePath, transcodeKey, 0, lang); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert subtitle"})
+			return
+		}
+		c.Header("Content-Type", "text/vtt")
+		c.File(filepath.Join(h.cfg.TranscodeDir, transcodeKey, fmt.Sprintf("subtitle_%s.vtt", lang)))
+	}
+}
+
+// GetAudioRendition serves an alternate audio track as an audio-only HLS
+// rendition, transcoding it on first request
+func (h *StreamHandler) GetAudioRendition(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+	key := transcodeKey(ref)
+
+	trackIndex, err := strconv.Atoi(c.Param("track"))
+	if err != nil || trackIndex < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid audio track"})
+		return
+	}
+
+	outputDir := h.sessionManager.AudioOutputDir(key, trackIndex)
+	name := c.Param("file")
+
+	// Segments are referenced relative to the rendition playlist
+	if name != "manifest.m3u8" {
+		if !renditionSegmentPattern.MatchString(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment"})
+			return
+		}
+
+		segmentPath := filepath.Join(outputDir, name)
+		if session := h.sessionManager.GetAudioSession(key, trackIndex); session != nil {
+			session.Touch()
+			h.sessionManager.WaitForFile(segmentPath, h.segmentWaitTimeout(ffmpeg.TranscodeProfile{}, 1))
+		}
+
+		if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Segment not found"})
+			return
+		}
+
+		c.Header("Content-Type", "video/MP2T")
+		c.Header("Cache-Control", "max-age=86400")
+		c.File(segmentPath)
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+
+	var tracks []ffmpeg.AudioTrack
+	if err := json.Unmarshal([]byte(file.AudioTracks), &tracks); err != nil || trackIndex >= len(tracks) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audio track not found"})
+		return
+	}
+
+	if _, err := h.sessionManager.GetOrStartAudioSession(key, file.FilePath, trackIndex, "192k"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transcoding: " + err.Error()})
+		return
+	}
+
+	if err := h.sessionManager.WaitForAudioSegments(key, trackIndex, 2, h.segmentWaitTimeout(ffmpeg.TranscodeProfile{}, 2)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Transcoding timeout - " + err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	c.Header("Cache-Control", "no-cache")
+	c.File(filepath.Join(outputDir, "manifest.m3u8"))
+}
+
 // DirectPlay streams the original file directly
 func (h *StreamHandler) DirectPlay(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+	filePath := file.FilePath
+
+	// Check if file exists
+	if !ffmpeg.InputExists(filePath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+
+	// ?start= (used by channels) remuxes from that offset when the
+	// container can be written progressively. Other files, DVD titles
+	// included, are sent to the HLS stream, which transcodes from there.
+	if start, err := strconv.Atoi(c.Query("start")); err == nil && start > 0 {
+		format, ok := remuxFormats[strings.ToLower(filepath.Ext(filePath))]
+		if ok && !ffmpeg.IsConcatInput(filePath) {
+			h.directPlayFrom(c, filePath, start, format)
+			return
+		}
+		query := c.Request.URL.Query()
+		query.Del("type")
+		c.Redirect(http.StatusFound, "/api/stream/"+ref.String()+"/manifest.m3u8?"+query.Encode())
+		return
+	}
+
+	if ffmpeg.IsConcatInput(filePath) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "DVD titles and split movies span several files and must be transcoded"})
+		return
+	}
+
+	f, err := os.Open(filePath)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read media file"})
+		return
+	}
+
+	// ServeContent handles Range/If-Range and sets Accept-Ranges itself; the
+	// content type is set first so it isn't sniffed from the extension
+	c.Header("Content-Type", h.getContentType(filePath))
+	http.ServeContent(c.Writer, c.Request, filepath.Base(filePath), info.ModTime(), f)
+}
+
+// remuxFormats maps the containers DirectPlay can remux from an offset to
+// the ffmpeg format of the remuxed stream
+var remuxFormats = map[string]string{
+	".mp4":  "mp4",
+	".m4v":  "mp4",
+	".mov":  "mp4",
+	".mkv":  "matroska",
+	".webm": "matroska",
+}
+
+// directPlayFrom streams filePath remuxed from start seconds. The output
+// has no fixed length, so range requests aren't supported on it.
+func (h *StreamHandler) directPlayFrom(c *gin.Context, filePath string, start int, format string) {
+	contentType := "video/mp4"
+	if format == "matroska" {
+		contentType = "video/x-matroska"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Accept-Ranges", "none")
+	c.Status(http.StatusOK)
+
+	if err := h.transcoder.RemuxFrom(c.Request.Context(), filePath, start, format, c.Writer); err != nil {
+		if c.Request.Context().Err() == nil {
+			log.Printf("Direct play remux failed for %s: %v", filePath, err)
+		}
+	}
+}
+
+// Download serves a media item as a single MP4 attachment. Direct-play files
+// are sent as-is; others are remuxed (H.264 sources) or transcoded to the
+// requested quality, cached in the transcode dir for later downloads.
+// GET /api/stream/:id/download?quality=1080p|720p|480p
+func (h *StreamHandler) Download(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+	filePath := file.FilePath
+
+	// Check if file exists
+	if !ffmpeg.InputExists(filePath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
+		return
+	}
+
+	name := h.downloadName(ref)
+	quality := c.Query("quality")
+
+	// Downloads are MP4s, so direct-play MKVs are remuxed below
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if quality == "" && (ext == ".mp4" || ext == ".m4v") && h.canDirectPlay(file) {
+		c.FileAttachment(filePath, name+strings.ToLower(filepath.Ext(filePath)))
+		return
+	}
+
+	var build func(ctx context.Context, outputPath string) error
+	variant := "remux"
+	switch {
+	case quality != "":
+		profile, ok := h.profiles[quality]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quality (use 1080p, 720p or 480p)"})
+			return
+		}
+		variant = profile.Name
+		build = func(ctx context.Context, outputPath string) error {
+			return h.transcoder.TranscodeToMP4(ctx, filePath, outputPath, profile)
+		}
+	case file.VideoCodec == "h264":
+		build = func(ctx context.Context, outputPath string) error {
+			return h.transcoder.RemuxToMP4(ctx, filePath, outputPath, file.AudioCodec)
+		}
+	default:
+		profile := h.profileForResolution(file.Resolution)
+		variant = profile.Name
+		build = func(ctx context.Context, outputPath string) error {
+			return h.transcoder.TranscodeToMP4(ctx, filePath, outputPath, profile)
+		}
+	}
+
+	job := ffmpeg.DownloadJob{
+		Key:        downloadKey(ref, variant),
+		Variant:    variant,
+		InputPath:  filePath,
+		OutputPath: filepath.Join(h.cfg.TranscodeDir, transcodeKey(ref), "download_"+variant+".mp4"),
+		Build:      build,
+	}
+	if !h.ensureDownload(c, job) {
+		return
+	}
+
+	c.FileAttachment(job.OutputPath, name+".mp4")
+}
+
+// downloadKey names the build of a download variant in the session manager,
+// e.g. "12-download-720p"
+func downloadKey(ref db.MediaRef, variant string) string {
+	return transcodeKey(ref) + downloadKeyInfix + variant
+}
+
+// downloadKeyInfix separates a download key's transcode key and variant
+const downloadKeyInfix = "-download-"
+
+// ensureDownload builds the job's output unless it already exists, sharing
+// one ffmpeg run between concurrent requests. Builds hold a transcode slot,
+// so when none is free the client is told to retry. A build is stopped
+// shortly after the last request waiting for it goes away. It writes an
+// error response and returns false on failure.
+func (h *StreamHandler) ensureDownload(c *gin.Context, job ffmpeg.DownloadJob) bool {
+	err := h.sessionManager.BuildDownload(c.Request.Context(), job)
+	switch {
+	case errors.Is(err, ffmpeg.ErrTranscodeBusy):
+		transcodeBusy(c)
+		return false
+	case c.Request.Context().Err() != nil:
+		return false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare download"})
+		return false
+	}
+	return true
+}
+
+// downloadName returns a filename (without extension) for a media item, e.g.
+// "Heat (1995)" or "Show - S01E02 - Title"
+func (h *StreamHandler) downloadName(ref db.MediaRef) string {
+	name := ""
+	switch ref.Type {
+	case db.MediaTypeEpisode:
+		if episode, err := h.db.GetEpisodeByID(ref.ID); err == nil {
+			name = fmt.Sprintf("S%02dE%02d", episode.SeasonNumber, episode.EpisodeNumber)
+			if show, err := h.db.GetTVShowByID(episode.TVShowID); err == nil {
+				name = show.Title + " - " + name
+			}
+			if episode.Title != "" {
+				name += " - " + episode.Title
+			}
+		}
+	case db.MediaTypeExtra:
+		if extra, err := h.db.GetExtraByID(ref.ID); err == nil {
+			name = extra.Title
+		}
+	default:
+		if media, err := h.db.GetMediaByID(ref.ID); err == nil {
+			name = media.Title
+			if media.Year > 0 {
+				name += fmt.Sprintf(" (%d)", media.Year)
+			}
+		}
+	}
+
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" {
+		name = "download"
+	}
+	return name
+}
+
+// StopTranscode stops an active transcode session
+func (h *StreamHandler) StopTranscode(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
 		return
 	}
 
-	mediaType := c.Query("type")
-	var filePath string
+	audio, _ := strconv.Atoi(c.Query("audio"))
+	var burnSubtitle *ffmpeg.SubtitleTrack
+	if subtitle, err := strconv.Atoi(c.Query("subtitle")); err == nil && c.Query("burn") == "true" {
+		burnSubtitle = &ffmpeg.SubtitleTrack{Index: subtitle}
+	}
+	h.sessionManager.StopSession(hlsKey(ref, h.normalizeQuery(c), audio, burnSubtitle))
+	h.sessionManager.StopSession(adaptiveKey(ref))
+	c.JSON(http.StatusOK, gin.H{"message": "Transcode stopped"})
+}
 
-	switch mediaType {
-	case "episode":
-		// Look up episode from episodes table
-		episode, err := h.db.GetEpisodeByID(id)
+// lookupMediaFile resolves the playable file for a ref (movie, episode or
+// extra). Ripped discs resolve to their main title and split movies to their
+// parts, either of which may be an ffmpeg concat input. It writes the error response and returns false if the
+// item can't be found or played.
+func (h *StreamHandler) lookupMediaFile(c *gin.Context, ref db.MediaRef) (*db.MediaFile, bool) {
+	var file *db.MediaFile
+	switch ref.Type {
+	case db.MediaTypeEpisode:
+		episode, err := h.db.GetEpisodeByID(ref.ID)
 		if err == db.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Episode not found"})
-			return
+			return nil, false
 		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch episode"})
-			return
+			return nil, false
 		}
-		filePath = episode.FilePath
-	case "extra":
-		// Look up from extras table
-		extra, err := h.db.GetExtraByID(id)
+		file = &episode.MediaFile
+	case db.MediaTypeExtra:
+		extra, err := h.db.GetExtraByID(ref.ID)
 		if err == db.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Extra not found"})
-			return
+			return nil, false
 		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch extra"})
-			return
+			return nil, false
 		}
-		filePath = extra.FilePath
+		file = &extra.MediaFile
 	default:
-		// Look up from media table (movies)
-		media, err := h.db.GetMediaByID(id)
+		media, err := h.db.GetMediaByID(ref.ID)
 		if err == db.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Media not found"})
-			return
+			return nil, false
 		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch media"})
-			return
+			return nil, false
 		}
-		filePath = media.FilePath
-	}
+		file = &media.MediaFile
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Media file not found"})
-		return
+		// Split movies play their parts one after another
+		if len(media.Parts) > 1 {
+			files := make([]string, len(media.Parts))
+			for i, part := range media.Parts {
+				files[i] = part.FilePath
+			}
+			resolved := *file
+			resolved.FilePath = ffmpeg.ConcatInput(files)
+			return &resolved, true
+		}
 	}
 
-	// Determine content type
-	contentType := h.getContentType(filePath)
-	c.Header("Content-Type", contentType)
-	c.Header("Accept-Ranges", "bytes")
-
-	c.File(filePath)
+	// Ripped discs play their main title
+	if ffmpeg.IsDisc(file.FilePath) {
+		title, err := ffmpeg.MainTitle(file.FilePath)
+		if err == ffmpeg.ErrDiscImage {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Disc images can't be played; extract or mount the image"})
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Disc main title not found"})
+			return nil, false
+		}
+		resolved := *file
+		resolved.FilePath = title.Input
+		file = &resolved
+	}
+	return file, true
 }
 
-// StopTranscode stops an active transcode session
-func (h *StreamHandler) StopTranscode(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
-		return
+// directPlayVideoCodecs and directPlayAudioCodecs are the codecs Apple TV
+// decodes natively from an MP4 or MKV container
+var (
+	directPlayVideoCodecs = map[string]bool{"h264": true, "hevc": true}
+	directPlayAudioCodecs = map[string]bool{"aac": true, "ac3": true, "eac3": true}
+)
+
+// canDirectPlay checks if the file can be played directly on Apple TV: H.264
+// or HEVC video with AAC or AC-3 audio in an MP4/MKV container. Codecs come
+// from the library, or from probing the file when the scan didn't record
+// them. Decisions are cached by path and size so manifest requests don't
+// re-probe.
+func (h *StreamHandler) canDirectPlay(file *db.MediaFile) bool {
+	if ffmpeg.IsConcatInput(file.FilePath) {
+		return false
 	}
 
-	h.sessionManager.StopSession(id)
-	c.JSON(http.StatusOK, gin.H{"message": "Transcode stopped"})
-}
+	ext := strings.ToLower(filepath.Ext(file.FilePath))
+	isMP4 := ext == ".mp4" || ext == ".m4v"
+	if !isMP4 && ext != ".mkv" {
+		return false
+	}
 
-// canDirectPlay checks if the file can be played directly on Apple TV
-func (h *StreamHandler) canDirectPlay(filePath string) bool {
-	ext := strings.ToLower(filepath.Ext(filePath))
+	cacheKey := fmt.Sprintf("%s:%d", file.FilePath, file.FileSize)
+	h.directPlayMu.Lock()
+	directPlay, cached := h.directPlay[cacheKey]
+	h.directPlayMu.Unlock()
+	if cached {
+		return directPlay
+	}
 
-	// MP4 and M4V are typically directly playable
-	if ext == ".mp4" || ext == ".m4v" {
-		return true
+	videoCodec, audioCodec := file.VideoCodec, file.AudioCodec
+	if videoCodec == "" || audioCodec == "" {
+		metadata, err := h.ffprobe.GetMetadata(file.FilePath)
+		if err != nil {
+			// MP4s were assumed playable before codecs were probed
+			log.Printf("Direct play probe failed for %s: %v", file.FilePath, err)
+			return isMP4
+		}
+		videoCodec, audioCodec = metadata.VideoCodec, metadata.AudioCodec
 	}
 
-	// For MKV, we'd need to probe the codec
-	// For now, assume MKV needs transcoding (common case)
-	return false
+	// Files without audio only need a playable video stream
+	directPlay = directPlayVideoCodecs[videoCodec] && (audioCodec == "" || directPlayAudioCodecs[audioCodec])
+
+	h.directPlayMu.Lock()
+	h.directPlay[cacheKey] = directPlay
+	h.directPlayMu.Unlock()
+	return directPlay
 }
 
 func (h *StreamHandler) generateDirectPlayManifest(media *db.Media, id int64) string {
@@ -333,14 +1055,14 @@ func (h *StreamHandler) generateDirectPlayManifest(media *db.Media, id int64) st
 `, duration, duration, id)
 }
 
-func (h *StreamHandler) generateDirectPlayManifestForFile(filePath string, duration int, id int64, mediaType string) string {
+func (h *StreamHandler) generateDirectPlayManifestForFile(filePath string, duration int, ref db.MediaRef, start int) string {
 	if duration == 0 {
 		duration = 3600 // Default 1 hour
 	}
 
-	typeParam := ""
-	if mediaType != "" {
-		typeParam = "?type=" + mediaType
+	startTag := ""
+	if start > 0 {
+		startTag = fmt.Sprintf("#EXT-X-START:TIME-OFFSET=%d,PRECISE=YES\n", start)
 	}
 
 	return fmt.Sprintf(`#EXTM3U
@@ -348,10 +1070,94 @@ func (h *StreamHandler) generateDirectPlayManifestForFile(filePath string, durat
 #EXT-X-TARGETDURATION:%d
 #EXT-X-MEDIA-SEQUENCE:0
 #EXT-X-PLAYLIST-TYPE:VOD
-#EXTINF:%d.0,
-/api/stream/%d/direct%s
+%s#EXTINF:%d.0,
+/api/stream/%s/direct
 #EXT-X-ENDLIST
-`, duration, duration, id, typeParam)
+`, duration, startTag, duration, ref)
+}
+
+// estimateBandwidth approximates the peak bitrate for #EXT-X-STREAM-INF
+func estimateBandwidth(file *db.MediaFile) int64 {
+	if file.Duration > 0 && file.FileSize > 0 {
+		return file.FileSize * 8 / int64(file.Duration)
+	}
+	return 8000000
+}
+
+// generateMasterPlaylist returns a master playlist whose single variant is the
+// media playlist, with #EXT-X-MEDIA groups for every audio track and every
+// text subtitle track. The selected subtitle track (if any) is the default.
+func generateMasterPlaylist(file *db.MediaFile, ref db.MediaRef, bandwidth int64, selected *ffmpeg.SubtitleTrack, audio int, variantQuery string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:4\n")
+
+	streamInf := fmt.Sprintf("BANDWIDTH=%d", bandwidth)
+
+	// Audio renditions: the selected track (the first by default) is muxed
+	// into the variant, the rest are transcoded to audio-only renditions on
+	// demand
+	var audioTracks []ffmpeg.AudioTrack
+	json.Unmarshal([]byte(file.AudioTracks), &audioTracks)
+	if len(audioTracks) > 1 {
+		for i, track := range audioTracks {
+			name := renditionName(track.Title, track.Language, i)
+			if track.Index == audio {
+				fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=YES,AUTOSELECT=YES\n",
+					name, normalizeLanguage(track.Language))
+				continue
+			}
+			fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=NO,AUTOSELECT=YES,URI=\"/api/stream/%s/audio/%d/manifest.m3u8\"\n",
+				name, normalizeLanguage(track.Language), ref, track.Index)
+		}
+		streamInf += `,AUDIO="audio"`
+	}
+
+	// Subtitle renditions, one per distinct key
+	var subtitleTracks []ffmpeg.SubtitleTrack
+	json.Unmarshal([]byte(file.SubtitleTracks), &subtitleTracks)
+	seen := make(map[string]bool)
+	for i := range subtitleTracks {
+		track := &subtitleTracks[i]
+		key := subtitleKey(track)
+		if !textSubtitleCodecs[track.Codec] || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		name := renditionName(track.Title, track.Language, i)
+		if track.Forced && track.Title == "" {
+			name += " (Forced)"
+		}
+		isDefault, forced := "NO", "NO"
+		if selected != nil && subtitleKey(selected) == key {
+			isDefault = "YES"
+		}
+		if track.Forced {
+			forced = "YES"
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"%s\",LANGUAGE=\"%s\",DEFAULT=%s,AUTOSELECT=YES,FORCED=%s,URI=\"/api/stream/%s/subtitles/%s.m3u8\"\n",
+			name, normalizeLanguage(track.Language), isDefault, forced, ref, key)
+	}
+	if len(seen) > 0 {
+		streamInf += `,SUBTITLES="subs"`
+	}
+
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%s\n/api/stream/%s/manifest.m3u8?%s\n",
+		streamInf, ref, variantQuery)
+
+	return b.String()
+}
+
+// renditionName returns a display name for an #EXT-X-MEDIA entry
+func renditionName(title, language string, index int) string {
+	name := title
+	if name == "" {
+		name = normalizeLanguage(language)
+	}
+	if name == "" || name == "und" {
+		name = fmt.Sprintf("Track %d", index+1)
+	}
+	return strings.ReplaceAll(name, `"`, "'")
 }
 
 func (h *StreamHandler) getContentType(filePath string) string {
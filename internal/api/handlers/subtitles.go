@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// languageAliases maps ISO 639-1 and bibliographic ISO 639-2 codes to the
+// terminology codes ffprobe usually reports, so "en" matches "eng"
+var languageAliases = map[string]string{
+	"en": "eng", "es": "spa", "fr": "fra", "fre": "fra", "de": "deu", "ger": "deu",
+	"it": "ita", "pt": "por", "nl": "nld", "dut": "nld", "sv": "swe", "no": "nor",
+	"da": "dan", "fi": "fin", "pl": "pol", "ru": "rus", "ja": "jpn", "ko": "kor",
+	"zh": "zho", "chi": "zho", "ar": "ara", "he": "heb", "hi": "hin", "tr": "tur",
+	"el": "ell", "gre": "ell", "cs": "ces", "cze": "ces", "hu": "hun",
+}
+
+// textSubtitleCodecs are the subtitle codecs ffmpeg can convert to WebVTT.
+// Image-based formats (PGS, VobSub) need OCR and are never auto-selected.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// normalizeLanguage returns a canonical lowercase language code
+func normalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := languageAliases[lang]; ok {
+		return alias
+	}
+	return lang
+}
+
+// selectSubtitleTrack picks the subtitle track to serve for a preferred language.
+// When the preferred language matches the primary audio language only a forced
+// track is selected; otherwise the full track is preferred over a forced one.
+// With no preference, forced subtitles in the audio language are still served.
+func selectSubtitleTrack(subtitleTracksJSON, audioTracksJSON, preferred string) *ffmpeg.SubtitleTrack {
+	if subtitleTracksJSON == "" || strings.EqualFold(preferred, "off") {
+		return nil
+	}
+
+	var tracks []ffmpeg.SubtitleTrack
+	if err := json.Unmarshal([]byte(subtitleTracksJSON), &tracks); err != nil {
+		return nil
+	}
+
+	audioLang := ""
+	var audioTracks []ffmpeg.AudioTrack
+	if err := json.Unmarshal([]byte(audioTracksJSON), &audioTracks); err == nil && len(audioTracks) > 0 {
+		audioLang = normalizeLanguage(audioTracks[0].Language)
+	}
+
+	find := func(lang string, forced bool) *ffmpeg.SubtitleTrack {
+		for i := range tracks {
+			t := &tracks[i]
+			if t.Forced == forced && textSubtitleCodecs[t.Codec] && normalizeLanguage(t.Language) == lang {
+				return t
+			}
+		}
+		return nil
+	}
+
+	preferred = normalizeLanguage(preferred)
+	if preferred == "" || preferred == audioLang {
+		if audioLang == "" {
+			return nil
+		}
+		return find(audioLang, true)
+	}
+
+	if track := find(preferred, false); track != nil {
+		return track
+	}
+	return find(preferred, true)
+}
+
+// subtitleKey returns the name used for a track's extracted file and URL
+func subtitleKey(track *ffmpeg.SubtitleTrack) string {
+	key := normalizeLanguage(track.Language)
+	if key == "" {
+		key = "und"
+	}
+	if track.Forced {
+		key += "-forced"
+	}
+	return key
+}
+
+// ensureSubtitleExtracted converts the track to WebVTT in the transcode dir
+// unless a previous request already did
+func (h *StreamHandler) ensureSubtitleExtracted(filePath, transcodeKey string, track *ffmpeg.SubtitleTrack) error {
+	key := subtitleKey(track)
+	subtitlePath := filepath.Join(h.cfg.TranscodeDir, transcodeKey, fmt.Sprintf("subtitle_%s.vtt", key))
+	if _, err := os.Stat(subtitlePath); err == nil {
+		return nil
+	}
+	return h.transcoder.ExtractSubtitles(filePath, transcodeKey, track.Index, key)
+}
+
+// externalSubtitleKey returns the name used for a sidecar subtitle's URL,
+// matching subtitleKey so clients treat both alike
+func externalSubtitleKey(sub *db.ExternalSubtitle) string {
+	return subtitleKey(&ffmpeg.SubtitleTrack{Language: sub.Language, Forced: sub.Forced})
+}
+
+// externalSubtitleByKey finds the sidecar subtitle with the given key. The
+// first one wins when several share a language.
+func externalSubtitleByKey(subtitles []*db.ExternalSubtitle, key string) *db.ExternalSubtitle {
+	for _, sub := range subtitles {
+		if externalSubtitleKey(sub) == key {
+			return sub
+		}
+	}
+	return nil
+}
+
+// srtTimestampRegex matches the comma before the milliseconds of a SubRip
+// timestamp, which WebVTT writes as a dot
+var srtTimestampRegex = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+
+// srtToVTT converts SubRip subtitles to WebVTT. Files that aren't UTF-8 are
+// read as Latin-1, the usual encoding of older rips.
+func srtToVTT(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		data = []byte(string(runes))
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	var out bytes.Buffer
+	out.WriteString("WEBVTT\n\n")
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if bytes.Contains(line, []byte("-->")) {
+			line = srtTimestampRegex.ReplaceAll(line, []byte("$1.$2"))
+		}
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
+// subtitleTrackByKey finds the convertible subtitle track with the given key
+func subtitleTrackByKey(subtitleTracksJSON, key string) *ffmpeg.SubtitleTrack {
+	var tracks []ffmpeg.SubtitleTrack
+	if err := json.Unmarshal([]byte(subtitleTracksJSON), &tracks); err != nil {
+		return nil
+	}
+	for i := range tracks {
+		if textSubtitleCodecs[tracks[i].Codec] && subtitleKey(&tracks[i]) == key {
+			return &tracks[i]
+		}
+	}
+	return nil
+}
+
+// generateSubtitlePlaylist returns a single-segment playlist for a VTT file
+func generateSubtitlePlaylist(duration int, ref db.MediaRef, key string) string {
+	if duration == 0 {
+		duration = 3600 // Default 1 hour
+	}
+
+	return fmt.Sprintf(`#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:%d
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:%d.0,
+/api/stream/%s/subtitles/%s.vtt
+#EXT-X-ENDLIST
+`, duration, duration, ref, key)
+}
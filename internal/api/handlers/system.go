@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// SystemHandler reports server-wide information to clients
+type SystemHandler struct {
+	cfg    *config.Config
+	ffmpeg *ffmpeg.Capabilities
+}
+
+// NewSystemHandler creates a new system handler. ffmpegCaps is the probe
+// done at startup.
+func NewSystemHandler(cfg *config.Config, ffmpegCaps *ffmpeg.Capabilities) *SystemHandler {
+	return &SystemHandler{cfg: cfg, ffmpeg: ffmpegCaps}
+}
+
+// TranscodingCapabilities describes how the server can transcode
+type TranscodingCapabilities struct {
+	Enabled        bool     `json:"enabled"`  // ffmpeg is installed and runs
+	HWAccel        bool     `json:"hw_accel"` // false if the configured type wasn't found at startup
+	HWAccelType    string   `json:"hw_accel_type,omitempty"`
+	Profiles       []string `json:"profiles"`
+	MaxResolution  string   `json:"max_resolution,omitempty"` // largest transcode profile
+	DefaultQuality string   `json:"default_quality"`
+}
+
+// CapabilitiesResponse is returned by GET /api/system/capabilities
+type CapabilitiesResponse struct {
+	Version        string                  `json:"version"`
+	Transcoding    TranscodingCapabilities `json:"transcoding"`
+	FFmpeg         *ffmpeg.Capabilities    `json:"ffmpeg"`
+	TMDbConfigured bool                    `json:"tmdb_configured"`
+	Watcher        bool                    `json:"watcher"`
+	GuestAccess    bool                    `json:"guest_access"` // browsing works without logging in
+}
+
+// GetCapabilities returns what this server is configured and able to do, so
+// clients can adapt (e.g. only offer direct play without transcoding)
+func (h *SystemHandler) GetCapabilities(c *gin.Context) {
+	// Profiles from largest to smallest
+	profiles := make([]string, 0, len(ffmpeg.Profiles))
+	for name := range ffmpeg.Profiles {
+		profiles = append(profiles, name)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return ffmpeg.Profiles[profiles[i]].Height > ffmpeg.Profiles[profiles[j]].Height
+	})
+
+	transcoding := TranscodingCapabilities{
+		Enabled:        h.ffmpeg.Available,
+		HWAccel:        h.cfg.EnableHWAccel,
+		Profiles:       profiles,
+		DefaultQuality: h.cfg.DefaultQuality,
+	}
+	if h.cfg.EnableHWAccel {
+		transcoding.HWAccelType = h.cfg.HWAccelType
+	}
+	if h.ffmpeg.Available && len(profiles) > 0 {
+		transcoding.MaxResolution = profiles[0]
+	} else {
+		transcoding.Profiles = []string{}
+	}
+
+	c.JSON(http.StatusOK, CapabilitiesResponse{
+		Version:        Version,
+		Transcoding:    transcoding,
+		FFmpeg:         h.ffmpeg,
+		TMDbConfigured: h.cfg.TMDbAPIKey != "",
+		Watcher:        h.cfg.EnableWatcher,
+		GuestAccess:    h.cfg.AllowGuest,
+	})
+}
+
+// APIIndex is served at / when the server runs without the web interface
+type APIIndex struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	WebUI     bool              `json:"web_ui"`
+	Endpoints map[string]string `json:"endpoints"`
+}
+
+// Index describes the API for deployments without the web interface, so
+// requests to / get something more useful than a 404
+func (h *SystemHandler) Index(c *gin.Context) {
+	c.JSON(http.StatusOK, APIIndex{
+		Name:    "media-server",
+		Version: Version,
+		WebUI:   false,
+		Endpoints: map[string]string{
+			"health":       "/health",
+			"login":        "/api/auth/login",
+			"register":     "/api/auth/register",
+			"capabilities": "/api/system/capabilities",
+			"library":      "/api/library/all",
+			"shows":        "/api/shows",
+			"sections":     "/api/sections",
+			"playlists":    "/api/playlists",
+			"channels":     "/api/channels",
+		},
+	})
+}
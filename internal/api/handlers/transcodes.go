@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// TranscodeSessionInfo describes a running transcode for admins
+type TranscodeSessionInfo struct {
+	Key            string    `json:"key"`
+	MediaID        int64     `json:"media_id,omitempty"`
+	MediaType      string    `json:"media_type,omitempty"`
+	Ref            string    `json:"ref,omitempty"`
+	Profile        string    `json:"profile,omitempty"`
+	AudioTrack     *int      `json:"audio_track,omitempty"` // set for audio renditions
+	Normalized     bool      `json:"normalized,omitempty"`
+	Download       bool      `json:"download,omitempty"` // an MP4 being built for a download
+	StartedAt      time.Time `json:"started_at"`
+	ElapsedSeconds int       `json:"elapsed_seconds"`
+	Segments       int       `json:"segments"`
+}
+
+// ListTranscodes lists the transcodes and download builds running for all
+// users
+// GET /api/admin/transcodes
+func (h *StreamHandler) ListTranscodes(c *gin.Context) {
+	sessions := h.sessionManager.ListSessions()
+	items := make([]TranscodeSessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		info := TranscodeSessionInfo{
+			Key:            s.Key,
+			Profile:        s.Profile,
+			Normalized:     strings.HasSuffix(s.Key, "-norm"),
+			Download:       s.Download,
+			StartedAt:      s.StartTime,
+			ElapsedSeconds: int(time.Since(s.StartTime).Seconds()),
+			Segments:       s.Segments,
+		}
+		if s.AudioTrack >= 0 {
+			track := s.AudioTrack
+			info.AudioTrack = &track
+		}
+		if ref, ok := parseTranscodeKey(s.Key); ok {
+			info.MediaID = ref.ID
+			info.MediaType = string(ref.Type)
+			info.Ref = ref.String()
+		}
+		items = append(items, info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": len(items)})
+}
+
+// TranscodeStatus summarizes transcoding load
+type TranscodeStatus struct {
+	ActiveSessions     int `json:"active_sessions"`      // video and audio transcodes and download builds
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds"` // 0 when idle transcodes aren't stopped
+}
+
+// GetTranscodeStatus returns the number of running transcodes
+// GET /api/admin/transcodes/status
+func (h *StreamHandler) GetTranscodeStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, TranscodeStatus{
+		ActiveSessions:     h.sessionManager.ActiveSessionCount(),
+		IdleTimeoutSeconds: h.cfg.TranscodeIdleTimeout,
+	})
+}
+
+// StopTranscodeSession stops any user's transcode, along with its audio
+// renditions, or download build
+// DELETE /api/admin/transcodes/:key
+func (h *StreamHandler) StopTranscodeSession(c *gin.Context) {
+	key := c.Param("key")
+	for _, s := range h.sessionManager.ListSessions() {
+		if s.Key == key {
+			h.sessionManager.StopSession(key)
+			c.JSON(http.StatusOK, gin.H{"message": "Transcode stopped"})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Transcode session not found"})
+}
+
+// TranscodeProgress reports how a media item's HLS transcode is going
+type TranscodeProgress struct {
+	State           string  `json:"state"` // running, complete, error or none
+	Percent         float64 `json:"percent"`
+	Speed           float64 `json:"speed,omitempty"`            // multiple of realtime
+	PositionSeconds int     `json:"position_seconds,omitempty"` // how far into the file ffmpeg is
+	StartSegment    int     `json:"start_segment,omitempty"`    // where a seeked transcode started
+	Segments        int     `json:"segments"`
+	Error           string  `json:"error,omitempty"` // ffmpeg's error when state is error
+}
+
+// GetTranscodeProgress returns the progress of a media item's HLS transcode,
+// for clients to show while waiting on the first segments. It honours
+// the same stream options as the manifest.
+// GET /api/stream/:id/transcode/status
+func (h *StreamHandler) GetTranscodeProgress(c *gin.Context) {
+	ref, ok := mediaRefParam(c, "id")
+	if !ok {
+		return
+	}
+
+	file, ok := h.lookupMediaFile(c, ref)
+	if !ok {
+		return
+	}
+
+	audio, ok := audioQuery(c, file)
+	if !ok {
+		return
+	}
+	var burnSubtitle *ffmpeg.SubtitleTrack
+	if subtitle, burn := subtitleQuery(c, file); burn {
+		burnSubtitle = subtitle
+	}
+	key := hlsKey(ref, h.normalizeQuery(c), audio, burnSubtitle)
+	status := TranscodeProgress{State: "none"}
+
+	progress, ok := h.sessionManager.Progress(key)
+	switch {
+	case ok && progress.Running:
+		status.State = "running"
+		status.Speed = progress.Speed
+		status.PositionSeconds = int(progress.Position)
+		status.StartSegment = progress.StartSegment
+		status.Segments = progress.Segments
+		if file.Duration > 0 {
+			status.Percent = min(100, progress.Position*100/float64(file.Duration))
+		}
+	case ok && progress.Err != nil:
+		status.State = "error"
+		status.Error = progress.Err.Error()
+		status.Segments = progress.Segments
+	default:
+		manifestPath := filepath.Join(h.cfg.TranscodeDir, key, "manifest.m3u8")
+		if data, err := os.ReadFile(manifestPath); err == nil && strings.Contains(string(data), "#EXT-X-ENDLIST") {
+			status.State = "complete"
+			status.Percent = 100
+			status.Segments = h.sessionManager.GetAvailableSegments(key)
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
@@ -2,31 +2,36 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
 )
 
 type WatchlistHandler struct {
-	db *db.DB
+	db  *db.DB
+	cfg *config.Config
 }
 
-func NewWatchlistHandler(database *db.DB) *WatchlistHandler {
-	return &WatchlistHandler{db: database}
+func NewWatchlistHandler(database *db.DB, cfg *config.Config) *WatchlistHandler {
+	return &WatchlistHandler{db: database, cfg: cfg}
 }
 
 type WatchlistRequest struct {
-	MediaType string `json:"media_type" binding:"required,oneof=movie tvshow episode"`
+	MediaType string `json:"media_type" binding:"omitempty,oneof=movie tvshow episode"` // optional when the path holds a ref
+}
+
+type UnwatchedRequest struct {
+	MediaType    string `json:"media_type" binding:"omitempty,oneof=movie tvshow episode"`
+	KeepPosition *bool  `json:"keep_position"` // overrides unwatched_keep_position
 }
 
 // GetWatchlist returns the user's watchlist
 func (h *WatchlistHandler) GetWatchlist(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-
-	if limit > 100 {
-		limit = 100
+	limit, ok := parseLimit(c, defaultPageSize, maxPageSize)
+	if !ok {
+		return
 	}
 
 	items, err := h.db.GetWatchlist(userID.(int64), limit)
@@ -41,21 +46,19 @@ func (h *WatchlistHandler) GetWatchlist(c *gin.Context) {
 // AddToWatchlist adds a media item to the user's watchlist
 func (h *WatchlistHandler) AddToWatchlist(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("mediaId")
 
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	var req WatchlistRequest
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	var req WatchlistRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	ref, err := parseMediaRef(c.Param("mediaId"), req.MediaType)
+	if err != nil {
+		badMediaRef(c, err)
 		return
 	}
 
-	err = h.db.AddToWatchlist(userID.(int64), mediaID, db.MediaType(req.MediaType))
+	err = h.db.AddToWatchlist(userID.(int64), ref.ID, ref.Type)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to watchlist"})
 		return
@@ -67,20 +70,13 @@ func (h *WatchlistHandler) AddToWatchlist(c *gin.Context) {
 // RemoveFromWatchlist removes a media item from the user's watchlist
 func (h *WatchlistHandler) RemoveFromWatchlist(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("mediaId")
-	mediaType := c.Query("type")
 
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	ref, ok := mediaRefParam(c, "mediaId")
+	if !ok {
 		return
 	}
 
-	if mediaType == "" {
-		mediaType = "movie"
-	}
-
-	err = h.db.RemoveFromWatchlist(userID.(int64), mediaID, db.MediaType(mediaType))
+	err := h.db.RemoveFromWatchlist(userID.(int64), ref.ID, ref.Type)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from watchlist"})
 		return
@@ -92,20 +88,13 @@ func (h *WatchlistHandler) RemoveFromWatchlist(c *gin.Context) {
 // CheckWatchlist checks if a media item is in the user's watchlist
 func (h *WatchlistHandler) CheckWatchlist(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("mediaId")
-	mediaType := c.Query("type")
 
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	ref, ok := mediaRefParam(c, "mediaId")
+	if !ok {
 		return
 	}
 
-	if mediaType == "" {
-		mediaType = "movie"
-	}
-
-	inWatchlist, err := h.db.IsInWatchlist(userID.(int64), mediaID, db.MediaType(mediaType))
+	inWatchlist, err := h.db.IsInWatchlist(userID.(int64), ref.ID, ref.Type)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check watchlist"})
 		return
@@ -117,21 +106,19 @@ func (h *WatchlistHandler) CheckWatchlist(c *gin.Context) {
 // MarkAsWatched marks a media item as watched (completed)
 func (h *WatchlistHandler) MarkAsWatched(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	mediaIDStr := c.Param("id")
 
-	mediaID, err := strconv.ParseInt(mediaIDStr, 10, 64)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid media ID"})
+	var req WatchlistRequest
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	var req WatchlistRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	ref, err := parseMediaRef(c.Param("id"), req.MediaType)
+	if err != nil {
+		badMediaRef(c, err)
 		return
 	}
 
-	err = h.db.MarkAsWatched(userID.(int64), mediaID, db.MediaType(req.MediaType))
+	err = h.db.MarkAsWatched(userID.(int64), ref.ID, ref.Type)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as watched"})
 		return
@@ -139,3 +126,33 @@ func (h *WatchlistHandler) MarkAsWatched(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Marked as watched"})
 }
+
+// MarkAsUnwatched clears the watched state of a media item. The position is
+// reset unless the config or the request asks to keep it.
+func (h *WatchlistHandler) MarkAsUnwatched(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req UnwatchedRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	ref, err := parseMediaRef(c.Param("id"), req.MediaType)
+	if err != nil {
+		badMediaRef(c, err)
+		return
+	}
+
+	keepPosition := h.cfg.UnwatchedKeepPosition
+	if req.KeepPosition != nil {
+		keepPosition = *req.KeepPosition
+	}
+
+	err = h.db.MarkAsUnwatched(userID.(int64), ref.ID, ref.Type, !keepPosition)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as unwatched"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Marked as unwatched", "position_kept": keepPosition})
+}
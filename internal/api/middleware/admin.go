@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// RequireAdmin returns a middleware that only lets admin users through.
+// It must run after JWTAuth so user_id is set in the context.
+func RequireAdmin(database *db.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := database.GetUserByID(c.GetInt64("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		if !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
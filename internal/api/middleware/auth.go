@@ -6,11 +6,44 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/stephencjuliano/media-server/internal/db"
 )
 
-// JWTAuth returns a middleware that validates JWT tokens
-func JWTAuth(secret string) gin.HandlerFunc {
+// StreamTokenScope marks tokens that only authenticate media requests, to
+// /api/stream and /api/images. They outlive access tokens so a <video>
+// element or HLS player, which can't refresh the token in its URL, keeps
+// playing past the access token's expiry.
+const StreamTokenScope = "stream"
+
+// streamTokenPaths are the path prefixes stream tokens are accepted for
+var streamTokenPaths = []string{"/api/stream/", "/api/images/"}
+
+// allowsStreamToken reports whether a stream token may authenticate path
+func allowsStreamToken(path string) bool {
+	for _, prefix := range streamTokenPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTAuth returns a middleware that validates JWT tokens and rejects revoked
+// ones. Headless clients can send an X-API-Key header instead, or an api_key
+// query parameter where they can't set headers (IPTV players fetching
+// playlist URLs). With a non-zero guestUserID, requests without a token run
+// as that user instead of getting a 401; invalid tokens are still rejected.
+func JWTAuth(database *db.DB, secret string, guestUserID int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			apiKey = c.Query("api_key")
+		}
+		if apiKey != "" {
+			apiKeyAuth(c, database, apiKey)
+			return
+		}
+
 		var tokenString string
 
 		// Try Authorization header first
@@ -27,6 +60,14 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			tokenString = c.Query("token")
 		}
 
+		if tokenString == "" && guestUserID != 0 {
+			c.Set("user_id", guestUserID)
+			c.Set("username", "guest")
+			c.Set("guest", true)
+			c.Next()
+			return
+		}
+
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
 			c.Abort()
@@ -52,15 +93,79 @@ func JWTAuth(secret string) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if scope, _ := claims["scope"].(string); scope == StreamTokenScope && !allowsStreamToken(c.Request.URL.Path) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is only valid for streaming"})
+			c.Abort()
+			return
+		}
+
+		// Tokens issued before the jti claim can't be revoked one by one, but
+		// revoking all of a user's tokens still covers them
+		userID, hasUserID := claims["user_id"].(float64)
+		tokenID, _ := claims["jti"].(string)
+		issuedAt, _ := claims["iat"].(float64)
+		revoked, err := database.TokenRevoked(tokenID, int64(userID), int64(issuedAt))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
 
 		// Set user info in context
-		if userID, ok := claims["user_id"].(float64); ok {
+		if hasUserID {
 			c.Set("user_id", int64(userID))
 		}
 		if username, ok := claims["username"].(string); ok {
 			c.Set("username", username)
 		}
+		c.Set("token_id", tokenID)
+		if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+			c.Set("token_expires_at", expiresAt.Time)
+		}
 
 		c.Next()
 	}
 }
+
+// apiKeyAuth authenticates a request as the owner of an API key. Read-only
+// keys can only make GET requests.
+func apiKeyAuth(c *gin.Context, database *db.DB, apiKey string) {
+	key, err := database.UseAPIKey(db.HashToken(apiKey))
+	if err == db.ErrNotFound {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API key"})
+		c.Abort()
+		return
+	}
+
+	user, err := database.GetUserByID(key.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+
+	if key.Scope == db.APIKeyScopeRead {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is read-only"})
+			c.Abort()
+			return
+		}
+	}
+
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("api_key_id", key.ID)
+	c.Next()
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// guestWritablePrefixes are the routes guests may use with methods other
+// than GET: saving playback progress and stopping their transcodes
+var guestWritablePrefixes = []string{"/api/progress/", "/api/stream/"}
+
+// guestHiddenPrefixes are read routes that expose the server's filesystem
+var guestHiddenPrefixes = []string{"/api/files", "/api/sources"}
+
+// GuestReadOnly returns a middleware that limits guest requests (see JWTAuth)
+// to browsing and playback. It must run after JWTAuth.
+func GuestReadOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("guest") {
+			c.Next()
+			return
+		}
+
+		path := c.FullPath()
+		if hasAnyPrefix(path, guestHiddenPrefixes) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+			c.Abort()
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !hasAnyPrefix(path, guestWritablePrefixes) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Log in to make changes"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
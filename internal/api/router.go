@@ -1,16 +1,27 @@
 package api
 
 import (
+	"log"
+	"os"
+
 	"github.com/gin-gonic/gin"
 	"github.com/stephencjuliano/media-server/internal/api/handlers"
 	"github.com/stephencjuliano/media-server/internal/api/middleware"
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/internal/library"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
 )
 
-// NewRouter creates and configures the Gin router
-func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
+// NewRouter creates and configures the Gin router. The scanner is shared with
+// the file watcher so both see the same scan state; ffmpegCaps is the startup
+// ffmpeg probe.
+func NewRouter(database *db.DB, cfg *config.Config, scanner *library.Scanner, ffmpegCaps *ffmpeg.Capabilities) *gin.Engine {
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Printf("Invalid trusted_proxies, trusting none: %v", err)
+		router.SetTrustedProxies(nil)
+	}
 
 	// Global middleware
 	router.Use(middleware.CORS())
@@ -18,35 +29,31 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(database, cfg)
-	libraryHandler := handlers.NewLibraryHandler(database, cfg)
+	libraryHandler := handlers.NewLibraryHandler(database, cfg, scanner)
 	streamHandler := handlers.NewStreamHandler(database, cfg)
-	progressHandler := handlers.NewProgressHandler(database)
+	progressHandler := handlers.NewProgressHandler(database, cfg)
 	sourceHandler := handlers.NewSourceHandler(database)
-	watchlistHandler := handlers.NewWatchlistHandler(database)
+	watchlistHandler := handlers.NewWatchlistHandler(database, cfg)
 	playlistHandler := handlers.NewPlaylistHandler(database)
 	sectionHandler := handlers.NewSectionHandler(database)
 	templateHandler := handlers.NewSectionTemplateHandler(database)
-	showsHandler := handlers.NewShowsHandler(database)
+	showsHandler := handlers.NewShowsHandler(database, cfg, scanner)
 	extrasHandler := handlers.NewExtrasHandler(database)
 	metadataHandler := handlers.NewMetadataHandler(database, cfg)
-	channelHandler := handlers.NewChannelHandler(database)
+	artworkHandler := handlers.NewArtworkHandler(database, cfg)
+	channelHandler := handlers.NewChannelHandler(database, cfg)
 	deployHandler := handlers.NewDeployHandler()
+	systemHandler := handlers.NewSystemHandler(cfg, ffmpegCaps)
 	filesHandler := handlers.NewFilesHandler("/media")
 
-	// Serve web admin interface with aggressive no-cache headers
-	serveIndex := func(c *gin.Context) {
-		c.Header("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
-		c.Header("Pragma", "no-cache")
-		c.Header("Expires", "0")
-		c.Header("CDN-Cache-Control", "no-store")
-		c.Header("Cloudflare-CDN-Cache-Control", "no-store")
-		c.Header("Surrogate-Control", "no-store")
-		c.Header("X-Content-Version", "2026012711")
-		c.File("./web/index.html")
+	// Serve web admin interface with aggressive no-cache headers. API-only
+	// deployments ship without it and get a JSON index at / instead.
+	if _, err := os.Stat("./web/index.html"); err != nil {
+		log.Printf("Web interface not found in ./web, serving API only")
+		router.GET("/", systemHandler.Index)
+	} else {
+		registerWebRoutes(router)
 	}
-	router.GET("/", serveIndex)
-	router.GET("/index.html", serveIndex)
-	router.Static("/assets", "./web/assets")
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
@@ -71,38 +78,93 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 			deploy.GET("/logs", deployHandler.GetLogs)
 		}
 
-		// Protected routes
+		// Protected routes. In guest mode unauthenticated clients get read-only
+		// access as a shared guest user.
+		var guestUserID int64
+		if cfg.AllowGuest {
+			id, err := database.EnsureGuestUser()
+			if err != nil {
+				log.Printf("Guest access disabled: %v", err)
+			} else {
+				guestUserID = id
+			}
+		}
 		protected := api.Group("")
-		protected.Use(middleware.JWTAuth(cfg.JWTSecret))
+		protected.Use(middleware.JWTAuth(database, cfg.JWTSecret, guestUserID), middleware.GuestReadOnly())
 		{
+			// Account
+			protected.POST("/auth/change-password", authHandler.ChangePassword)
+			protected.POST("/auth/logout", authHandler.Logout)
+			protected.GET("/auth/api-keys", authHandler.ListAPIKeys)
+			protected.POST("/auth/api-keys", authHandler.CreateAPIKey)
+			protected.DELETE("/auth/api-keys/:id", authHandler.DeleteAPIKey)
+
 			// Library
 			library := protected.Group("/library")
 			{
 				library.GET("/movies", libraryHandler.GetMovies)
 				library.GET("/shows", libraryHandler.GetShows)
+				library.GET("/all", libraryHandler.GetAll)
 				library.GET("/recent", libraryHandler.GetRecent)
+				library.GET("/new-episodes", libraryHandler.GetNewEpisodes)
+				library.GET("/most-watched", progressHandler.GetMostWatched)
 				library.GET("/stats", libraryHandler.GetStats)
-				library.POST("/scan", libraryHandler.TriggerScan)
+				library.GET("/counts", libraryHandler.GetCounts)
+				library.POST("/scan", middleware.RequireAdmin(database), libraryHandler.TriggerScan)
+				library.POST("/scan/stop", middleware.RequireAdmin(database), libraryHandler.StopScan)
+				library.GET("/scan/status", libraryHandler.GetScanStatus)
+				library.GET("/scan/events", libraryHandler.StreamScanStatus)
+				library.POST("/refresh-metadata", middleware.RequireAdmin(database), libraryHandler.RefreshMetadata)
+				library.POST("/cleanup", middleware.RequireAdmin(database), libraryHandler.CleanupLibrary)
+				library.POST("/parse-preview", middleware.RequireAdmin(database), libraryHandler.ParsePreview)
 			}
 
+			// Search across movies, shows and episodes
+			protected.GET("/search", libraryHandler.Search)
+
+			// System
+			protected.GET("/system/capabilities", systemHandler.GetCapabilities)
+
 			// Media
 			protected.GET("/media/:id", libraryHandler.GetMedia)
+			protected.DELETE("/media/:id", middleware.RequireAdmin(database), libraryHandler.DeleteMedia)
+			protected.GET("/media/:id/playback-info", streamHandler.GetPlaybackInfo)
+			protected.GET("/media/:id/credits", libraryHandler.GetMediaCredits)
 
 			// Metadata management
 			protected.POST("/media/:id/metadata/search", metadataHandler.SearchTMDB)
 			protected.PUT("/media/:id/metadata/apply", metadataHandler.ApplyMetadata)
 			protected.POST("/media/:id/metadata/refresh", metadataHandler.RefreshMetadata)
 
+			// Artwork
+			protected.GET("/artwork/:id/poster", artworkHandler.GetPoster)
+			protected.GET("/images/:size/:file", artworkHandler.GetImage)
+
 			// Streaming
 			stream := protected.Group("/stream")
 			{
 				stream.GET("/:id/manifest.m3u8", streamHandler.GetManifest)
-				stream.GET("/:id/segment/:num.ts", streamHandler.GetSegment)
-				stream.GET("/:id/subtitles/:lang.vtt", streamHandler.GetSubtitle)
+				stream.GET("/:id/master.m3u8", streamHandler.GetMasterManifest)
+				stream.GET("/:id/adaptive/:variant/:file", streamHandler.GetAdaptiveVariant)
+				stream.GET("/:id/segment/:num", streamHandler.GetSegment)
+				stream.GET("/:id/subtitles/:lang", streamHandler.GetSubtitle)
+				stream.GET("/:id/audio/:track/:file", streamHandler.GetAudioRendition)
 				stream.GET("/:id/direct", streamHandler.DirectPlay)
+				stream.GET("/:id/download", streamHandler.Download)
+				stream.GET("/:id/transcode/status", streamHandler.GetTranscodeProgress)
 				stream.DELETE("/:id/transcode", streamHandler.StopTranscode)
 			}
 
+			// Admin
+			admin := protected.Group("/admin")
+			admin.Use(middleware.RequireAdmin(database))
+			{
+				admin.GET("/transcodes", streamHandler.ListTranscodes)
+				admin.GET("/transcodes/status", streamHandler.GetTranscodeStatus)
+				admin.DELETE("/transcodes/:key", streamHandler.StopTranscodeSession)
+				admin.POST("/users/:id/revoke-tokens", authHandler.RevokeUserTokens)
+			}
+
 			// Progress
 			progress := protected.Group("/progress")
 			{
@@ -118,6 +180,7 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 			{
 				sources.GET("", sourceHandler.GetSources)
 				sources.POST("", sourceHandler.CreateSource)
+				sources.PATCH("/:id", sourceHandler.UpdateSource)
 				sources.DELETE("/:id", sourceHandler.DeleteSource)
 			}
 
@@ -139,6 +202,8 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 
 			// Mark as watched
 			protected.POST("/media/:id/watched", watchlistHandler.MarkAsWatched)
+			protected.POST("/media/:id/unwatched", watchlistHandler.MarkAsUnwatched)
+			protected.POST("/media/:id/played", progressHandler.RecordPlay)
 
 			// Playlists
 			playlists := protected.Group("/playlists")
@@ -150,6 +215,8 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 				playlists.DELETE("/:playlistId", playlistHandler.DeletePlaylist)
 				playlists.POST("/:playlistId/items/:mediaId", playlistHandler.AddToPlaylist)
 				playlists.DELETE("/:playlistId/items/:mediaId", playlistHandler.RemoveFromPlaylist)
+				playlists.POST("/:playlistId/shows/:showId", playlistHandler.AddShowToPlaylist)
+				playlists.POST("/:playlistId/seasons/:seasonId", playlistHandler.AddSeasonToPlaylist)
 				playlists.PUT("/:playlistId/reorder", playlistHandler.ReorderPlaylist)
 			}
 
@@ -190,12 +257,18 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 			{
 				shows.GET("", showsHandler.GetShows)
 				shows.GET("/:showId", showsHandler.GetShow)
+				shows.DELETE("/:showId", middleware.RequireAdmin(database), showsHandler.DeleteShow)
 				shows.GET("/:showId/seasons", showsHandler.GetSeasons)
 				shows.GET("/:showId/seasons/:seasonNum", showsHandler.GetSeason)
 				shows.GET("/:showId/seasons/:seasonNum/episodes", showsHandler.GetEpisodes)
 				shows.GET("/:showId/episodes", showsHandler.GetAllEpisodes)
 				shows.GET("/:showId/random", showsHandler.GetRandomEpisode)
 				shows.GET("/:showId/seasons/:seasonNum/random", showsHandler.GetRandomEpisodeFromSeason)
+				shows.POST("/:showId/seasons/:seasonNum/watched", showsHandler.MarkSeasonWatched)
+				shows.POST("/:showId/seasons/:seasonNum/unwatched", showsHandler.MarkSeasonUnwatched)
+				shows.GET("/:showId/up-next", showsHandler.GetUpNext)
+				shows.GET("/:showId/credits", showsHandler.GetCredits)
+				shows.PUT("/:showId/episode-order", middleware.RequireAdmin(database), showsHandler.SetEpisodeOrder)
 			}
 
 			// Episodes (direct access)
@@ -217,14 +290,19 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 			protected.GET("/episodes/:episodeId/extras", extrasHandler.GetEpisodeExtras)
 
 			// Channels (virtual live TV)
+			protected.GET("/channels.m3u", channelHandler.GetChannelsM3U)
 			channels := protected.Group("/channels")
 			{
 				channels.GET("", channelHandler.ListChannels)
 				channels.POST("", channelHandler.CreateChannel)
+				channels.GET("/guide", channelHandler.GetGuide)
+				channels.GET("/epg.xml", channelHandler.GetChannelsEPG)
 				channels.GET("/:id", channelHandler.GetChannel)
 				channels.PUT("/:id", channelHandler.UpdateChannel)
 				channels.DELETE("/:id", channelHandler.DeleteChannel)
 				channels.GET("/:id/now", channelHandler.GetNowPlaying)
+				channels.GET("/:id/live", channelHandler.GetLiveStream)
+				channels.GET("/:id/playlist.m3u8", channelHandler.GetChannelM3U)
 				channels.GET("/:id/schedule", channelHandler.GetSchedule)
 				channels.POST("/:id/regenerate", channelHandler.RegenerateSchedule)
 				channels.GET("/:id/sources", channelHandler.GetSources)
@@ -237,3 +315,23 @@ func NewRouter(database *db.DB, cfg *config.Config) *gin.Engine {
 
 	return router
 }
+
+// registerWebRoutes serves the web admin interface. index.html is
+// revalidated on every load so deploys show up immediately, while hashed
+// assets are cached as immutable.
+func registerWebRoutes(router *gin.Engine) {
+	serveIndex := func(c *gin.Context) {
+		c.Header("Cache-Control", "no-cache")
+		c.Header("CDN-Cache-Control", "no-store")
+		c.Header("Cloudflare-CDN-Cache-Control", "no-store")
+		c.Header("Surrogate-Control", "no-store")
+		c.Header("X-Content-Version", "2026012711")
+		c.File("./web/index.html")
+	}
+	router.GET("/", serveIndex)
+	router.GET("/index.html", serveIndex)
+	router.GET("/assets/*filepath", serveAsset)
+	router.HEAD("/assets/*filepath", serveAsset)
+	router.GET("/manifest.json", serveWebFile("manifest.json", "application/manifest+json"))
+	router.GET("/sw.js", serveWebFile("sw.js", "text/javascript; charset=utf-8"))
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webDir holds the bundled web interface
+const webDir = "./web"
+
+// hashedAssetRegex matches build output with a content hash in its name
+// (app.3f9a1c2e.js, index-B7xk2Qa9.css), which never changes in place
+var hashedAssetRegex = regexp.MustCompile(`[.-]([0-9A-Za-z_]{8,})\.[0-9A-Za-z]+$`)
+
+// isHashedAsset reports whether name carries a content hash. Hashes need a
+// digit so words like my-background.png aren't mistaken for one; a hash
+// without digits is merely revalidated.
+func isHashedAsset(name string) bool {
+	match := hashedAssetRegex.FindStringSubmatch(name)
+	return match != nil && strings.ContainsAny(match[1], "0123456789")
+}
+
+// immutableCacheControl lets browsers keep hashed assets for a year
+// without revalidating
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// serveAsset serves a file from web/assets. Hashed files are cached as
+// immutable; anything else is revalidated on every use so edits show up.
+// http.ServeFile answers Range and conditional requests.
+func serveAsset(c *gin.Context) {
+	name := path.Clean("/" + c.Param("filepath"))
+	file := filepath.Join(webDir, "assets", filepath.FromSlash(name))
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if isHashedAsset(name) {
+		c.Header("Cache-Control", immutableCacheControl)
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+	http.ServeFile(c.Writer, c.Request, file)
+}
+
+// serveWebFile returns a handler for a top-level web file that must always
+// be revalidated, such as the manifest or a service worker
+func serveWebFile(name, contentType string) gin.HandlerFunc {
+	file := filepath.Join(webDir, name)
+	return func(c *gin.Context) {
+		if _, err := os.Stat(file); err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		if contentType != "" {
+			c.Header("Content-Type", contentType)
+		}
+		if strings.HasSuffix(name, ".js") {
+			// Let a worker served from the root control the whole app
+			c.Header("Service-Worker-Allowed", "/")
+		}
+		http.ServeFile(c.Writer, c.Request, file)
+	}
+}
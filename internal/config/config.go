@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,30 +11,103 @@ import (
 // Config holds all configuration for the media server
 type Config struct {
 	// Server settings
-	Host        string `yaml:"host"`
-	Port        string `yaml:"port"`
-	Environment string `yaml:"environment"`
+	Host            string `yaml:"host"`
+	Port            string `yaml:"port"`
+	Environment     string `yaml:"environment"`
+	ExternalBaseURL string `yaml:"external_base_url"` // e.g. https://media.example.com; empty for relative URLs
+	// Reverse proxies whose X-Forwarded-For is believed; none by default
+	TrustedProxies []string `yaml:"trusted_proxies"`
 
-	// Database
-	DatabasePath string `yaml:"database_path"`
+	// Storage. DataDir is the base for any of the paths below left empty.
+	DataDir       string `yaml:"data_dir"`
+	DatabasePath  string `yaml:"database_path"`   // default: <data_dir>/media-server.db
+	ImageCacheDir string `yaml:"image_cache_dir"` // default: <data_dir>/images
 
-	// JWT settings
-	JWTSecret     string `yaml:"jwt_secret"`
-	JWTExpiration int    `yaml:"jwt_expiration_hours"`
+	// JWT settings. Access tokens are short-lived; clients renew them with a
+	// refresh token, which is replaced on every use.
+	JWTSecret              string `yaml:"jwt_secret"`
+	JWTExpiration          int    `yaml:"jwt_expiration_hours"`
+	RefreshTokenExpiration int    `yaml:"refresh_token_expiration_days"`
+	AllowGuest             bool   `yaml:"allow_guest"` // browse and stream without logging in
 
 	// Media sources
-	MediaSources []MediaSource `yaml:"media_sources"`
+	MediaSources  []MediaSource `yaml:"media_sources"`
+	EnableWatcher bool          `yaml:"enable_watcher"` // pick up new files without a manual scan
 
 	// Transcoding
 	FFmpegPath       string `yaml:"ffmpeg_path"`
-	TranscodeDir     string `yaml:"transcode_dir"`
+	TranscodeDir     string `yaml:"transcode_dir"` // default: <data_dir>/transcode
 	EnableHWAccel    bool   `yaml:"enable_hw_accel"`
 	HWAccelType      string `yaml:"hw_accel_type"` // videotoolbox, nvenc, qsv
 	DefaultQuality   string `yaml:"default_quality"`
 	ThumbnailSeconds int    `yaml:"thumbnail_seconds"`
 
+	// How long stream requests wait for transcoded segments, and how often they
+	// check. 0 derives both from the segment length and output resolution.
+	SegmentWaitSeconds int `yaml:"segment_wait_seconds"`
+	SegmentPollMillis  int `yaml:"segment_poll_ms"`
+
+	// Seconds without a playlist or segment request before a transcode is
+	// stopped and its segments deleted. 0 keeps abandoned transcodes running.
+	TranscodeIdleTimeout int `yaml:"transcode_idle_timeout"`
+	// Video transcodes and download builds allowed at once, 0 for no limit.
+	// Beyond it, idle transcodes are evicted or new streams and downloads get
+	// 503 Service Unavailable.
+	MaxTranscodeSessions int `yaml:"max_transcode_sessions"`
+	// Hours a cached download MP4 is kept after it was last downloaded. 0
+	// keeps them until the transcode dir is cleared.
+	DownloadCacheHours int `yaml:"download_cache_hours"`
+
+	// Encoder tuning, applied to every profile unless overridden per profile
+	TranscodePreset   string                            `yaml:"transcode_preset"` // x264 preset, e.g. veryfast, medium
+	TranscodeCRF      int                               `yaml:"transcode_crf"`    // 0 = target bitrate mode
+	TranscodeProfiles map[string]TranscodeProfileConfig `yaml:"transcode_profiles"`
+
+	// Output pixel format, "source" to keep the input's. The yuv420p default
+	// lets 10-bit HEVC and other high bit depth sources encode to H.264.
+	TranscodePixelFormat string `yaml:"transcode_pixel_format"`
+
+	// Transcoded audio. Clients can override normalization with ?normalize=
+	AudioSampleRate int  `yaml:"audio_sample_rate"` // Hz, 0 = keep the source rate
+	NormalizeAudio  bool `yaml:"normalize_audio"`   // loudnorm filter, evens out quiet/loud sources
+
+	// Playback
+	SubtitleLanguage string `yaml:"subtitle_language"` // preferred subtitle language (e.g. "eng"), empty for forced-only
+	// Un-marking an item as watched resets its position unless this is set
+	UnwatchedKeepPosition bool `yaml:"unwatched_keep_position"`
+	// Progress past this percentage of the duration marks an item watched
+	WatchedThresholdPercent int `yaml:"watched_threshold_percent"`
+	// Items played for less than this many seconds stay out of continue watching
+	ContinueWatchingMinSeconds int `yaml:"continue_watching_min_seconds"`
+	// Specials (season 0) are listed after a show's seasons instead of first
+	SpecialsLast bool `yaml:"specials_last"`
+
+	// Artwork. The poster endpoint generates a placeholder (title initials)
+	// for items without a poster instead of returning 404.
+	PosterPlaceholders bool `yaml:"poster_placeholders"`
+
 	// TMDb API
 	TMDbAPIKey string `yaml:"tmdb_api_key"`
+	// Requests per second sent to TMDB (0 = unlimited), and how often a
+	// request rate limited (429) or failed by TMDB is retried
+	TMDbRateLimit  float64 `yaml:"tmdb_rate_limit"`
+	TMDbMaxRetries int     `yaml:"tmdb_max_retries"`
+	// TMDB responses are cached on disk for this many hours, 0 to disable
+	TMDbCacheDir      string `yaml:"tmdb_cache_dir"` // default: <data_dir>/tmdb-cache
+	TMDbCacheTTLHours int    `yaml:"tmdb_cache_ttl_hours"`
+
+	// File is the config file Load read, empty if none was found. Save
+	// writes here.
+	File string `yaml:"-"`
+}
+
+// TranscodeProfileConfig overrides settings of a built-in transcode profile
+// (1080p, 720p, 480p). Empty/zero fields keep the global or built-in value.
+type TranscodeProfileConfig struct {
+	Preset       string `yaml:"preset"`
+	CRF          int    `yaml:"crf"`
+	VideoBitrate string `yaml:"video_bitrate"` // max bitrate when crf is set
+	AudioBitrate string `yaml:"audio_bitrate"`
 }
 
 // MediaSource represents a media storage location
@@ -46,26 +120,82 @@ type MediaSource struct {
 	Password string `yaml:"password,omitempty"`
 }
 
-// DefaultConfig returns a config with sensible defaults
+// DefaultConfig returns a config with sensible defaults. Storage paths are
+// derived from DataDir by Load.
 func DefaultConfig() *Config {
-	homeDir, _ := os.UserHomeDir()
-	dataDir := filepath.Join(homeDir, ".media-server")
-
 	return &Config{
 		Host:             "0.0.0.0",
 		Port:             "8080",
 		Environment:      "development",
-		DatabasePath:     filepath.Join(dataDir, "media-server.db"),
+		DataDir:          defaultDataDir(),
 		JWTSecret:        "", // Must be set by user
-		JWTExpiration:    24 * 7,
+		JWTExpiration:    1,
 		MediaSources:     []MediaSource{},
+		EnableWatcher:    false,
 		FFmpegPath:       "ffmpeg",
-		TranscodeDir:     filepath.Join(dataDir, "transcode"),
 		EnableHWAccel:    true,
 		HWAccelType:      "videotoolbox",
 		DefaultQuality:   "1080p",
 		ThumbnailSeconds: 30,
+		SubtitleLanguage: "",
 		TMDbAPIKey:       "",
+		TMDbRateLimit:    40,
+		TMDbMaxRetries:   5,
+
+		TMDbCacheTTLHours:      24 * 7,
+		RefreshTokenExpiration: 30,
+
+		TranscodeIdleTimeout:       300,
+		DownloadCacheHours:         24,
+		WatchedThresholdPercent:    95,
+		ContinueWatchingMinSeconds: 60,
+	}
+}
+
+// legacyDataDir is where data lived before data_dir existed
+func legacyDataDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".media-server")
+}
+
+// defaultDataDir follows the XDG base directory spec on Linux
+// ($XDG_DATA_HOME/media-server, or ~/.local/share/media-server), except
+// that an existing ~/.media-server keeps being used. Other platforms use
+// ~/.media-server.
+func defaultDataDir() string {
+	legacy := legacyDataDir()
+	if runtime.GOOS != "linux" {
+		return legacy
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return filepath.Join(xdgDir("XDG_DATA_HOME", ".local", "share"), "media-server")
+}
+
+// xdgDir returns the XDG base directory named by env, or its default under
+// the home directory
+func xdgDir(env string, defaultPath ...string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(append([]string{homeDir}, defaultPath...)...)
+}
+
+// deriveStoragePaths fills in the storage paths left empty from dataDir
+func (c *Config) deriveStoragePaths(dataDir string) {
+	if c.DatabasePath == "" {
+		c.DatabasePath = filepath.Join(dataDir, "media-server.db")
+	}
+	if c.TranscodeDir == "" {
+		c.TranscodeDir = filepath.Join(dataDir, "transcode")
+	}
+	if c.ImageCacheDir == "" {
+		c.ImageCacheDir = filepath.Join(dataDir, "images")
+	}
+	if c.TMDbCacheDir == "" {
+		c.TMDbCacheDir = filepath.Join(dataDir, "tmdb-cache")
 	}
 }
 
@@ -77,9 +207,12 @@ func Load() (*Config, error) {
 	configPaths := []string{
 		"config.yaml",
 		"config.yml",
-		filepath.Join(os.Getenv("HOME"), ".media-server", "config.yaml"),
-		"/etc/media-server/config.yaml",
+		filepath.Join(legacyDataDir(), "config.yaml"),
 	}
+	if runtime.GOOS == "linux" {
+		configPaths = append(configPaths, filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "media-server", "config.yaml"))
+	}
+	configPaths = append(configPaths, "/etc/media-server/config.yaml")
 
 	var configFile string
 	for _, path := range configPaths {
@@ -98,6 +231,7 @@ func Load() (*Config, error) {
 		if err := yaml.Unmarshal(data, cfg); err != nil {
 			return nil, err
 		}
+		cfg.File = configFile
 	}
 
 	// Override with environment variables
@@ -110,6 +244,12 @@ func Load() (*Config, error) {
 	if env := os.Getenv("MEDIA_SERVER_ENV"); env != "" {
 		cfg.Environment = env
 	}
+	if baseURL := os.Getenv("MEDIA_SERVER_EXTERNAL_BASE_URL"); baseURL != "" {
+		cfg.ExternalBaseURL = baseURL
+	}
+	if dataDir := os.Getenv("MEDIA_SERVER_DATA_DIR"); dataDir != "" {
+		cfg.DataDir = dataDir
+	}
 	if dbPath := os.Getenv("MEDIA_SERVER_DB_PATH"); dbPath != "" {
 		cfg.DatabasePath = dbPath
 	}
@@ -120,6 +260,9 @@ func Load() (*Config, error) {
 		cfg.TMDbAPIKey = tmdbKey
 	}
 
+	// Paths not set individually live under the data dir
+	cfg.deriveStoragePaths(cfg.DataDir)
+
 	// Ensure directories exist
 	if err := os.MkdirAll(filepath.Dir(cfg.DatabasePath), 0755); err != nil {
 		return nil, err
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HWAccelTypes are the accepted hw_accel_type values
+var HWAccelTypes = []string{"videotoolbox", "nvenc", "qsv", "vaapi"}
+
+// mediaSourceTypes are the accepted media source types
+var mediaSourceTypes = []string{"local", "smb", "nfs"}
+
+// Validate reports the first setting the server can't run with
+func (c *Config) Validate() error {
+	if c.JWTSecret == "" {
+		return errors.New("jwt_secret must be set")
+	}
+	if c.JWTExpiration <= 0 {
+		return errors.New("jwt_expiration_hours must be positive")
+	}
+	if c.RefreshTokenExpiration <= 0 {
+		return errors.New("refresh_token_expiration_days must be positive")
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+	if (c.EnableHWAccel || c.HWAccelType != "") && !slices.Contains(HWAccelTypes, c.HWAccelType) {
+		return fmt.Errorf("invalid hw_accel_type %q, expected one of %v", c.HWAccelType, HWAccelTypes)
+	}
+	if c.DataDir == "" && (c.DatabasePath == "" || c.TranscodeDir == "" || c.ImageCacheDir == "" || c.TMDbCacheDir == "") {
+		return errors.New("data_dir must be set")
+	}
+	if c.WatchedThresholdPercent < 1 || c.WatchedThresholdPercent > 100 {
+		return fmt.Errorf("watched_threshold_percent must be between 1 and 100, got %d", c.WatchedThresholdPercent)
+	}
+	if c.TranscodeIdleTimeout < 0 {
+		return errors.New("transcode_idle_timeout must not be negative")
+	}
+	if c.MaxTranscodeSessions < 0 {
+		return errors.New("max_transcode_sessions must not be negative")
+	}
+	if c.DownloadCacheHours < 0 {
+		return errors.New("download_cache_hours must not be negative")
+	}
+	if c.ContinueWatchingMinSeconds < 0 {
+		return errors.New("continue_watching_min_seconds must not be negative")
+	}
+	if c.TMDbRateLimit < 0 || c.TMDbMaxRetries < 0 {
+		return errors.New("tmdb_rate_limit and tmdb_max_retries must not be negative")
+	}
+	if c.TMDbCacheTTLHours < 0 {
+		return errors.New("tmdb_cache_ttl_hours must not be negative")
+	}
+
+	ids := make(map[string]bool)
+	for _, source := range c.MediaSources {
+		if source.ID == "" || source.Path == "" {
+			return fmt.Errorf("media source %q needs an id and a path", source.Name)
+		}
+		if ids[source.ID] {
+			return fmt.Errorf("duplicate media source id %q", source.ID)
+		}
+		ids[source.ID] = true
+		if source.Type != "" && !slices.Contains(mediaSourceTypes, source.Type) {
+			return fmt.Errorf("media source %q has invalid type %q", source.ID, source.Type)
+		}
+	}
+	return nil
+}
+
+// Save validates c and writes it to path as YAML. The file is replaced
+// atomically, so a crash mid-write leaves the previous config intact.
+//
+// Comments and key order of an existing file are kept. Settings the file
+// doesn't mention are only added when they differ from their defaults, so
+// derived storage paths stay derived. Values that came from environment
+// variables are written like any other.
+func (c *Config) Save(path string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	var updated, defaults yaml.Node
+	if err := updated.Encode(c); err != nil {
+		return err
+	}
+	if err := defaults.Encode(c.defaults()); err != nil {
+		return err
+	}
+
+	// Resolve symlinks so the rename replaces the target, not the link
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	perm := os.FileMode(0600) // the config holds secrets
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var existing yaml.Node
+		if err := yaml.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		if len(existing.Content) == 1 && existing.Content[0].Kind == yaml.MappingNode {
+			doc, root = &existing, existing.Content[0]
+		}
+		if info, err := os.Stat(path); err == nil {
+			perm = info.Mode().Perm()
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	mergeMapping(root, &updated, &defaults)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), perm)
+}
+
+// defaults returns the config Load would produce from an empty file, with
+// storage paths derived from c's data dir
+func (c *Config) defaults() *Config {
+	defaults := DefaultConfig()
+	defaults.deriveStoragePaths(c.DataDir)
+	return defaults
+}
+
+// mergeMapping writes the keys of src into dst. Existing values are replaced
+// in place, keeping their comments; nested mappings are merged key by key.
+// Keys dst lacks are appended unless src has the default value for them.
+func mergeMapping(dst, src, defaults *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		if existing := mappingValue(dst, key.Value); existing != nil {
+			if existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+				mergeMapping(existing, value, mappingValue(defaults, key.Value))
+				continue
+			}
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!str" && existing.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+				value.Style = existing.Style // keep "quoted" strings quoted
+			}
+			value.HeadComment = existing.HeadComment
+			value.LineComment = existing.LineComment
+			value.FootComment = existing.FootComment
+			*existing = *value
+			continue
+		}
+
+		if sameNode(value, mappingValue(defaults, key.Value)) {
+			continue
+		}
+		dst.Content = append(dst.Content, key, value)
+	}
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sameNode reports whether two nodes hold the same value
+func sameNode(a, b *yaml.Node) bool {
+	if a == nil || b == nil || a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it
+// over path once it's synced to disk
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// aggregatesMaxAge bounds how stale cached counts get when the library is
+// changed behind the repository's back (e.g. by hand in SQLite).
+const aggregatesMaxAge = time.Minute
+
+// LibraryAggregates are the library-wide counts behind the stats dashboard
+// and faceted browsing
+type LibraryAggregates struct {
+	LibraryStats
+	Facets     *LibraryFacets `json:"facets"`
+	ComputedAt time.Time      `json:"computed_at"`
+}
+
+// aggregateCache keeps LibraryAggregates between library changes. Each
+// invalidation bumps the generation, so a computation that raced with a
+// change isn't cached.
+type aggregateCache struct {
+	mu         sync.Mutex
+	generation uint64
+	value      *LibraryAggregates
+}
+
+// invalidateAggregates drops the cached counts. Repository methods that add,
+// change or remove library items call it.
+func (db *DB) invalidateAggregates() {
+	db.aggregates.mu.Lock()
+	defer db.aggregates.mu.Unlock()
+	db.aggregates.generation++
+	db.aggregates.value = nil
+}
+
+// GetLibraryAggregates returns library counts by type, genre, resolution and
+// year, computing them only after the library changed. The result is shared
+// between callers and must not be modified.
+func (db *DB) GetLibraryAggregates() (*LibraryAggregates, error) {
+	cache := &db.aggregates
+	cache.mu.Lock()
+	if cache.value != nil && time.Since(cache.value.ComputedAt) < aggregatesMaxAge {
+		value := cache.value
+		cache.mu.Unlock()
+		return value, nil
+	}
+	generation := cache.generation
+	cache.mu.Unlock()
+
+	stats, err := db.GetLibraryStats()
+	if err != nil {
+		return nil, err
+	}
+	facets, err := db.GetLibraryFacets()
+	if err != nil {
+		return nil, err
+	}
+	value := &LibraryAggregates{LibraryStats: *stats, Facets: facets, ComputedAt: time.Now()}
+
+	cache.mu.Lock()
+	if cache.generation == generation {
+		cache.value = value
+	}
+	cache.mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// apiKeyTouchInterval limits how often a key's last use is written, so
+// clients streaming segments don't write on every request
+const apiKeyTouchInterval = time.Minute
+
+const apiKeyColumns = `id, user_id, COALESCE(name, ''), scope, prefix, created_at, last_used_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	key := &APIKey{}
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Scope, &key.Prefix, &key.CreatedAt, &key.LastUsedAt)
+	return key, err
+}
+
+// CreateAPIKey stores a new API key by the hash of its value
+func (db *DB) CreateAPIKey(key *APIKey, keyHash string) (*APIKey, error) {
+	result, err := db.conn.Exec(
+		`INSERT INTO api_keys (user_id, name, scope, prefix, key_hash) VALUES (?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Scope, key.Prefix, keyHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	created, err := scanAPIKey(db.conn.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetUserAPIKeys returns a user's API keys, newest first
+func (db *DB) GetUserAPIKeys(userID int64) ([]*APIKey, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes one of a user's API keys
+func (db *DB) DeleteAPIKey(userID, id int64) error {
+	result, err := db.conn.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseAPIKey looks up the API key with a hash and records that it was used.
+// It returns ErrNotFound for unknown keys.
+func (db *DB) UseAPIKey(keyHash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.conn.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if _, err := db.conn.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, key.ID); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
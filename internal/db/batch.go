@@ -0,0 +1,91 @@
+package db
+
+// CreateMediaBatch inserts several media items in one transaction, which a
+// scan adding thousands of files commits far fewer times than CreateMedia.
+// It returns the created items in the order given; if any insert fails,
+// none of them are added.
+func (db *DB) CreateMediaBatch(items []*Media) ([]*Media, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	defer db.invalidateAggregates()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertMediaSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, 0, len(items))
+	for _, media := range items {
+		result, err := stmt.Exec(mediaInsertArgs(media)...)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := result.LastInsertId()
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	created := make([]*Media, 0, len(ids))
+	for _, id := range ids {
+		media, err := db.GetMediaByID(id)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, media)
+	}
+	return created, nil
+}
+
+// CreateEpisodeBatch inserts several episodes in one transaction, like
+// CreateMediaBatch
+func (db *DB) CreateEpisodeBatch(episodes []*Episode) ([]*Episode, error) {
+	if len(episodes) == 0 {
+		return nil, nil
+	}
+	defer db.invalidateAggregates()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertEpisodeSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	ids := make([]int64, 0, len(episodes))
+	for _, episode := range episodes {
+		result, err := stmt.Exec(episodeInsertArgs(episode)...)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := result.LastInsertId()
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	created := make([]*Episode, 0, len(ids))
+	for _, id := range ids {
+		episode, err := db.GetEpisodeByID(id)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, episode)
+	}
+	return created, nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+// newTestSource adds the media source test items belong to
+func newTestSource(t *testing.T, db *DB) *MediaSource {
+	t.Helper()
+	source, err := db.CreateMediaSource(&MediaSource{Name: "Test", Path: "/media", Type: "local", Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateMediaSource: %v", err)
+	}
+	return source
+}
+
+// newTestMovie adds a movie of an hour to source
+func newTestMovie(t *testing.T, db *DB, source *MediaSource, title string, year int, rating float64) *Media {
+	t.Helper()
+	media := &Media{Type: MediaTypeMovie}
+	media.Title, media.Year, media.Rating = title, year, rating
+	media.SourceID = source.ID
+	media.FilePath = fmt.Sprintf("/media/movies/%s.mkv", title)
+	media.Duration = 3600
+	created, err := db.CreateMedia(media)
+	if err != nil {
+		t.Fatalf("CreateMedia: %v", err)
+	}
+	return created
+}
+
+// newTestShow adds a show with no seasons
+func newTestShow(t *testing.T, db *DB, title string) *TVShow {
+	t.Helper()
+	show, err := db.CreateTVShow(&TVShow{Title: title})
+	if err != nil {
+		t.Fatalf("CreateTVShow: %v", err)
+	}
+	return show
+}
+
+// newTestSeason adds a season to show
+func newTestSeason(t *testing.T, db *DB, show *TVShow, number int) *Season {
+	t.Helper()
+	season, err := db.CreateSeason(&Season{TVShowID: show.ID, SeasonNumber: number})
+	if err != nil {
+		t.Fatalf("CreateSeason: %v", err)
+	}
+	return season
+}
+
+// newTestEpisode adds a half hour episode to season
+func newTestEpisode(t *testing.T, db *DB, source *MediaSource, season *Season, number int) *Episode {
+	t.Helper()
+	episode := &Episode{TVShowID: season.TVShowID, SeasonID: season.ID, SeasonNumber: season.SeasonNumber, EpisodeNumber: number}
+	episode.Title = fmt.Sprintf("S%02dE%02d", season.SeasonNumber, number)
+	episode.SourceID = source.ID
+	episode.FilePath = fmt.Sprintf("/media/shows/%d/%s.mkv", season.TVShowID, episode.Title)
+	episode.Duration = 1800
+	created, err := db.CreateEpisode(episode)
+	if err != nil {
+		t.Fatalf("CreateEpisode: %v", err)
+	}
+	return created
+}
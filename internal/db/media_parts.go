@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+)
+
+// AddMediaPart records a file of a split movie, or moves it to another movie
+// or part number if it's already recorded. The movie's duration becomes the
+// combined duration of its parts.
+func (db *DB) AddMediaPart(part *MediaPart) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO media_parts (media_id, part_number, file_path, file_size, duration)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(file_path) DO UPDATE SET media_id = excluded.media_id,
+			part_number = excluded.part_number, file_size = excluded.file_size, duration = excluded.duration`,
+		part.MediaID, part.PartNumber, part.FilePath, part.FileSize, part.Duration,
+	); err != nil {
+		return err
+	}
+	if err := updatePartsDuration(tx, part.MediaID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMediaParts returns the parts of a split movie in playback order, or none
+// if it's a single file
+func (db *DB) GetMediaParts(mediaID int64) ([]MediaPart, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, media_id, part_number, file_path, COALESCE(file_size, 0), COALESCE(duration, 0)
+		 FROM media_parts WHERE media_id = ? ORDER BY part_number, file_path`,
+		mediaID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []MediaPart
+	for rows.Next() {
+		var part MediaPart
+		if err := rows.Scan(&part.ID, &part.MediaID, &part.PartNumber, &part.FilePath, &part.FileSize, &part.Duration); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, rows.Err()
+}
+
+// GetMediaPartByFilePath returns the part stored in a file
+func (db *DB) GetMediaPartByFilePath(filePath string) (*MediaPart, error) {
+	var part MediaPart
+	err := db.conn.QueryRow(
+		`SELECT id, media_id, part_number, file_path, COALESCE(file_size, 0), COALESCE(duration, 0)
+		 FROM media_parts WHERE file_path = ?`,
+		filePath,
+	).Scan(&part.ID, &part.MediaID, &part.PartNumber, &part.FilePath, &part.FileSize, &part.Duration)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &part, nil
+}
+
+// GetMovieFilesInDirectory returns the movie files directly inside dir,
+// parts of split movies included, mapped to their movie's ID
+func (db *DB) GetMovieFilesInDirectory(dir string) (map[string]int64, error) {
+	pattern := escapeLike(dir+string(filepath.Separator)) + "%"
+	rows, err := db.conn.Query(
+		`SELECT file_path, id FROM media WHERE type = 'movie' AND file_path LIKE ? ESCAPE '\'
+		 UNION ALL
+		 SELECT file_path, media_id FROM media_parts WHERE file_path LIKE ? ESCAPE '\'`,
+		pattern, pattern,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]int64)
+	for rows.Next() {
+		var path string
+		var mediaID int64
+		if err := rows.Scan(&path, &mediaID); err != nil {
+			return nil, err
+		}
+		// LIKE also matches files in subfolders
+		if filepath.Dir(path) == dir {
+			files[path] = mediaID
+		}
+	}
+	return files, rows.Err()
+}
+
+// updatePartsDuration sets a split movie's duration to the combined duration
+// of its parts. Movies without parts are left alone.
+func updatePartsDuration(tx *sql.Tx, mediaID int64) error {
+	_, err := tx.Exec(
+		`UPDATE media SET duration = (SELECT SUM(COALESCE(duration, 0)) FROM media_parts WHERE media_id = media.id)
+		 WHERE id = ? AND EXISTS (SELECT 1 FROM media_parts WHERE media_id = media.id)`,
+		mediaID,
+	)
+	return err
+}
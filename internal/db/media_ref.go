@@ -0,0 +1,156 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidMediaType is returned when a media type is not one of the
+// MediaType constants
+var ErrInvalidMediaType = errors.New("invalid media type")
+
+// MediaRef is a compound reference to a playable item, e.g. "movie:12" or
+// "episode:12". IDs are only unique within their own table, so clients should
+// pass refs around instead of bare IDs.
+type MediaRef struct {
+	Type MediaType
+	ID   int64
+}
+
+// String formats the ref as "type:id"
+func (r MediaRef) String() string {
+	return fmt.Sprintf("%s:%d", r.Type, r.ID)
+}
+
+// ParseMediaRef parses a "type:id" ref
+func ParseMediaRef(s string) (MediaRef, error) {
+	typePart, idPart, ok := strings.Cut(s, ":")
+	if !ok {
+		return MediaRef{}, fmt.Errorf("invalid media ref %q", s)
+	}
+
+	mediaType := MediaType(typePart)
+	if !mediaType.Valid() {
+		return MediaRef{}, fmt.Errorf("%w %q", ErrInvalidMediaType, typePart)
+	}
+
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || id <= 0 {
+		return MediaRef{}, fmt.Errorf("invalid media ID %q", idPart)
+	}
+
+	return MediaRef{Type: mediaType, ID: id}, nil
+}
+
+// Ref returns the compound reference for a media item
+func (m *Media) Ref() MediaRef {
+	return MediaRef{Type: m.Type, ID: m.ID}
+}
+
+// Ref returns the compound reference for an episode
+func (e *Episode) Ref() MediaRef {
+	return MediaRef{Type: MediaTypeEpisode, ID: e.ID}
+}
+
+// Ref returns the compound reference for an extra
+func (ex *Extra) Ref() MediaRef {
+	return MediaRef{Type: MediaTypeExtra, ID: ex.ID}
+}
+
+// Ref returns the compound reference for a library item
+func (i *LibraryItem) Ref() MediaRef {
+	return MediaRef{Type: i.Type, ID: i.ID}
+}
+
+// MarshalJSON adds the "ref" field to media JSON
+func (m Media) MarshalJSON() ([]byte, error) {
+	type media Media
+	return json.Marshal(struct {
+		media
+		Ref string `json:"ref"`
+	}{media(m), m.Ref().String()})
+}
+
+// MarshalJSON adds the "ref" field to episode JSON
+func (e Episode) MarshalJSON() ([]byte, error) {
+	type episode Episode
+	return json.Marshal(struct {
+		episode
+		Ref string `json:"ref"`
+	}{episode(e), e.Ref().String()})
+}
+
+// MarshalJSON adds the "ref" field to extra JSON
+func (ex Extra) MarshalJSON() ([]byte, error) {
+	type extra Extra
+	return json.Marshal(struct {
+		extra
+		Ref string `json:"ref"`
+	}{extra(ex), ex.Ref().String()})
+}
+
+// MarshalJSON adds the "ref" field to watch progress JSON
+func (p WatchProgress) MarshalJSON() ([]byte, error) {
+	type progress WatchProgress
+	return json.Marshal(struct {
+		progress
+		Ref string `json:"ref"`
+	}{progress(p), MediaRef{Type: p.MediaType, ID: p.MediaID}.String()})
+}
+
+// MarshalJSON adds the "ref" field to playlist item JSON
+func (i PlaylistItemWithMedia) MarshalJSON() ([]byte, error) {
+	type item PlaylistItemWithMedia
+	return json.Marshal(struct {
+		item
+		Ref string `json:"ref"`
+	}{item(i), MediaRef{Type: i.MediaType, ID: i.MediaID}.String()})
+}
+
+// MarshalJSON adds the "ref" field to channel schedule item JSON
+func (i ChannelScheduleItem) MarshalJSON() ([]byte, error) {
+	type item ChannelScheduleItem
+	return json.Marshal(struct {
+		item
+		Ref string `json:"ref"`
+	}{item(i), MediaRef{Type: i.MediaType, ID: i.MediaID}.String()})
+}
+
+// MarshalJSON adds the "ref" field to library item JSON
+func (i LibraryItem) MarshalJSON() ([]byte, error) {
+	type item LibraryItem
+	return json.Marshal(struct {
+		item
+		Ref string `json:"ref"`
+	}{item(i), i.Ref().String()})
+}
+
+// SectionItem is a movie, show, episode or extra listed in a section
+type SectionItem struct {
+	Kind MediaType
+	Item interface{}
+}
+
+// MarshalJSON adds the "kind" field to the item's JSON, so sections mixing
+// movies and shows can tell them apart
+func (i SectionItem) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(i.Item)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := json.Marshal(i.Kind)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 || data[0] != '{' {
+		return data, nil
+	}
+	fields := data[1:]
+	if string(fields) != "}" {
+		fields = append([]byte{','}, fields...)
+	}
+	return append([]byte(`{"kind":`+string(kind)), fields...), nil
+}
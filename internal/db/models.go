@@ -43,10 +43,29 @@ type User struct {
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
+	IsAdmin      bool      `json:"is_admin"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// API key scopes. Read-only keys can only make GET requests.
+const (
+	APIKeyScopeFull = "full"
+	APIKeyScopeRead = "read"
+)
+
+// APIKey lets a headless client act as its user without logging in. Only a
+// hash of the key is stored; Prefix is its start, to tell keys apart.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
 // MediaType represents the type of media
 type MediaType string
 
@@ -57,6 +76,15 @@ const (
 	MediaTypeExtra   MediaType = "extra"
 )
 
+// Valid reports whether t is one of the known media types
+func (t MediaType) Valid() bool {
+	switch t {
+	case MediaTypeMovie, MediaTypeTVShow, MediaTypeEpisode, MediaTypeExtra:
+		return true
+	}
+	return false
+}
+
 // ExtraCategory represents the type of extra content
 type ExtraCategory string
 
@@ -73,14 +101,25 @@ const (
 
 // Media represents a media item (movie or TV show)
 type Media struct {
-	ID           int64     `json:"id"`
-	MediaFile              // Embedded
-	TMDBMetadata           // Embedded
-	Timestamps             // Embedded
-	Type         MediaType `json:"type"`
-	Runtime      int       `json:"runtime,omitempty"`
-	SeasonCount  int       `json:"season_count,omitempty"`
-	EpisodeCount int       `json:"episode_count,omitempty"`
+	ID           int64       `json:"id"`
+	MediaFile                // Embedded
+	TMDBMetadata             // Embedded
+	Timestamps               // Embedded
+	Type         MediaType   `json:"type"`
+	Runtime      int         `json:"runtime,omitempty"`
+	SeasonCount  int         `json:"season_count,omitempty"`
+	EpisodeCount int         `json:"episode_count,omitempty"`
+	Parts        []MediaPart `json:"parts,omitempty"` // Files of a split movie, loaded by GetMediaByID
+}
+
+// MediaPart is one file of a movie split into parts, like "CD2"
+type MediaPart struct {
+	ID         int64  `json:"id"`
+	MediaID    int64  `json:"media_id"`
+	PartNumber int    `json:"part_number"`
+	FilePath   string `json:"file_path"`
+	FileSize   int64  `json:"file_size"`
+	Duration   int    `json:"duration"`
 }
 
 // TVShow represents a TV series (parent of episodes)
@@ -97,6 +136,8 @@ type TVShow struct {
 	TMDbID       int       `json:"tmdb_id,omitempty"`
 	IMDbID       string    `json:"imdb_id,omitempty"`
 	Status       string    `json:"status,omitempty"` // Returning Series, Ended, etc.
+	EpisodeOrder   string  `json:"episode_order,omitempty"`    // how episode files are numbered, see EpisodeOrder*
+	EpisodeGroupID string  `json:"episode_group_id,omitempty"` // TMDB episode group backing a non-aired order
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	// Computed fields (populated by queries with JOINs, not stored in DB)
@@ -112,6 +153,18 @@ type TVShow struct {
 	MaxResolution     string `json:"max_resolution,omitempty"`       // Highest resolution available
 }
 
+// Episode orders a show's files can be numbered in. Orders other than aired
+// are backed by a TMDB episode group of the same kind.
+const (
+	EpisodeOrderAired      = "aired"
+	EpisodeOrderDVD        = "dvd"
+	EpisodeOrderAbsolute   = "absolute"
+	EpisodeOrderDigital    = "digital"
+	EpisodeOrderStoryArc   = "story_arc"
+	EpisodeOrderProduction = "production"
+	EpisodeOrderTV         = "tv"
+)
+
 // Season represents a TV season
 type Season struct {
 	ID           int64     `json:"id"`
@@ -135,11 +188,30 @@ type Episode struct {
 	Title         string  `json:"title"`
 	Overview      string  `json:"overview,omitempty"`
 	StillPath     string  `json:"still_path,omitempty"`
-	AirDate       string  `json:"air_date,omitempty"`
-	Runtime       int     `json:"runtime,omitempty"`
+	AirDate       string     `json:"air_date,omitempty"`
+	AiredAt       *time.Time `json:"aired_at,omitempty"` // AirDate parsed; nil if unknown
+	Runtime       int        `json:"runtime,omitempty"`
 	Rating        float64 `json:"rating,omitempty"`
 	MediaFile               // Embedded
 	Timestamps              // Embedded
+
+	// Parent show artwork, filled in by AttachShowArtwork for episode cards
+	// that have no still
+	ShowPosterPath   string `json:"show_poster_path,omitempty"`
+	ShowBackdropPath string `json:"show_backdrop_path,omitempty"`
+
+	// Playback URLs, filled in by API handlers
+	StreamURL string `json:"stream_url,omitempty"`
+	DirectURL string `json:"direct_url,omitempty"`
+}
+
+// EpisodeWithShow is an episode with the show and season context needed to
+// display it outside of its show (e.g. in a "new episodes" feed)
+type EpisodeWithShow struct {
+	Episode        *Episode `json:"episode"`
+	ShowTitle      string   `json:"show_title"`
+	ShowPosterPath string   `json:"show_poster_path,omitempty"`
+	SeasonName     string   `json:"season_name,omitempty"`
 }
 
 // MediaSource represents a configured media source
@@ -152,6 +224,10 @@ type MediaSource struct {
 	Password  string    `json:"-"`
 	Enabled   bool      `json:"enabled"`
 	LastScan  time.Time `json:"last_scan,omitempty"`
+
+	// AbsoluteNumbering reads season-less names like "Show - 1071" as
+	// absolute episode numbers (common for anime)
+	AbsoluteNumbering bool `json:"absolute_numbering"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -165,7 +241,10 @@ type WatchProgress struct {
 	Position  int       `json:"position"`  // in seconds
 	Duration  int       `json:"duration"`  // in seconds
 	Completed bool      `json:"completed"`
+	PlayCount int       `json:"play_count"` // times the user finished it
 	UpdatedAt time.Time `json:"updated_at"`
+
+	LastPlayedAt *time.Time `json:"last_played_at,omitempty"`
 }
 
 // Watchlist represents a user's saved items
@@ -187,6 +266,21 @@ type Playlist struct {
 	ItemCount   int       `json:"item_count"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Smart playlists hold whatever matches their rules when they're read,
+	// instead of items added by hand
+	Smart bool           `json:"smart"`
+	Rules []PlaylistRule `json:"rules,omitempty"`
+}
+
+// PlaylistRule is a smart playlist criterion, with the same fields,
+// operators and grouping as a SectionRule
+type PlaylistRule struct {
+	Field       string `json:"field"`
+	Operator    string `json:"operator"`
+	Value       string `json:"value"` // JSON-encoded value
+	Group       int    `json:"group"`
+	Conjunction string `json:"conjunction,omitempty"`
 }
 
 // PlaylistItem represents an item in a playlist
@@ -236,6 +330,30 @@ type Extra struct {
 	ParentTitle string `json:"parent_title,omitempty"`
 }
 
+// ExternalSubtitle is a sidecar subtitle file next to a movie or episode,
+// like "Movie.Title.2019.en.forced.srt"
+type ExternalSubtitle struct {
+	ID        int64     `json:"id"`
+	MediaID   int64     `json:"media_id"`
+	MediaType MediaType `json:"media_type"`
+	FilePath  string    `json:"file_path"`
+	Language  string    `json:"language,omitempty"` // as written in the file name, "" if it has none
+	Format    string    `json:"format"`             // srt, ass, ssa or vtt
+	Forced    bool      `json:"forced"`
+}
+
+// Credit is a cast member of a movie or show. Order is their billing on
+// TMDB, lowest first.
+type Credit struct {
+	MediaID     int64     `json:"media_id"`
+	MediaType   MediaType `json:"media_type"`
+	PersonID    int       `json:"person_id"` // TMDB person ID
+	Name        string    `json:"name"`
+	Character   string    `json:"character,omitempty"`
+	ProfilePath string    `json:"profile_path,omitempty"`
+	Order       int       `json:"order"`
+}
+
 // Section types
 const (
 	SectionTypeStandard = "standard" // Manual assignment
@@ -246,11 +364,20 @@ const (
 // Rule operators
 const (
 	OperatorEquals      = "equals"
+	OperatorNotEquals   = "not_equals"
 	OperatorContains    = "contains"
+	OperatorNotContains = "not_contains"
 	OperatorGreaterThan = "greater_than"
 	OperatorLessThan    = "less_than"
 	OperatorInRange     = "in_range"
 	OperatorRegex       = "regex"
+	OperatorWithinDays  = "within_days" // date fields, e.g. added in the last N days
+)
+
+// Rule conjunctions, joining the rules of a group
+const (
+	ConjunctionAnd = "and"
+	ConjunctionOr  = "or"
 )
 
 // Section represents a library section (Movies, TV Shows, or custom sections)
@@ -278,9 +405,13 @@ type SectionRule struct {
 	ID        int64     `json:"id"`
 	SectionID int64     `json:"section_id"`
 	Field     string    `json:"field"`    // 'type', 'genre', 'year', 'resolution', 'rating', etc.
-	Operator  string    `json:"operator"` // 'equals', 'contains', 'greater_than', 'less_than', 'in_range', 'regex'
+	Operator  string    `json:"operator"` // 'equals', 'not_equals', 'contains', 'not_contains', 'greater_than', 'less_than', 'in_range', 'regex', 'within_days'
 	Value     string    `json:"value"`    // JSON-encoded value
-	CreatedAt time.Time `json:"created_at"`
+	// Rules with the same Group are AND'd, or OR'd when Conjunction is "or";
+	// groups are AND'd together
+	Group       int       `json:"group"`
+	Conjunction string    `json:"conjunction,omitempty"` // 'and' (default) or 'or'
+	CreatedAt   time.Time `json:"created_at"`
 }
 
 // MediaSection links media items to sections (many-to-many)
@@ -289,6 +420,7 @@ type MediaSection struct {
 	MediaID   int64     `json:"media_id"`
 	MediaType MediaType `json:"media_type"`
 	SectionID int64     `json:"section_id"`
+	Manual    bool      `json:"manual"` // added by a user rather than matched by rules
 	AddedAt   time.Time `json:"added_at"`
 }
 
@@ -305,6 +437,9 @@ const (
 	ChannelSourceShow         = "show"
 	ChannelSourceMovie        = "movie"
 	ChannelSourceExtraCategory = "extra_category"
+	// Short clips played between items rather than as programs: a playlist
+	// (source_id), or the movies and extras in a folder (source_value)
+	ChannelSourceBumper = "bumper"
 )
 
 // Channel represents a virtual "live TV" channel
@@ -317,6 +452,9 @@ type Channel struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
+	// A bumper plays after every BumperFrequency items; 0 turns bumpers off
+	BumperFrequency int `json:"bumper_frequency"`
+
 	// Populated when fetching with sources
 	Sources []ChannelSource `json:"sources,omitempty"`
 
@@ -360,7 +498,7 @@ func (o *ChannelSourceOptions) GetEffectiveVersionMode() string {
 type ChannelSource struct {
 	ID          int64                 `json:"id"`
 	ChannelID   int64                 `json:"channel_id"`
-	SourceType  string                `json:"source_type"` // 'section', 'playlist', 'show', 'movie', 'extra_category'
+	SourceType  string                `json:"source_type"` // 'section', 'playlist', 'show', 'movie', 'extra_category', 'bumper'
 	SourceID    *int64                `json:"source_id,omitempty"`
 	SourceValue string                `json:"source_value,omitempty"` // For extra_category: category name
 	Weight      int                   `json:"weight"`                 // Higher = more frequent
@@ -380,14 +518,18 @@ type ChannelScheduleItem struct {
 	ScheduledPosition int       `json:"scheduled_position"`
 	CycleNumber       int       `json:"cycle_number"`
 	Duration          int       `json:"duration"`        // in seconds
-	CumulativeStart   int       `json:"cumulative_start"` // cumulative seconds from cycle start
+	CumulativeStart   int       `json:"cumulative_start"` // seconds from schedule start
 	Played            bool      `json:"played"`
+	StartsAt          time.Time `json:"starts_at"` // wall-clock start, computed on read
 
 	// Populated for display
-	Title        string `json:"title,omitempty"`
-	ShowTitle    string `json:"show_title,omitempty"`
-	PosterPath   string `json:"poster_path,omitempty"`
-	BackdropPath string `json:"backdrop_path,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ShowTitle     string `json:"show_title,omitempty"`
+	Overview      string `json:"overview,omitempty"`
+	SeasonNumber  int    `json:"season_number,omitempty"`
+	EpisodeNumber int    `json:"episode_number,omitempty"`
+	PosterPath    string `json:"poster_path,omitempty"`
+	BackdropPath  string `json:"backdrop_path,omitempty"`
 }
 
 // ChannelNowPlaying represents what's currently playing on a channel
@@ -399,3 +541,13 @@ type ChannelNowPlaying struct {
 	CycleStart  time.Time            `json:"cycle_start"` // when current cycle started
 	StreamURL   string               `json:"stream_url,omitempty"`
 }
+
+// ChannelGuideEntry is one row of the channel guide: what's on a channel now
+// and what comes next
+type ChannelGuideEntry struct {
+	Channel    Channel              `json:"channel"`
+	NowPlaying *ChannelScheduleItem `json:"now_playing"`
+	Elapsed    int                  `json:"elapsed"` // seconds into current item
+	Next       *ChannelScheduleItem `json:"next"`
+	StreamURL  string               `json:"stream_url,omitempty"`
+}
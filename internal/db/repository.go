@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -57,7 +59,7 @@ func scanEpisodeRow(row *sql.Row) (Episode, error) {
 	err := row.Scan(
 		&e.ID, &e.TVShowID, &e.SeasonID, &e.SeasonNumber,
 		&e.EpisodeNumber, &e.Title, &e.Overview, &e.StillPath,
-		&e.AirDate, &e.Runtime, &e.Rating, &e.SourceID, &e.FilePath,
+		&e.AirDate, &e.AiredAt, &e.Runtime, &e.Rating, &e.SourceID, &e.FilePath,
 		&e.FileSize, &e.Duration, &e.VideoCodec, &e.AudioCodec,
 		&e.Resolution, &e.AudioTracks, &e.SubtitleTracks,
 		&e.CreatedAt, &e.UpdatedAt,
@@ -78,10 +80,11 @@ func scanExtraRow(row *sql.Row) (Extra, error) {
 
 // User Repository Methods
 
-// CreateUser creates a new user
+// CreateUser creates a new user. The first user created becomes the admin.
 func (db *DB) CreateUser(username, email, passwordHash string) (*User, error) {
 	result, err := db.conn.Exec(
-		`INSERT INTO users (username, email, password_hash) VALUES (?, ?, ?)`,
+		`INSERT INTO users (username, email, password_hash, is_admin)
+		VALUES (?, ?, ?, (SELECT COUNT(*) = 0 FROM users WHERE is_guest = 0))`,
 		username, email, passwordHash,
 	)
 	if err != nil {
@@ -92,13 +95,41 @@ func (db *DB) CreateUser(username, email, passwordHash string) (*User, error) {
 	return db.GetUserByID(id)
 }
 
+// GuestUsername is the account unauthenticated clients share in guest mode
+const GuestUsername = "guest"
+
+// EnsureGuestUser returns the ID of the guest account, creating it if needed.
+// It has no usable password, so nobody can log in as it, and it never counts
+// as the first user for admin promotion.
+func (db *DB) EnsureGuestUser() (int64, error) {
+	_, err := db.conn.Exec(
+		`INSERT OR IGNORE INTO users (username, email, password_hash, is_admin, is_guest)
+		 VALUES (?, 'guest@localhost', '', 0, 1)`,
+		GuestUsername,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	var isGuest bool
+	err = db.conn.QueryRow(`SELECT id, is_guest FROM users WHERE username = ?`, GuestUsername).Scan(&id, &isGuest)
+	if err != nil {
+		return 0, err
+	}
+	if !isGuest {
+		return 0, fmt.Errorf("username %q belongs to a registered user", GuestUsername)
+	}
+	return id, nil
+}
+
 // GetUserByID retrieves a user by ID
 func (db *DB) GetUserByID(id int64) (*User, error) {
 	user := &User{}
 	err := db.conn.QueryRow(
-		`SELECT id, username, email, password_hash, created_at, updated_at FROM users WHERE id = ?`,
+		`SELECT id, username, email, password_hash, is_admin, created_at, updated_at FROM users WHERE id = ?`,
 		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -109,9 +140,9 @@ func (db *DB) GetUserByID(id int64) (*User, error) {
 func (db *DB) GetUserByUsername(username string) (*User, error) {
 	user := &User{}
 	err := db.conn.QueryRow(
-		`SELECT id, username, email, password_hash, created_at, updated_at FROM users WHERE username = ?`,
+		`SELECT id, username, email, password_hash, is_admin, created_at, updated_at FROM users WHERE username = ?`,
 		username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -122,22 +153,42 @@ func (db *DB) GetUserByUsername(username string) (*User, error) {
 func (db *DB) GetUserByEmail(email string) (*User, error) {
 	user := &User{}
 	err := db.conn.QueryRow(
-		`SELECT id, username, email, password_hash, created_at, updated_at FROM users WHERE email = ?`,
+		`SELECT id, username, email, password_hash, is_admin, created_at, updated_at FROM users WHERE email = ?`,
 		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
 	return user, err
 }
 
+// UpdateUserPassword replaces a user's password hash
+func (db *DB) UpdateUserPassword(id int64, passwordHash string) error {
+	result, err := db.conn.Exec(
+		`UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		passwordHash, id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // Media Source Repository Methods
 
 // CreateMediaSource creates a new media source
 func (db *DB) CreateMediaSource(source *MediaSource) (*MediaSource, error) {
+	defer db.invalidateAggregates()
+
 	result, err := db.conn.Exec(
-		`INSERT INTO media_sources (name, path, type, username, password, enabled) VALUES (?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO media_sources (name, path, type, username, password, enabled, absolute_numbering)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		source.Name, source.Path, source.Type, source.Username, source.Password, source.Enabled,
+		source.AbsoluteNumbering,
 	)
 	if err != nil {
 		return nil, err
@@ -152,11 +203,13 @@ func (db *DB) GetMediaSourceByID(id int64) (*MediaSource, error) {
 	source := &MediaSource{}
 	var lastScan sql.NullTime
 	err := db.conn.QueryRow(
-		`SELECT id, name, path, type, username, password, enabled, last_scan, created_at, updated_at
+		`SELECT id, name, path, type, username, password, enabled, COALESCE(absolute_numbering, 0),
+			last_scan, created_at, updated_at
 		 FROM media_sources WHERE id = ?`,
 		id,
 	).Scan(&source.ID, &source.Name, &source.Path, &source.Type, &source.Username,
-		&source.Password, &source.Enabled, &lastScan, &source.CreatedAt, &source.UpdatedAt)
+		&source.Password, &source.Enabled, &source.AbsoluteNumbering, &lastScan,
+		&source.CreatedAt, &source.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -169,7 +222,8 @@ func (db *DB) GetMediaSourceByID(id int64) (*MediaSource, error) {
 // GetAllMediaSources retrieves all media sources
 func (db *DB) GetAllMediaSources() ([]*MediaSource, error) {
 	rows, err := db.conn.Query(
-		`SELECT id, name, path, type, username, password, enabled, last_scan, created_at, updated_at
+		`SELECT id, name, path, type, username, password, enabled, COALESCE(absolute_numbering, 0),
+			last_scan, created_at, updated_at
 		 FROM media_sources ORDER BY name`,
 	)
 	if err != nil {
@@ -182,8 +236,8 @@ func (db *DB) GetAllMediaSources() ([]*MediaSource, error) {
 		source := &MediaSource{}
 		var lastScan sql.NullTime
 		if err := rows.Scan(&source.ID, &source.Name, &source.Path, &source.Type,
-			&source.Username, &source.Password, &source.Enabled, &lastScan,
-			&source.CreatedAt, &source.UpdatedAt); err != nil {
+			&source.Username, &source.Password, &source.Enabled, &source.AbsoluteNumbering,
+			&lastScan, &source.CreatedAt, &source.UpdatedAt); err != nil {
 			return nil, err
 		}
 		if lastScan.Valid {
@@ -196,6 +250,8 @@ func (db *DB) GetAllMediaSources() ([]*MediaSource, error) {
 
 // DeleteMediaSource deletes a media source
 func (db *DB) DeleteMediaSource(id int64) error {
+	defer db.invalidateAggregates()
+
 	result, err := db.conn.Exec(`DELETE FROM media_sources WHERE id = ?`, id)
 	if err != nil {
 		return err
@@ -207,6 +263,23 @@ func (db *DB) DeleteMediaSource(id int64) error {
 	return nil
 }
 
+// UpdateMediaSourceSettings changes whether a source is scanned and how its
+// episodes are numbered
+func (db *DB) UpdateMediaSourceSettings(id int64, enabled, absoluteNumbering bool) error {
+	result, err := db.conn.Exec(
+		`UPDATE media_sources SET enabled = ?, absolute_numbering = ?, updated_at = ? WHERE id = ?`,
+		enabled, absoluteNumbering, time.Now(), id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // UpdateMediaSourceLastScan updates the last scan time
 func (db *DB) UpdateMediaSourceLastScan(id int64) error {
 	_, err := db.conn.Exec(
@@ -220,17 +293,9 @@ func (db *DB) UpdateMediaSourceLastScan(id int64) error {
 
 // CreateMedia creates a new media item
 func (db *DB) CreateMedia(media *Media) (*Media, error) {
-	result, err := db.conn.Exec(
-		`INSERT INTO media (title, original_title, type, year, overview, poster_path, backdrop_path,
-			rating, runtime, genres, tmdb_id, imdb_id, season_count, episode_count, source_id,
-			file_path, file_size, duration, video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		media.Title, media.OriginalTitle, media.Type, media.Year, media.Overview,
-		media.PosterPath, media.BackdropPath, media.Rating, media.Runtime, media.Genres,
-		media.TMDbID, media.IMDbID, media.SeasonCount, media.EpisodeCount, media.SourceID,
-		media.FilePath, media.FileSize, media.Duration, media.VideoCodec, media.AudioCodec,
-		media.Resolution, media.AudioTracks, media.SubtitleTracks,
-	)
+	defer db.invalidateAggregates()
+
+	result, err := db.conn.Exec(insertMediaSQL, mediaInsertArgs(media)...)
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +304,22 @@ func (db *DB) CreateMedia(media *Media) (*Media, error) {
 	return db.GetMediaByID(id)
 }
 
+const insertMediaSQL = `INSERT INTO media (title, original_title, type, year, overview, poster_path, backdrop_path,
+		rating, runtime, genres, tmdb_id, imdb_id, season_count, episode_count, source_id,
+		file_path, file_size, duration, video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks)
+	 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// mediaInsertArgs returns the values of insertMediaSQL for media
+func mediaInsertArgs(media *Media) []any {
+	return []any{
+		media.Title, media.OriginalTitle, media.Type, media.Year, media.Overview,
+		media.PosterPath, media.BackdropPath, media.Rating, media.Runtime, media.Genres,
+		media.TMDbID, media.IMDbID, media.SeasonCount, media.EpisodeCount, media.SourceID,
+		media.FilePath, media.FileSize, media.Duration, media.VideoCodec, media.AudioCodec,
+		media.Resolution, media.AudioTracks, media.SubtitleTracks,
+	}
+}
+
 // GetMediaByID retrieves media by ID
 func (db *DB) GetMediaByID(id int64) (*Media, error) {
 	query := `SELECT id, title, original_title, type, year, overview, poster_path, backdrop_path,
@@ -253,25 +334,85 @@ func (db *DB) GetMediaByID(id int64) (*Media, error) {
 	if err != nil {
 		return nil, err
 	}
+	if media.Parts, err = db.GetMediaParts(id); err != nil {
+		return nil, err
+	}
 	return &media, nil
 }
 
-// GetMediaByType retrieves all media of a specific type
-func (db *DB) GetMediaByType(mediaType MediaType, limit, offset int) ([]*Media, error) {
+// Orders for GetMediaByType
+const (
+	MediaSortTitle  = "title"
+	MediaSortYear   = "year"
+	MediaSortRating = "rating"
+	MediaSortAdded  = "added"
+)
+
+// mediaSortColumns maps the MediaSort constants to the expressions ordered
+// by, so only these ever reach the SQL
+var mediaSortColumns = map[string]string{
+	MediaSortTitle:  "title",
+	MediaSortYear:   "year",
+	MediaSortRating: "rating",
+	MediaSortAdded:  "COALESCE(date_added, created_at)",
+}
+
+// ValidMediaSort reports whether sort is one of the MediaSort constants
+func ValidMediaSort(sort string) bool {
+	_, ok := mediaSortColumns[sort]
+	return ok
+}
+
+// MediaListOptions orders movie and show listings
+type MediaListOptions struct {
+	Sort       string // a MediaSort constant, title if empty
+	Descending bool
+}
+
+// orderBy returns the ORDER BY expressions for the options. Only columns
+// from mediaSortColumns are used, never the sort string itself. Items without
+// a year or rating, which are stored as 0, sort last in either direction.
+func (o MediaListOptions) orderBy() string {
+	column, ok := mediaSortColumns[o.Sort]
+	if !ok {
+		o.Sort, column = MediaSortTitle, mediaSortColumns[MediaSortTitle]
+	}
+	direction := "ASC"
+	if o.Descending {
+		direction = "DESC"
+	}
+	if o.Sort == MediaSortYear || o.Sort == MediaSortRating {
+		return fmt.Sprintf("COALESCE(%s, 0) = 0, %s %s, title, id", column, column, direction)
+	}
+	return fmt.Sprintf("%s %s, title, id", column, direction)
+}
+
+// GetMediaByType retrieves a page of media of a specific type, along with
+// the total count of that type
+func (db *DB) GetMediaByType(mediaType MediaType, opts MediaListOptions, limit, offset int) ([]*Media, int, error) {
+	var total int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM media WHERE type = ?`, mediaType).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
 	rows, err := db.conn.Query(
 		`SELECT id, title, original_title, type, year, overview, poster_path, backdrop_path,
 			rating, runtime, genres, tmdb_id, imdb_id, season_count, episode_count, source_id,
 			file_path, file_size, duration, video_codec, audio_codec, resolution, audio_tracks,
 			subtitle_tracks, created_at, updated_at
-		 FROM media WHERE type = ? ORDER BY title LIMIT ? OFFSET ?`,
+		 FROM media WHERE type = ? ORDER BY `+opts.orderBy()+` LIMIT ? OFFSET ?`,
 		mediaType, limit, offset,
 	)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	return scanMediaRows(rows)
+	media, err := scanMediaRows(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return media, total, nil
 }
 
 // GetRecentMedia retrieves recently added media
@@ -281,7 +422,7 @@ func (db *DB) GetRecentMedia(limit int) ([]*Media, error) {
 			rating, runtime, genres, tmdb_id, imdb_id, season_count, episode_count, source_id,
 			file_path, file_size, duration, video_codec, audio_codec, resolution, audio_tracks,
 			subtitle_tracks, created_at, updated_at
-		 FROM media ORDER BY created_at DESC LIMIT ?`,
+		 FROM media ORDER BY COALESCE(date_added, created_at) DESC, id DESC LIMIT ?`,
 		limit,
 	)
 	if err != nil {
@@ -292,6 +433,145 @@ func (db *DB) GetRecentMedia(limit int) ([]*Media, error) {
 	return scanMediaRows(rows)
 }
 
+// dateAddedFormat matches CURRENT_TIMESTAMP, so date_added sorts together
+// with created_at
+const dateAddedFormat = "2006-01-02 15:04:05"
+
+// UndatedFile is a movie or episode without a date_added, found by
+// GetUndatedFiles
+type UndatedFile struct {
+	Type     MediaType // MediaTypeMovie for media rows, MediaTypeEpisode for episodes
+	ID       int64
+	FilePath string
+}
+
+// SetDateAdded records when a movie or episode file was added to the library,
+// taken from its modification time. "Recently added" listings sort by it,
+// falling back to the row's created_at.
+func (db *DB) SetDateAdded(mediaType MediaType, id int64, added time.Time) error {
+	table := "media"
+	if mediaType == MediaTypeEpisode {
+		table = "episodes"
+	}
+	_, err := db.conn.Exec(
+		`UPDATE `+table+` SET date_added = ? WHERE id = ?`,
+		added.UTC().Format(dateAddedFormat), id,
+	)
+	return err
+}
+
+// GetUndatedFiles returns movies and episodes scanned before date_added
+// existed, for backfilling
+func (db *DB) GetUndatedFiles() ([]UndatedFile, error) {
+	rows, err := db.conn.Query(
+		`SELECT 'movie', id, file_path FROM media WHERE date_added IS NULL AND COALESCE(file_path, '') != ''
+		 UNION ALL
+		 SELECT 'episode', id, file_path FROM episodes WHERE date_added IS NULL AND COALESCE(file_path, '') != ''`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []UndatedFile
+	for rows.Next() {
+		var f UndatedFile
+		if err := rows.Scan(&f.Type, &f.ID, &f.FilePath); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// FileState is the size and modification time recorded for a scanned file
+type FileState struct {
+	FileSize int64
+	ModTime  int64 // unix seconds, 0 if not recorded yet
+}
+
+// GetSourceFileStates returns the recorded state of every movie and episode
+// file of a source, by path. Rescans compare them to skip unchanged files.
+func (db *DB) GetSourceFileStates(sourceID int64) (map[string]FileState, error) {
+	rows, err := db.conn.Query(
+		`SELECT file_path, COALESCE(file_size, 0), COALESCE(file_mtime, 0) FROM media
+		 WHERE source_id = ? AND COALESCE(file_path, '') != ''
+		 UNION ALL
+		 SELECT file_path, COALESCE(file_size, 0), COALESCE(file_mtime, 0) FROM episodes
+		 WHERE source_id = ? AND COALESCE(file_path, '') != ''`,
+		sourceID, sourceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]FileState)
+	for rows.Next() {
+		var path string
+		var state FileState
+		if err := rows.Scan(&path, &state.FileSize, &state.ModTime); err != nil {
+			return nil, err
+		}
+		states[path] = state
+	}
+	return states, rows.Err()
+}
+
+// SetFileModTime records the modification time of the movies and episodes
+// stored in a file
+func (db *DB) SetFileModTime(filePath string, modTime time.Time) error {
+	for _, table := range []string{"media", "episodes"} {
+		if _, err := db.conn.Exec(
+			`UPDATE `+table+` SET file_mtime = ? WHERE file_path = ?`,
+			modTime.Unix(), filePath,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateFileMetadata replaces the technical metadata of the movies and
+// episodes stored in a file after it changed on disk. Split movies keep the
+// combined duration of their parts.
+func (db *DB) UpdateFileMetadata(file *MediaFile) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"media", "episodes"} {
+		if _, err := tx.Exec(
+			`UPDATE `+table+` SET file_size = ?, duration = ?, video_codec = ?, audio_codec = ?,
+				resolution = ?, audio_tracks = ?, subtitle_tracks = ?, updated_at = ?
+			 WHERE file_path = ?`,
+			file.FileSize, file.Duration, file.VideoCodec, file.AudioCodec, file.Resolution,
+			file.AudioTracks, file.SubtitleTracks, time.Now(), file.FilePath,
+		); err != nil {
+			return err
+		}
+	}
+
+	var mediaID int64
+	err = tx.QueryRow(`SELECT media_id FROM media_parts WHERE file_path = ?`, file.FilePath).Scan(&mediaID)
+	if err == nil {
+		if _, err := tx.Exec(
+			`UPDATE media_parts SET file_size = ?, duration = ? WHERE file_path = ?`,
+			file.FileSize, file.Duration, file.FilePath,
+		); err != nil {
+			return err
+		}
+		if err := updatePartsDuration(tx, mediaID); err != nil {
+			return err
+		}
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return tx.Commit()
+}
+
 // GetMediaByFilePath checks if media with given file path exists
 func (db *DB) GetMediaByFilePath(filePath string) (*Media, error) {
 	query := `SELECT id, title, original_title, type, year, overview, poster_path, backdrop_path,
@@ -349,39 +629,74 @@ func (db *DB) SearchMedia(query string, mediaType MediaType, limit int) ([]*Medi
 
 // UpsertWatchProgress creates or updates watch progress
 func (db *DB) UpsertWatchProgress(userID, mediaID int64, mediaType MediaType, position, duration int, completed bool) error {
+	now := time.Now()
+	playCount, lastPlayedAt := 0, (*time.Time)(nil)
+	if completed {
+		playCount, lastPlayedAt = 1, &now
+	}
+
 	_, err := db.conn.Exec(
-		`INSERT INTO watch_progress (user_id, media_id, media_type, position, duration, completed, updated_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		`INSERT INTO watch_progress (user_id, media_id, media_type, position, duration, completed,
+			play_count, last_played_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(user_id, media_id, media_type) DO UPDATE SET
 		 position = excluded.position, duration = excluded.duration,
-		 completed = excluded.completed, updated_at = excluded.updated_at`,
-		userID, mediaID, mediaType, position, duration, completed, time.Now(),
+		 completed = excluded.completed, `+countCompletionSQL+`,
+		 updated_at = excluded.updated_at`,
+		userID, mediaID, mediaType, position, duration, completed, playCount, lastPlayedAt, now,
 	)
 	return err
 }
 
+// countCompletionSQL counts a play when an upsert into watch_progress moves
+// the row from in progress to completed. SET expressions see the old row.
+const countCompletionSQL = `play_count = COALESCE(play_count, 0) + (excluded.completed AND NOT completed),
+		 last_played_at = CASE WHEN excluded.completed AND NOT completed
+			THEN excluded.updated_at ELSE last_played_at END`
+
 // GetWatchProgress retrieves watch progress for a user and media
 func (db *DB) GetWatchProgress(userID, mediaID int64, mediaType MediaType) (*WatchProgress, error) {
 	progress := &WatchProgress{}
 	err := db.conn.QueryRow(
-		`SELECT id, user_id, media_id, media_type, position, duration, completed, updated_at
+		`SELECT `+watchProgressColumns+`
 		 FROM watch_progress WHERE user_id = ? AND media_id = ? AND media_type = ?`,
 		userID, mediaID, mediaType,
 	).Scan(&progress.ID, &progress.UserID, &progress.MediaID, &progress.MediaType,
-		&progress.Position, &progress.Duration, &progress.Completed, &progress.UpdatedAt)
+		&progress.Position, &progress.Duration, &progress.Completed, &progress.PlayCount,
+		&progress.LastPlayedAt, &progress.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
 	return progress, err
 }
 
-// GetContinueWatching retrieves in-progress media for a user
-func (db *DB) GetContinueWatching(userID int64, limit int) ([]*WatchProgress, error) {
+// GetContinueWatching retrieves in-progress media for a user. Items played
+// for less than minPosition seconds, or past thresholdPercent of their
+// duration, are left out.
+func (db *DB) GetContinueWatching(userID int64, limit, minPosition, thresholdPercent int) ([]*WatchProgress, error) {
 	rows, err := db.conn.Query(
-		`SELECT id, user_id, media_id, media_type, position, duration, completed, updated_at
+		`SELECT `+watchProgressColumns+`
 		 FROM watch_progress
-		 WHERE user_id = ? AND completed = 0 AND position > 0
+		 WHERE user_id = ? AND completed = 0 AND position > 0 AND position >= ?
+		   AND (duration = 0 OR position * 100 <= duration * ?)
 		 ORDER BY updated_at DESC LIMIT ?`,
+		userID, minPosition, thresholdPercent, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWatchProgressRows(rows)
+}
+
+// GetMostWatched returns a user's played items, most played first
+func (db *DB) GetMostWatched(userID int64, limit int) ([]*WatchProgress, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+watchProgressColumns+`
+		 FROM watch_progress
+		 WHERE user_id = ? AND play_count > 0
+		 ORDER BY play_count DESC, last_played_at DESC LIMIT ?`,
 		userID, limit,
 	)
 	if err != nil {
@@ -389,16 +704,25 @@ func (db *DB) GetContinueWatching(userID int64, limit int) ([]*WatchProgress, er
 	}
 	defer rows.Close()
 
+	return scanWatchProgressRows(rows)
+}
+
+// watchProgressColumns are the watch_progress columns scanWatchProgressRows reads
+const watchProgressColumns = `id, user_id, media_id, media_type, position, duration, completed,
+	COALESCE(play_count, 0), last_played_at, updated_at`
+
+func scanWatchProgressRows(rows *sql.Rows) ([]*WatchProgress, error) {
 	var items []*WatchProgress
 	for rows.Next() {
 		p := &WatchProgress{}
 		if err := rows.Scan(&p.ID, &p.UserID, &p.MediaID, &p.MediaType,
-			&p.Position, &p.Duration, &p.Completed, &p.UpdatedAt); err != nil {
+			&p.Position, &p.Duration, &p.Completed, &p.PlayCount,
+			&p.LastPlayedAt, &p.UpdatedAt); err != nil {
 			return nil, err
 		}
 		items = append(items, p)
 	}
-	return items, nil
+	return items, rows.Err()
 }
 
 // Watchlist Repository Methods
@@ -455,6 +779,8 @@ func (db *DB) GetWatchlist(userID int64, limit int) ([]*Media, error) {
 
 // UpdateMedia updates an existing media item
 func (db *DB) UpdateMedia(media *Media) error {
+	defer db.invalidateAggregates()
+
 	_, err := db.conn.Exec(
 		`UPDATE media SET
 			title = ?, original_title = ?, overview = ?, poster_path = ?, backdrop_path = ?,
@@ -468,20 +794,399 @@ func (db *DB) UpdateMedia(media *Media) error {
 	return err
 }
 
-// MarkAsWatched marks a media item as completed (100% watched)
-func (db *DB) MarkAsWatched(userID, mediaID int64, mediaType MediaType) error {
-	// Get media duration if available
-	var duration int
-	db.conn.QueryRow(`SELECT COALESCE(duration, 0) FROM media WHERE id = ?`, mediaID).Scan(&duration)
+// DeleteMedia removes a media item along with the per-user and section rows
+// that reference it. Extras linked to it are detached by the foreign key.
+func (db *DB) DeleteMedia(id int64) error {
+	defer db.invalidateAggregates()
+
+	media, err := db.GetMediaByID(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMediaReferences(tx, id, media.Type); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM media WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTVShow removes a show with its seasons and episodes, which the
+// foreign keys cascade to, along with its extras and the per-user and
+// section rows referencing the show or its episodes
+func (db *DB) DeleteTVShow(id int64) error {
+	defer db.invalidateAggregates()
+
+	if _, err := db.GetTVShowByID(id); err != nil {
+		return err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Extras only have nullable references to the show and its episodes,
+	// which would leave them detached rather than deleted
+	var refs []MediaRef
+	rows, err := tx.Query(
+		`SELECT id, 'episode' FROM episodes WHERE tv_show_id = ?
+		 UNION ALL
+		 SELECT id, 'extra' FROM extras
+		 WHERE tv_show_id = ? OR episode_id IN (SELECT id FROM episodes WHERE tv_show_id = ?)`,
+		id, id, id,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var ref MediaRef
+		if err := rows.Scan(&ref.ID, &ref.Type); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	refs = append(refs, MediaRef{Type: MediaTypeTVShow, ID: id})
+	for _, ref := range refs {
+		if err := deleteMediaReferences(tx, ref.ID, ref.Type); err != nil {
+			return err
+		}
+		if ref.Type == MediaTypeExtra {
+			if _, err := tx.Exec(`DELETE FROM extras WHERE id = ?`, ref.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM tv_shows WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteMediaReferences removes an item's progress, watchlist, playlist,
+// section, channel schedule, subtitle and credit entries, closing the gaps
+// left in playlists
+func deleteMediaReferences(tx *sql.Tx, id int64, mediaType MediaType) error {
+	var playlistIDs []int64
+	rows, err := tx.Query(`SELECT DISTINCT playlist_id FROM playlist_items WHERE media_id = ? AND media_type = ?`, id, mediaType)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var playlistID int64
+		if err := rows.Scan(&playlistID); err != nil {
+			rows.Close()
+			return err
+		}
+		playlistIDs = append(playlistIDs, playlistID)
+	}
+	rows.Close()
+
+	for _, table := range []string{"watch_progress", "watchlist", "playlist_items", "media_sections", "channel_schedule", "external_subtitles", "credits"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE media_id = ? AND media_type = ?`, id, mediaType); err != nil {
+			return err
+		}
+	}
+
+	for _, playlistID := range playlistIDs {
+		if err := finishPlaylistEdit(tx, playlistID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMediaByFilePath removes the movies, episodes and extras stored in a
+// file, along with seasons and shows left without episodes. It returns the
+// number of items removed; a file holding several episodes removes them all.
+func (db *DB) DeleteMediaByFilePath(filePath string) (int, error) {
+	defer db.invalidateAggregates()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type fileItem struct {
+		ref      MediaRef
+		seasonID int64
+		showID   int64
+	}
+	var items []fileItem
+
+	// A later part of a split movie only leaves the movie shorter; the
+	// movie's own file takes its parts with it
+	var partMediaID int64
+	err = tx.QueryRow(
+		`SELECT media_id FROM media_parts WHERE file_path = ? AND file_path != (SELECT file_path FROM media WHERE id = media_id)`,
+		filePath,
+	).Scan(&partMediaID)
+	if err == nil {
+		if _, err := tx.Exec(`DELETE FROM media_parts WHERE file_path = ?`, filePath); err != nil {
+			return 0, err
+		}
+		if err := updatePartsDuration(tx, partMediaID); err != nil {
+			return 0, err
+		}
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, type, 0, 0 FROM media WHERE file_path = ?
+		 UNION ALL
+		 SELECT id, 'episode', season_id, tv_show_id FROM episodes WHERE file_path = ?
+		 UNION ALL
+		 SELECT id, 'extra', 0, 0 FROM extras WHERE file_path = ?`,
+		filePath, filePath, filePath,
+	)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var item fileItem
+		if err := rows.Scan(&item.ref.ID, &item.ref.Type, &item.seasonID, &item.showID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	seasonIDs := make(map[int64]bool)
+	showIDs := make(map[int64]bool)
+	for _, item := range items {
+		if err := deleteMediaReferences(tx, item.ref.ID, item.ref.Type); err != nil {
+			return 0, err
+		}
+
+		table := "media"
+		switch item.ref.Type {
+		case MediaTypeEpisode:
+			table = "episodes"
+			seasonIDs[item.seasonID] = true
+			showIDs[item.showID] = true
+		case MediaTypeExtra:
+			table = "extras"
+		}
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ?`, item.ref.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	for seasonID := range seasonIDs {
+		if _, err := tx.Exec(
+			`DELETE FROM seasons WHERE id = ? AND NOT EXISTS (SELECT 1 FROM episodes WHERE season_id = ?)`,
+			seasonID, seasonID,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	// Shows go once their last episode does; seasons and episodes cascade
+	for showID := range showIDs {
+		var remaining int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM episodes WHERE tv_show_id = ?`, showID).Scan(&remaining); err != nil {
+			return 0, err
+		}
+		if remaining > 0 {
+			continue
+		}
+		if err := deleteMediaReferences(tx, showID, MediaTypeTVShow); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`DELETE FROM tv_shows WHERE id = ?`, showID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(items), tx.Commit()
+}
+
+// LibraryFile is a movie, episode or extra along with the file it's stored in
+type LibraryFile struct {
+	Ref       MediaRef
+	FilePath  string
+	SourceID  int64
+	SeasonID  int64 // Episodes only
+	ShowID    int64 // Episodes, and extras of a show
+	MovieID   int64 // Extras of a movie
+	EpisodeID int64 // Extras of an episode
+	Part      bool  // A later part of the split movie Ref
+}
+
+// GetLibraryFiles lists the file of every movie, episode and extra in the
+// library that has one, and of the later parts of split movies after their
+// movie's own file
+func (db *DB) GetLibraryFiles() ([]LibraryFile, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, type, file_path, COALESCE(source_id, 0), 0, 0, 0, 0, 0 FROM media WHERE COALESCE(file_path, '') != ''
+		 UNION ALL
+		 SELECT mp.media_id, 'movie', mp.file_path, COALESCE(m.source_id, 0), 0, 0, 0, 0, 1
+		 FROM media_parts mp JOIN media m ON m.id = mp.media_id
+		 WHERE mp.file_path != COALESCE(m.file_path, '')
+		 UNION ALL
+		 SELECT id, 'episode', file_path, COALESCE(source_id, 0), season_id, tv_show_id, 0, 0, 0 FROM episodes WHERE COALESCE(file_path, '') != ''
+		 UNION ALL
+		 SELECT id, 'extra', file_path, COALESCE(source_id, 0), 0, COALESCE(tv_show_id, 0),
+			COALESCE(movie_id, 0), COALESCE(episode_id, 0), 0
+		 FROM extras`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []LibraryFile
+	for rows.Next() {
+		var file LibraryFile
+		if err := rows.Scan(&file.Ref.ID, &file.Ref.Type, &file.FilePath, &file.SourceID, &file.SeasonID, &file.ShowID,
+			&file.MovieID, &file.EpisodeID, &file.Part); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// DeleteUnlinkedExtras removes the extras with no movie, show or episode,
+// like those whose parent was removed from the library, returning how many
+// were removed
+func (db *DB) DeleteUnlinkedExtras() (int, error) {
+	defer db.invalidateAggregates()
+
+	result, err := db.conn.Exec(
+		`DELETE FROM extras WHERE movie_id IS NULL AND tv_show_id IS NULL AND episode_id IS NULL`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// MarkAsWatched marks a media item as completed (100% watched)
+func (db *DB) MarkAsWatched(userID, mediaID int64, mediaType MediaType) error {
+	// Get media duration if available
+	var duration int
+	db.conn.QueryRow(`SELECT COALESCE(duration, 0) FROM media WHERE id = ?`, mediaID).Scan(&duration)
+
+	now := time.Now()
+	_, err := db.conn.Exec(
+		`INSERT INTO watch_progress (user_id, media_id, media_type, position, duration, completed,
+			play_count, last_played_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, 1, 1, ?, ?)
+		 ON CONFLICT(user_id, media_id, media_type) DO UPDATE SET
+		 completed = 1, `+countCompletionSQL+`,
+		 updated_at = excluded.updated_at`,
+		userID, mediaID, mediaType, duration, duration, now, now,
+	)
+	return err
+}
+
+// RecordPlay counts a play of a media item (a scrobble), marking it watched
+// even if it already was, and returns the new play count
+func (db *DB) RecordPlay(userID, mediaID int64, mediaType MediaType) (int, error) {
+	now := time.Now()
+	var playCount int
+	err := db.conn.QueryRow(
+		`INSERT INTO watch_progress (user_id, media_id, media_type, completed,
+			play_count, last_played_at, updated_at)
+		 VALUES (?, ?, ?, 1, 1, ?, ?)
+		 ON CONFLICT(user_id, media_id, media_type) DO UPDATE SET
+		 completed = 1, play_count = COALESCE(play_count, 0) + 1,
+		 last_played_at = excluded.last_played_at, updated_at = excluded.updated_at
+		 RETURNING play_count`,
+		userID, mediaID, mediaType, now, now,
+	).Scan(&playCount)
+	return playCount, err
+}
+
+// MarkAsUnwatched clears the completed flag on a media item. With
+// resetPosition the progress row is removed, so playback starts over;
+// otherwise the position is kept.
+func (db *DB) MarkAsUnwatched(userID, mediaID int64, mediaType MediaType, resetPosition bool) error {
+	if resetPosition {
+		_, err := db.conn.Exec(
+			`DELETE FROM watch_progress WHERE user_id = ? AND media_id = ? AND media_type = ?`,
+			userID, mediaID, mediaType,
+		)
+		return err
+	}
+
+	_, err := db.conn.Exec(
+		`UPDATE watch_progress SET completed = 0, updated_at = ?
+		 WHERE user_id = ? AND media_id = ? AND media_type = ?`,
+		time.Now(), userID, mediaID, mediaType,
+	)
+	return err
+}
+
+// MarkSeasonWatched marks every episode of a season completed for a user and
+// returns how many episodes there are. Episodes already watched keep their
+// play count, so marking a season twice is harmless.
+func (db *DB) MarkSeasonWatched(userID, seasonID int64) (int, error) {
+	episodes, err := db.GetEpisodesBySeasonID(seasonID, EpisodeListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, episode := range episodes {
+		if _, err := tx.Exec(
+			`INSERT INTO watch_progress (user_id, media_id, media_type, position, duration, completed,
+				play_count, last_played_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, 1, 1, ?, ?)
+			 ON CONFLICT(user_id, media_id, media_type) DO UPDATE SET
+			 completed = 1, `+countCompletionSQL+`,
+			 updated_at = excluded.updated_at`,
+			userID, episode.ID, MediaTypeEpisode, episode.Duration, episode.Duration, now, now,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(episodes), tx.Commit()
+}
 
-	_, err := db.conn.Exec(
-		`INSERT INTO watch_progress (user_id, media_id, media_type, position, duration, completed, updated_at)
-		 VALUES (?, ?, ?, ?, ?, 1, ?)
-		 ON CONFLICT(user_id, media_id, media_type) DO UPDATE SET
-		 completed = 1, updated_at = excluded.updated_at`,
-		userID, mediaID, mediaType, duration, duration, time.Now(),
+// MarkSeasonUnwatched removes a user's progress on every episode of a season
+// and returns how many progress rows there were
+func (db *DB) MarkSeasonUnwatched(userID, seasonID int64) (int, error) {
+	result, err := db.conn.Exec(
+		`DELETE FROM watch_progress
+		 WHERE user_id = ? AND media_type = ?
+		 AND media_id IN (SELECT id FROM episodes WHERE season_id = ?)`,
+		userID, MediaTypeEpisode, seasonID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	return int(removed), err
 }
 
 // Playlist Repository Methods
@@ -500,27 +1205,73 @@ func (db *DB) CreatePlaylist(userID int64, name, description string) (*Playlist,
 	return db.GetPlaylistByID(id)
 }
 
+// CreateSmartPlaylist creates a playlist holding whatever matches rules
+func (db *DB) CreateSmartPlaylist(userID int64, name, description string, rules []PlaylistRule) (*Playlist, error) {
+	rulesJSON, err := encodePlaylistRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.conn.Exec(
+		`INSERT INTO playlists (user_id, name, description, smart, rules) VALUES (?, ?, ?, 1, ?)`,
+		userID, name, description, rulesJSON,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return db.GetPlaylistByID(id)
+}
+
+const playlistColumns = `p.id, p.user_id, p.name, p.description, p.is_public, p.created_at, p.updated_at,
+	(SELECT COUNT(*) FROM playlist_items WHERE playlist_id = p.id) as item_count,
+	COALESCE(p.smart, 0), p.rules`
+
+func scanPlaylist(row interface{ Scan(...any) error }) (*Playlist, error) {
+	p := &Playlist{}
+	var rules sql.NullString
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.Description,
+		&p.IsPublic, &p.CreatedAt, &p.UpdatedAt, &p.ItemCount, &p.Smart, &rules); err != nil {
+		return nil, err
+	}
+	return p, decodePlaylistRules(p, rules)
+}
+
+// countSmartPlaylists sets the item count of smart playlists to their number
+// of matches. It queries, so call it with no rows open.
+func (db *DB) countSmartPlaylists(playlists ...*Playlist) error {
+	for _, p := range playlists {
+		if !p.Smart {
+			continue
+		}
+		count, err := db.smartPlaylistCount(p)
+		if err != nil {
+			return err
+		}
+		p.ItemCount = count
+	}
+	return nil
+}
+
 // GetPlaylistByID retrieves a playlist by ID with item count
 func (db *DB) GetPlaylistByID(id int64) (*Playlist, error) {
-	playlist := &Playlist{}
-	err := db.conn.QueryRow(
-		`SELECT p.id, p.user_id, p.name, p.description, p.is_public, p.created_at, p.updated_at,
-			(SELECT COUNT(*) FROM playlist_items WHERE playlist_id = p.id) as item_count
-		 FROM playlists p WHERE p.id = ?`,
+	playlist, err := scanPlaylist(db.conn.QueryRow(
+		`SELECT `+playlistColumns+` FROM playlists p WHERE p.id = ?`,
 		id,
-	).Scan(&playlist.ID, &playlist.UserID, &playlist.Name, &playlist.Description,
-		&playlist.IsPublic, &playlist.CreatedAt, &playlist.UpdatedAt, &playlist.ItemCount)
+	))
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
-	return playlist, err
+	if err != nil {
+		return nil, err
+	}
+	return playlist, db.countSmartPlaylists(playlist)
 }
 
 // GetUserPlaylists retrieves all playlists for a user (including public playlists)
 func (db *DB) GetUserPlaylists(userID int64) ([]*Playlist, error) {
 	rows, err := db.conn.Query(
-		`SELECT p.id, p.user_id, p.name, p.description, p.is_public, p.created_at, p.updated_at,
-			(SELECT COUNT(*) FROM playlist_items WHERE playlist_id = p.id) as item_count
+		`SELECT `+playlistColumns+`
 		 FROM playlists p
 		 WHERE p.user_id = ? OR p.is_public = 1
 		 ORDER BY p.user_id = ? DESC, p.updated_at DESC`,
@@ -533,14 +1284,18 @@ func (db *DB) GetUserPlaylists(userID int64) ([]*Playlist, error) {
 
 	playlists := make([]*Playlist, 0)
 	for rows.Next() {
-		p := &Playlist{}
-		if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.Description,
-			&p.IsPublic, &p.CreatedAt, &p.UpdatedAt, &p.ItemCount); err != nil {
+		p, err := scanPlaylist(rows)
+		if err != nil {
 			return nil, err
 		}
 		playlists = append(playlists, p)
 	}
-	return playlists, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	return playlists, db.countSmartPlaylists(playlists...)
 }
 
 // UpdatePlaylist updates a playlist's name and description
@@ -559,6 +1314,26 @@ func (db *DB) UpdatePlaylist(id int64, name, description string) error {
 	return nil
 }
 
+// UpdatePlaylistRules replaces a smart playlist's rules
+func (db *DB) UpdatePlaylistRules(id int64, rules []PlaylistRule) error {
+	rulesJSON, err := encodePlaylistRules(rules)
+	if err != nil {
+		return err
+	}
+	result, err := db.conn.Exec(
+		`UPDATE playlists SET rules = ?, updated_at = ? WHERE id = ? AND smart = 1`,
+		rulesJSON, time.Now(), id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // DeletePlaylist deletes a playlist and all its items
 func (db *DB) DeletePlaylist(id int64) error {
 	result, err := db.conn.Exec(`DELETE FROM playlists WHERE id = ?`, id)
@@ -572,32 +1347,83 @@ func (db *DB) DeletePlaylist(id int64) error {
 	return nil
 }
 
-// AddToPlaylist adds a media item to a playlist
-func (db *DB) AddToPlaylist(playlistID, mediaID int64, mediaType MediaType) error {
-	// Get the next position
-	var maxPos int
-	db.conn.QueryRow(
-		`SELECT COALESCE(MAX(position), 0) FROM playlist_items WHERE playlist_id = ?`,
-		playlistID,
-	).Scan(&maxPos)
+// AddToPlaylist appends a media item to a playlist. It reports false, and
+// changes nothing, if the item is already in the playlist.
+func (db *DB) AddToPlaylist(playlistID, mediaID int64, mediaType MediaType) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
 
-	_, err := db.conn.Exec(
+	result, err := tx.Exec(
 		`INSERT INTO playlist_items (playlist_id, media_id, media_type, position)
-		 VALUES (?, ?, ?, ?)`,
-		playlistID, mediaID, mediaType, maxPos+1,
+		 SELECT ?, ?, ?, COALESCE(MAX(position), 0) + 1 FROM playlist_items WHERE playlist_id = ?
+		 ON CONFLICT(playlist_id, media_id, media_type) DO NOTHING`,
+		playlistID, mediaID, mediaType, playlistID,
 	)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
 	}
 
-	// Update playlist's updated_at timestamp
-	db.conn.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID)
-	return nil
+	if err := finishPlaylistEdit(tx, playlistID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// AddEpisodesToPlaylist appends episodes to a playlist in the given order,
+// all or none, and returns how many were added. Episodes already in the
+// playlist are skipped.
+func (db *DB) AddEpisodesToPlaylist(playlistID int64, episodes []*Episode) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT INTO playlist_items (playlist_id, media_id, media_type, position)
+		 SELECT ?, ?, 'episode', COALESCE(MAX(position), 0) + 1 FROM playlist_items WHERE playlist_id = ?
+		 ON CONFLICT(playlist_id, media_id, media_type) DO NOTHING`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	added := 0
+	for _, episode := range episodes {
+		result, err := stmt.Exec(playlistID, episode.ID, playlistID)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		added += int(n)
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	if err := finishPlaylistEdit(tx, playlistID); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
 }
 
-// RemoveFromPlaylist removes a media item from a playlist
+// RemoveFromPlaylist removes a media item from a playlist, closing the gap
+// it leaves
 func (db *DB) RemoveFromPlaylist(playlistID, mediaID int64, mediaType MediaType) error {
-	result, err := db.conn.Exec(
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
 		`DELETE FROM playlist_items WHERE playlist_id = ? AND media_id = ? AND media_type = ?`,
 		playlistID, mediaID, mediaType,
 	)
@@ -609,23 +1435,56 @@ func (db *DB) RemoveFromPlaylist(playlistID, mediaID int64, mediaType MediaType)
 		return ErrNotFound
 	}
 
-	// Reorder remaining items
-	db.conn.Exec(
-		`UPDATE playlist_items SET position = (
-			SELECT COUNT(*) FROM playlist_items pi2
-			WHERE pi2.playlist_id = playlist_items.playlist_id
-			AND pi2.id <= playlist_items.id
-		) WHERE playlist_id = ?`,
-		playlistID,
-	)
+	if err := finishPlaylistEdit(tx, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	// Update playlist's updated_at timestamp
-	db.conn.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID)
-	return nil
+// NormalizePlaylistPositions renumbers a playlist's items 1..N, keeping
+// their order, to repair gaps or duplicate positions
+func (db *DB) NormalizePlaylistPositions(playlistID int64) error {
+	_, err := db.conn.Exec(normalizePlaylistPositionsSQL, playlistID)
+	return err
+}
+
+// normalizePlaylistPositionsSQL renumbers one playlist's items by their
+// current position, ties broken by insertion order. The ranking is computed
+// before any row changes, unlike a correlated subquery.
+const normalizePlaylistPositionsSQL = `UPDATE playlist_items SET position = ranked.position
+	FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY position, id) AS position
+		FROM playlist_items WHERE playlist_id = ?) AS ranked
+	WHERE playlist_items.id = ranked.id AND playlist_items.position != ranked.position`
+
+// finishPlaylistEdit renumbers a playlist's items and bumps its updated_at,
+// within the transaction that edited it
+func finishPlaylistEdit(tx *sql.Tx, playlistID int64) error {
+	if _, err := tx.Exec(normalizePlaylistPositionsSQL, playlistID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID)
+	return err
 }
 
-// GetPlaylistItems retrieves all items in a playlist with media details
+// GetPlaylistItems retrieves all items in a playlist with media details.
+// Smart playlists' rules are evaluated now, so they're always up to date.
 func (db *DB) GetPlaylistItems(playlistID int64) ([]*PlaylistItemWithMedia, error) {
+	playlist := &Playlist{ID: playlistID}
+	var rules sql.NullString
+	err := db.conn.QueryRow(
+		`SELECT user_id, COALESCE(smart, 0), rules FROM playlists WHERE id = ?`,
+		playlistID,
+	).Scan(&playlist.UserID, &playlist.Smart, &rules)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if playlist.Smart {
+		if err := decodePlaylistRules(playlist, rules); err != nil {
+			return nil, err
+		}
+		return db.smartPlaylistItems(playlist)
+	}
+
 	// Use UNION to get items from both media table (movies) and episodes table
 	rows, err := db.conn.Query(
 		`SELECT pi.id, pi.playlist_id, pi.media_id, pi.media_type, pi.position, pi.added_at,
@@ -663,7 +1522,9 @@ func (db *DB) GetPlaylistItems(playlistID int64) ([]*PlaylistItemWithMedia, erro
 	return items, nil
 }
 
-// ReorderPlaylistItems reorders items in a playlist based on the provided order
+// ReorderPlaylistItems reorders items in a playlist based on the provided
+// order. Items left out of itemIDs keep their relative order after the
+// listed ones.
 func (db *DB) ReorderPlaylistItems(playlistID int64, itemIDs []int64) error {
 	tx, err := db.conn.Begin()
 	if err != nil {
@@ -671,6 +1532,15 @@ func (db *DB) ReorderPlaylistItems(playlistID int64, itemIDs []int64) error {
 	}
 	defer tx.Rollback()
 
+	// Move everything past the listed positions first, so unlisted items
+	// can't collide with them
+	if _, err := tx.Exec(
+		`UPDATE playlist_items SET position = position + ? WHERE playlist_id = ?`,
+		len(itemIDs), playlistID,
+	); err != nil {
+		return err
+	}
+
 	for i, itemID := range itemIDs {
 		_, err := tx.Exec(
 			`UPDATE playlist_items SET position = ? WHERE id = ? AND playlist_id = ?`,
@@ -681,9 +1551,9 @@ func (db *DB) ReorderPlaylistItems(playlistID int64, itemIDs []int64) error {
 		}
 	}
 
-	// Update playlist's updated_at timestamp
-	tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now(), playlistID)
-
+	if err := finishPlaylistEdit(tx, playlistID); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -691,6 +1561,8 @@ func (db *DB) ReorderPlaylistItems(playlistID int64, itemIDs []int64) error {
 
 // CreateTVShow creates a new TV show
 func (db *DB) CreateTVShow(show *TVShow) (*TVShow, error) {
+	defer db.invalidateAggregates()
+
 	result, err := db.conn.Exec(
 		`INSERT INTO tv_shows (title, original_title, year, overview, poster_path, backdrop_path,
 			rating, genres, tmdb_id, imdb_id, status)
@@ -712,7 +1584,8 @@ func (db *DB) GetTVShowByID(id int64) (*TVShow, error) {
 		SELECT
 			s.id, s.title, COALESCE(s.original_title, ''), s.year, COALESCE(s.overview, ''),
 			COALESCE(s.poster_path, ''), COALESCE(s.backdrop_path, ''), s.rating, COALESCE(s.genres, ''),
-			s.tmdb_id, COALESCE(s.imdb_id, ''), COALESCE(s.status, ''), s.created_at, s.updated_at,
+			s.tmdb_id, COALESCE(s.imdb_id, ''), COALESCE(s.status, ''),
+			COALESCE(s.episode_order, 'aired'), COALESCE(s.episode_group_id, ''), s.created_at, s.updated_at,
 			COUNT(DISTINCT se.id) as season_count,
 			COUNT(DISTINCT e.id) as episode_count,
 			(SELECT resolution FROM episodes WHERE tv_show_id = s.id
@@ -738,8 +1611,8 @@ func (db *DB) GetTVShowByID(id int64) (*TVShow, error) {
 	err := db.conn.QueryRow(query, id).Scan(
 		&show.ID, &show.Title, &show.OriginalTitle, &show.Year, &show.Overview,
 		&show.PosterPath, &show.BackdropPath, &show.Rating, &show.Genres,
-		&show.TMDbID, &show.IMDbID, &show.Status, &show.CreatedAt, &show.UpdatedAt,
-		&show.SeasonCount, &show.EpisodeCount,
+		&show.TMDbID, &show.IMDbID, &show.Status, &show.EpisodeOrder, &show.EpisodeGroupID,
+		&show.CreatedAt, &show.UpdatedAt, &show.SeasonCount, &show.EpisodeCount,
 		&commonResolution, &commonVideoCodec, &commonAudioCodec,
 		&show.TotalDuration, &show.AvgEpisodeLength, &maxResolution,
 	)
@@ -773,12 +1646,14 @@ func (db *DB) GetTVShowByTMDBID(tmdbID int) (*TVShow, error) {
 	show := &TVShow{}
 	err := db.conn.QueryRow(
 		`SELECT id, title, original_title, year, overview, poster_path, backdrop_path,
-			rating, genres, tmdb_id, imdb_id, status, created_at, updated_at
+			rating, genres, tmdb_id, imdb_id, status, COALESCE(episode_order, 'aired'),
+			COALESCE(episode_group_id, ''), created_at, updated_at
 		 FROM tv_shows WHERE tmdb_id = ?`,
 		tmdbID,
 	).Scan(&show.ID, &show.Title, &show.OriginalTitle, &show.Year, &show.Overview,
 		&show.PosterPath, &show.BackdropPath, &show.Rating, &show.Genres, &show.TMDbID,
-		&show.IMDbID, &show.Status, &show.CreatedAt, &show.UpdatedAt)
+		&show.IMDbID, &show.Status, &show.EpisodeOrder, &show.EpisodeGroupID,
+		&show.CreatedAt, &show.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -790,12 +1665,14 @@ func (db *DB) GetTVShowByTitle(title string) (*TVShow, error) {
 	show := &TVShow{}
 	err := db.conn.QueryRow(
 		`SELECT id, title, original_title, year, overview, poster_path, backdrop_path,
-			rating, genres, tmdb_id, imdb_id, status, created_at, updated_at
+			rating, genres, tmdb_id, imdb_id, status, COALESCE(episode_order, 'aired'),
+			COALESCE(episode_group_id, ''), created_at, updated_at
 		 FROM tv_shows WHERE title = ? COLLATE NOCASE`,
 		title,
 	).Scan(&show.ID, &show.Title, &show.OriginalTitle, &show.Year, &show.Overview,
 		&show.PosterPath, &show.BackdropPath, &show.Rating, &show.Genres, &show.TMDbID,
-		&show.IMDbID, &show.Status, &show.CreatedAt, &show.UpdatedAt)
+		&show.IMDbID, &show.Status, &show.EpisodeOrder, &show.EpisodeGroupID,
+		&show.CreatedAt, &show.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -812,7 +1689,8 @@ func (db *DB) GetAllTVShows(limit, offset int) ([]*TVShow, int, error) {
 		SELECT
 			s.id, s.title, COALESCE(s.original_title, ''), s.year, COALESCE(s.overview, ''),
 			COALESCE(s.poster_path, ''), COALESCE(s.backdrop_path, ''), s.rating, COALESCE(s.genres, ''),
-			s.tmdb_id, COALESCE(s.imdb_id, ''), COALESCE(s.status, ''), s.created_at, s.updated_at,
+			s.tmdb_id, COALESCE(s.imdb_id, ''), COALESCE(s.status, ''),
+			COALESCE(s.episode_order, 'aired'), COALESCE(s.episode_group_id, ''), s.created_at, s.updated_at,
 			COUNT(DISTINCT se.id) as season_count,
 			COUNT(DISTINCT e.id) as episode_count,
 			(SELECT resolution FROM episodes WHERE tv_show_id = s.id
@@ -847,8 +1725,8 @@ func (db *DB) GetAllTVShows(limit, offset int) ([]*TVShow, int, error) {
 		if err := rows.Scan(
 			&show.ID, &show.Title, &show.OriginalTitle, &show.Year, &show.Overview,
 			&show.PosterPath, &show.BackdropPath, &show.Rating, &show.Genres,
-			&show.TMDbID, &show.IMDbID, &show.Status, &show.CreatedAt, &show.UpdatedAt,
-			&show.SeasonCount, &show.EpisodeCount,
+			&show.TMDbID, &show.IMDbID, &show.Status, &show.EpisodeOrder, &show.EpisodeGroupID,
+			&show.CreatedAt, &show.UpdatedAt, &show.SeasonCount, &show.EpisodeCount,
 			&commonResolution, &commonVideoCodec, &commonAudioCodec,
 			&show.TotalDuration, &show.AvgEpisodeLength, &maxResolution,
 		); err != nil {
@@ -876,6 +1754,8 @@ func (db *DB) GetAllTVShows(limit, offset int) ([]*TVShow, int, error) {
 
 // UpdateTVShow updates a TV show
 func (db *DB) UpdateTVShow(show *TVShow) error {
+	defer db.invalidateAggregates()
+
 	_, err := db.conn.Exec(
 		`UPDATE tv_shows SET title = ?, original_title = ?, year = ?, overview = ?,
 			poster_path = ?, backdrop_path = ?, rating = ?, genres = ?, tmdb_id = ?,
@@ -888,6 +1768,22 @@ func (db *DB) UpdateTVShow(show *TVShow) error {
 	return err
 }
 
+// SetTVShowEpisodeOrder changes how a show's episode files are numbered.
+// groupID is the TMDB episode group for orders other than aired.
+func (db *DB) SetTVShowEpisodeOrder(id int64, order, groupID string) error {
+	result, err := db.conn.Exec(
+		`UPDATE tv_shows SET episode_order = ?, episode_group_id = ?, updated_at = ? WHERE id = ?`,
+		order, groupID, time.Now(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // SearchTVShows searches for TV shows by title with fuzzy matching
 func (db *DB) SearchTVShows(query string, limit int) ([]*TVShow, error) {
 	rows, err := db.conn.Query(
@@ -1036,13 +1932,19 @@ func (db *DB) GetSeasonByNumber(showID int64, seasonNum int) (*Season, error) {
 	return season, err
 }
 
-// GetSeasonsByShowID retrieves all seasons for a TV show
-func (db *DB) GetSeasonsByShowID(showID int64) ([]*Season, error) {
+// GetSeasonsByShowID retrieves all seasons for a TV show. Specials (season 0)
+// come first, or after the other seasons if specialsLast is set.
+func (db *DB) GetSeasonsByShowID(showID int64, specialsLast bool) ([]*Season, error) {
+	orderBy := "s.season_number"
+	if specialsLast {
+		orderBy = "s.season_number = 0, s.season_number"
+	}
+
 	rows, err := db.conn.Query(
 		`SELECT s.id, s.tv_show_id, s.season_number, s.name, s.overview, s.poster_path, s.air_date,
 			s.episode_count, s.created_at,
 			(SELECT COUNT(*) FROM episodes WHERE season_id = s.id) as actual_episode_count
-		 FROM seasons s WHERE s.tv_show_id = ? ORDER BY s.season_number`,
+		 FROM seasons s WHERE s.tv_show_id = ? ORDER BY `+orderBy,
 		showID,
 	)
 	if err != nil {
@@ -1070,19 +1972,50 @@ func (db *DB) GetSeasonsByShowID(showID int64) ([]*Season, error) {
 
 // ============ Episode Repository Methods ============
 
+// airDateLayouts are the air date formats we can parse. TMDB uses ISO dates;
+// the others cover hand-edited or imported metadata.
+var airDateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006/01/02",
+	"January 2, 2006",
+	"2 January 2006",
+}
+
+// parseAirDate converts a free-text air date to "YYYY-MM-DD" for the aired_at
+// column, or nil if it can't be parsed
+func parseAirDate(airDate string) interface{} {
+	airDate = strings.TrimSpace(airDate)
+	if airDate == "" {
+		return nil
+	}
+	for _, layout := range airDateLayouts {
+		if t, err := time.Parse(layout, airDate); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return nil
+}
+
+// EpisodeListOptions controls filtering and ordering of episode listings
+type EpisodeListOptions struct {
+	HideUnaired   bool // Skip episodes whose air date is in the future
+	SortByAirDate bool // Order by air date instead of season/episode number
+}
+
+// where returns the SQL condition for the options, for episodes aliased as e
+func (o EpisodeListOptions) where() string {
+	if o.HideUnaired {
+		return " AND (e.aired_at IS NULL OR e.aired_at <= date('now'))"
+	}
+	return ""
+}
+
 // CreateEpisode creates a new episode
 func (db *DB) CreateEpisode(episode *Episode) (*Episode, error) {
-	result, err := db.conn.Exec(
-		`INSERT INTO episodes (tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
-			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		episode.TVShowID, episode.SeasonID, episode.SeasonNumber, episode.EpisodeNumber,
-		episode.Title, episode.Overview, episode.StillPath, episode.AirDate, episode.Runtime,
-		episode.Rating, episode.SourceID, episode.FilePath, episode.FileSize, episode.Duration,
-		episode.VideoCodec, episode.AudioCodec, episode.Resolution, episode.AudioTracks,
-		episode.SubtitleTracks,
-	)
+	defer db.invalidateAggregates()
+
+	result, err := db.conn.Exec(insertEpisodeSQL, episodeInsertArgs(episode)...)
 	if err != nil {
 		return nil, err
 	}
@@ -1091,10 +2024,26 @@ func (db *DB) CreateEpisode(episode *Episode) (*Episode, error) {
 	return db.GetEpisodeByID(id)
 }
 
+const insertEpisodeSQL = `INSERT INTO episodes (tv_show_id, season_id, season_number, episode_number, title, overview,
+		still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
+		video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks)
+	 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// episodeInsertArgs returns the values of insertEpisodeSQL for episode
+func episodeInsertArgs(episode *Episode) []any {
+	return []any{
+		episode.TVShowID, episode.SeasonID, episode.SeasonNumber, episode.EpisodeNumber,
+		episode.Title, episode.Overview, episode.StillPath, episode.AirDate, parseAirDate(episode.AirDate),
+		episode.Runtime, episode.Rating, episode.SourceID, episode.FilePath, episode.FileSize, episode.Duration,
+		episode.VideoCodec, episode.AudioCodec, episode.Resolution, episode.AudioTracks,
+		episode.SubtitleTracks,
+	}
+}
+
 // GetEpisodeByID retrieves an episode by ID
 func (db *DB) GetEpisodeByID(id int64) (*Episode, error) {
 	query := `SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-		still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
+		still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
 		video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
 	 FROM episodes WHERE id = ?`
 	episode, err := getByID(db.conn, query, id, scanEpisodeRow)
@@ -1110,7 +2059,7 @@ func (db *DB) GetEpisodeByID(id int64) (*Episode, error) {
 // GetEpisodeByFilePath retrieves an episode by file path
 func (db *DB) GetEpisodeByFilePath(filePath string) (*Episode, error) {
 	query := `SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-		still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
+		still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
 		video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
 	 FROM episodes WHERE file_path = ?`
 	episode, err := getByFilePath(db.conn, query, filePath, scanEpisodeRow)
@@ -1128,13 +2077,13 @@ func (db *DB) GetEpisodeByNumber(showID int64, seasonNum, episodeNum int) (*Epis
 	episode := &Episode{}
 	err := db.conn.QueryRow(
 		`SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
+			still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
 			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
 		 FROM episodes WHERE tv_show_id = ? AND season_number = ? AND episode_number = ?`,
 		showID, seasonNum, episodeNum,
 	).Scan(&episode.ID, &episode.TVShowID, &episode.SeasonID, &episode.SeasonNumber,
 		&episode.EpisodeNumber, &episode.Title, &episode.Overview, &episode.StillPath,
-		&episode.AirDate, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
+		&episode.AirDate, &episode.AiredAt, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
 		&episode.FileSize, &episode.Duration, &episode.VideoCodec, &episode.AudioCodec,
 		&episode.Resolution, &episode.AudioTracks, &episode.SubtitleTracks,
 		&episode.CreatedAt, &episode.UpdatedAt)
@@ -1144,13 +2093,31 @@ func (db *DB) GetEpisodeByNumber(showID int64, seasonNum, episodeNum int) (*Epis
 	return episode, err
 }
 
+// UpdateEpisodeMetadata updates an episode's TMDB metadata, leaving its
+// numbering and file information alone
+func (db *DB) UpdateEpisodeMetadata(episode *Episode) error {
+	_, err := db.conn.Exec(
+		`UPDATE episodes SET title = ?, overview = ?, still_path = ?, air_date = ?, aired_at = ?,
+			runtime = ?, rating = ?, updated_at = ?
+		 WHERE id = ?`,
+		episode.Title, episode.Overview, episode.StillPath, episode.AirDate, parseAirDate(episode.AirDate),
+		episode.Runtime, episode.Rating, time.Now(), episode.ID,
+	)
+	return err
+}
+
 // GetEpisodesBySeasonID retrieves all episodes for a season
-func (db *DB) GetEpisodesBySeasonID(seasonID int64) ([]*Episode, error) {
+func (db *DB) GetEpisodesBySeasonID(seasonID int64, opts EpisodeListOptions) ([]*Episode, error) {
+	orderBy := "e.episode_number"
+	if opts.SortByAirDate {
+		orderBy = "e.aired_at IS NULL, e.aired_at, e.episode_number"
+	}
+
 	rows, err := db.conn.Query(
-		`SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
-			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
-		 FROM episodes WHERE season_id = ? ORDER BY episode_number`,
+		`SELECT e.id, e.tv_show_id, e.season_id, e.season_number, e.episode_number, e.title, e.overview,
+			e.still_path, e.air_date, e.aired_at, e.runtime, e.rating, e.source_id, e.file_path, e.file_size, e.duration,
+			e.video_codec, e.audio_codec, e.resolution, e.audio_tracks, e.subtitle_tracks, e.created_at, e.updated_at
+		 FROM episodes e WHERE e.season_id = ?`+opts.where()+` ORDER BY `+orderBy,
 		seasonID,
 	)
 	if err != nil {
@@ -1162,12 +2129,17 @@ func (db *DB) GetEpisodesBySeasonID(seasonID int64) ([]*Episode, error) {
 }
 
 // GetEpisodesByShowID retrieves all episodes for a TV show
-func (db *DB) GetEpisodesByShowID(showID int64) ([]*Episode, error) {
+func (db *DB) GetEpisodesByShowID(showID int64, opts EpisodeListOptions) ([]*Episode, error) {
+	orderBy := "e.season_number, e.episode_number"
+	if opts.SortByAirDate {
+		orderBy = "e.aired_at IS NULL, e.aired_at, e.season_number, e.episode_number"
+	}
+
 	rows, err := db.conn.Query(
-		`SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
-			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
-		 FROM episodes WHERE tv_show_id = ? ORDER BY season_number, episode_number`,
+		`SELECT e.id, e.tv_show_id, e.season_id, e.season_number, e.episode_number, e.title, e.overview,
+			e.still_path, e.air_date, e.aired_at, e.runtime, e.rating, e.source_id, e.file_path, e.file_size, e.duration,
+			e.video_codec, e.audio_codec, e.resolution, e.audio_tracks, e.subtitle_tracks, e.created_at, e.updated_at
+		 FROM episodes e WHERE e.tv_show_id = ?`+opts.where()+` ORDER BY `+orderBy,
 		showID,
 	)
 	if err != nil {
@@ -1178,18 +2150,59 @@ func (db *DB) GetEpisodesByShowID(showID int64) ([]*Episode, error) {
 	return scanEpisodeRows(rows)
 }
 
+// GetUpNextEpisode returns the episode after the one the user completed most
+// recently, in season/episode order and rolling over into the next season,
+// or the first episode if they haven't completed any. Specials are skipped,
+// and watching one doesn't move the user's place in the show.
+// It returns ErrNotFound once the last episode has been watched.
+func (db *DB) GetUpNextEpisode(userID, showID int64) (*Episode, error) {
+	var lastID int64
+	err := db.conn.QueryRow(
+		`SELECT e.id FROM watch_progress wp
+		 JOIN episodes e ON wp.media_type = 'episode' AND wp.media_id = e.id
+		 WHERE wp.user_id = ? AND e.tv_show_id = ? AND wp.completed = 1 AND e.season_number > 0
+		 ORDER BY COALESCE(wp.last_played_at, wp.updated_at) DESC, e.season_number DESC, e.episode_number DESC
+		 LIMIT 1`,
+		userID, showID,
+	).Scan(&lastID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	episodes, err := db.GetEpisodesByShowID(showID, EpisodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if lastID != 0 {
+		for i, episode := range episodes {
+			if episode.ID == lastID {
+				start = i + 1
+				break
+			}
+		}
+	}
+	for _, episode := range episodes[start:] {
+		if episode.SeasonNumber > 0 {
+			return episode, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
 // GetRandomEpisode retrieves a random episode from a TV show
 func (db *DB) GetRandomEpisode(showID int64) (*Episode, error) {
 	episode := &Episode{}
 	err := db.conn.QueryRow(
 		`SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
+			still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
 			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
 		 FROM episodes WHERE tv_show_id = ? ORDER BY RANDOM() LIMIT 1`,
 		showID,
 	).Scan(&episode.ID, &episode.TVShowID, &episode.SeasonID, &episode.SeasonNumber,
 		&episode.EpisodeNumber, &episode.Title, &episode.Overview, &episode.StillPath,
-		&episode.AirDate, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
+		&episode.AirDate, &episode.AiredAt, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
 		&episode.FileSize, &episode.Duration, &episode.VideoCodec, &episode.AudioCodec,
 		&episode.Resolution, &episode.AudioTracks, &episode.SubtitleTracks,
 		&episode.CreatedAt, &episode.UpdatedAt)
@@ -1204,13 +2217,13 @@ func (db *DB) GetRandomEpisodeFromSeason(seasonID int64) (*Episode, error) {
 	episode := &Episode{}
 	err := db.conn.QueryRow(
 		`SELECT id, tv_show_id, season_id, season_number, episode_number, title, overview,
-			still_path, air_date, runtime, rating, source_id, file_path, file_size, duration,
+			still_path, air_date, aired_at, runtime, rating, source_id, file_path, file_size, duration,
 			video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks, created_at, updated_at
 		 FROM episodes WHERE season_id = ? ORDER BY RANDOM() LIMIT 1`,
 		seasonID,
 	).Scan(&episode.ID, &episode.TVShowID, &episode.SeasonID, &episode.SeasonNumber,
 		&episode.EpisodeNumber, &episode.Title, &episode.Overview, &episode.StillPath,
-		&episode.AirDate, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
+		&episode.AirDate, &episode.AiredAt, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
 		&episode.FileSize, &episode.Duration, &episode.VideoCodec, &episode.AudioCodec,
 		&episode.Resolution, &episode.AudioTracks, &episode.SubtitleTracks,
 		&episode.CreatedAt, &episode.UpdatedAt)
@@ -1220,27 +2233,152 @@ func (db *DB) GetRandomEpisodeFromSeason(seasonID int64) (*Episode, error) {
 	return episode, err
 }
 
+// AttachShowArtwork fills in the parent show's poster and backdrop on each
+// episode, looking every show up once
+func (db *DB) AttachShowArtwork(episodes ...*Episode) error {
+	if len(episodes) == 0 {
+		return nil
+	}
+
+	var placeholders []string
+	var params []interface{}
+	seen := make(map[int64]bool)
+	for _, e := range episodes {
+		if !seen[e.TVShowID] {
+			seen[e.TVShowID] = true
+			placeholders = append(placeholders, "?")
+			params = append(params, e.TVShowID)
+		}
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT id, COALESCE(poster_path, ''), COALESCE(backdrop_path, '')
+		 FROM tv_shows WHERE id IN (`+strings.Join(placeholders, ", ")+`)`,
+		params...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type artwork struct{ poster, backdrop string }
+	art := make(map[int64]artwork)
+	for rows.Next() {
+		var id int64
+		var a artwork
+		if err := rows.Scan(&id, &a.poster, &a.backdrop); err != nil {
+			return err
+		}
+		art[id] = a
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range episodes {
+		a := art[e.TVShowID]
+		e.ShowPosterPath = a.poster
+		e.ShowBackdropPath = a.backdrop
+	}
+	return nil
+}
+
 func scanEpisodeRows(rows *sql.Rows) ([]*Episode, error) {
 	episodes := make([]*Episode, 0)
 	for rows.Next() {
 		episode := &Episode{}
 		if err := rows.Scan(&episode.ID, &episode.TVShowID, &episode.SeasonID, &episode.SeasonNumber,
 			&episode.EpisodeNumber, &episode.Title, &episode.Overview, &episode.StillPath,
-			&episode.AirDate, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
+			&episode.AirDate, &episode.AiredAt, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
 			&episode.FileSize, &episode.Duration, &episode.VideoCodec, &episode.AudioCodec,
 			&episode.Resolution, &episode.AudioTracks, &episode.SubtitleTracks,
 			&episode.CreatedAt, &episode.UpdatedAt); err != nil {
 			return nil, err
 		}
-		episodes = append(episodes, episode)
+		episodes = append(episodes, episode)
+	}
+	return episodes, nil
+}
+
+// New episode feed filters
+const (
+	NewEpisodesFilterWatchlist = "watchlist" // shows in the user's watchlist
+	NewEpisodesFilterWatching  = "watching"  // shows the user has watch history for
+)
+
+// GetNewEpisodes returns episodes across all shows, newest first, with show
+// and season context. Ordered by when they were added unless opts.SortByAirDate
+// is set. filter optionally restricts to the user's watchlist or watched shows.
+func (db *DB) GetNewEpisodes(userID int64, filter string, opts EpisodeListOptions, limit, offset int) ([]*EpisodeWithShow, int, error) {
+	where := "WHERE 1=1" + opts.where()
+	var params []interface{}
+
+	switch filter {
+	case NewEpisodesFilterWatchlist:
+		where += ` AND e.tv_show_id IN (
+			SELECT media_id FROM watchlist WHERE user_id = ? AND media_type = 'tvshow')`
+		params = append(params, userID)
+	case NewEpisodesFilterWatching:
+		where += ` AND e.tv_show_id IN (
+			SELECT ep.tv_show_id FROM watch_progress wp
+			JOIN episodes ep ON ep.id = wp.media_id
+			WHERE wp.user_id = ? AND wp.media_type = 'episode')`
+		params = append(params, userID)
+	}
+
+	var total int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM episodes e `+where, params...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	orderBy := "COALESCE(e.date_added, e.created_at) DESC, e.id DESC"
+	if opts.SortByAirDate {
+		orderBy = "e.aired_at IS NULL, e.aired_at DESC, COALESCE(e.date_added, e.created_at) DESC, e.id DESC"
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT e.id, e.tv_show_id, e.season_id, e.season_number, e.episode_number, e.title, e.overview,
+			e.still_path, e.air_date, e.aired_at, e.runtime, e.rating, e.source_id, e.file_path, e.file_size, e.duration,
+			e.video_codec, e.audio_codec, e.resolution, e.audio_tracks, e.subtitle_tracks, e.created_at, e.updated_at,
+			s.title, COALESCE(s.poster_path, ''), COALESCE(se.name, '')
+		 FROM episodes e
+		 JOIN tv_shows s ON s.id = e.tv_show_id
+		 LEFT JOIN seasons se ON se.id = e.season_id
+		 `+where+`
+		 ORDER BY `+orderBy+` LIMIT ? OFFSET ?`,
+		append(params, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]*EpisodeWithShow, 0)
+	for rows.Next() {
+		episode := &Episode{}
+		item := &EpisodeWithShow{Episode: episode}
+		if err := rows.Scan(&episode.ID, &episode.TVShowID, &episode.SeasonID, &episode.SeasonNumber,
+			&episode.EpisodeNumber, &episode.Title, &episode.Overview, &episode.StillPath,
+			&episode.AirDate, &episode.AiredAt, &episode.Runtime, &episode.Rating, &episode.SourceID, &episode.FilePath,
+			&episode.FileSize, &episode.Duration, &episode.VideoCodec, &episode.AudioCodec,
+			&episode.Resolution, &episode.AudioTracks, &episode.SubtitleTracks,
+			&episode.CreatedAt, &episode.UpdatedAt,
+			&item.ShowTitle, &item.ShowPosterPath, &item.SeasonName); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
 	}
-	return episodes, nil
+
+	return items, total, rows.Err()
 }
 
 // ============ Extras Repository Methods ============
 
 // CreateExtra creates a new extra content record
 func (db *DB) CreateExtra(extra *Extra) (*Extra, error) {
+	defer db.invalidateAggregates()
+
 	result, err := db.conn.Exec(
 		`INSERT INTO extras (title, category, movie_id, tv_show_id, episode_id, season_number, episode_number,
 			source_id, file_path, file_size, duration, video_codec, audio_codec, resolution, audio_tracks, subtitle_tracks)
@@ -1341,46 +2479,63 @@ func (db *DB) GetExtrasByEpisodeID(episodeID int64) ([]*Extra, error) {
 	return scanExtraRows(rows)
 }
 
-// GetAllExtras retrieves all extras with pagination
-func (db *DB) GetAllExtras(limit, offset int) ([]*Extra, int, error) {
-	// Get total count
-	var total int
-	err := db.conn.QueryRow(`SELECT COUNT(*) FROM extras`).Scan(&total)
-	if err != nil {
-		return nil, 0, err
-	}
+// Extras parent filters
+const (
+	ExtraParentMovie = "movie" // attached to a movie
+	ExtraParentShow  = "show"  // attached to a TV show or one of its episodes
+	ExtraParentNone  = "none"  // orphaned: not attached to anything
+)
 
-	rows, err := db.conn.Query(
-		`SELECT e.id, e.title, e.category, e.movie_id, e.tv_show_id, e.episode_id, e.season_number, e.episode_number,
-			e.source_id, e.file_path, e.file_size, e.duration, e.video_codec, e.audio_codec, e.resolution,
-			e.audio_tracks, e.subtitle_tracks, e.created_at, e.updated_at
-		 FROM extras e ORDER BY e.created_at DESC LIMIT ? OFFSET ?`,
-		limit, offset,
-	)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
+// ExtraListOptions controls filtering and ordering of extras listings
+type ExtraListOptions struct {
+	SortByDuration bool   // Longest first instead of newest first
+	Parent         string // One of the ExtraParent* filters, or "" for all
+}
 
-	extras, err := scanExtraRows(rows)
-	return extras, total, err
+// where returns the SQL condition for the options, for extras aliased as e
+func (o ExtraListOptions) where() string {
+	switch o.Parent {
+	case ExtraParentMovie:
+		return " AND e.movie_id IS NOT NULL"
+	case ExtraParentShow:
+		return " AND (e.tv_show_id IS NOT NULL OR e.episode_id IS NOT NULL)"
+	case ExtraParentNone:
+		return " AND e.movie_id IS NULL AND e.tv_show_id IS NULL AND e.episode_id IS NULL"
+	}
+	return ""
+}
+
+// GetAllExtras retrieves all extras with pagination
+func (db *DB) GetAllExtras(opts ExtraListOptions, limit, offset int) ([]*Extra, int, error) {
+	return db.listExtras("WHERE 1=1", nil, opts, limit, offset)
 }
 
 // GetExtrasByCategory gets all extras of a specific category with pagination
-func (db *DB) GetExtrasByCategory(category ExtraCategory, limit, offset int) ([]*Extra, int, error) {
+func (db *DB) GetExtrasByCategory(category ExtraCategory, opts ExtraListOptions, limit, offset int) ([]*Extra, int, error) {
+	return db.listExtras("WHERE e.category = ?", []interface{}{category}, opts, limit, offset)
+}
+
+func (db *DB) listExtras(where string, params []interface{}, opts ExtraListOptions, limit, offset int) ([]*Extra, int, error) {
+	where += opts.where()
+
 	// Get total count
 	var total int
-	err := db.conn.QueryRow(`SELECT COUNT(*) FROM extras WHERE category = ?`, category).Scan(&total)
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM extras e `+where, params...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	orderBy := "e.created_at DESC, e.id DESC"
+	if opts.SortByDuration {
+		orderBy = "COALESCE(e.duration, 0) DESC, e.title"
+	}
+
 	rows, err := db.conn.Query(
-		`SELECT id, title, category, movie_id, tv_show_id, episode_id, season_number, episode_number,
-			source_id, file_path, file_size, duration, video_codec, audio_codec, resolution,
-			audio_tracks, subtitle_tracks, created_at, updated_at
-		 FROM extras WHERE category = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`,
-		category, limit, offset,
+		`SELECT e.id, e.title, e.category, e.movie_id, e.tv_show_id, e.episode_id, e.season_number, e.episode_number,
+			e.source_id, e.file_path, e.file_size, COALESCE(e.duration, 0), e.video_codec, e.audio_codec,
+			COALESCE(e.resolution, ''), e.audio_tracks, e.subtitle_tracks, e.created_at, e.updated_at
+		 FROM extras e `+where+` ORDER BY `+orderBy+` LIMIT ? OFFSET ?`,
+		append(params, limit, offset)...,
 	)
 	if err != nil {
 		return nil, 0, err
@@ -1459,8 +2614,129 @@ func (db *DB) GetRandomExtra(category string) (*Extra, error) {
 	return extra, err
 }
 
+// SetExternalSubtitles replaces the sidecar subtitles of the movies and
+// episodes stored in a video file
+func (db *DB) SetExternalSubtitles(videoPath string, subtitles []ExternalSubtitle) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var refs []MediaRef
+	rows, err := tx.Query(
+		`SELECT id, type FROM media WHERE file_path = ?
+		 UNION ALL
+		 SELECT id, 'episode' FROM episodes WHERE file_path = ?`,
+		videoPath, videoPath,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var ref MediaRef
+		if err := rows.Scan(&ref.ID, &ref.Type); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
+
+	for _, ref := range refs {
+		if _, err := tx.Exec(`DELETE FROM external_subtitles WHERE media_id = ? AND media_type = ?`, ref.ID, ref.Type); err != nil {
+			return err
+		}
+		for _, sub := range subtitles {
+			if _, err := tx.Exec(
+				`INSERT INTO external_subtitles (media_id, media_type, file_path, language, format, forced)
+				 VALUES (?, ?, ?, ?, ?, ?)`,
+				ref.ID, ref.Type, sub.FilePath, sub.Language, sub.Format, sub.Forced,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetExternalSubtitles returns the sidecar subtitles of a movie or episode
+func (db *DB) GetExternalSubtitles(ref MediaRef) ([]*ExternalSubtitle, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, media_id, media_type, file_path, COALESCE(language, ''), format, forced
+		 FROM external_subtitles WHERE media_id = ? AND media_type = ? ORDER BY file_path`,
+		ref.ID, ref.Type,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subtitles := make([]*ExternalSubtitle, 0)
+	for rows.Next() {
+		sub := &ExternalSubtitle{}
+		if err := rows.Scan(&sub.ID, &sub.MediaID, &sub.MediaType, &sub.FilePath, &sub.Language,
+			&sub.Format, &sub.Forced); err != nil {
+			return nil, err
+		}
+		subtitles = append(subtitles, sub)
+	}
+	return subtitles, rows.Err()
+}
+
+// ReplaceCredits replaces the cast of a movie or show
+func (db *DB) ReplaceCredits(ref MediaRef, credits []Credit) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM credits WHERE media_id = ? AND media_type = ?`, ref.ID, ref.Type); err != nil {
+		return err
+	}
+	for _, credit := range credits {
+		if _, err := tx.Exec(
+			`INSERT INTO credits (media_id, media_type, person_id, name, character, profile_path, sort_order)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			ref.ID, ref.Type, credit.PersonID, credit.Name, credit.Character, credit.ProfilePath, credit.Order,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetCredits returns the cast of a movie or show in billing order
+func (db *DB) GetCredits(ref MediaRef) ([]*Credit, error) {
+	rows, err := db.conn.Query(
+		`SELECT media_id, media_type, person_id, name, COALESCE(character, ''), COALESCE(profile_path, ''), sort_order
+		 FROM credits WHERE media_id = ? AND media_type = ? ORDER BY sort_order, id`,
+		ref.ID, ref.Type,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make([]*Credit, 0)
+	for rows.Next() {
+		credit := &Credit{}
+		if err := rows.Scan(&credit.MediaID, &credit.MediaType, &credit.PersonID, &credit.Name,
+			&credit.Character, &credit.ProfilePath, &credit.Order); err != nil {
+			return nil, err
+		}
+		credits = append(credits, credit)
+	}
+	return credits, rows.Err()
+}
+
 // DeleteExtrasBySourceID removes all extras from a source
 func (db *DB) DeleteExtrasBySourceID(sourceID int64) error {
+	defer db.invalidateAggregates()
+
 	_, err := db.conn.Exec(`DELETE FROM extras WHERE source_id = ?`, sourceID)
 	return err
 }
@@ -1651,7 +2927,8 @@ func (db *DB) DeleteSection(id int64) error {
 // GetSectionRules returns all rules for a section
 func (db *DB) GetSectionRules(sectionID int64) ([]SectionRule, error) {
 	query := `
-        SELECT id, section_id, field, operator, value, created_at
+        SELECT id, section_id, field, operator, value, rule_group,
+            COALESCE(conjunction, 'and'), created_at
         FROM section_rules
         WHERE section_id = ?
         ORDER BY id ASC
@@ -1666,7 +2943,8 @@ func (db *DB) GetSectionRules(sectionID int64) ([]SectionRule, error) {
 	var rules []SectionRule
 	for rows.Next() {
 		var r SectionRule
-		err := rows.Scan(&r.ID, &r.SectionID, &r.Field, &r.Operator, &r.Value, &r.CreatedAt)
+		err := rows.Scan(&r.ID, &r.SectionID, &r.Field, &r.Operator, &r.Value, &r.Group,
+			&r.Conjunction, &r.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -1679,11 +2957,15 @@ func (db *DB) GetSectionRules(sectionID int64) ([]SectionRule, error) {
 // CreateSectionRule creates a new rule for a section
 func (db *DB) CreateSectionRule(rule *SectionRule) error {
 	query := `
-        INSERT INTO section_rules (section_id, field, operator, value)
-        VALUES (?, ?, ?, ?)
+        INSERT INTO section_rules (section_id, field, operator, value, rule_group, conjunction)
+        VALUES (?, ?, ?, ?, ?, ?)
     `
 
-	result, err := db.conn.Exec(query, rule.SectionID, rule.Field, rule.Operator, rule.Value)
+	if rule.Conjunction == "" {
+		rule.Conjunction = ConjunctionAnd
+	}
+	result, err := db.conn.Exec(query, rule.SectionID, rule.Field, rule.Operator, rule.Value,
+		rule.Group, rule.Conjunction)
 	if err != nil {
 		return err
 	}
@@ -1704,11 +2986,25 @@ func (db *DB) DeleteSectionRule(id int64) error {
 	return err
 }
 
-// AddMediaToSection adds a media item to a section
+// AddMediaToSection manually adds a media item to a section. In a smart
+// section this pins the item above the rule matches.
 func (db *DB) AddMediaToSection(mediaID int64, mediaType MediaType, sectionID int64) error {
 	query := `
-        INSERT OR IGNORE INTO media_sections (media_id, media_type, section_id)
-        VALUES (?, ?, ?)
+        INSERT INTO media_sections (media_id, media_type, section_id, manual)
+        VALUES (?, ?, ?, 1)
+        ON CONFLICT(media_id, media_type, section_id) DO UPDATE SET manual = 1, added_at = CURRENT_TIMESTAMP
+    `
+
+	_, err := db.conn.Exec(query, mediaID, mediaType, sectionID)
+	return err
+}
+
+// autoAssignMediaToSection records a smart-section rule match, leaving any
+// manual entry for the item as it is
+func (db *DB) autoAssignMediaToSection(mediaID int64, mediaType MediaType, sectionID int64) error {
+	query := `
+        INSERT OR IGNORE INTO media_sections (media_id, media_type, section_id, manual)
+        VALUES (?, ?, ?, 0)
     `
 
 	_, err := db.conn.Exec(query, mediaID, mediaType, sectionID)
@@ -1726,6 +3022,47 @@ func (db *DB) RemoveMediaFromSection(mediaID int64, mediaType MediaType, section
 	return err
 }
 
+// GetMediaIDs returns the IDs of media items from a source and of a type,
+// for batch jobs. Zero values don't filter.
+func (db *DB) GetMediaIDs(sourceID int64, mediaType MediaType) ([]int64, error) {
+	return db.queryIDs(
+		`SELECT id FROM media
+		 WHERE (? = 0 OR source_id = ?) AND (? = '' OR type = ?)
+		 ORDER BY id`,
+		sourceID, sourceID, mediaType, mediaType,
+	)
+}
+
+// GetTVShowIDs returns the IDs of TV shows with episodes from a source, or
+// of all shows when sourceID is 0
+func (db *DB) GetTVShowIDs(sourceID int64) ([]int64, error) {
+	return db.queryIDs(
+		`SELECT id FROM tv_shows
+		 WHERE ? = 0 OR id IN (SELECT tv_show_id FROM episodes WHERE source_id = ?)
+		 ORDER BY id`,
+		sourceID, sourceID,
+	)
+}
+
+// queryIDs runs a query selecting a single ID column
+func (db *DB) queryIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // GetMediaSections returns all section IDs a media item belongs to
 func (db *DB) GetMediaSections(mediaID int64, mediaType MediaType) ([]int64, error) {
 	query := `
@@ -1752,7 +3089,8 @@ func (db *DB) GetMediaSections(mediaID int64, mediaType MediaType) ([]int64, err
 	return sectionIDs, rows.Err()
 }
 
-// GetMediaBySectionID returns media items in a section
+// GetMediaBySectionID returns media items in a section. Smart sections list
+// their manually added items first, then the rule matches not already listed.
 func (db *DB) GetMediaBySectionID(sectionID int64, limit, offset int) ([]interface{}, int, error) {
 	// First get the section to determine its type
 	section, err := db.GetSectionByID(sectionID)
@@ -1760,41 +3098,50 @@ func (db *DB) GetMediaBySectionID(sectionID int64, limit, offset int) ([]interfa
 		return nil, 0, err
 	}
 
-	var items []interface{}
-	var total int
-
-	if section.SectionType == SectionTypeSmart {
-		// For smart sections, evaluate rules
-		items, total, err = db.evaluateSmartSection(section, limit, offset)
-	} else {
+	if section.SectionType != SectionTypeSmart {
 		// For standard sections, get manually assigned media
-		items, total, err = db.getManualSectionMedia(sectionID, limit, offset)
+		return db.getManualSectionMedia(sectionID, false, limit, offset)
+	}
+
+	// Manual items occupy the first manualTotal positions
+	items, manualTotal, err := db.getManualSectionMedia(sectionID, true, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ruleOffset := offset - manualTotal
+	if ruleOffset < 0 {
+		ruleOffset = 0
+	}
+	ruleItems, ruleTotal, err := db.evaluateSmartSection(section, limit-len(items), ruleOffset)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return items, total, err
+	return append(items, ruleItems...), manualTotal + ruleTotal, nil
 }
 
-// Helper method for manual sections
-func (db *DB) getManualSectionMedia(sectionID int64, limit, offset int) ([]interface{}, int, error) {
-	// Get total count
-	countQuery := `
-        SELECT COUNT(*)
-        FROM media_sections
-        WHERE section_id = ?
-    `
+// Helper method for manual sections. With manualOnly, smart-section rule
+// matches recorded by auto-assignment are skipped.
+func (db *DB) getManualSectionMedia(sectionID int64, manualOnly bool, limit, offset int) ([]interface{}, int, error) {
+	where := "WHERE section_id = ?"
+	if manualOnly {
+		where += " AND manual = 1"
+	}
 
+	// Get total count
 	var total int
-	err := db.conn.QueryRow(countQuery, sectionID).Scan(&total)
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM media_sections `+where, sectionID).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// Get media items
 	query := `
-        SELECT ms.media_id, ms.media_type
-        FROM media_sections ms
-        WHERE ms.section_id = ?
-        ORDER BY ms.added_at DESC
+        SELECT media_id, media_type
+        FROM media_sections
+        ` + where + `
+        ORDER BY added_at DESC, id DESC
         LIMIT ? OFFSET ?
     `
 
@@ -1802,70 +3149,329 @@ func (db *DB) getManualSectionMedia(sectionID int64, limit, offset int) ([]inter
 	if err != nil {
 		return nil, 0, err
 	}
-	defer rows.Close()
 
-	var items []interface{}
+	// Collect the refs before fetching items: with a single connection, the
+	// lookups can't run while rows is still open
+	var refs []MediaRef
 	for rows.Next() {
-		var mediaID int64
-		var mediaType MediaType
-
-		if err := rows.Scan(&mediaID, &mediaType); err != nil {
+		var ref MediaRef
+		if err := rows.Scan(&ref.ID, &ref.Type); err != nil {
 			continue
 		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
 
-		// Fetch the actual media item based on type
-		switch mediaType {
-		case MediaTypeMovie:
-			if media, err := db.GetMediaByID(mediaID); err == nil {
-				items = append(items, media)
-			}
-		case MediaTypeEpisode:
-			if episode, err := db.GetEpisodeByID(mediaID); err == nil {
-				items = append(items, episode)
-			}
-		case MediaTypeExtra:
-			if extra, err := db.GetExtraByID(mediaID); err == nil {
-				items = append(items, extra)
-			}
+	items := make([]interface{}, 0, len(refs))
+	for _, ref := range refs {
+		if item, ok := db.sectionItem(ref); ok {
+			items = append(items, item)
 		}
 	}
 
 	return items, total, nil
 }
 
+// sectionItem fetches the item behind a section entry, marked with its kind.
+// It returns false if the item no longer exists.
+func (db *DB) sectionItem(ref MediaRef) (SectionItem, bool) {
+	var item interface{}
+	var err error
+	switch ref.Type {
+	case MediaTypeMovie:
+		item, err = db.GetMediaByID(ref.ID)
+	case MediaTypeTVShow:
+		item, err = db.GetTVShowByID(ref.ID)
+	case MediaTypeEpisode:
+		item, err = db.GetEpisodeByID(ref.ID)
+	case MediaTypeExtra:
+		item, err = db.GetExtraByID(ref.ID)
+	default:
+		return SectionItem{}, false
+	}
+	if err != nil {
+		return SectionItem{}, false
+	}
+	return SectionItem{Kind: ref.Type, Item: item}, true
+}
+
 // ============ Library Statistics ============
 
 // LibraryStats contains aggregate library statistics
 type LibraryStats struct {
-	MovieCount   int `json:"movie_count"`
-	ShowCount    int `json:"show_count"`
-	EpisodeCount int `json:"episode_count"`
-	ExtraCount   int `json:"extra_count"`
-	SourceCount  int `json:"source_count"`
+	MovieCount    int   `json:"movie_count"`
+	ShowCount     int   `json:"show_count"`
+	SeasonCount   int   `json:"season_count"`
+	EpisodeCount  int   `json:"episode_count"`
+	ExtraCount    int   `json:"extra_count"`
+	SourceCount   int   `json:"source_count"`
+	TotalSize     int64 `json:"total_size"`     // bytes, movies and episodes
+	TotalDuration int64 `json:"total_duration"` // seconds, movies and episodes
+
+	// Movie and episode files by resolution (4K, 1080p, 720p or SD) and
+	// video codec
+	Resolutions map[string]int `json:"resolutions"`
+	VideoCodecs map[string]int `json:"video_codecs"`
+
+	Sources []SourceStats `json:"sources"`
+}
+
+// SourceStats counts the movies and episodes found in a media source
+type SourceStats struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	MovieCount   int    `json:"movie_count"`
+	EpisodeCount int    `json:"episode_count"`
+	TotalSize    int64  `json:"total_size"`
 }
 
+// libraryFilesCTE unions the files of movies and episodes into one "files"
+// table
+const libraryFilesCTE = `WITH files AS (
+		SELECT source_id, file_size, duration, video_codec, resolution FROM media WHERE type = 'movie'
+		UNION ALL
+		SELECT source_id, file_size, duration, video_codec, resolution FROM episodes
+	)`
+
 // GetLibraryStats returns aggregate statistics for the media library
 func (db *DB) GetLibraryStats() (*LibraryStats, error) {
-	stats := &LibraryStats{}
+	stats := &LibraryStats{
+		Resolutions: make(map[string]int),
+		VideoCodecs: make(map[string]int),
+		Sources:     []SourceStats{},
+	}
 
 	query := `
 		SELECT
 			(SELECT COUNT(*) FROM media WHERE type = 'movie') as movies,
 			(SELECT COUNT(*) FROM tv_shows) as shows,
+			(SELECT COUNT(*) FROM seasons) as seasons,
 			(SELECT COUNT(*) FROM episodes) as episodes,
 			(SELECT COUNT(*) FROM extras) as extras,
 			(SELECT COUNT(*) FROM media_sources WHERE enabled = 1) as sources
 	`
 
 	err := db.conn.QueryRow(query).Scan(
-		&stats.MovieCount, &stats.ShowCount, &stats.EpisodeCount,
+		&stats.MovieCount, &stats.ShowCount, &stats.SeasonCount, &stats.EpisodeCount,
 		&stats.ExtraCount, &stats.SourceCount,
 	)
 	if err != nil {
 		return nil, err
 	}
-
-	return stats, nil
+
+	err = db.conn.QueryRow(
+		libraryFilesCTE + ` SELECT COALESCE(SUM(file_size), 0), COALESCE(SUM(duration), 0) FROM files`,
+	).Scan(&stats.TotalSize, &stats.TotalDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(
+		libraryFilesCTE + ` SELECT ` + fmt.Sprintf(resolutionBucket, "resolution") + `,
+			LOWER(COALESCE(video_codec, '')), COUNT(*)
+		 FROM files GROUP BY 1, 2`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var resolution, codec string
+		var count int
+		if err := rows.Scan(&resolution, &codec, &count); err != nil {
+			return nil, err
+		}
+		if resolution != "" {
+			stats.Resolutions[resolution] += count
+		}
+		if codec != "" {
+			stats.VideoCodecs[codec] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sourceRows, err := db.conn.Query(
+		libraryFilesCTE + ` SELECT s.id, s.name,
+			(SELECT COUNT(*) FROM media WHERE source_id = s.id AND type = 'movie'),
+			(SELECT COUNT(*) FROM episodes WHERE source_id = s.id),
+			(SELECT COALESCE(SUM(file_size), 0) FROM files WHERE source_id = s.id)
+		 FROM media_sources s ORDER BY s.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer sourceRows.Close()
+	for sourceRows.Next() {
+		var source SourceStats
+		if err := sourceRows.Scan(&source.ID, &source.Name, &source.MovieCount,
+			&source.EpisodeCount, &source.TotalSize); err != nil {
+			return nil, err
+		}
+		stats.Sources = append(stats.Sources, source)
+	}
+	return stats, sourceRows.Err()
+}
+
+// LibraryItem is a movie or TV show in the unified library listing
+type LibraryItem struct {
+	ID           int64     `json:"id"`
+	Type         MediaType `json:"type"`
+	Title        string    `json:"title"`
+	Year         int       `json:"year,omitempty"`
+	PosterPath   string    `json:"poster_path,omitempty"`
+	BackdropPath string    `json:"backdrop_path,omitempty"`
+	Rating       float64   `json:"rating,omitempty"`
+	Genres       string    `json:"genres,omitempty"`
+	Resolution   string    `json:"resolution,omitempty"` // 4K, 1080p, 720p or SD; shows use their most common episode resolution
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LibraryFacets counts library items by type, genre, resolution and year
+type LibraryFacets struct {
+	Types       map[MediaType]int `json:"types"`
+	Genres      map[string]int    `json:"genres"`
+	Resolutions map[string]int    `json:"resolutions"`
+	Years       map[int]int       `json:"years"`
+}
+
+// LibraryListOptions filters and orders the unified library listing. Empty
+// fields don't filter.
+type LibraryListOptions struct {
+	Type        MediaType
+	Genre       string
+	Resolution  string // 4K, 1080p, 720p or SD
+	SortByAdded bool   // newest first instead of by title
+}
+
+func (o LibraryListOptions) where() (string, []interface{}) {
+	where := ""
+	var params []interface{}
+	if o.Type != "" {
+		where += " AND i.type = ?"
+		params = append(params, o.Type)
+	}
+	if o.Genre != "" {
+		// genres are stored as "Action, Drama"
+		where += " AND (', ' || i.genres || ', ') LIKE ?"
+		params = append(params, "%, "+o.Genre+", %")
+	}
+	if o.Resolution != "" {
+		where += " AND i.resolution = ?"
+		params = append(params, o.Resolution)
+	}
+	return where, params
+}
+
+// resolutionBucket maps a "WxH" resolution column to the label used by the
+// resolution facet
+const resolutionBucket = `CASE
+		WHEN %[1]s IS NULL OR INSTR(%[1]s, 'x') = 0 THEN ''
+		WHEN CAST(SUBSTR(%[1]s, INSTR(%[1]s, 'x') + 1) AS INTEGER) >= 2160 THEN '4K'
+		WHEN CAST(SUBSTR(%[1]s, INSTR(%[1]s, 'x') + 1) AS INTEGER) >= 1080 THEN '1080p'
+		WHEN CAST(SUBSTR(%[1]s, INSTR(%[1]s, 'x') + 1) AS INTEGER) >= 720 THEN '720p'
+		ELSE 'SD' END`
+
+// libraryItemsCTE unions movies and shows into one "items" table
+var libraryItemsCTE = `WITH items AS (
+		SELECT m.id, 'movie' AS type, m.title, COALESCE(m.year, 0) AS year,
+			COALESCE(m.poster_path, '') AS poster_path, COALESCE(m.backdrop_path, '') AS backdrop_path,
+			COALESCE(m.rating, 0) AS rating, COALESCE(m.genres, '') AS genres,
+			` + fmt.Sprintf(resolutionBucket, "m.resolution") + ` AS resolution, m.created_at,
+			COALESCE(m.date_added, m.created_at) AS added_at
+		FROM media m WHERE m.type = 'movie'
+		UNION ALL
+		SELECT s.id, 'tvshow', s.title, COALESCE(s.year, 0),
+			COALESCE(s.poster_path, ''), COALESCE(s.backdrop_path, ''),
+			COALESCE(s.rating, 0), COALESCE(s.genres, ''),
+			` + fmt.Sprintf(resolutionBucket, `(SELECT resolution FROM episodes WHERE tv_show_id = s.id
+				GROUP BY resolution ORDER BY COUNT(*) DESC LIMIT 1)`) + `, s.created_at,
+			COALESCE((SELECT MIN(date_added) FROM episodes WHERE tv_show_id = s.id), s.created_at)
+		FROM tv_shows s
+	)`
+
+// GetLibraryItems returns movies and shows as one paginated listing, with the
+// total matching opts
+func (db *DB) GetLibraryItems(opts LibraryListOptions, limit, offset int) ([]*LibraryItem, int, error) {
+	where, params := opts.where()
+
+	var total int
+	if err := db.conn.QueryRow(
+		libraryItemsCTE+` SELECT COUNT(*) FROM items i WHERE 1=1`+where, params...,
+	).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	orderBy := "i.title COLLATE NOCASE, i.type, i.id"
+	if opts.SortByAdded {
+		orderBy = "i.added_at DESC, i.id DESC"
+	}
+
+	rows, err := db.conn.Query(
+		libraryItemsCTE+` SELECT i.id, i.type, i.title, i.year, i.poster_path, i.backdrop_path,
+			i.rating, i.genres, i.resolution, i.created_at
+		 FROM items i WHERE 1=1`+where+` ORDER BY `+orderBy+` LIMIT ? OFFSET ?`,
+		append(params, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	items := make([]*LibraryItem, 0)
+	for rows.Next() {
+		item := &LibraryItem{}
+		if err := rows.Scan(&item.ID, &item.Type, &item.Title, &item.Year, &item.PosterPath,
+			&item.BackdropPath, &item.Rating, &item.Genres, &item.Resolution, &item.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
+
+// GetLibraryFacets counts all movies and shows by type, genre and resolution.
+// Items without a genre or resolution aren't counted in those facets.
+func (db *DB) GetLibraryFacets() (*LibraryFacets, error) {
+	facets := &LibraryFacets{
+		Types:       make(map[MediaType]int),
+		Genres:      make(map[string]int),
+		Resolutions: make(map[string]int),
+		Years:       make(map[int]int),
+	}
+
+	rows, err := db.conn.Query(
+		libraryItemsCTE + ` SELECT type, genres, resolution, COALESCE(year, 0), COUNT(*)
+		 FROM items GROUP BY type, genres, resolution, year`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mediaType MediaType
+		var genres, resolution string
+		var year, count int
+		if err := rows.Scan(&mediaType, &genres, &resolution, &year, &count); err != nil {
+			return nil, err
+		}
+
+		facets.Types[mediaType] += count
+		if year > 0 {
+			facets.Years[year] += count
+		}
+		if resolution != "" {
+			facets.Resolutions[resolution] += count
+		}
+		for _, genre := range strings.Split(genres, ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				facets.Genres[genre] += count
+			}
+		}
+	}
+	return facets, rows.Err()
 }
 
 // ============ Channel Repository Methods ============
@@ -1892,9 +3498,11 @@ func (db *DB) CreateChannel(userID int64, name, description, icon string) (*Chan
 func (db *DB) GetChannelByID(id int64) (*Channel, error) {
 	channel := &Channel{}
 	err := db.conn.QueryRow(
-		`SELECT id, user_id, name, description, icon, created_at, updated_at FROM channels WHERE id = ?`,
+		`SELECT id, user_id, name, description, icon, created_at, updated_at, COALESCE(bumper_frequency, 1)
+		FROM channels WHERE id = ?`,
 		id,
-	).Scan(&channel.ID, &channel.UserID, &channel.Name, &channel.Description, &channel.Icon, &channel.CreatedAt, &channel.UpdatedAt)
+	).Scan(&channel.ID, &channel.UserID, &channel.Name, &channel.Description, &channel.Icon, &channel.CreatedAt, &channel.UpdatedAt,
+		&channel.BumperFrequency)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
 	}
@@ -1917,13 +3525,16 @@ func (db *DB) GetUserChannels(userID int64) ([]Channel, error) {
 	// This avoids nested queries which can cause SQLite deadlocks
 	rows, err := db.conn.Query(
 		`SELECT c.id, c.user_id, c.name, c.description, c.icon, c.created_at, c.updated_at,
+			COALESCE(c.bumper_frequency, 1),
 			COALESCE(s.item_count, 0) as item_count,
 			COALESCE(s.total_duration, 0) as total_duration
 		FROM channels c
 		LEFT JOIN (
 			SELECT channel_id, COUNT(*) as item_count, SUM(duration) as total_duration
-			FROM channel_schedule
-			WHERE cycle_number = 1
+			FROM channel_schedule cs
+			WHERE cycle_number = (
+				SELECT MAX(cycle_number) FROM channel_schedule WHERE channel_id = cs.channel_id
+			)
 			GROUP BY channel_id
 		) s ON c.id = s.channel_id
 		WHERE c.user_id = ?
@@ -1938,7 +3549,8 @@ func (db *DB) GetUserChannels(userID int64) ([]Channel, error) {
 	var channels []Channel
 	for rows.Next() {
 		var ch Channel
-		if err := rows.Scan(&ch.ID, &ch.UserID, &ch.Name, &ch.Description, &ch.Icon, &ch.CreatedAt, &ch.UpdatedAt, &ch.ItemCount, &ch.TotalDuration); err != nil {
+		if err := rows.Scan(&ch.ID, &ch.UserID, &ch.Name, &ch.Description, &ch.Icon, &ch.CreatedAt, &ch.UpdatedAt,
+			&ch.BumperFrequency, &ch.ItemCount, &ch.TotalDuration); err != nil {
 			continue
 		}
 		channels = append(channels, ch)
@@ -1959,6 +3571,16 @@ func (db *DB) UpdateChannel(id int64, name, description, icon string) (*Channel,
 	return db.GetChannelByID(id)
 }
 
+// SetChannelBumperFrequency sets how many items play between a channel's
+// bumpers, 0 for none. Cycles already scheduled keep theirs.
+func (db *DB) SetChannelBumperFrequency(id int64, frequency int) error {
+	_, err := db.conn.Exec(
+		`UPDATE channels SET bumper_frequency = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		frequency, id,
+	)
+	return err
+}
+
 // DeleteChannel deletes a channel and all its data
 func (db *DB) DeleteChannel(id int64) error {
 	_, err := db.conn.Exec(`DELETE FROM channels WHERE id = ?`, id)
@@ -2071,7 +3693,7 @@ func (db *DB) getSourceName(sourceType string, sourceID *int64, sourceValue stri
 
 	var name string
 	switch sourceType {
-	case ChannelSourcePlaylist:
+	case ChannelSourcePlaylist, ChannelSourceBumper:
 		db.conn.QueryRow(`SELECT name FROM playlists WHERE id = ?`, *sourceID).Scan(&name)
 	case ChannelSourceSection:
 		db.conn.QueryRow(`SELECT name FROM sections WHERE id = ?`, *sourceID).Scan(&name)
@@ -2103,134 +3725,336 @@ type channelScheduleInput struct {
 	Title     string
 }
 
-// GenerateChannelSchedule generates or regenerates a channel's schedule
+// ChannelScheduleLookahead is how far past "now" a channel's schedule is kept
+// generated. Reading the schedule extends it lazily once less than this remains.
+const ChannelScheduleLookahead = 24 * time.Hour
+
+// channelScheduleHistory is how long items that finished playing are kept
+const channelScheduleHistory = 6 * time.Hour
+
+// GenerateChannelSchedule generates or regenerates a channel's schedule,
+// starting now with a new random seed
 func (db *DB) GenerateChannelSchedule(channelID int64) error {
-	// Seed random number generator
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	programming, err := db.getChannelProgramming(channelID)
+	if err != nil {
+		return err
+	}
 
-	// Get all sources for this channel
-	sources, err := db.GetChannelSources(channelID)
+	seed := time.Now().UnixNano()
+	if len(scheduleChannelCycle(programming, seed, 1)) == 0 {
+		return nil // No sources or nothing playable, keep the current schedule
+	}
+
+	// Clear existing schedule and reset the state future cycles derive from
+	_, err = db.conn.Exec(`DELETE FROM channel_schedule WHERE channel_id = ?`, channelID)
+	if err != nil {
+		return err
+	}
+	_, err = db.conn.Exec(
+		`UPDATE channels SET schedule_seed = ?, schedule_start = ? WHERE id = ?`,
+		seed, time.Now().UTC(), channelID,
+	)
 	if err != nil {
 		return err
 	}
 
-	if len(sources) == 0 {
-		return nil // No sources, nothing to schedule
+	return db.ensureChannelSchedule(channelID)
+}
+
+// channelProgramming is what a channel's schedule is built from
+type channelProgramming struct {
+	sources         []channelSourceItems
+	bumpers         []channelScheduleInput
+	bumperFrequency int // items between bumpers, 0 for none
+}
+
+// getChannelProgramming loads the schedulable items of every channel source,
+// and the bumpers to play between them
+func (db *DB) getChannelProgramming(channelID int64) (channelProgramming, error) {
+	var programming channelProgramming
+	err := db.conn.QueryRow(
+		`SELECT COALESCE(bumper_frequency, 1) FROM channels WHERE id = ?`,
+		channelID,
+	).Scan(&programming.bumperFrequency)
+	if err == sql.ErrNoRows {
+		return programming, ErrNotFound
+	}
+	if err != nil {
+		return programming, err
 	}
 
-	// Build per-source item lists
-	type sourceWithItems struct {
-		source ChannelSource
-		items  []channelScheduleInput
+	sources, err := db.GetChannelSources(channelID)
+	if err != nil {
+		return programming, err
 	}
-	var sourcesWithItems []sourceWithItems
 
 	for _, source := range sources {
 		items := db.getMediaFromSource(source)
 		if len(items) == 0 {
 			continue // Skip empty sources
 		}
+		if source.SourceType == ChannelSourceBumper {
+			programming.bumpers = append(programming.bumpers, items...)
+			continue
+		}
+		programming.sources = append(programming.sources, channelSourceItems{source: source, items: items})
+	}
+	return programming, nil
+}
+
+// scheduleChannelCycle builds one cycle of a channel's schedule. The result
+// depends only on the programming, seed and cycle number, so a schedule can
+// be continued later exactly as it would have been generated up front.
+func scheduleChannelCycle(programming channelProgramming, seed int64, cycle int) []channelScheduleInput {
+	sources := programming.sources
+	if len(sources) == 0 {
+		return nil // Bumpers alone aren't a program
+	}
+
+	rng := rand.New(rand.NewSource(seed + int64(cycle)))
+
+	// Copy so shuffling doesn't disturb the order later cycles start from
+	cycleSources := make([]channelSourceItems, len(sources))
+	for i, sw := range sources {
+		items := append([]channelScheduleInput(nil), sw.items...)
 
 		// Shuffle items only if source.Shuffle is true
-		if source.Shuffle {
+		if sw.source.Shuffle {
 			rng.Shuffle(len(items), func(i, j int) {
 				items[i], items[j] = items[j], items[i]
 			})
 		}
-
-		sourcesWithItems = append(sourcesWithItems, sourceWithItems{source: source, items: items})
+		cycleSources[i] = channelSourceItems{source: sw.source, items: items}
 	}
 
-	if len(sourcesWithItems) == 0 {
-		return nil
+	// Shuffle source order once so ties don't always favour the first source
+	rng.Shuffle(len(cycleSources), func(i, j int) {
+		cycleSources[i], cycleSources[j] = cycleSources[j], cycleSources[i]
+	})
+
+	items := interleaveChannelSources(cycleSources, rng)
+	return insertBumpers(items, programming.bumpers, programming.bumperFrequency, rng)
+}
+
+// insertBumpers plays a random bumper after every frequency items. A bumper
+// never follows an item that is itself one of the bumper clips, so two never
+// play back to back, and cycles end on a program rather than a bumper.
+func insertBumpers(items, bumpers []channelScheduleInput, frequency int, rng *rand.Rand) []channelScheduleInput {
+	if len(bumpers) == 0 || frequency < 1 {
+		return items
 	}
 
-	var finalItems []channelScheduleInput
+	isBumper := make(map[MediaRef]bool, len(bumpers))
+	for _, bumper := range bumpers {
+		isBumper[MediaRef{Type: bumper.MediaType, ID: bumper.MediaID}] = true
+	}
 
-	// Check if all sources have equal weight (fair rotation mode)
-	allEqualWeight := true
-	firstWeight := sourcesWithItems[0].source.Weight
-	for _, sw := range sourcesWithItems {
-		if sw.source.Weight != firstWeight {
-			allEqualWeight = false
-			break
+	withBumpers := make([]channelScheduleInput, 0, len(items)+len(items)/frequency)
+	for i, item := range items {
+		withBumpers = append(withBumpers, item)
+		if (i+1)%frequency != 0 || i == len(items)-1 || isBumper[MediaRef{Type: item.MediaType, ID: item.MediaID}] {
+			continue
+		}
+		next := items[i+1]
+		if isBumper[MediaRef{Type: next.MediaType, ID: next.MediaID}] {
+			continue
 		}
+		withBumpers = append(withBumpers, bumpers[rng.Intn(len(bumpers))])
+	}
+	return withBumpers
+}
+
+// getChannelScheduleState returns the seed and start time of a channel's
+// schedule. ok is false if the schedule was never generated with them.
+func (db *DB) getChannelScheduleState(channelID int64) (seed int64, start time.Time, ok bool, err error) {
+	var scheduleStart sql.NullTime
+	err = db.conn.QueryRow(
+		`SELECT COALESCE(schedule_seed, 0), schedule_start FROM channels WHERE id = ?`,
+		channelID,
+	).Scan(&seed, &scheduleStart)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, false, ErrNotFound
+	}
+	if err != nil {
+		return 0, time.Time{}, false, err
 	}
+	return seed, scheduleStart.Time, scheduleStart.Valid, nil
+}
 
-	if allEqualWeight && len(sourcesWithItems) > 1 {
-		// Fair rotation: cycle through sources in round-robin fashion
-		// Each source gets weight repetitions per cycle
-		weight := firstWeight
+// ensureChannelSchedule appends cycles until the schedule reaches
+// ChannelScheduleLookahead past now and prunes items that ended long ago.
+// Cycles that would already be over are computed but not stored, so an idle
+// channel catches up without filling the table.
+func (db *DB) ensureChannelSchedule(channelID int64) error {
+	seed, start, ok, err := db.getChannelScheduleState(channelID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Never scheduled, or generated before rolling schedules existed and
+		// without a seed: start the schedule now so the channel isn't dark.
+		// GenerateChannelSchedule stores no state (and so doesn't come back
+		// here) if nothing is playable.
+		return db.GenerateChannelSchedule(channelID)
+	}
 
-		// Find the maximum number of items across all sources
-		maxItems := 0
-		for _, sw := range sourcesWithItems {
-			if len(sw.items) > maxItems {
-				maxItems = len(sw.items)
-			}
-		}
+	elapsed := int(time.Since(start).Seconds())
+	target := elapsed + int(ChannelScheduleLookahead.Seconds())
+	keepFrom := elapsed - int(channelScheduleHistory.Seconds())
 
-		// Repeat for weight * maxItems rounds to ensure full coverage
-		totalRounds := weight * maxItems
+	var lastCycle, lastPosition, end int
+	err = db.conn.QueryRow(
+		`SELECT COALESCE(MAX(cycle_number), 0), COALESCE(MAX(scheduled_position), -1),
+			COALESCE(MAX(cumulative_start + duration), 0)
+		FROM channel_schedule WHERE channel_id = ?`,
+		channelID,
+	).Scan(&lastCycle, &lastPosition, &end)
+	if err != nil {
+		return err
+	}
+	if end >= target {
+		return nil
+	}
 
-		// Build source order indices
-		sourceOrder := make([]int, len(sourcesWithItems))
-		for i := range sourceOrder {
-			sourceOrder[i] = i
-		}
+	programming, err := db.getChannelProgramming(channelID)
+	if err != nil {
+		return err
+	}
 
-		// Shuffle source order ONCE at the start for variety
-		rng.Shuffle(len(sourceOrder), func(i, j int) {
-			sourceOrder[i], sourceOrder[j] = sourceOrder[j], sourceOrder[i]
-		})
+	var pending []ChannelScheduleItem
+	cycle, position := lastCycle, lastPosition
+	for end < target {
+		cycle++
+		items := scheduleChannelCycle(programming, seed, cycle)
+		if len(items) == 0 {
+			break // Sources emptied since the schedule was generated
+		}
 
-		// Round-robin through sources with consistent order
-		for round := 0; round < totalRounds; round++ {
-			for _, srcIdx := range sourceOrder {
-				sw := sourcesWithItems[srcIdx]
-				itemIdx := round % len(sw.items)
-				finalItems = append(finalItems, sw.items[itemIdx])
-			}
+		cycleDuration := 0
+		for _, item := range items {
+			cycleDuration += item.Duration
 		}
-	} else {
-		// Weighted shuffle: multiply items by weight and shuffle together
-		for _, sw := range sourcesWithItems {
-			for i := 0; i < sw.source.Weight; i++ {
-				finalItems = append(finalItems, sw.items...)
+		if cycleDuration <= 0 {
+			break // Nothing with a length to play; looping would never end
+		}
+		keep := end+cycleDuration > keepFrom
+
+		for _, item := range items {
+			position++
+			if keep {
+				pending = append(pending, ChannelScheduleItem{
+					MediaID:           item.MediaID,
+					MediaType:         item.MediaType,
+					ScheduledPosition: position,
+					CycleNumber:       cycle,
+					Duration:          item.Duration,
+					CumulativeStart:   end,
+				})
 			}
+			end += item.Duration
 		}
-
-		// Shuffle the combined list
-		rng.Shuffle(len(finalItems), func(i, j int) {
-			finalItems[i], finalItems[j] = finalItems[j], finalItems[i]
-		})
 	}
 
-	if len(finalItems) == 0 {
-		return nil
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	// Clear existing schedule
-	_, err = db.conn.Exec(`DELETE FROM channel_schedule WHERE channel_id = ?`, channelID)
-	if err != nil {
+	// Another request may have extended the schedule while we built ours
+	var currentCycle int
+	if err := tx.QueryRow(
+		`SELECT COALESCE(MAX(cycle_number), 0) FROM channel_schedule WHERE channel_id = ?`,
+		channelID,
+	).Scan(&currentCycle); err != nil {
 		return err
 	}
+	if currentCycle != lastCycle {
+		return nil
+	}
 
-	// Insert new schedule with cumulative timing
-	cumulativeStart := 0
-	for position, item := range finalItems {
-		_, err = db.conn.Exec(
+	for _, item := range pending {
+		_, err = tx.Exec(
 			`INSERT INTO channel_schedule (channel_id, media_id, media_type, scheduled_position, cycle_number, duration, cumulative_start)
-			VALUES (?, ?, ?, ?, 1, ?, ?)`,
-			channelID, item.MediaID, item.MediaType, position, item.Duration, cumulativeStart,
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			channelID, item.MediaID, item.MediaType, item.ScheduledPosition, item.CycleNumber,
+			item.Duration, item.CumulativeStart,
 		)
 		if err != nil {
 			return err
 		}
-		cumulativeStart += item.Duration
 	}
 
-	return nil
+	_, err = tx.Exec(
+		`DELETE FROM channel_schedule WHERE channel_id = ? AND cumulative_start + duration < ?`,
+		channelID, keepFrom,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// channelSourceItems is a channel source with its schedulable items
+type channelSourceItems struct {
+	source ChannelSource
+	items  []channelScheduleInput
+}
+
+// interleaveChannelSources merges sources using smooth weighted round-robin,
+// so a weight 2 source plays twice as often as a weight 1 source and sources
+// alternate instead of playing back to back. The cycle runs until every
+// source has played all of its items at least once; sources that run out
+// earlier start over, reshuffled if the source shuffles.
+func interleaveChannelSources(sources []channelSourceItems, rng *rand.Rand) []channelScheduleInput {
+	weights := make([]int, len(sources))
+	totalWeight := 0
+	for i, sw := range sources {
+		weights[i] = sw.source.Weight
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+		totalWeight += weights[i]
+	}
+
+	// Enough picks for the source that needs the most turns to play everything
+	totalPicks := 0
+	for i, sw := range sources {
+		picks := (len(sw.items)*totalWeight + weights[i] - 1) / weights[i]
+		if picks > totalPicks {
+			totalPicks = picks
+		}
+	}
+
+	current := make([]int, len(sources))
+	cursors := make([]int, len(sources))
+	finalItems := make([]channelScheduleInput, 0, totalPicks)
+
+	for n := 0; n < totalPicks; n++ {
+		best := 0
+		for i := range sources {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= totalWeight
+
+		sw := sources[best]
+		if cursors[best] == len(sw.items) {
+			cursors[best] = 0
+			if sw.source.Shuffle && len(sw.items) > 1 {
+				rng.Shuffle(len(sw.items), func(i, j int) {
+					sw.items[i], sw.items[j] = sw.items[j], sw.items[i]
+				})
+			}
+		}
+		finalItems = append(finalItems, sw.items[cursors[best]])
+		cursors[best]++
+	}
+
+	return finalItems
 }
 
 // getMediaFromSource extracts media items from a channel source
@@ -2240,24 +4064,27 @@ func (db *DB) getMediaFromSource(source ChannelSource) []channelScheduleInput {
 	switch source.SourceType {
 	case ChannelSourceShow:
 		if source.SourceID != nil {
-			// Determine version mode (main, commentary, or both)
+			// Determine version mode (main, commentary, or both) and season filter
 			versionMode := VersionModeMain
+			var seasons []int
 			if source.Options != nil {
 				versionMode = source.Options.GetEffectiveVersionMode()
+				seasons = source.Options.Seasons
 			}
 
 			// Add regular episodes (unless commentary-only mode)
 			if versionMode == VersionModeMain || versionMode == VersionModeBoth {
-				query := `SELECT id, title, duration, season_number FROM episodes WHERE tv_show_id = ? AND duration > 0`
+				query := `SELECT id, title, duration, season_number FROM episodes WHERE tv_show_id = ? AND duration > 0
+					ORDER BY season_number, episode_number, id`
 				rows, err := db.conn.Query(query, *source.SourceID)
 				if err == nil {
 					defer rows.Close()
 
 					// Build season filter set if options specify seasons
 					var seasonFilter map[int]bool
-					if source.Options != nil && len(source.Options.Seasons) > 0 {
+					if len(seasons) > 0 {
 						seasonFilter = make(map[int]bool)
-						for _, s := range source.Options.Seasons {
+						for _, s := range seasons {
 							seasonFilter[s] = true
 						}
 					}
@@ -2283,13 +4110,13 @@ func (db *DB) getMediaFromSource(source ChannelSource) []channelScheduleInput {
 
 			// Add commentary (if commentary or both mode)
 			if versionMode == VersionModeCommentary || versionMode == VersionModeBoth {
-				extras := db.getShowExtrasForChannel(*source.SourceID, []string{string(ExtraCategoryCommentary)}, nil)
+				extras := db.getShowExtrasForChannel(*source.SourceID, []string{string(ExtraCategoryCommentary)}, seasons)
 				items = append(items, extras...)
 			}
 
 			// Add other extras by category if requested
 			if source.Options != nil && len(source.Options.ExtrasCategories) > 0 {
-				extras := db.getShowExtrasForChannel(*source.SourceID, source.Options.ExtrasCategories, nil)
+				extras := db.getShowExtrasForChannel(*source.SourceID, source.Options.ExtrasCategories, seasons)
 				items = append(items, extras...)
 			}
 		}
@@ -2313,43 +4140,19 @@ func (db *DB) getMediaFromSource(source ChannelSource) []channelScheduleInput {
 
 	case ChannelSourcePlaylist:
 		if source.SourceID != nil {
-			rows, err := db.conn.Query(
-				`SELECT pi.media_id, pi.media_type,
-					CASE
-						WHEN pi.media_type = 'movie' THEN (SELECT duration FROM media WHERE id = pi.media_id)
-						WHEN pi.media_type = 'episode' THEN (SELECT duration FROM episodes WHERE id = pi.media_id)
-						WHEN pi.media_type = 'extra' THEN (SELECT duration FROM extras WHERE id = pi.media_id)
-					END as duration,
-					CASE
-						WHEN pi.media_type = 'movie' THEN (SELECT title FROM media WHERE id = pi.media_id)
-						WHEN pi.media_type = 'episode' THEN (SELECT title FROM episodes WHERE id = pi.media_id)
-						WHEN pi.media_type = 'extra' THEN (SELECT title FROM extras WHERE id = pi.media_id)
-					END as title
-				FROM playlist_items pi
-				WHERE pi.playlist_id = ?
-				ORDER BY pi.position`,
-				*source.SourceID,
-			)
-			if err == nil {
-				defer rows.Close()
-				for rows.Next() {
-					var i channelScheduleInput
-					var duration sql.NullInt64
-					var title sql.NullString
-					if rows.Scan(&i.MediaID, &i.MediaType, &duration, &title) == nil {
-						i.Duration = int(duration.Int64)
-						i.Title = title.String
-						if i.Duration > 0 {
-							items = append(items, i)
-						}
-					}
-				}
-			}
+			items = db.getPlaylistItemsForChannel(*source.SourceID)
+		}
+
+	case ChannelSourceBumper:
+		if source.SourceID != nil {
+			items = db.getPlaylistItemsForChannel(*source.SourceID)
+		} else if source.SourceValue != "" {
+			items = db.getFolderItemsForChannel(source.SourceValue)
 		}
 
 	case ChannelSourceExtraCategory:
 		rows, err := db.conn.Query(
-			`SELECT id, title, duration FROM extras WHERE category = ? AND duration > 0`,
+			`SELECT id, title, duration FROM extras WHERE category = ? AND duration > 0 ORDER BY id`,
 			source.SourceValue,
 		)
 		if err == nil {
@@ -2371,6 +4174,94 @@ func (db *DB) getMediaFromSource(source ChannelSource) []channelScheduleInput {
 	return items
 }
 
+// getPlaylistItemsForChannel retrieves a playlist's playable items, in
+// playlist order. Smart playlists are resolved by their rules.
+func (db *DB) getPlaylistItemsForChannel(playlistID int64) []channelScheduleInput {
+	var items []channelScheduleInput
+
+	var smart bool
+	if err := db.conn.QueryRow(`SELECT COALESCE(smart, 0) FROM playlists WHERE id = ?`, playlistID).Scan(&smart); err != nil {
+		return items
+	}
+	if smart {
+		resolved, err := db.GetPlaylistItems(playlistID)
+		if err != nil {
+			return items
+		}
+		for _, item := range resolved {
+			if item.Duration > 0 {
+				items = append(items, channelScheduleInput{MediaID: item.MediaID, MediaType: item.MediaType, Duration: item.Duration, Title: item.Title})
+			}
+		}
+		return items
+	}
+
+	rows, err := db.conn.Query(
+		`SELECT pi.media_id, pi.media_type,
+			CASE
+				WHEN pi.media_type = 'movie' THEN (SELECT duration FROM media WHERE id = pi.media_id)
+				WHEN pi.media_type = 'episode' THEN (SELECT duration FROM episodes WHERE id = pi.media_id)
+				WHEN pi.media_type = 'extra' THEN (SELECT duration FROM extras WHERE id = pi.media_id)
+			END as duration,
+			CASE
+				WHEN pi.media_type = 'movie' THEN (SELECT title FROM media WHERE id = pi.media_id)
+				WHEN pi.media_type = 'episode' THEN (SELECT title FROM episodes WHERE id = pi.media_id)
+				WHEN pi.media_type = 'extra' THEN (SELECT title FROM extras WHERE id = pi.media_id)
+			END as title
+		FROM playlist_items pi
+		WHERE pi.playlist_id = ?
+		ORDER BY pi.position`,
+		playlistID,
+	)
+	if err != nil {
+		return items
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i channelScheduleInput
+		var duration sql.NullInt64
+		var title sql.NullString
+		if rows.Scan(&i.MediaID, &i.MediaType, &duration, &title) == nil {
+			i.Duration = int(duration.Int64)
+			i.Title = title.String
+			if i.Duration > 0 {
+				items = append(items, i)
+			}
+		}
+	}
+	return items
+}
+
+// getFolderItemsForChannel retrieves the movies and extras whose files are
+// in a folder or below it, by title
+func (db *DB) getFolderItemsForChannel(folder string) []channelScheduleInput {
+	var items []channelScheduleInput
+
+	pattern := escapeLike(strings.TrimRight(folder, "/")) + "/%"
+	rows, err := db.conn.Query(
+		`SELECT id, 'movie', title, duration FROM media
+		WHERE type = 'movie' AND duration > 0 AND file_path LIKE ? ESCAPE '\'
+		UNION ALL
+		SELECT id, 'extra', title, duration FROM extras
+		WHERE duration > 0 AND file_path LIKE ? ESCAPE '\'
+		ORDER BY 3, 1`,
+		pattern, pattern,
+	)
+	if err != nil {
+		return items
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i channelScheduleInput
+		if rows.Scan(&i.MediaID, &i.MediaType, &i.Title, &i.Duration) == nil {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
 // getShowExtrasForChannel retrieves extras for a TV show filtered by categories
 func (db *DB) getShowExtrasForChannel(showID int64, categories []string, seasons []int) []channelScheduleInput {
 	var items []channelScheduleInput
@@ -2389,7 +4280,7 @@ func (db *DB) getShowExtrasForChannel(showID int64, categories []string, seasons
 		query += "?"
 		args = append(args, cat)
 	}
-	query += ")"
+	query += ") ORDER BY id"
 
 	rows, err := db.conn.Query(query, args...)
 	if err != nil {
@@ -2509,22 +4400,20 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 		return nil, err
 	}
 
-	// Get total cycle duration
-	var totalDuration sql.NullInt64
-	err = db.conn.QueryRow(
-		`SELECT COALESCE(SUM(duration), 0) FROM channel_schedule WHERE channel_id = ? AND cycle_number = 1`,
-		channelID,
-	).Scan(&totalDuration)
-	if err != nil || totalDuration.Int64 == 0 {
-		return &ChannelNowPlaying{Channel: *channel}, nil
+	if err := db.ensureChannelSchedule(channelID); err != nil {
+		return nil, err
 	}
 
-	cycleDuration := int(totalDuration.Int64)
+	_, start, ok, err := db.getChannelScheduleState(channelID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &ChannelNowPlaying{Channel: *channel}, nil
+	}
 
-	// Calculate current position in cycle based on time
-	// Use channel creation time as epoch for consistent scheduling
-	elapsed := int(time.Since(channel.CreatedAt).Seconds())
-	positionInCycle := elapsed % cycleDuration
+	// Current position in the schedule based on time
+	elapsed := int(time.Since(start).Seconds())
 
 	// Find current item
 	var current ChannelScheduleItem
@@ -2532,11 +4421,12 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 		`SELECT cs.id, cs.channel_id, cs.media_id, cs.media_type, cs.scheduled_position,
 			cs.cycle_number, cs.duration, cs.cumulative_start, cs.played
 		FROM channel_schedule cs
-		WHERE cs.channel_id = ? AND cs.cycle_number = 1
+		WHERE cs.channel_id = ?
 			AND cs.cumulative_start <= ?
 			AND cs.cumulative_start + cs.duration > ?
+		ORDER BY cs.scheduled_position
 		LIMIT 1`,
-		channelID, positionInCycle, positionInCycle,
+		channelID, elapsed, elapsed,
 	).Scan(
 		&current.ID, &current.ChannelID, &current.MediaID, &current.MediaType,
 		&current.ScheduledPosition, &current.CycleNumber, &current.Duration,
@@ -2548,9 +4438,17 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 
 	// Populate title and poster for current item
 	db.populateScheduleItemDetails(&current)
+	current.StartsAt = start.Add(time.Duration(current.CumulativeStart) * time.Second)
 
 	// Calculate elapsed time within current item
-	elapsedInItem := positionInCycle - current.CumulativeStart
+	elapsedInItem := elapsed - current.CumulativeStart
+
+	// When the current cycle started
+	var cycleStart int
+	db.conn.QueryRow(
+		`SELECT MIN(cumulative_start) FROM channel_schedule WHERE channel_id = ? AND cycle_number = ?`,
+		channelID, current.CycleNumber,
+	).Scan(&cycleStart)
 
 	// Get up next items (next 3)
 	var upNext []ChannelScheduleItem
@@ -2558,7 +4456,7 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 		`SELECT cs.id, cs.channel_id, cs.media_id, cs.media_type, cs.scheduled_position,
 			cs.cycle_number, cs.duration, cs.cumulative_start, cs.played
 		FROM channel_schedule cs
-		WHERE cs.channel_id = ? AND cs.cycle_number = 1
+		WHERE cs.channel_id = ?
 			AND cs.scheduled_position > ?
 		ORDER BY cs.scheduled_position
 		LIMIT 3`,
@@ -2582,6 +4480,7 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 	// Second pass: populate details (safe now that rows is closed)
 	for i := range upNext {
 		db.populateScheduleItemDetails(&upNext[i])
+		upNext[i].StartsAt = start.Add(time.Duration(upNext[i].CumulativeStart) * time.Second)
 	}
 
 	return &ChannelNowPlaying{
@@ -2589,26 +4488,152 @@ func (db *DB) GetChannelNowPlaying(channelID int64) (*ChannelNowPlaying, error)
 		NowPlaying: &current,
 		Elapsed:    elapsedInItem,
 		UpNext:     upNext,
-		CycleStart: channel.CreatedAt,
+		CycleStart: start.Add(time.Duration(cycleStart) * time.Second),
 	}, nil
 }
 
-// populateScheduleItemDetails fills in title and poster for a schedule item
+// ensureUserChannelSchedules extends the schedules of a user's channels that
+// run out within ChannelScheduleLookahead. One query finds them, so channels
+// whose schedules are long enough cost nothing.
+func (db *DB) ensureUserChannelSchedules(userID int64) error {
+	rows, err := db.conn.Query(
+		`SELECT c.id
+		FROM channels c
+		LEFT JOIN (
+			SELECT channel_id, MAX(cumulative_start + duration) AS schedule_end
+			FROM channel_schedule
+			GROUP BY channel_id
+		) s ON s.channel_id = c.id
+		WHERE c.user_id = ? AND (c.schedule_start IS NULL
+			OR COALESCE(s.schedule_end, 0) < (julianday('now') - julianday(c.schedule_start)) * 86400 + ?)`,
+		userID, int(ChannelScheduleLookahead.Seconds()),
+	)
+	if err != nil {
+		return err
+	}
+	var channelIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		channelIDs = append(channelIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range channelIDs {
+		if err := db.ensureChannelSchedule(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChannelGuide returns the current and next item for every channel a user
+// owns. Schedules running short are extended first, then the guide itself
+// is read in a single query across all schedules.
+func (db *DB) GetChannelGuide(userID int64) ([]ChannelGuideEntry, error) {
+	if err := db.ensureUserChannelSchedules(userID); err != nil {
+		return nil, err
+	}
+
+	channels, err := db.GetUserChannels(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]ChannelGuideEntry, len(channels))
+	byChannel := make(map[int64]*ChannelGuideEntry, len(channels))
+	for i, ch := range channels {
+		entries[i].Channel = ch
+		byChannel[ch.ID] = &entries[i]
+	}
+
+	// First two unfinished items per channel: the current one and the next
+	rows, err := db.conn.Query(
+		`WITH pos AS (
+			SELECT id AS channel_id,
+				CAST((julianday('now') - julianday(schedule_start)) * 86400 AS INTEGER) AS elapsed
+			FROM channels
+			WHERE user_id = ? AND schedule_start IS NOT NULL
+		),
+		upcoming AS (
+			SELECT cs.*, p.elapsed,
+				ROW_NUMBER() OVER (PARTITION BY cs.channel_id ORDER BY cs.scheduled_position) AS rn
+			FROM channel_schedule cs
+			JOIN pos p ON p.channel_id = cs.channel_id
+			WHERE cs.cumulative_start + cs.duration > p.elapsed
+		)
+		SELECT u.id, u.channel_id, u.media_id, u.media_type, u.scheduled_position,
+			u.cycle_number, u.duration, u.cumulative_start, u.played, u.elapsed,
+			COALESCE(m.title, e.title, x.title, ''), COALESCE(t.title, ''),
+			COALESCE(m.poster_path, t.poster_path, ''), COALESCE(m.backdrop_path, t.backdrop_path, '')
+		FROM upcoming u
+		LEFT JOIN media m ON u.media_type = 'movie' AND m.id = u.media_id
+		LEFT JOIN episodes e ON u.media_type = 'episode' AND e.id = u.media_id
+		LEFT JOIN tv_shows t ON t.id = e.tv_show_id
+		LEFT JOIN extras x ON u.media_type = 'extra' AND x.id = u.media_id
+		WHERE u.rn <= 2
+		ORDER BY u.channel_id, u.scheduled_position`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		item := &ChannelScheduleItem{}
+		var elapsed int
+		if err := rows.Scan(
+			&item.ID, &item.ChannelID, &item.MediaID, &item.MediaType,
+			&item.ScheduledPosition, &item.CycleNumber, &item.Duration,
+			&item.CumulativeStart, &item.Played, &elapsed,
+			&item.Title, &item.ShowTitle, &item.PosterPath, &item.BackdropPath,
+		); err != nil {
+			return nil, err
+		}
+		item.StartsAt = now.Add(time.Duration(item.CumulativeStart-elapsed) * time.Second)
+
+		entry := byChannel[item.ChannelID]
+		if entry == nil {
+			continue
+		}
+		if entry.NowPlaying == nil && item.CumulativeStart <= elapsed {
+			entry.NowPlaying = item
+			entry.Elapsed = elapsed - item.CumulativeStart
+		} else if entry.Next == nil {
+			entry.Next = item
+		}
+	}
+
+	return entries, rows.Err()
+}
+
+// populateScheduleItemDetails fills in title, overview and poster for a
+// schedule item
 func (db *DB) populateScheduleItemDetails(item *ChannelScheduleItem) {
 	switch item.MediaType {
 	case MediaTypeMovie:
 		db.conn.QueryRow(
-			`SELECT title, poster_path, backdrop_path FROM media WHERE id = ?`,
+			`SELECT title, COALESCE(overview, ''), poster_path, backdrop_path FROM media WHERE id = ?`,
 			item.MediaID,
-		).Scan(&item.Title, &item.PosterPath, &item.BackdropPath)
+		).Scan(&item.Title, &item.Overview, &item.PosterPath, &item.BackdropPath)
 	case MediaTypeEpisode:
 		db.conn.QueryRow(
-			`SELECT e.title, t.title, t.poster_path, t.backdrop_path
+			`SELECT e.title, t.title, COALESCE(e.overview, ''), e.season_number, e.episode_number,
+				t.poster_path, t.backdrop_path
 			FROM episodes e
 			JOIN tv_shows t ON e.tv_show_id = t.id
 			WHERE e.id = ?`,
 			item.MediaID,
-		).Scan(&item.Title, &item.ShowTitle, &item.PosterPath, &item.BackdropPath)
+		).Scan(&item.Title, &item.ShowTitle, &item.Overview, &item.SeasonNumber, &item.EpisodeNumber,
+			&item.PosterPath, &item.BackdropPath)
 	case MediaTypeExtra:
 		db.conn.QueryRow(
 			`SELECT title FROM extras WHERE id = ?`,
@@ -2617,13 +4642,24 @@ func (db *DB) populateScheduleItemDetails(item *ChannelScheduleItem) {
 	}
 }
 
-// GetChannelSchedule returns the full schedule for a channel
+// GetChannelSchedule returns a channel's schedule from the item playing now
+// onwards, extending it first if it's running short
 func (db *DB) GetChannelSchedule(channelID int64, limit, offset int) ([]ChannelScheduleItem, int, error) {
+	if err := db.ensureChannelSchedule(channelID); err != nil {
+		return nil, 0, err
+	}
+
+	_, start, ok, err := db.getChannelScheduleState(channelID)
+	if err != nil || !ok {
+		return nil, 0, err
+	}
+	elapsed := int(time.Since(start).Seconds())
+
 	// Get total count
 	var total int
 	db.conn.QueryRow(
-		`SELECT COUNT(*) FROM channel_schedule WHERE channel_id = ? AND cycle_number = 1`,
-		channelID,
+		`SELECT COUNT(*) FROM channel_schedule WHERE channel_id = ? AND cumulative_start + duration > ?`,
+		channelID, elapsed,
 	).Scan(&total)
 
 	// Get items
@@ -2631,10 +4667,10 @@ func (db *DB) GetChannelSchedule(channelID int64, limit, offset int) ([]ChannelS
 		`SELECT id, channel_id, media_id, media_type, scheduled_position,
 			cycle_number, duration, cumulative_start, played
 		FROM channel_schedule
-		WHERE channel_id = ? AND cycle_number = 1
+		WHERE channel_id = ? AND cumulative_start + duration > ?
 		ORDER BY scheduled_position
 		LIMIT ? OFFSET ?`,
-		channelID, limit, offset,
+		channelID, elapsed, limit, offset,
 	)
 	if err != nil {
 		return nil, 0, err
@@ -2658,6 +4694,7 @@ func (db *DB) GetChannelSchedule(channelID int64, limit, offset int) ([]ChannelS
 	// Second pass: populate details
 	for i := range items {
 		db.populateScheduleItemDetails(&items[i])
+		items[i].StartsAt = start.Add(time.Duration(items[i].CumulativeStart) * time.Second)
 	}
 
 	return items, total, rowsErr
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func movieTitles(media []*Media) []string {
+	titles := make([]string, len(media))
+	for i, m := range media {
+		titles[i] = m.Title
+	}
+	return titles
+}
+
+func TestGetMediaByTypeSortsUnknownLast(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	newTestMovie(t, db, source, "Unknown", 0, 0)
+	newTestMovie(t, db, source, "Old", 1980, 6.5)
+	newTestMovie(t, db, source, "New", 2020, 8.1)
+
+	tests := []struct {
+		opts MediaListOptions
+		want []string
+	}{
+		{MediaListOptions{Sort: MediaSortYear}, []string{"Old", "New", "Unknown"}},
+		{MediaListOptions{Sort: MediaSortYear, Descending: true}, []string{"New", "Old", "Unknown"}},
+		{MediaListOptions{Sort: MediaSortRating}, []string{"Old", "New", "Unknown"}},
+		{MediaListOptions{Sort: MediaSortRating, Descending: true}, []string{"New", "Old", "Unknown"}},
+		{MediaListOptions{Sort: MediaSortTitle}, []string{"New", "Old", "Unknown"}},
+	}
+	for _, tt := range tests {
+		media, _, err := db.GetMediaByType(MediaTypeMovie, tt.opts, 10, 0)
+		if err != nil {
+			t.Fatalf("GetMediaByType(%+v): %v", tt.opts, err)
+		}
+		if got := movieTitles(media); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("GetMediaByType(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestGetMediaByTypeIgnoresUnknownSort(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	newTestMovie(t, db, source, "B", 2000, 5)
+	newTestMovie(t, db, source, "A", 2001, 6)
+
+	for _, sort := range []string{
+		"year; DROP TABLE media; --",
+		"(SELECT password_hash FROM users)",
+		"title DESC, id",
+	} {
+		media, total, err := db.GetMediaByType(MediaTypeMovie, MediaListOptions{Sort: sort}, 10, 0)
+		if err != nil {
+			t.Fatalf("GetMediaByType(sort %q): %v", sort, err)
+		}
+		if got := movieTitles(media); total != 2 || fmt.Sprint(got) != "[A B]" {
+			t.Errorf("GetMediaByType(sort %q) = %v of %d, want [A B] of 2, by title", sort, got, total)
+		}
+	}
+	if ValidMediaSort("year; DROP TABLE media; --") {
+		t.Error("ValidMediaSort accepted an injected sort")
+	}
+}
+
+func TestGetMediaByTypeTotalStableAcrossPages(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	for _, title := range []string{"A", "B", "C", "D", "E"} {
+		newTestMovie(t, db, source, title, 2000, 7)
+	}
+	var titles []string
+	for offset := 0; offset <= 6; offset += 2 {
+		media, total, err := db.GetMediaByType(MediaTypeMovie, MediaListOptions{}, 2, offset)
+		if err != nil {
+			t.Fatalf("GetMediaByType(offset %d): %v", offset, err)
+		}
+		if total != 5 {
+			t.Errorf("GetMediaByType(offset %d) total = %d, want 5", offset, total)
+		}
+		titles = append(titles, movieTitles(media)...)
+	}
+	if got := fmt.Sprint(titles); got != "[A B C D E]" {
+		t.Errorf("pages hold %s, want [A B C D E]", got)
+	}
+}
+
+func TestGenerateChannelScheduleSkipsUnselectedSeasons(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	user, err := db.CreateUser("alice", "alice@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	show := newTestShow(t, db, "Firefly")
+	excluded := make(map[int64]bool)
+	for number := 1; number <= 3; number++ {
+		season := newTestSeason(t, db, show, number)
+		for episode := 1; episode <= 3; episode++ {
+			created := newTestEpisode(t, db, source, season, episode)
+			if number == 3 {
+				excluded[created.ID] = true
+			}
+		}
+	}
+
+	channel, err := db.CreateChannel(user.ID, "Firefly", "", "")
+	if err != nil {
+		t.Fatalf("CreateChannel: %v", err)
+	}
+	options := &ChannelSourceOptions{Seasons: []int{1, 2}}
+	if _, err := db.AddChannelSource(channel.ID, ChannelSourceShow, &show.ID, "", 1, true, options); err != nil {
+		t.Fatalf("AddChannelSource: %v", err)
+	}
+	if err := db.GenerateChannelSchedule(channel.ID); err != nil {
+		t.Fatalf("GenerateChannelSchedule: %v", err)
+	}
+
+	schedule, _, err := db.GetChannelSchedule(channel.ID, 1000, 0)
+	if err != nil {
+		t.Fatalf("GetChannelSchedule: %v", err)
+	}
+	if len(schedule) == 0 {
+		t.Fatal("schedule is empty")
+	}
+	for _, item := range schedule {
+		if item.MediaType != MediaTypeEpisode {
+			t.Errorf("scheduled %s %d, want only episodes", item.MediaType, item.MediaID)
+		} else if excluded[item.MediaID] {
+			t.Errorf("scheduled episode %d of season 3, which the channel leaves out", item.MediaID)
+		}
+	}
+}
+
+// playlistPositions returns the titles of a playlist's items by position,
+// failing unless the positions run 1..N
+func playlistPositions(t *testing.T, db *DB, playlistID int64) []string {
+	t.Helper()
+	rows, err := db.conn.Query(
+		`SELECT pi.position, m.title FROM playlist_items pi JOIN media m ON m.id = pi.media_id
+		 WHERE pi.playlist_id = ? ORDER BY pi.position, pi.id`,
+		playlistID,
+	)
+	if err != nil {
+		t.Fatalf("query playlist items: %v", err)
+	}
+	defer rows.Close()
+
+	var titles []string
+	for rows.Next() {
+		var position int
+		var title string
+		if err := rows.Scan(&position, &title); err != nil {
+			t.Fatalf("scan playlist item: %v", err)
+		}
+		titles = append(titles, title)
+		if position != len(titles) {
+			t.Errorf("%s is at position %d, want %d", title, position, len(titles))
+		}
+	}
+	return titles
+}
+
+func TestPlaylistPositionsStayContiguous(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	user, err := db.CreateUser("alice", "alice@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	playlist, err := db.CreatePlaylist(user.ID, "Mix", "")
+	if err != nil {
+		t.Fatalf("CreatePlaylist: %v", err)
+	}
+
+	movies := make(map[string]*Media)
+	for _, title := range []string{"A", "B", "C", "D", "E"} {
+		movies[title] = newTestMovie(t, db, source, title, 2000, 7)
+	}
+	add := func(title string) {
+		t.Helper()
+		if _, err := db.AddToPlaylist(playlist.ID, movies[title].ID, MediaTypeMovie); err != nil {
+			t.Fatalf("AddToPlaylist(%s): %v", title, err)
+		}
+	}
+	remove := func(title string) {
+		t.Helper()
+		if err := db.RemoveFromPlaylist(playlist.ID, movies[title].ID, MediaTypeMovie); err != nil {
+			t.Fatalf("RemoveFromPlaylist(%s): %v", title, err)
+		}
+	}
+
+	add("A")
+	add("B")
+	add("C")
+	remove("B")
+	add("D")
+	remove("A")
+	add("E")
+	add("B")
+	if got, want := fmt.Sprint(playlistPositions(t, db, playlist.ID)), "[C D E B]"; got != want {
+		t.Fatalf("after adds and removes, items = %s, want %s", got, want)
+	}
+
+	// Break the numbering the way an interrupted edit could: a gap and a
+	// duplicate position
+	if _, err := db.conn.Exec(
+		`UPDATE playlist_items SET position = CASE media_id WHEN ? THEN 3 WHEN ? THEN 3 WHEN ? THEN 7 ELSE 9 END
+		 WHERE playlist_id = ?`,
+		movies["C"].ID, movies["D"].ID, movies["E"].ID, playlist.ID,
+	); err != nil {
+		t.Fatalf("break positions: %v", err)
+	}
+	if err := db.NormalizePlaylistPositions(playlist.ID); err != nil {
+		t.Fatalf("NormalizePlaylistPositions: %v", err)
+	}
+	if got, want := fmt.Sprint(playlistPositions(t, db, playlist.ID)), "[C D E B]"; got != want {
+		t.Errorf("after NormalizePlaylistPositions, items = %s, want %s", got, want)
+	}
+}
+
+func TestDeleteMediaByFilePathRemovesEmptySeasonsAndShows(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+	user, err := db.CreateUser("alice", "alice@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	playlist, err := db.CreatePlaylist(user.ID, "Mix", "")
+	if err != nil {
+		t.Fatalf("CreatePlaylist: %v", err)
+	}
+
+	show := newTestShow(t, db, "Firefly")
+	season1 := newTestSeason(t, db, show, 1)
+	season2 := newTestSeason(t, db, show, 2)
+	s1e1 := newTestEpisode(t, db, source, season1, 1)
+	s1e2 := newTestEpisode(t, db, source, season1, 2)
+	s2e1 := newTestEpisode(t, db, source, season2, 1)
+
+	if err := db.UpsertWatchProgress(user.ID, s2e1.ID, MediaTypeEpisode, 600, 1800, false); err != nil {
+		t.Fatalf("UpsertWatchProgress: %v", err)
+	}
+	if _, err := db.AddToPlaylist(playlist.ID, s2e1.ID, MediaTypeEpisode); err != nil {
+		t.Fatalf("AddToPlaylist: %v", err)
+	}
+
+	deleteFile := func(episode *Episode) {
+		t.Helper()
+		removed, err := db.DeleteMediaByFilePath(episode.FilePath)
+		if err != nil {
+			t.Fatalf("DeleteMediaByFilePath(%s): %v", episode.FilePath, err)
+		}
+		if removed != 1 {
+			t.Errorf("DeleteMediaByFilePath(%s) removed %d items, want 1", episode.FilePath, removed)
+		}
+		if _, err := db.GetEpisodeByID(episode.ID); err != ErrNotFound {
+			t.Errorf("GetEpisodeByID(%d) after delete: err = %v, want ErrNotFound", episode.ID, err)
+		}
+	}
+	exists := func(what string, err error, want bool) {
+		t.Helper()
+		if want && err != nil {
+			t.Errorf("%s: %v, want it kept", what, err)
+		} else if !want && err != ErrNotFound {
+			t.Errorf("%s: err = %v, want ErrNotFound", what, err)
+		}
+	}
+
+	// The season's last episode takes the season, its progress and its
+	// playlist entry with it
+	deleteFile(s2e1)
+	_, err = db.GetSeasonByID(season2.ID)
+	exists("season 2", err, false)
+	_, err = db.GetWatchProgress(user.ID, s2e1.ID, MediaTypeEpisode)
+	exists("progress", err, false)
+	items, err := db.GetPlaylistItems(playlist.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylistItems: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("playlist has %d items, want 0", len(items))
+	}
+	_, err = db.GetTVShowByID(show.ID)
+	exists("show", err, true)
+
+	// A season keeps going while it has episodes
+	deleteFile(s1e1)
+	_, err = db.GetSeasonByID(season1.ID)
+	exists("season 1", err, true)
+
+	// The show's last episode takes the show
+	deleteFile(s1e2)
+	_, err = db.GetSeasonByID(season1.ID)
+	exists("season 1", err, false)
+	_, err = db.GetTVShowByID(show.ID)
+	exists("show", err, false)
+}
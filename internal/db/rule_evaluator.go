@@ -6,9 +6,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// evaluateSmartSection evaluates rules and returns matching media
+// evaluateSmartSection returns the movies and shows matching a section's
+// rules, newest first. Each table is filtered by the rules on the fields it
+// has, and type rules compare against "tvshow" for shows, so they still
+// route a section to movies or shows only.
 func (db *DB) evaluateSmartSection(section *Section, limit, offset int) ([]interface{}, int, error) {
 	// Get rules for this section
 	rules, err := db.GetSectionRules(section.ID)
@@ -21,262 +25,477 @@ func (db *DB) evaluateSmartSection(section *Section, limit, offset int) ([]inter
 		return []interface{}{}, 0, nil
 	}
 
-	// Check if this is a TV show section (type = tvshow rule)
-	isTVShowSection := false
-	for _, rule := range rules {
-		if rule.Field == "type" && rule.Operator == OperatorEquals {
-			var value string
-			json.Unmarshal([]byte(rule.Value), &value)
-			if value == "tvshow" {
-				isTVShowSection = true
-				break
-			}
+	// SQLite has no regex support, so groups with regex rules are matched in
+	// Go against the rows the other groups let through
+	var sqlGroups, regexGroups []ruleGroup
+	for _, group := range groupRules(rules) {
+		if group.hasRegex() {
+			regexGroups = append(regexGroups, group)
+		} else {
+			sqlGroups = append(sqlGroups, group)
 		}
 	}
-
-	// If it's a TV show section, query the tv_shows table
-	if isTVShowSection {
-		return db.evaluateTVShowSection(rules, limit, offset)
+	if len(regexGroups) > 0 {
+		return db.evaluateRegexSection(section.ID, sqlGroups, regexGroups, limit, offset)
 	}
 
-	// Build query based on rules for regular media
-	query, params := buildQueryFromRules(rules, limit, offset)
-
-	// Execute query to get total count
-	countQuery := strings.Replace(query, "SELECT *", "SELECT COUNT(*)", 1)
-	countQuery = strings.Split(countQuery, "LIMIT")[0] // Remove LIMIT for count
-
+	matches, params := smartSectionMatches(section.ID, sqlGroups, nil)
 	var total int
-	err = db.conn.QueryRow(countQuery, params[:len(params)-2]...).Scan(&total)
-	if err != nil {
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM (`+matches+`)`, params...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
-	// Execute main query
-	rows, err := db.conn.Query(query, params...)
+	rows, err := db.conn.Query(
+		`SELECT kind, id FROM (`+matches+`) ORDER BY added DESC, kind, id DESC LIMIT ? OFFSET ?`,
+		append(params, limit, offset)...,
+	)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer rows.Close()
 
-	// Scan results
-	var items []interface{}
+	// Collect the refs before fetching items, as getManualSectionMedia does
+	var refs []MediaRef
 	for rows.Next() {
-		// This is simplified - in reality you'd need to determine the type
-		// and scan into the appropriate struct
-		var m Media
-		err := rows.Scan(
-			&m.ID, &m.Title, &m.OriginalTitle, &m.Type, &m.Year,
-			&m.Overview, &m.PosterPath, &m.BackdropPath, &m.Rating, &m.Runtime,
-			&m.Genres, &m.TMDbID, &m.IMDbID, &m.SeasonCount, &m.EpisodeCount,
-			&m.SourceID, &m.FilePath, &m.FileSize, &m.Duration, &m.VideoCodec,
-			&m.AudioCodec, &m.Resolution, &m.AudioTracks, &m.SubtitleTracks,
-			&m.CreatedAt, &m.UpdatedAt,
-		)
-		if err != nil {
-			continue
+		var ref MediaRef
+		if err := rows.Scan(&ref.Type, &ref.ID); err != nil {
+			rows.Close()
+			return nil, 0, err
 		}
-		items = append(items, &m)
+		refs = append(refs, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return items, total, rows.Err()
+	return db.sectionItems(refs), total, nil
 }
 
-// evaluateTVShowSection queries the tv_shows table for smart TV show sections
-func (db *DB) evaluateTVShowSection(rules []SectionRule, limit, offset int) ([]interface{}, int, error) {
-	// Build WHERE clause for rules
-	whereClause := "WHERE 1=1"
-	params := []interface{}{}
-
-	// Apply non-type rules (type rule is already used to select this path)
-	for _, rule := range rules {
-		if rule.Field == "type" {
-			continue // Skip type rule, we already know it's tvshow
-		}
-		condition, ruleParams := buildTVShowCondition(rule)
-		if condition != "" {
-			whereClause += " AND " + condition
-			params = append(params, ruleParams...)
+// maxRegexCandidates caps the rows matched in Go for sections with regex
+// rules. Every candidate the other rules let through is fetched and matched,
+// so only the newest maxRegexCandidates of a broad section are considered.
+const maxRegexCandidates = 5000
+
+// evaluateRegexSection is evaluateSmartSection for sections with regex
+// rules. The candidates come with the values of the fields the regex groups
+// test, so items are only fetched for the requested page.
+func (db *DB) evaluateRegexSection(sectionID int64, sqlGroups, regexGroups []ruleGroup, limit, offset int) ([]interface{}, int, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, group := range regexGroups {
+		for _, rule := range group.rules {
+			if !seen[rule.Field] {
+				seen[rule.Field] = true
+				fields = append(fields, rule.Field)
+			}
 		}
 	}
 
-	// Get total count
-	countQuery := "SELECT COUNT(*) FROM tv_shows " + whereClause
-
-	var total int
-	err := db.conn.QueryRow(countQuery, params...).Scan(&total)
+	matches, params := smartSectionMatches(sectionID, sqlGroups, fields)
+	rows, err := db.conn.Query(
+		`SELECT * FROM (`+matches+`) ORDER BY added DESC, kind, id DESC LIMIT ?`,
+		append(params, maxRegexCandidates)...,
+	)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Build main query with pagination
-	query := "SELECT id, title, original_title, year, overview, poster_path, backdrop_path, rating, genres, tmdb_id, imdb_id, status, created_at, updated_at FROM tv_shows " + whereClause + " ORDER BY title ASC LIMIT ? OFFSET ?"
-	params = append(params, limit, offset)
+	var refs []MediaRef
+	for rows.Next() {
+		var ref MediaRef
+		var added interface{}
+		values := make([]string, len(fields))
+		dest := []interface{}{&ref.Type, &ref.ID, &added}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
 
-	// Execute main query
-	rows, err := db.conn.Query(query, params...)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
+		value := func(rule SectionRule) (string, bool) {
+			if !ruleApplies(rule, ref.Type) {
+				return "", false
+			}
+			for i, field := range fields {
+				if field == rule.Field {
+					return values[i], true
+				}
+			}
+			return "", false
+		}
 
-	// Scan results
-	var items []interface{}
-	for rows.Next() {
-		var show TVShow
-		err := rows.Scan(
-			&show.ID, &show.Title, &show.OriginalTitle, &show.Year,
-			&show.Overview, &show.PosterPath, &show.BackdropPath,
-			&show.Rating, &show.Genres, &show.TMDbID, &show.IMDbID,
-			&show.Status, &show.CreatedAt, &show.UpdatedAt,
-		)
-		if err != nil {
-			continue
+		matched := true
+		for _, group := range regexGroups {
+			if !group.evaluate(value) {
+				matched = false
+				break
+			}
 		}
-		items = append(items, &show)
+		if matched {
+			refs = append(refs, ref)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return items, total, rows.Err()
+	total := len(refs)
+	if offset >= total {
+		return []interface{}{}, total, nil
+	}
+	return db.sectionItems(refs[offset:min(offset+limit, total)]), total, nil
 }
 
-// buildTVShowCondition builds a SQL condition for TV show rules
-func buildTVShowCondition(rule SectionRule) (string, []interface{}) {
-	var condition string
-	var params []interface{}
-
-	switch rule.Operator {
-	case OperatorEquals:
-		var value string
-		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s = ?", rule.Field)
-		params = append(params, value)
+// smartSectionMatches returns the query for a smart section's rule matches,
+// movies and shows as rows of kind, id and added, followed by the values of
+// fields as text
+func smartSectionMatches(sectionID int64, groups []ruleGroup, fields []string) (string, []interface{}) {
+	movieWhere, params := ruleWhere(sectionID, groups, buildCondition, "media.id", "media.type")
+	showWhere, showParams := ruleWhere(sectionID, groups, buildTVShowCondition, "tv_shows.id", "'tvshow'")
+	params = append(params, showParams...)
+
+	movieColumns, showColumns := "", ""
+	for _, field := range fields {
+		movieColumns += ", " + ruleValueColumn(field, mediaRuleColumns)
+		showColumns += ", " + ruleValueColumn(field, tvShowRuleColumns)
+	}
 
-	case OperatorContains:
-		var value string
-		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s LIKE ?", rule.Field)
-		params = append(params, "%"+value+"%")
+	return `SELECT type AS kind, id, COALESCE(date_added, created_at) AS added` + movieColumns + `
+		FROM media WHERE type = 'movie' AND ` + movieWhere + `
+		UNION ALL
+		SELECT 'tvshow', id, created_at` + showColumns + ` FROM tv_shows WHERE ` + showWhere, params
+}
 
-	case OperatorGreaterThan:
-		var value float64
-		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s > ?", rule.Field)
-		params = append(params, value)
+// ruleValueColumn selects a rule field as text, as getMediaField formats it
+func ruleValueColumn(field string, columns map[string]string) string {
+	column, ok := columns[field]
+	switch {
+	case !ok:
+		return "''"
+	case dateRuleFields[field]:
+		return fmt.Sprintf("COALESCE(datetime(%s), '')", column)
+	default:
+		return fmt.Sprintf("COALESCE(CAST(%s AS TEXT), '')", column)
+	}
+}
 
-	case OperatorLessThan:
-		var value float64
-		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s < ?", rule.Field)
-		params = append(params, value)
+// ruleWhere builds the WHERE condition of a table's rule matches, leaving
+// out items manually added to the section
+func ruleWhere(sectionID int64, groups []ruleGroup, build func(SectionRule) (string, []interface{}), idColumn, mediaType string) (string, []interface{}) {
+	where := notManualInSection(idColumn, mediaType)
+	params := []interface{}{sectionID}
+	for _, group := range groups {
+		condition, groupParams := group.condition(build)
+		if condition != "" {
+			where += " AND " + condition
+			params = append(params, groupParams...)
+		}
+	}
+	return where, params
+}
 
-	case OperatorInRange:
-		var values []int
-		json.Unmarshal([]byte(rule.Value), &values)
-		if len(values) == 2 {
-			condition = fmt.Sprintf("%s BETWEEN ? AND ?", rule.Field)
-			params = append(params, values[0], values[1])
+// sectionItems fetches the items behind refs, skipping any since deleted
+func (db *DB) sectionItems(refs []MediaRef) []interface{} {
+	items := make([]interface{}, 0, len(refs))
+	for _, ref := range refs {
+		if item, ok := db.sectionItem(ref); ok {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	return condition, params
+// tvShowRuleColumns map the rule fields TV shows have to SQL expressions.
+// A show's play count sums its episodes' plays across all users.
+var tvShowRuleColumns = map[string]string{
+	"type":   "'tvshow'",
+	"title":  "title",
+	"year":   "year",
+	"genre":  "genres",
+	"genres": "genres",
+	"rating": "rating",
+	// Technical fields are the most common among the show's episodes, and
+	// runtime their average length in minutes
+	"resolution":  episodeMode("resolution"),
+	"video_codec": episodeMode("video_codec"),
+	"audio_codec": episodeMode("audio_codec"),
+	"runtime":     "(SELECT CAST(AVG(duration) / 60 AS INTEGER) FROM episodes WHERE tv_show_id = tv_shows.id)",
+	// A show counts as added when its newest episode was
+	"added": `COALESCE((SELECT MAX(COALESCE(e.date_added, e.created_at)) FROM episodes e
+		WHERE e.tv_show_id = tv_shows.id), tv_shows.created_at)`,
+	"play_count": `(SELECT COALESCE(SUM(wp.play_count), 0) FROM watch_progress wp
+		JOIN episodes e ON wp.media_type = 'episode' AND wp.media_id = e.id
+		WHERE e.tv_show_id = tv_shows.id)`,
 }
 
-// buildQueryFromRules builds a SQL query from section rules
-func buildQueryFromRules(rules []SectionRule, limit, offset int) (string, []interface{}) {
-	query := "SELECT * FROM media WHERE 1=1"
-	params := []interface{}{}
+// episodeMode returns the most common value of an episode column among a
+// show's episodes
+func episodeMode(column string) string {
+	return `(SELECT ` + column + ` FROM episodes WHERE tv_show_id = tv_shows.id
+		GROUP BY ` + column + ` ORDER BY COUNT(*) DESC LIMIT 1)`
+}
 
-	for _, rule := range rules {
-		condition, ruleParams := buildCondition(rule)
-		if condition != "" {
-			query += " AND " + condition
-			params = append(params, ruleParams...)
-		}
-	}
+// buildTVShowCondition builds a SQL condition for TV show rules. Fields
+// shows don't have are skipped.
+func buildTVShowCondition(rule SectionRule) (string, []interface{}) {
+	return ruleCondition(rule, tvShowRuleColumns)
+}
 
-	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
-	params = append(params, limit, offset)
+// notManualInSection is a condition excluding items manually added to the
+// section given as the first parameter
+func notManualInSection(idColumn, mediaType string) string {
+	return `NOT EXISTS (SELECT 1 FROM media_sections ms WHERE ms.section_id = ? AND ms.manual = 1
+		AND ms.media_id = ` + idColumn + ` AND ms.media_type = ` + mediaType + `)`
+}
 
-	return query, params
+// mediaRuleColumns map the rule fields getMediaField knows to SQL
+// expressions. Only these are ever put into rule queries. Sections are
+// shared, so play counts are summed across all users.
+var mediaRuleColumns = map[string]string{
+	"type":        "type",
+	"title":       "title",
+	"year":        "year",
+	"genre":       "genres",
+	"genres":      "genres",
+	"rating":      "rating",
+	"runtime":     "runtime",
+	"resolution":  "resolution",
+	"video_codec": "video_codec",
+	"audio_codec": "audio_codec",
+	"added":       "COALESCE(date_added, created_at)",
+	"play_count": `(SELECT COALESCE(SUM(wp.play_count), 0) FROM watch_progress wp
+		WHERE wp.media_id = media.id AND wp.media_type = media.type)`,
 }
 
-// buildCondition builds a SQL condition from a single rule
+// dateRuleFields are the rule fields OperatorWithinDays applies to
+var dateRuleFields = map[string]bool{
+	"added": true,
+}
+
+// withinDaysCondition builds the SQL condition of an OperatorWithinDays rule.
+// Dates are stored in UTC, like SQLite's 'now'.
+func withinDaysCondition(rule SectionRule, column string) (string, []interface{}) {
+	var days int
+	if err := json.Unmarshal([]byte(rule.Value), &days); err != nil || days < 0 || !dateRuleFields[rule.Field] {
+		return "", nil
+	}
+	return fmt.Sprintf("datetime(%s) >= datetime('now', ?)", column), []interface{}{fmt.Sprintf("-%d days", days)}
+}
+
+// ValidRuleField reports whether rules can filter on field
+func ValidRuleField(field string) bool {
+	_, ok := mediaRuleColumns[field]
+	return ok
+}
+
+// buildCondition builds a SQL condition from a single rule. Rules on
+// unknown fields are skipped.
 func buildCondition(rule SectionRule) (string, []interface{}) {
+	return ruleCondition(rule, mediaRuleColumns)
+}
+
+// ruleCondition builds the SQL condition of a rule on one of columns, or ""
+// for fields columns doesn't have
+func ruleCondition(rule SectionRule, columns map[string]string) (string, []interface{}) {
 	var condition string
 	var params []interface{}
+	column, ok := columns[rule.Field]
+	if !ok {
+		return "", nil
+	}
 
 	switch rule.Operator {
 	case OperatorEquals:
 		// Value should be JSON-encoded, decode it
 		var value string
 		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s = ?", rule.Field)
+		condition = fmt.Sprintf("%s = ?", column)
 		params = append(params, value)
 
 	case OperatorContains:
 		var value string
 		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s LIKE ?", rule.Field)
+		condition = fmt.Sprintf("%s LIKE ?", column)
+		params = append(params, "%"+value+"%")
+
+	case OperatorNotEquals:
+		// Missing values count as empty, as in evaluateRule
+		var value string
+		json.Unmarshal([]byte(rule.Value), &value)
+		condition = fmt.Sprintf("COALESCE(%s, '') != ?", column)
+		params = append(params, value)
+
+	case OperatorNotContains:
+		var value string
+		json.Unmarshal([]byte(rule.Value), &value)
+		condition = fmt.Sprintf("COALESCE(%s, '') NOT LIKE ?", column)
 		params = append(params, "%"+value+"%")
 
 	case OperatorGreaterThan:
 		var value float64
 		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s > ?", rule.Field)
+		condition = fmt.Sprintf("%s > ?", column)
 		params = append(params, value)
 
 	case OperatorLessThan:
 		var value float64
 		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s < ?", rule.Field)
+		condition = fmt.Sprintf("%s < ?", column)
 		params = append(params, value)
 
 	case OperatorInRange:
 		var values []int
 		json.Unmarshal([]byte(rule.Value), &values)
 		if len(values) == 2 {
-			condition = fmt.Sprintf("%s BETWEEN ? AND ?", rule.Field)
+			condition = fmt.Sprintf("%s BETWEEN ? AND ?", column)
 			params = append(params, values[0], values[1])
 		}
 
-	case OperatorRegex:
-		// SQLite doesn't have native regex, would need extension
-		// For now, fall back to LIKE
-		var value string
-		json.Unmarshal([]byte(rule.Value), &value)
-		condition = fmt.Sprintf("%s LIKE ?", rule.Field)
-		params = append(params, "%"+value+"%")
+	case OperatorWithinDays:
+		condition, params = withinDaysCondition(rule, column)
+
+		// OperatorRegex is matched in Go, see evaluateRegexSection
 	}
 
 	return condition, params
 }
 
-// EvaluateMediaAgainstRules checks if a media item matches section rules
-func (db *DB) EvaluateMediaAgainstRules(media *Media, rules []SectionRule) bool {
+// ruleGroup is the rules sharing a SectionRule.Group
+type ruleGroup struct {
+	rules []SectionRule
+	or    bool // any rule matching is enough
+}
+
+// groupRules splits rules into their groups, in order of first appearance.
+// A group's rules are OR'd if any of them has the or conjunction.
+func groupRules(rules []SectionRule) []ruleGroup {
+	var groups []ruleGroup
+	index := make(map[int]int)
 	for _, rule := range rules {
-		if !evaluateRule(media, rule) {
-			return false // All rules must match (AND logic)
+		i, ok := index[rule.Group]
+		if !ok {
+			i = len(groups)
+			index[rule.Group] = i
+			groups = append(groups, ruleGroup{})
+		}
+		groups[i].rules = append(groups[i].rules, rule)
+		if rule.Conjunction == ConjunctionOr {
+			groups[i].or = true
+		}
+	}
+	return groups
+}
+
+// condition joins the group's rule conditions into one, or returns "" if
+// none of its rules apply
+func (g ruleGroup) condition(build func(SectionRule) (string, []interface{})) (string, []interface{}) {
+	var conditions []string
+	var params []interface{}
+	for _, rule := range g.rules {
+		condition, ruleParams := build(rule)
+		if condition != "" {
+			conditions = append(conditions, condition)
+			params = append(params, ruleParams...)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	conjunction := " AND "
+	if g.or {
+		conjunction = " OR "
+	}
+	return "(" + strings.Join(conditions, conjunction) + ")", params
+}
+
+// hasRegex reports whether the group has a regex rule
+func (g ruleGroup) hasRegex() bool {
+	for _, rule := range g.rules {
+		if rule.Operator == OperatorRegex {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluateMediaAgainstRules checks if a media item matches section rules:
+// every group has to match, by all its rules or, for or groups, any of
+// them. Rules the SQL path skips are skipped here too.
+func (db *DB) EvaluateMediaAgainstRules(media *Media, rules []SectionRule) bool {
+	value := func(rule SectionRule) (string, bool) {
+		if !ruleApplies(rule, MediaTypeMovie) {
+			return "", false
+		}
+		return getMediaField(media, rule.Field), true
+	}
+	for _, group := range groupRules(rules) {
+		if !group.evaluate(value) {
+			return false
 		}
 	}
 	return true
 }
 
-// evaluateRule checks if a single rule matches the media
-func evaluateRule(media *Media, rule SectionRule) bool {
-	// Get field value from media
-	fieldValue := getMediaField(media, rule.Field)
+// evaluate matches the group in Go. value returns a rule's field value, or
+// false for rules that don't apply to the item.
+func (g ruleGroup) evaluate(value func(SectionRule) (string, bool)) bool {
+	applied := false
+	for _, rule := range g.rules {
+		fieldValue, ok := value(rule)
+		if !ok {
+			continue
+		}
+		applied = true
+		if evaluateRule(fieldValue, rule) == g.or {
+			return g.or
+		}
+	}
+	return !applied || !g.or
+}
+
+// ruleApplies reports whether a rule filters items of kind, a movie or show.
+// The SQL builders skip rules on fields the table lacks or with unusable
+// values; regex rules only need the field.
+func ruleApplies(rule SectionRule, kind MediaType) bool {
+	columns, build := mediaRuleColumns, buildCondition
+	if kind == MediaTypeTVShow {
+		columns, build = tvShowRuleColumns, buildTVShowCondition
+	}
+	if rule.Operator == OperatorRegex {
+		_, ok := columns[rule.Field]
+		return ok
+	}
+	condition, _ := build(rule)
+	return condition != ""
+}
 
+// evaluateRule checks if a single rule matches a field value, as formatted
+// by getMediaField
+func evaluateRule(fieldValue string, rule SectionRule) bool {
 	switch rule.Operator {
 	case OperatorEquals:
 		var targetValue string
 		json.Unmarshal([]byte(rule.Value), &targetValue)
 		return fieldValue == targetValue
 
+	case OperatorNotEquals:
+		var targetValue string
+		json.Unmarshal([]byte(rule.Value), &targetValue)
+		return fieldValue != targetValue
+
 	case OperatorContains:
 		var targetValue string
 		json.Unmarshal([]byte(rule.Value), &targetValue)
 		return strings.Contains(strings.ToLower(fieldValue), strings.ToLower(targetValue))
 
+	case OperatorNotContains:
+		var targetValue string
+		json.Unmarshal([]byte(rule.Value), &targetValue)
+		return !strings.Contains(strings.ToLower(fieldValue), strings.ToLower(targetValue))
+
 	case OperatorGreaterThan:
 		var targetValue float64
 		json.Unmarshal([]byte(rule.Value), &targetValue)
@@ -302,6 +521,12 @@ func evaluateRule(media *Media, rule SectionRule) bool {
 		json.Unmarshal([]byte(rule.Value), &pattern)
 		matched, _ := regexp.MatchString(pattern, fieldValue)
 		return matched
+
+	case OperatorWithinDays:
+		var days int
+		json.Unmarshal([]byte(rule.Value), &days)
+		date, err := time.Parse(dateAddedFormat, fieldValue)
+		return err == nil && !date.Before(time.Now().UTC().AddDate(0, 0, -days))
 	}
 
 	return false
@@ -320,12 +545,21 @@ func getMediaField(media *Media, field string) string {
 		return media.Genres
 	case "rating":
 		return fmt.Sprintf("%.1f", media.Rating)
+	case "runtime":
+		return strconv.Itoa(media.Runtime)
+	case "added":
+		if media.CreatedAt.IsZero() {
+			return time.Now().UTC().Format(dateAddedFormat) // only newly scanned media is evaluated here
+		}
+		return media.CreatedAt.UTC().Format(dateAddedFormat)
 	case "resolution":
 		return media.Resolution
 	case "video_codec":
 		return media.VideoCodec
 	case "audio_codec":
 		return media.AudioCodec
+	case "play_count":
+		return "0" // only newly scanned media is evaluated here
 	default:
 		return ""
 	}
@@ -353,7 +587,7 @@ func (db *DB) AutoAssignMediaToSections(media *Media) error {
 		// Check if media matches all rules
 		if db.EvaluateMediaAgainstRules(media, rules) {
 			// Add to section
-			db.AddMediaToSection(media.ID, media.Type, section.ID)
+			db.autoAssignMediaToSection(media.ID, media.Type, section.ID)
 		}
 	}
 
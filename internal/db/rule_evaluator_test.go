@@ -0,0 +1,142 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSmartSectionRulesMatchAutoAssign(t *testing.T) {
+	db := newTestDB(t)
+	source := newTestSource(t, db)
+
+	movies := []*Media{
+		newTestMovie(t, db, source, "Raiders", 1981, 8.4),
+		newTestMovie(t, db, source, "Mad Max", 2015, 8.1),
+		newTestMovie(t, db, source, "Jungle", 2017, 6.9),
+		newTestMovie(t, db, source, "Drive", 2011, 7.8),
+		newTestMovie(t, db, source, "Dune", 2021, 8.0),
+		newTestMovie(t, db, source, "Scream", 1996, 7.4),
+		newTestMovie(t, db, source, "Unknown", 0, 0),
+	}
+	genres := map[string]string{
+		"Raiders": "Action, Adventure",
+		"Mad Max": "Action",
+		"Jungle":  "Adventure",
+		"Drive":   "Drama",
+		"Dune":    "Science Fiction, Adventure",
+		"Scream":  "Horror",
+	}
+	for _, movie := range movies {
+		movie.Genres = genres[movie.Title]
+		if _, err := db.conn.Exec(`UPDATE media SET genres = ? WHERE id = ?`, movie.Genres, movie.ID); err != nil {
+			t.Fatalf("set genres of %s: %v", movie.Title, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		rules []SectionRule
+		want  []string
+	}{
+		{
+			name: "action or adventure after 2000",
+			rules: []SectionRule{
+				{Field: "genre", Operator: OperatorContains, Value: `"Action"`, Group: 0, Conjunction: ConjunctionOr},
+				{Field: "genre", Operator: OperatorContains, Value: `"Adventure"`, Group: 0, Conjunction: ConjunctionOr},
+				{Field: "year", Operator: OperatorGreaterThan, Value: `2000`, Group: 1},
+			},
+			want: []string{"Dune", "Jungle", "Mad Max"},
+		},
+		{
+			name: "one and group",
+			rules: []SectionRule{
+				{Field: "genre", Operator: OperatorContains, Value: `"Adventure"`},
+				{Field: "rating", Operator: OperatorGreaterThan, Value: `7.5`},
+			},
+			want: []string{"Dune", "Raiders"},
+		},
+		{
+			name: "negated rules on missing values",
+			rules: []SectionRule{
+				{Field: "genre", Operator: OperatorNotContains, Value: `"Action"`},
+				{Field: "resolution", Operator: OperatorNotEquals, Value: `"2160p"`},
+				{Field: "year", Operator: OperatorLessThan, Value: `2012`},
+			},
+			want: []string{"Drive", "Scream", "Unknown"},
+		},
+		{
+			name: "or groups anded together",
+			rules: []SectionRule{
+				{Field: "genre", Operator: OperatorContains, Value: `"Drama"`, Group: 1, Conjunction: ConjunctionOr},
+				{Field: "genre", Operator: OperatorContains, Value: `"Horror"`, Group: 1, Conjunction: ConjunctionOr},
+				{Field: "year", Operator: OperatorLessThan, Value: `2000`, Group: 2, Conjunction: ConjunctionOr},
+				{Field: "rating", Operator: OperatorGreaterThan, Value: `7.5`, Group: 2, Conjunction: ConjunctionOr},
+			},
+			want: []string{"Drive", "Scream"},
+		},
+	}
+
+	sections := make([]*Section, len(tests))
+	for i, tt := range tests {
+		section := &Section{Name: tt.name, Slug: fmt.Sprintf("section-%d", i), SectionType: SectionTypeSmart, IsVisible: true}
+		if err := db.CreateSection(section); err != nil {
+			t.Fatalf("CreateSection(%s): %v", tt.name, err)
+		}
+		for _, rule := range tt.rules {
+			rule.SectionID = section.ID
+			if err := db.CreateSectionRule(&rule); err != nil {
+				t.Fatalf("CreateSectionRule(%s): %v", tt.name, err)
+			}
+		}
+		sections[i] = section
+	}
+
+	// Membership by the SQL rule evaluation
+	sqlMatches := make([][]string, len(tests))
+	for i, section := range sections {
+		items, total, err := db.GetMediaBySectionID(section.ID, 100, 0)
+		if err != nil {
+			t.Fatalf("GetMediaBySectionID(%s): %v", tests[i].name, err)
+		}
+		if total != len(items) {
+			t.Errorf("%s: total = %d, want %d", tests[i].name, total, len(items))
+		}
+		for _, item := range items {
+			if media, ok := item.(SectionItem).Item.(*Media); ok {
+				sqlMatches[i] = append(sqlMatches[i], media.Title)
+			}
+		}
+		sort.Strings(sqlMatches[i])
+	}
+
+	// Membership by evaluating each movie in Go as it's scanned
+	autoMatches := make([][]string, len(tests))
+	for _, movie := range movies {
+		if err := db.AutoAssignMediaToSections(movie); err != nil {
+			t.Fatalf("AutoAssignMediaToSections(%s): %v", movie.Title, err)
+		}
+		sectionIDs, err := db.GetMediaSections(movie.ID, movie.Type)
+		if err != nil {
+			t.Fatalf("GetMediaSections(%s): %v", movie.Title, err)
+		}
+		for _, sectionID := range sectionIDs {
+			for i, section := range sections {
+				if section.ID == sectionID {
+					autoMatches[i] = append(autoMatches[i], movie.Title)
+				}
+			}
+		}
+	}
+
+	for i, tt := range tests {
+		sort.Strings(autoMatches[i])
+		sqlGot, autoGot := fmt.Sprint(sqlMatches[i]), fmt.Sprint(autoMatches[i])
+		if sqlGot != autoGot {
+			t.Errorf("%s: SQL rules match %s, AutoAssignMediaToSections %s", tt.name, sqlGot, autoGot)
+		}
+		if want := fmt.Sprint(tt.want); sqlGot != want {
+			t.Errorf("%s: SQL rules match %s, want %s", tt.name, sqlGot, want)
+		}
+	}
+}
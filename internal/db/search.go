@@ -0,0 +1,248 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SearchResult is a movie, show or episode matching a library search
+type SearchResult struct {
+	Kind       MediaType `json:"kind"`
+	ID         int64     `json:"id"`
+	Title      string    `json:"title"`
+	Year       int       `json:"year,omitempty"`
+	PosterPath string    `json:"poster_path,omitempty"`
+
+	// Episodes only
+	ShowID        int64  `json:"show_id,omitempty"`
+	ShowTitle     string `json:"show_title,omitempty"`
+	SeasonNumber  int    `json:"season_number,omitempty"`
+	EpisodeNumber int    `json:"episode_number,omitempty"`
+}
+
+// The search index is an FTS5 table over the titles and overviews of movies,
+// shows and episodes, kept in sync by triggers. Rowids interleave the three
+// tables (id*3 + kind offset) so triggers can find an item's row directly.
+var searchIndexMigrations = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+		title, original_title, overview,
+		kind UNINDEXED, item_id UNINDEXED,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`,
+
+	`CREATE TRIGGER IF NOT EXISTS search_media_insert AFTER INSERT ON media
+	WHEN NEW.type = 'movie' BEGIN
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		VALUES (NEW.id * 3, NEW.title, NEW.original_title, NEW.overview, 'movie', NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_media_update AFTER UPDATE OF type, title, original_title, overview ON media BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3;
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		SELECT NEW.id * 3, NEW.title, NEW.original_title, NEW.overview, 'movie', NEW.id
+		WHERE NEW.type = 'movie';
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_media_delete AFTER DELETE ON media BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3;
+	END`,
+
+	`CREATE TRIGGER IF NOT EXISTS search_tv_shows_insert AFTER INSERT ON tv_shows BEGIN
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		VALUES (NEW.id * 3 + 1, NEW.title, NEW.original_title, NEW.overview, 'tvshow', NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_tv_shows_update AFTER UPDATE OF title, original_title, overview ON tv_shows BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3 + 1;
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		VALUES (NEW.id * 3 + 1, NEW.title, NEW.original_title, NEW.overview, 'tvshow', NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_tv_shows_delete AFTER DELETE ON tv_shows BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3 + 1;
+	END`,
+
+	`CREATE TRIGGER IF NOT EXISTS search_episodes_insert AFTER INSERT ON episodes BEGIN
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		VALUES (NEW.id * 3 + 2, NEW.title, NULL, NEW.overview, 'episode', NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_episodes_update AFTER UPDATE OF title, overview ON episodes BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3 + 2;
+		INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+		VALUES (NEW.id * 3 + 2, NEW.title, NULL, NEW.overview, 'episode', NEW.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS search_episodes_delete AFTER DELETE ON episodes BEGIN
+		DELETE FROM search_index WHERE rowid = OLD.id * 3 + 2;
+	END`,
+}
+
+// searchIndexTriggers are the triggers searchIndexMigrations creates
+var searchIndexTriggers = []string{
+	"search_media_insert", "search_media_update", "search_media_delete",
+	"search_tv_shows_insert", "search_tv_shows_update", "search_tv_shows_delete",
+	"search_episodes_insert", "search_episodes_update", "search_episodes_delete",
+}
+
+// searchIndexBackfill fills the search index from the library, for
+// databases created before it existed or last opened without FTS5
+var searchIndexBackfill = []string{
+	`INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+	SELECT id * 3, title, original_title, overview, 'movie', id FROM media WHERE type = 'movie'`,
+	`INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+	SELECT id * 3 + 1, title, original_title, overview, 'tvshow', id FROM tv_shows`,
+	`INSERT INTO search_index (rowid, title, original_title, overview, kind, item_id)
+	SELECT id * 3 + 2, title, NULL, overview, 'episode', id FROM episodes`,
+}
+
+// migrateSearchIndex creates the full-text search index. SQLite builds
+// without FTS5 (go-sqlite3 needs the sqlite_fts5 build tag) fall back to
+// LIKE searches. Their triggers are dropped, as SQLite can't run them without
+// FTS5 and every write to the library would fail; the index is rebuilt
+// when the database is next opened with FTS5.
+func (db *DB) migrateSearchIndex() error {
+	var enabled int
+	db.conn.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	if enabled == 0 {
+		for _, trigger := range searchIndexTriggers {
+			if _, err := db.conn.Exec(`DROP TRIGGER IF EXISTS ` + trigger); err != nil {
+				return fmt.Errorf("dropping search index trigger %s failed: %w", trigger, err)
+			}
+		}
+		log.Printf("SQLite was built without FTS5, library search falls back to LIKE")
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Without its triggers the index missed changes and is rebuilt
+	var triggers int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?`, searchIndexTriggers[0],
+	).Scan(&triggers); err != nil {
+		return err
+	}
+
+	for _, migration := range searchIndexMigrations {
+		if _, err := tx.Exec(migration); err != nil {
+			return fmt.Errorf("search index migration failed: %w", err)
+		}
+	}
+
+	if triggers == 0 {
+		if _, err := tx.Exec(`DELETE FROM search_index`); err != nil {
+			return err
+		}
+		for _, backfill := range searchIndexBackfill {
+			if _, err := tx.Exec(backfill); err != nil {
+				return fmt.Errorf("search index backfill failed: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.fullTextSearch = true
+	return nil
+}
+
+// searchResultColumns selects a SearchResult from s (kind, item_id), joined
+// to its movie m, show t or episode e and the episode's show es
+const searchResultColumns = `s.kind, s.item_id,
+	COALESCE(m.title, t.title, e.title, ''),
+	COALESCE(m.year, t.year, CAST(substr(e.aired_at, 1, 4) AS INTEGER), 0),
+	COALESCE(m.poster_path, t.poster_path, e.still_path, es.poster_path, ''),
+	COALESCE(e.tv_show_id, 0), COALESCE(es.title, ''),
+	COALESCE(e.season_number, 0), COALESCE(e.episode_number, 0)`
+
+const searchResultJoins = `
+	LEFT JOIN media m ON s.kind = 'movie' AND m.id = s.item_id
+	LEFT JOIN tv_shows t ON s.kind = 'tvshow' AND t.id = s.item_id
+	LEFT JOIN episodes e ON s.kind = 'episode' AND e.id = s.item_id
+	LEFT JOIN tv_shows es ON es.id = e.tv_show_id`
+
+// Search finds movies, shows and episodes whose title or overview matches
+// query, best matches first. Every word must match, as a prefix so results
+// show up while typing.
+func (db *DB) Search(query string, limit int) ([]*SearchResult, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	var rows *sql.Rows
+	var err error
+	if db.fullTextSearch {
+		// Each word is quoted so FTS5 syntax in the query is taken literally.
+		// Title matches outweigh original titles, which outweigh overviews.
+		terms := make([]string, len(words))
+		for i, word := range words {
+			terms[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+		}
+		rows, err = db.conn.Query(
+			`SELECT `+searchResultColumns+`
+			 FROM (SELECT kind, item_id, bm25(search_index, 10.0, 5.0, 1.0) AS rank
+				FROM search_index WHERE search_index MATCH ?
+				ORDER BY rank LIMIT ?) s`+searchResultJoins+`
+			 ORDER BY s.rank`,
+			strings.Join(terms, " "), limit,
+		)
+	} else {
+		rows, err = db.searchLike(words, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.Kind, &r.ID, &r.Title, &r.Year, &r.PosterPath,
+			&r.ShowID, &r.ShowTitle, &r.SeasonNumber, &r.EpisodeNumber); err != nil {
+			return nil, err
+		}
+		results = append(results, &r)
+	}
+	return results, rows.Err()
+}
+
+// searchLike is Search without FTS5. Items are ranked by where the whole
+// query matches: the exact title, the start of the title, anywhere in the
+// title and then only the words, in the overview.
+func (db *DB) searchLike(words []string, limit int) (*sql.Rows, error) {
+	var conditions []string
+	var args []interface{}
+	phrase := strings.Join(words, " ")
+	args = append(args, phrase, escapeLike(phrase)+"%", "%"+escapeLike(phrase)+"%")
+	for _, word := range words {
+		pattern := "%" + escapeLike(word) + "%"
+		conditions = append(conditions,
+			`(s.title LIKE ? ESCAPE '\' OR s.original_title LIKE ? ESCAPE '\' OR s.overview LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	args = append(args, limit)
+
+	return db.conn.Query(
+		`SELECT `+searchResultColumns+`
+		 FROM (SELECT kind, item_id, title, original_title, overview,
+				CASE WHEN title = ? COLLATE NOCASE THEN 0
+					WHEN title LIKE ? ESCAPE '\' THEN 1
+					WHEN title LIKE ? ESCAPE '\' THEN 2
+					ELSE 3 END AS rank
+			FROM (SELECT 'movie' AS kind, id AS item_id, title, original_title, overview FROM media WHERE type = 'movie'
+				UNION ALL SELECT 'tvshow', id, title, original_title, overview FROM tv_shows
+				UNION ALL SELECT 'episode', id, title, NULL, overview FROM episodes)) s`+searchResultJoins+`
+		 WHERE `+strings.Join(conditions, " AND ")+`
+		 ORDER BY s.rank, s.kind = 'episode', s.title COLLATE NOCASE
+		 LIMIT ?`,
+		args...,
+	)
+}
+
+// escapeLike escapes LIKE wildcards in s, for patterns using ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxSmartPlaylistItems caps how many matches a smart playlist holds, newest
+// first, so broad rules don't list the whole library
+const maxSmartPlaylistItems = 500
+
+// smartPlaylistColumns map the rule fields of smart playlists to SQL
+// expressions on movies and on episodes. They're the section rule fields,
+// except that playlists are personal: play_count is the owner's.
+func smartPlaylistColumns(userID int64) (movies, episodes map[string]string) {
+	playCount := func(idColumn, mediaType string) string {
+		return fmt.Sprintf(`(SELECT COALESCE(SUM(wp.play_count), 0) FROM watch_progress wp
+			WHERE wp.user_id = %d AND wp.media_id = %s AND wp.media_type = '%s')`, userID, idColumn, mediaType)
+	}
+
+	movies = make(map[string]string, len(mediaRuleColumns))
+	for field, column := range mediaRuleColumns {
+		movies[field] = column
+	}
+	movies["play_count"] = playCount("media.id", "movie")
+
+	// Episodes have their show's genres
+	episodes = map[string]string{
+		"type":        "'episode'",
+		"title":       "episodes.title",
+		"year":        "CAST(substr(episodes.aired_at, 1, 4) AS INTEGER)",
+		"genre":       "tv_shows.genres",
+		"genres":      "tv_shows.genres",
+		"rating":      "episodes.rating",
+		"runtime":     "COALESCE(episodes.runtime, episodes.duration / 60)",
+		"resolution":  "episodes.resolution",
+		"video_codec": "episodes.video_codec",
+		"audio_codec": "episodes.audio_codec",
+		"added":       "COALESCE(episodes.date_added, episodes.created_at)",
+		"play_count":  playCount("episodes.id", "episode"),
+	}
+	return movies, episodes
+}
+
+// ValidPlaylistRuleField reports whether smart playlist rules can filter on
+// field
+func ValidPlaylistRuleField(field string) bool {
+	movies, _ := smartPlaylistColumns(0)
+	_, ok := movies[field]
+	return ok
+}
+
+// sectionRules returns the playlist's rules as section rules, to evaluate
+// them with the section rule builders
+func (p *Playlist) sectionRules() []SectionRule {
+	rules := make([]SectionRule, len(p.Rules))
+	for i, rule := range p.Rules {
+		rules[i] = SectionRule{
+			Field:       rule.Field,
+			Operator:    rule.Operator,
+			Value:       rule.Value,
+			Group:       rule.Group,
+			Conjunction: rule.Conjunction,
+		}
+	}
+	return rules
+}
+
+// smartPlaylistMatches returns the query for a smart playlist's matching
+// movies and episodes, with the columns of a PlaylistItemWithMedia and when
+// they were added. Regex rules aren't supported and are skipped.
+func smartPlaylistMatches(playlist *Playlist) (string, []interface{}) {
+	movieColumns, episodeColumns := smartPlaylistColumns(playlist.UserID)
+	where := func(columns map[string]string) (string, []interface{}) {
+		conditions := []string{"1"}
+		var params []interface{}
+		for _, group := range groupRules(playlist.sectionRules()) {
+			condition, groupParams := group.condition(func(rule SectionRule) (string, []interface{}) {
+				return ruleCondition(rule, columns)
+			})
+			if condition != "" {
+				conditions = append(conditions, condition)
+				params = append(params, groupParams...)
+			}
+		}
+		return strings.Join(conditions, " AND "), params
+	}
+	movieWhere, params := where(movieColumns)
+	episodeWhere, episodeParams := where(episodeColumns)
+	params = append(params, episodeParams...)
+
+	return `SELECT 'movie' AS kind, media.id, media.title, COALESCE(media.year, 0),
+			COALESCE(media.poster_path, ''), COALESCE(media.duration, 0), COALESCE(media.overview, ''),
+			COALESCE(media.rating, 0), COALESCE(media.resolution, ''),
+			COALESCE(media.date_added, media.created_at) AS added
+		FROM media WHERE media.type = 'movie' AND ` + movieWhere + `
+		UNION ALL
+		SELECT 'episode', episodes.id, episodes.title, 0,
+			COALESCE(episodes.still_path, ''), COALESCE(episodes.duration, 0), COALESCE(episodes.overview, ''),
+			COALESCE(episodes.rating, 0), COALESCE(episodes.resolution, ''),
+			COALESCE(episodes.date_added, episodes.created_at)
+		FROM episodes JOIN tv_shows ON tv_shows.id = episodes.tv_show_id
+		WHERE ` + episodeWhere, params
+}
+
+// smartPlaylistItems evaluates a smart playlist's rules, most recently added
+// matches first. Items have no playlist item ID; their position is their rank
+// and their added_at when they were added to the library.
+func (db *DB) smartPlaylistItems(playlist *Playlist) ([]*PlaylistItemWithMedia, error) {
+	items := make([]*PlaylistItemWithMedia, 0)
+	if len(playlist.Rules) == 0 {
+		return items, nil
+	}
+
+	matches, params := smartPlaylistMatches(playlist)
+	rows, err := db.conn.Query(
+		`SELECT * FROM (`+matches+`) ORDER BY added DESC, kind, id DESC LIMIT ?`,
+		append(params, maxSmartPlaylistItems)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &PlaylistItemWithMedia{PlaylistID: playlist.ID, Position: len(items) + 1}
+		var added string
+		if err := rows.Scan(&item.MediaType, &item.MediaID, &item.Title, &item.Year, &item.PosterPath,
+			&item.Duration, &item.Overview, &item.Rating, &item.Resolution, &added); err != nil {
+			return nil, err
+		}
+		// COALESCE loses the column type, so the date comes back as text
+		item.AddedAt, _ = time.Parse(dateAddedFormat, added)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// smartPlaylistCount counts a smart playlist's matches, up to the number it
+// lists
+func (db *DB) smartPlaylistCount(playlist *Playlist) (int, error) {
+	if len(playlist.Rules) == 0 {
+		return 0, nil
+	}
+	matches, params := smartPlaylistMatches(playlist)
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM (`+matches+`)`, params...).Scan(&count)
+	return min(count, maxSmartPlaylistItems), err
+}
+
+// decodePlaylistRules reads the rules column of a playlist
+func decodePlaylistRules(playlist *Playlist, rulesJSON sql.NullString) error {
+	if !playlist.Smart || !rulesJSON.Valid || rulesJSON.String == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(rulesJSON.String), &playlist.Rules)
+}
+
+// encodePlaylistRules formats rules for the rules column
+func encodePlaylistRules(rules []PlaylistRule) (string, error) {
+	if rules == nil {
+		rules = []PlaylistRule{}
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
@@ -10,7 +10,10 @@ import (
 
 // DB wraps the database connection
 type DB struct {
-	conn *sql.DB
+	conn       *sql.DB
+	aggregates aggregateCache
+
+	fullTextSearch bool // the FTS5 search index exists
 }
 
 // New creates a new database connection
@@ -51,10 +54,43 @@ func (db *DB) Migrate() error {
 			username TEXT UNIQUE NOT NULL,
 			email TEXT UNIQUE NOT NULL,
 			password_hash TEXT NOT NULL,
+			is_admin BOOLEAN DEFAULT 0,
+			is_guest BOOLEAN DEFAULT 0,
+			tokens_valid_after INTEGER DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		// Logged out JWTs, by jti claim, until they'd have expired (unix seconds)
+		`CREATE TABLE IF NOT EXISTS revoked_tokens (
+			jti TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`,
+
+		// Long-lived tokens for renewing access tokens, by SHA-256 hash
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			expires_at INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		// Per-device API keys, by SHA-256 hash
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT,
+			scope TEXT NOT NULL DEFAULT 'full',
+			prefix TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS media_sources (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			name TEXT NOT NULL,
@@ -63,6 +99,7 @@ func (db *DB) Migrate() error {
 			username TEXT,
 			password TEXT,
 			enabled INTEGER DEFAULT 1,
+			absolute_numbering INTEGER DEFAULT 0,
 			last_scan DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
@@ -93,11 +130,26 @@ func (db *DB) Migrate() error {
 			resolution TEXT,
 			audio_tracks TEXT,
 			subtitle_tracks TEXT,
+			date_added DATETIME,
+			file_mtime INTEGER,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (source_id) REFERENCES media_sources(id)
 		)`,
 
+		// Files of movies split into parts (CD1, CD2, ...), the movie's own
+		// file included, in playback order
+		`CREATE TABLE IF NOT EXISTS media_parts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			media_id INTEGER NOT NULL,
+			part_number INTEGER NOT NULL,
+			file_path TEXT UNIQUE NOT NULL,
+			file_size INTEGER,
+			duration INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS tv_shows (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			title TEXT NOT NULL,
@@ -111,6 +163,8 @@ func (db *DB) Migrate() error {
 			tmdb_id INTEGER,
 			imdb_id TEXT,
 			status TEXT,
+			episode_order TEXT DEFAULT 'aired',
+			episode_group_id TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -138,6 +192,7 @@ func (db *DB) Migrate() error {
 			overview TEXT,
 			still_path TEXT,
 			air_date TEXT,
+			aired_at DATE,
 			runtime INTEGER,
 			rating REAL,
 			source_id INTEGER,
@@ -149,6 +204,8 @@ func (db *DB) Migrate() error {
 			resolution TEXT,
 			audio_tracks TEXT,
 			subtitle_tracks TEXT,
+			date_added DATETIME,
+			file_mtime INTEGER,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (tv_show_id) REFERENCES tv_shows(id) ON DELETE CASCADE,
@@ -164,6 +221,8 @@ func (db *DB) Migrate() error {
 			position INTEGER DEFAULT 0,
 			duration INTEGER DEFAULT 0,
 			completed INTEGER DEFAULT 0,
+			play_count INTEGER DEFAULT 0,
+			last_played_at DATETIME,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
 			UNIQUE(user_id, media_id, media_type)
@@ -184,6 +243,9 @@ func (db *DB) Migrate() error {
 			user_id INTEGER NOT NULL,
 			name TEXT NOT NULL,
 			description TEXT,
+			is_public BOOLEAN DEFAULT 0,
+			smart BOOLEAN DEFAULT 0,
+			rules TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
@@ -226,6 +288,31 @@ func (db *DB) Migrate() error {
 			FOREIGN KEY (source_id) REFERENCES media_sources(id)
 		)`,
 
+		// Subtitle files found next to movies and episodes
+		`CREATE TABLE IF NOT EXISTS external_subtitles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			media_id INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			file_path TEXT NOT NULL,
+			language TEXT,
+			format TEXT NOT NULL,
+			forced BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(media_id, media_type, file_path)
+		)`,
+
+		// Top-billed cast of movies and shows, from TMDB
+		`CREATE TABLE IF NOT EXISTS credits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			media_id INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			person_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			character TEXT,
+			profile_path TEXT,
+			sort_order INTEGER NOT NULL DEFAULT 0
+		)`,
+
 		// Customizable sections
 		`CREATE TABLE IF NOT EXISTS sections (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -246,6 +333,8 @@ func (db *DB) Migrate() error {
 			field TEXT NOT NULL,
 			operator TEXT NOT NULL,
 			value TEXT NOT NULL,
+			rule_group INTEGER DEFAULT 0,
+			conjunction TEXT DEFAULT 'and',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (section_id) REFERENCES sections(id) ON DELETE CASCADE
 		)`,
@@ -255,6 +344,7 @@ func (db *DB) Migrate() error {
 			media_id INTEGER NOT NULL,
 			media_type TEXT NOT NULL,
 			section_id INTEGER NOT NULL,
+			manual BOOLEAN DEFAULT 0,
 			added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (section_id) REFERENCES sections(id) ON DELETE CASCADE,
 			UNIQUE(media_id, media_type, section_id)
@@ -267,6 +357,9 @@ func (db *DB) Migrate() error {
 			name TEXT NOT NULL,
 			description TEXT,
 			icon TEXT DEFAULT '📺',
+			schedule_seed INTEGER DEFAULT 0,
+			schedule_start DATETIME,
+			bumper_frequency INTEGER DEFAULT 1,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
@@ -280,6 +373,7 @@ func (db *DB) Migrate() error {
 			source_value TEXT,
 			weight INTEGER DEFAULT 1,
 			shuffle BOOLEAN DEFAULT 1,
+			options TEXT,
 			FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
 		)`,
 
@@ -297,11 +391,19 @@ func (db *DB) Migrate() error {
 		)`,
 
 		// Indexes for common queries
+		`CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON revoked_tokens(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_media_type ON media(type)`,
 		`CREATE INDEX IF NOT EXISTS idx_media_title ON media(title)`,
 		`CREATE INDEX IF NOT EXISTS idx_media_source ON media(source_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_episodes_show ON episodes(tv_show_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_episodes_season ON episodes(season_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_media_file_path ON media(file_path)`,
+		`CREATE INDEX IF NOT EXISTS idx_media_parts_media ON media_parts(media_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_episodes_file_path ON episodes(file_path)`,
+		`CREATE INDEX IF NOT EXISTS idx_episodes_aired_at ON episodes(aired_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_watch_progress_user ON watch_progress(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_watchlist_user ON watchlist(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_playlists_user ON playlists(user_id)`,
@@ -310,6 +412,8 @@ func (db *DB) Migrate() error {
 		`CREATE INDEX IF NOT EXISTS idx_extras_tv_show ON extras(tv_show_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_extras_episode ON extras(episode_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_extras_category ON extras(category)`,
+		`CREATE INDEX IF NOT EXISTS idx_external_subtitles_media ON external_subtitles(media_id, media_type)`,
+		`CREATE INDEX IF NOT EXISTS idx_credits_media ON credits(media_id, media_type)`,
 		`CREATE INDEX IF NOT EXISTS idx_sections_slug ON sections(slug)`,
 		`CREATE INDEX IF NOT EXISTS idx_sections_visible ON sections(is_visible)`,
 		`CREATE INDEX IF NOT EXISTS idx_sections_order ON sections(display_order)`,
@@ -320,6 +424,16 @@ func (db *DB) Migrate() error {
 		`CREATE INDEX IF NOT EXISTS idx_channel_sources_channel ON channel_sources(channel_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_channel_schedule_channel ON channel_schedule(channel_id, cycle_number, scheduled_position)`,
 
+		// Promote the first user to admin on databases created before roles existed
+		`UPDATE users SET is_admin = 1
+		WHERE id = (SELECT MIN(id) FROM users WHERE is_guest = 0)
+		AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin = 1)`,
+
+		// Backfill parsed air dates for episodes scanned before aired_at existed
+		`UPDATE episodes SET aired_at = substr(air_date, 1, 10)
+		WHERE aired_at IS NULL
+		AND air_date GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]*'`,
+
 		// Insert default sections (only if sections table is empty)
 		`INSERT INTO sections (name, slug, icon, section_type, display_order, is_visible)
 		SELECT 'Movies', 'movies', 'film', 'smart', 1, 1
@@ -361,6 +475,43 @@ func (db *DB) Migrate() error {
 		`ALTER TABLE channel_sources ADD COLUMN shuffle BOOLEAN DEFAULT 1`,
 		// Add options column for season/commentary/extras filtering
 		`ALTER TABLE channel_sources ADD COLUMN options TEXT`,
+		// Add admin flag to users
+		`ALTER TABLE users ADD COLUMN is_admin BOOLEAN DEFAULT 0`,
+		// Add parsed air date to episodes for sorting and unaired filtering
+		`ALTER TABLE episodes ADD COLUMN aired_at DATE`,
+		// Add rolling schedule state to channels
+		`ALTER TABLE channels ADD COLUMN schedule_seed INTEGER DEFAULT 0`,
+		`ALTER TABLE channels ADD COLUMN schedule_start DATETIME`,
+		// Distinguish manually added section items from smart-section auto-assignments
+		`ALTER TABLE media_sections ADD COLUMN manual BOOLEAN DEFAULT 0`,
+		// Mark the shared account used by unauthenticated clients in guest mode
+		`ALTER TABLE users ADD COLUMN is_guest BOOLEAN DEFAULT 0`,
+		// File modification time, so "recently added" survives a library rebuild
+		`ALTER TABLE media ADD COLUMN date_added DATETIME`,
+		`ALTER TABLE episodes ADD COLUMN date_added DATETIME`,
+		// Per-show episode numbering (DVD, absolute, ...) backed by a TMDB episode group
+		`ALTER TABLE tv_shows ADD COLUMN episode_order TEXT DEFAULT 'aired'`,
+		`ALTER TABLE tv_shows ADD COLUMN episode_group_id TEXT`,
+		// Playlist queries read is_public, which older schemas lack
+		`ALTER TABLE playlists ADD COLUMN is_public BOOLEAN DEFAULT 0`,
+		// Per-user play counts for "most watched"
+		`ALTER TABLE watch_progress ADD COLUMN play_count INTEGER DEFAULT 0`,
+		`ALTER TABLE watch_progress ADD COLUMN last_played_at DATETIME`,
+		// Sources whose episodes are numbered from the start of the show (anime)
+		`ALTER TABLE media_sources ADD COLUMN absolute_numbering INTEGER DEFAULT 0`,
+		// File modification time (unix seconds), so rescans skip unchanged files
+		`ALTER TABLE media ADD COLUMN file_mtime INTEGER`,
+		`ALTER TABLE episodes ADD COLUMN file_mtime INTEGER`,
+		// Section rule groups, for OR'ing rules
+		`ALTER TABLE section_rules ADD COLUMN rule_group INTEGER DEFAULT 0`,
+		`ALTER TABLE section_rules ADD COLUMN conjunction TEXT DEFAULT 'and'`,
+		// Smart playlists and their rules (JSON)
+		`ALTER TABLE playlists ADD COLUMN smart BOOLEAN DEFAULT 0`,
+		`ALTER TABLE playlists ADD COLUMN rules TEXT`,
+		// Bumpers between channel items
+		`ALTER TABLE channels ADD COLUMN bumper_frequency INTEGER DEFAULT 1`,
+		// Tokens issued before this time (unix seconds) are revoked
+		`ALTER TABLE users ADD COLUMN tokens_valid_after INTEGER DEFAULT 0`,
 	}
 
 	for _, migration := range optionalMigrations {
@@ -374,5 +525,5 @@ func (db *DB) Migrate() error {
 		}
 	}
 
-	return nil
+	return db.migrateSearchIndex()
 }
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// tokenCleanupInterval is how often expired refresh tokens and revocations
+// of expired access tokens are deleted
+const tokenCleanupInterval = time.Hour
+
+// HashToken is how refresh tokens and API keys are stored, so a leaked
+// database doesn't leak usable credentials
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokeToken revokes a single JWT by its ID (jti claim). The revocation is
+// kept until expiresAt, when the token stops being accepted anyway.
+func (db *DB) RevokeToken(tokenID string, userID int64, expiresAt time.Time) error {
+	_, err := db.conn.Exec(
+		`INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)`,
+		tokenID, userID, expiresAt.Unix(),
+	)
+	return err
+}
+
+// RevokeUserTokens revokes every access and refresh token issued to a user
+// so far, logging them out on all devices. Tokens issued afterwards are
+// unaffected.
+func (db *DB) RevokeUserTokens(userID int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE users SET tokens_valid_after = ? WHERE id = ?`,
+		time.Now().Unix(), userID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TokenRevoked reports whether a token was revoked on its own, or issued
+// (at issuedAt, unix seconds) before all of its user's tokens were revoked
+func (db *DB) TokenRevoked(tokenID string, userID, issuedAt int64) (bool, error) {
+	var revoked bool
+	err := db.conn.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+			OR COALESCE((SELECT tokens_valid_after FROM users WHERE id = ?), 0) > ?`,
+		tokenID, userID, issuedAt,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// CreateRefreshToken stores a refresh token, by the hash of its value
+func (db *DB) CreateRefreshToken(userID int64, tokenHash string, expiresAt time.Time) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)`,
+		userID, tokenHash, expiresAt.Unix(),
+	)
+	return err
+}
+
+// ConsumeRefreshToken deletes a refresh token and returns its user, so each
+// refresh token can only be used once. It returns ErrNotFound for unknown and
+// expired tokens.
+func (db *DB) ConsumeRefreshToken(tokenHash string) (int64, error) {
+	var userID, expiresAt int64
+	err := db.conn.QueryRow(
+		`DELETE FROM refresh_tokens WHERE token_hash = ? RETURNING user_id, expires_at`,
+		tokenHash,
+	).Scan(&userID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if expiresAt < time.Now().Unix() {
+		return 0, ErrNotFound
+	}
+	return userID, nil
+}
+
+// DeleteRefreshToken revokes one of a user's refresh tokens
+func (db *DB) DeleteRefreshToken(userID int64, tokenHash string) error {
+	_, err := db.conn.Exec(
+		`DELETE FROM refresh_tokens WHERE user_id = ? AND token_hash = ?`,
+		userID, tokenHash,
+	)
+	return err
+}
+
+// DeleteExpiredTokens deletes expired refresh tokens and forgets revocations
+// of access tokens that have expired, returning how many rows were deleted
+func (db *DB) DeleteExpiredTokens() (int64, error) {
+	now := time.Now().Unix()
+	var deleted int64
+	for _, table := range []string{"revoked_tokens", "refresh_tokens"} {
+		result, err := db.conn.Exec(`DELETE FROM `+table+` WHERE expires_at < ?`, now)
+		if err != nil {
+			return deleted, err
+		}
+		rows, _ := result.RowsAffected()
+		deleted += rows
+	}
+	return deleted, nil
+}
+
+// StartTokenCleanup starts the background goroutine that deletes expired
+// tokens. It runs for the life of the process, so call it once.
+func (db *DB) StartTokenCleanup() {
+	go func() {
+		for range time.Tick(tokenCleanupInterval) {
+			if _, err := db.DeleteExpiredTokens(); err != nil {
+				log.Printf("Failed to delete expired tokens: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+var (
+	// absoluteDashRegex matches fansub-style names, "One Piece - 1071" or
+	// "One Piece - E1071v2"
+	absoluteDashRegex = regexp.MustCompile(`^(.+?)\s+-\s+(?:[Ee][Pp]?\.?\s?)?(\d{1,4})(?:v\d)?(?:\s|$)`)
+
+	// absoluteTrailingRegex matches a number ending the name, "One.Piece.1071"
+	// or "One Piece EP1071"
+	absoluteTrailingRegex = regexp.MustCompile(`^(.+?)[\s._]+(?:[Ee][Pp]?\.?)?(\d{2,4})(?:v\d)?$`)
+
+	// bracketTagRegex matches release group, quality and checksum tags:
+	// "[SubsPlease]", "(1080p)", "[ABCD1234]"
+	bracketTagRegex = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)`)
+
+	// parenYearRegex matches a year in parentheses, "(1999)"
+	parenYearRegex = regexp.MustCompile(`\((19\d{2}|20\d{2})\)`)
+)
+
+// absoluteSeason is passed to processTVEpisode as the season of absolute
+// episode numbers, which are mapped onto the show's seasons
+const absoluteSeason = -1
+
+// maxAbsoluteSeasons bounds the seasons looked up when mapping absolute
+// episode numbers
+const maxAbsoluteSeasons = 100
+
+// parseAbsoluteEpisode reads the show title, year and absolute episode number
+// from a name without a season, like "[SubsPlease] One Piece - 1071 (1080p).mkv".
+// Four-digit numbers that look like years aren't episodes.
+func parseAbsoluteEpisode(filePath string) (title string, year, episode int, ok bool) {
+	name := filepath.Base(filePath)
+	if ext := strings.ToLower(filepath.Ext(name)); videoExtensions[ext] {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	if m := parenYearRegex.FindStringSubmatch(name); m != nil {
+		year, _ = strconv.Atoi(m[1])
+	}
+	name = bracketTagRegex.ReplaceAllString(name, " ")
+	name = strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " ")
+
+	m := absoluteDashRegex.FindStringSubmatch(name)
+	if m == nil {
+		m = absoluteTrailingRegex.FindStringSubmatch(name)
+	}
+	if m == nil {
+		return "", 0, 0, false
+	}
+
+	episode, _ = strconv.Atoi(m[2])
+	if episode == 0 || len(m[2]) == 4 && episode >= 1900 && episode <= 2099 {
+		return "", 0, 0, false
+	}
+
+	title = strings.Join(strings.Fields(strings.ReplaceAll(m[1], ".", " ")), " ")
+	if title == "" {
+		return "", 0, 0, false
+	}
+	return title, year, episode, true
+}
+
+// absoluteToSeason maps an absolute episode number onto a season and episode
+// given the episode counts of seasons 1, 2, ... Episodes past the known
+// seasons continue the last one; without season data everything is in
+// season 1.
+func absoluteToSeason(seasonLengths []int, absolute int) (season, episode int) {
+	episode = absolute
+	for i, length := range seasonLengths {
+		if episode <= length || i == len(seasonLengths)-1 {
+			return i + 1, episode
+		}
+		episode -= length
+	}
+	return 1, absolute
+}
+
+// seasonLengths returns the episode counts of a show's regular seasons in its
+// episode order, or nil without TMDB data. They're cached for the rest of
+// the scan, since every episode file of the show needs them.
+func (s *Scanner) seasonLengths(show *db.TVShow, metadata *episodeMetadata) []int {
+	if lengths, ok := s.absoluteSeasons[show.ID]; ok {
+		return lengths
+	}
+
+	var lengths []int
+	for num := 1; num <= maxAbsoluteSeasons; num++ {
+		season := metadata.season(num)
+		if season == nil {
+			break
+		}
+		lengths = append(lengths, len(season.Episodes))
+	}
+
+	if s.absoluteSeasons == nil {
+		s.absoluteSeasons = make(map[int64][]int)
+	}
+	s.absoluteSeasons[show.ID] = lengths
+	return lengths
+}
@@ -0,0 +1,144 @@
+package library
+
+import (
+	"log"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// scanBatchSize is how many new files a scan buffers before writing their
+// movies and episodes, each kind in one transaction
+const scanBatchSize = 100
+
+// pendingMovie is a new movie waiting for the next batch
+type pendingMovie struct {
+	media   *db.Media
+	credits []db.Credit
+}
+
+// pendingEpisodeFile is a new episode file waiting for the next batch, with
+// an episode for each episode it holds
+type pendingEpisodeFile struct {
+	filePath  string
+	showTitle string
+	episodes  []*db.Episode
+}
+
+// setBatching turns batched writes of new files on or off. Turning them off
+// writes out whatever is buffered.
+func (s *Scanner) setBatching(on bool) {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	if !on {
+		s.flushPending()
+	}
+	s.batching = on
+}
+
+// queueMovie buffers a new movie until the batch is written
+func (s *Scanner) queueMovie(media *db.Media, credits []db.Credit) {
+	s.pendingMovies = append(s.pendingMovies, pendingMovie{media: media, credits: credits})
+	s.markPending(media.FilePath)
+}
+
+// queueEpisodes buffers the new episodes of a file until the batch is written
+func (s *Scanner) queueEpisodes(filePath, showTitle string, episodes []*db.Episode) {
+	s.pendingEpisodes = append(s.pendingEpisodes, pendingEpisodeFile{filePath: filePath, showTitle: showTitle, episodes: episodes})
+	s.markPending(filePath)
+}
+
+func (s *Scanner) markPending(filePath string) {
+	if s.pendingFiles == nil {
+		s.pendingFiles = make(map[string]bool)
+	}
+	s.pendingFiles[filePath] = true
+}
+
+// dropPending forgets a buffered file, for files deleted before their batch
+// is written
+func (s *Scanner) dropPending(filePath string) {
+	if !s.pendingFiles[filePath] {
+		return
+	}
+	delete(s.pendingFiles, filePath)
+
+	movies := s.pendingMovies[:0]
+	for _, movie := range s.pendingMovies {
+		if movie.media.FilePath != filePath {
+			movies = append(movies, movie)
+		}
+	}
+	s.pendingMovies = movies
+
+	files := s.pendingEpisodes[:0]
+	for _, file := range s.pendingEpisodes {
+		if file.filePath != filePath {
+			files = append(files, file)
+		}
+	}
+	s.pendingEpisodes = files
+}
+
+// flushPending writes the buffered movies and episodes. If a batch fails, its
+// items are added one at a time, so one bad row doesn't lose the rest.
+func (s *Scanner) flushPending() {
+	movies, episodeFiles := s.pendingMovies, s.pendingEpisodes
+	s.pendingMovies, s.pendingEpisodes, s.pendingFiles = nil, nil, nil
+
+	if len(movies) > 0 {
+		items := make([]*db.Media, len(movies))
+		for i, movie := range movies {
+			items[i] = movie.media
+		}
+		created, err := s.db.CreateMediaBatch(items)
+		if err != nil {
+			log.Printf("Failed to add %d movies at once, adding them one at a time: %v", len(items), err)
+			created = make([]*db.Media, len(items))
+			for i, media := range items {
+				if created[i], err = s.db.CreateMedia(media); err != nil {
+					log.Printf("Failed to add movie %s: %v", media.FilePath, err)
+				}
+			}
+		}
+		for i, media := range created {
+			if media != nil {
+				s.movieAdded(media, movies[i].credits)
+			}
+		}
+	}
+
+	if len(episodeFiles) > 0 {
+		var items []*db.Episode
+		for _, file := range episodeFiles {
+			items = append(items, file.episodes...)
+		}
+		created, err := s.db.CreateEpisodeBatch(items)
+		if err != nil {
+			log.Printf("Failed to add %d episodes at once, adding them one at a time: %v", len(items), err)
+			created = make([]*db.Episode, len(items))
+			for i, episode := range items {
+				if created[i], err = s.db.CreateEpisode(episode); err != nil {
+					log.Printf("Failed to add episode %s: %v", episode.FilePath, err)
+				}
+			}
+		}
+
+		// Episodes were created in the order of their files
+		i := 0
+		for _, file := range episodeFiles {
+			added := false
+			for range file.episodes {
+				if episode := created[i]; episode != nil {
+					s.episodeAdded(episode, file.showTitle)
+					added = true
+				}
+				i++
+			}
+			if added {
+				s.recordModTime(file.filePath)
+				s.recordSidecarSubtitles(file.filePath)
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package library
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// ErrJobRunning is returned by jobs that run in the foreground when a scan or
+// refresh is already running
+var ErrJobRunning = errors.New("a scan or refresh is already in progress")
+
+// CleanupResult counts the library entries a cleanup removed, or would
+// remove in a dry run
+type CleanupResult struct {
+	DryRun         bool     `json:"dry_run"`
+	Movies         int      `json:"movies"`
+	Parts          int      `json:"parts"` // later parts of split movies
+	Episodes       int      `json:"episodes"`
+	Extras         int      `json:"extras"`
+	UnlinkedExtras int      `json:"unlinked_extras"` // extras without a movie, show or episode
+	Seasons        int      `json:"seasons"`
+	Shows          int      `json:"shows"`
+	Skipped        int      `json:"skipped"` // entries on sources that can't be reached
+	MissingFiles   []string `json:"missing_files"`
+}
+
+// CleanupOrphans removes the movies, parts of split movies, episodes and
+// extras whose files are gone, along with the seasons and shows that leaves
+// empty, and the extras left without a movie, show or episode. Entries on a
+// source whose folder is missing or empty are skipped rather than removed, so
+// an unmounted share doesn't empty the library. It returns ErrJobRunning if a
+// scan or refresh is running.
+func (s *Scanner) CleanupOrphans(dryRun bool) (*CleanupResult, error) {
+	if !s.tryStart(JobCleanup) {
+		return nil, ErrJobRunning
+	}
+	defer s.finish()
+
+	files, err := s.db.GetLibraryFiles()
+	if err != nil {
+		return nil, err
+	}
+	s.updateStatus(func(status *ScanStatus) {
+		status.FilesFound = len(files)
+	})
+
+	sources, err := s.db.GetAllMediaSources()
+	if err != nil {
+		return nil, err
+	}
+	reachable := make(map[int64]bool, len(sources))
+	for _, source := range sources {
+		reachable[source.ID] = sourceReachable(source.Path)
+	}
+
+	result := &CleanupResult{DryRun: dryRun, MissingFiles: make([]string, 0)}
+	missing := make(map[string]bool) // by file path, as episodes can share a file
+	seasonEpisodes, seasonMissing := make(map[int64]int), make(map[int64]int)
+	showEpisodes, showMissing := make(map[int64]int), make(map[int64]int)
+	moviesGone, episodesGone, showsGone := make(map[int64]bool), make(map[int64]bool), make(map[int64]bool)
+	var extras []db.LibraryFile
+
+	ctx := s.context()
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch file.Ref.Type {
+		case db.MediaTypeEpisode:
+			seasonEpisodes[file.SeasonID]++
+			showEpisodes[file.ShowID]++
+		case db.MediaTypeExtra:
+			extras = append(extras, file)
+		}
+
+		gone, seen := missing[file.FilePath]
+		if !seen {
+			// Entries on a deleted source aren't checked against its folder
+			if ok, known := reachable[file.SourceID]; known && !ok {
+				result.Skipped++
+				s.itemDone()
+				continue
+			}
+			s.setCurrentItem(file.FilePath)
+			_, err := os.Stat(file.FilePath)
+			gone = os.IsNotExist(err)
+			missing[file.FilePath] = gone
+			if gone {
+				result.MissingFiles = append(result.MissingFiles, file.FilePath)
+			}
+		}
+		s.itemDone()
+		if !gone {
+			continue
+		}
+
+		switch {
+		case file.Part:
+			// Parts go with their movie's own file
+			if !moviesGone[file.Ref.ID] {
+				result.Parts++
+			}
+		case file.Ref.Type == db.MediaTypeEpisode:
+			result.Episodes++
+			episodesGone[file.Ref.ID] = true
+			seasonMissing[file.SeasonID]++
+			showMissing[file.ShowID]++
+		case file.Ref.Type == db.MediaTypeExtra:
+			result.Extras++
+		default:
+			result.Movies++
+			moviesGone[file.Ref.ID] = true
+		}
+	}
+
+	// Seasons and shows go with their last episode
+	for seasonID, count := range seasonMissing {
+		if count == seasonEpisodes[seasonID] {
+			result.Seasons++
+		}
+	}
+	for showID, count := range showMissing {
+		if count == showEpisodes[showID] {
+			result.Shows++
+			showsGone[showID] = true
+		}
+	}
+
+	// Removing an extra's parent keeps the extra with no parent, so extras
+	// are removed once none of their parents is left
+	for _, extra := range extras {
+		if missing[extra.FilePath] {
+			continue
+		}
+		if (extra.MovieID == 0 || moviesGone[extra.MovieID]) &&
+			(extra.ShowID == 0 || showsGone[extra.ShowID]) &&
+			(extra.EpisodeID == 0 || episodesGone[extra.EpisodeID]) {
+			result.UnlinkedExtras++
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	for _, filePath := range result.MissingFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := s.RemoveFile(filePath); err != nil {
+			return nil, err
+		}
+	}
+	if result.UnlinkedExtras, err = s.db.DeleteUnlinkedExtras(); err != nil {
+		return nil, err
+	}
+	log.Printf("Library cleanup removed %d movies, %d movie parts, %d episodes and %d extras with missing files, and %d unlinked extras",
+		result.Movies, result.Parts, result.Episodes, result.Extras, result.UnlinkedExtras)
+	return result, nil
+}
+
+// sourceReachable reports whether a source's folder can be read and has
+// anything in it. An unmounted share often leaves an empty mount point.
+func sourceReachable(path string) bool {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(1)
+	return err == nil && len(names) > 0
+}
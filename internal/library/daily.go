@@ -0,0 +1,55 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// dailySeason is passed to processTVEpisode as the season of episodes named
+// by air date, which are looked up by the date
+const dailySeason = -2
+
+// airDateRegex matches the air date naming a daily show's episode,
+// "2023.05.12" or "2023-05-12"
+var airDateRegex = regexp.MustCompile(`(?:^|[\s._-])((?:19|20)\d{2})[.\-_ ](\d{2})[.\-_ ](\d{2})(?:[\s._-]|$)`)
+
+// findAirDate finds an air date in a file name without extension, returning
+// it as "YYYY-MM-DD" with where it starts and ends, or "" if there's none
+func findAirDate(name string) (airDate string, start, end int) {
+	loc := airDateRegex.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return "", 0, 0
+	}
+	airDate = name[loc[2]:loc[3]] + "-" + name[loc[4]:loc[5]] + "-" + name[loc[6]:loc[7]]
+	if _, err := time.Parse("2006-01-02", airDate); err != nil {
+		return "", 0, 0
+	}
+	return airDate, loc[2], loc[7]
+}
+
+// parseAirDate returns the air date of a daily show's episode file, like
+// "2023-05-12" for "The.Daily.Show.2023.05.12.mkv", or "" if the file isn't
+// named by date
+func parseAirDate(filePath string) string {
+	filename := filepath.Base(filePath)
+	if ext := strings.ToLower(filepath.Ext(filename)); videoExtensions[ext] || ffmpeg.IsDiscImage(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	airDate, _, _ := findAirDate(stripReleaseTags(filename))
+	return airDate
+}
+
+// dailyEpisodeNumber makes up a season and episode for an air date TMDB
+// doesn't know: the year, and the month and day, so 2023-05-12 is S2023E512.
+// Episodes of one day share a number.
+func dailyEpisodeNumber(airDate string) (season, episode int) {
+	date, err := time.Parse("2006-01-02", airDate)
+	if err != nil {
+		return 0, 0
+	}
+	return date.Year(), int(date.Month())*100 + date.Day()
+}
@@ -0,0 +1,219 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/tmdb"
+)
+
+// ErrInvalidEpisodeOrder is returned for unknown episode order names
+var ErrInvalidEpisodeOrder = errors.New("invalid episode order")
+
+// ErrNoEpisodeGroup is returned when TMDB has no episode group for the
+// requested order of a show
+var ErrNoEpisodeGroup = errors.New("no TMDB episode group for this order")
+
+// episodeGroupTypes maps episode orders to the TMDB episode group type
+// backing them
+var episodeGroupTypes = map[string]int{
+	db.EpisodeOrderDVD:        tmdb.EpisodeGroupDVD,
+	db.EpisodeOrderAbsolute:   tmdb.EpisodeGroupAbsolute,
+	db.EpisodeOrderDigital:    tmdb.EpisodeGroupDigital,
+	db.EpisodeOrderStoryArc:   tmdb.EpisodeGroupStoryArc,
+	db.EpisodeOrderProduction: tmdb.EpisodeGroupProduction,
+	db.EpisodeOrderTV:         tmdb.EpisodeGroupTV,
+}
+
+// ValidEpisodeOrder reports whether order is a known episode order
+func ValidEpisodeOrder(order string) bool {
+	_, ok := episodeGroupTypes[order]
+	return ok || order == db.EpisodeOrderAired
+}
+
+// episodeMetadata looks up TMDB season and episode details by the numbers
+// in a show's files, which follow the show's episode order
+type episodeMetadata struct {
+	ctx    context.Context
+	tmdb   *tmdb.Client
+	showID int                // TMDB show ID for aired order, 0 if unknown
+	group  *tmdb.EpisodeGroup // set for other orders
+}
+
+// episodeMetadataFor returns the metadata lookup for show. A show whose
+// episode group can't be loaded gets no metadata rather than metadata for
+// the wrong episodes.
+func (s *Scanner) episodeMetadataFor(show *db.TVShow, tmdbShowID int) *episodeMetadata {
+	if !s.tmdb.IsConfigured() {
+		return &episodeMetadata{ctx: s.context(), tmdb: s.tmdb}
+	}
+	if show.EpisodeGroupID == "" {
+		return &episodeMetadata{ctx: s.context(), tmdb: s.tmdb, showID: tmdbShowID}
+	}
+
+	group, err := s.tmdb.GetEpisodeGroupContext(s.context(), show.EpisodeGroupID)
+	if err != nil {
+		log.Printf("TMDB episode group %s failed for %s: %v", show.EpisodeGroupID, show.Title, err)
+		return &episodeMetadata{ctx: s.context(), tmdb: s.tmdb}
+	}
+	return &episodeMetadata{ctx: s.context(), tmdb: s.tmdb, group: group}
+}
+
+// season returns details for a season number, or nil
+func (m *episodeMetadata) season(num int) *tmdb.SeasonDetails {
+	if m.group != nil {
+		entry := m.group.Group(num)
+		if entry == nil {
+			return nil
+		}
+		details := &tmdb.SeasonDetails{SeasonNumber: num, Name: entry.Name}
+		for _, episode := range entry.Episodes {
+			details.Episodes = append(details.Episodes, tmdb.EpisodeSummary{
+				ID:            episode.ID,
+				EpisodeNumber: episode.Order + 1,
+				Name:          episode.Name,
+				Overview:      episode.Overview,
+				StillPath:     episode.StillPath,
+				AirDate:       episode.AirDate,
+				Runtime:       episode.Runtime,
+				VoteAverage:   episode.VoteAverage,
+			})
+		}
+		return details
+	}
+
+	if m.showID == 0 {
+		return nil
+	}
+	details, err := m.tmdb.GetTVSeasonDetailsContext(m.ctx, m.showID, num)
+	if err != nil {
+		return nil
+	}
+	return details
+}
+
+// episode returns details for a season and episode number, or nil
+func (m *episodeMetadata) episode(season, episode int) *tmdb.EpisodeDetails {
+	if m.group != nil {
+		return m.group.Episode(season, episode)
+	}
+
+	if m.showID == 0 {
+		return nil
+	}
+	details, err := m.tmdb.GetTVEpisodeDetailsContext(m.ctx, m.showID, season, episode)
+	if err != nil {
+		return nil
+	}
+	return details
+}
+
+// episodeByAirDate returns the season and episode number of the episode
+// that aired on airDate, or false if it isn't known
+func (m *episodeMetadata) episodeByAirDate(airDate string) (season, episode int, ok bool) {
+	if m.group != nil {
+		// Absolute orders have no seasons to number daily episodes with
+		if m.group.Type == tmdb.EpisodeGroupAbsolute {
+			return 0, 0, false
+		}
+		for _, group := range m.group.Groups {
+			for _, e := range group.Episodes {
+				if e.AirDate == airDate {
+					return group.Order, e.Order + 1, true
+				}
+			}
+		}
+		return 0, 0, false
+	}
+
+	if m.showID == 0 {
+		return 0, 0, false
+	}
+	details, err := m.tmdb.GetEpisodeByAirDateContext(m.ctx, m.showID, airDate)
+	if err != nil || details == nil {
+		return 0, 0, false
+	}
+	return details.SeasonNumber, details.EpisodeNumber, true
+}
+
+// SetEpisodeOrder changes how a show's episode files are numbered and
+// re-fetches the metadata of its episodes in the new order. Orders other
+// than aired use groupID, or the largest TMDB episode group of the matching
+// type when groupID is empty.
+func (s *Scanner) SetEpisodeOrder(show *db.TVShow, order, groupID string) error {
+	if !ValidEpisodeOrder(order) {
+		return ErrInvalidEpisodeOrder
+	}
+
+	if order == db.EpisodeOrderAired {
+		groupID = ""
+	} else {
+		if !s.tmdb.IsConfigured() || show.TMDbID == 0 {
+			return ErrNoEpisodeGroup
+		}
+		if groupID == "" {
+			groups, err := s.tmdb.GetEpisodeGroupsContext(s.context(), show.TMDbID)
+			if err != nil {
+				return fmt.Errorf("fetch episode groups: %w", err)
+			}
+			best := -1
+			for i, group := range groups {
+				if group.Type == episodeGroupTypes[order] && (best < 0 || group.EpisodeCount > groups[best].EpisodeCount) {
+					best = i
+				}
+			}
+			if best < 0 {
+				return ErrNoEpisodeGroup
+			}
+			groupID = groups[best].ID
+		}
+	}
+
+	if err := s.db.SetTVShowEpisodeOrder(show.ID, order, groupID); err != nil {
+		return err
+	}
+	show.EpisodeOrder = order
+	show.EpisodeGroupID = groupID
+
+	if !s.tmdb.IsConfigured() || show.TMDbID == 0 {
+		return nil
+	}
+	return s.remapEpisodes(show)
+}
+
+// remapEpisodes re-fetches episode metadata after the show's order changed
+func (s *Scanner) remapEpisodes(show *db.TVShow) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	metadata := s.episodeMetadataFor(show, show.TMDbID)
+	if metadata.showID == 0 && metadata.group == nil {
+		return fmt.Errorf("episode group %s unavailable", show.EpisodeGroupID)
+	}
+
+	episodes, err := s.db.GetEpisodesByShowID(show.ID, db.EpisodeListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, episode := range episodes {
+		details := metadata.episode(episode.SeasonNumber, episode.EpisodeNumber)
+		if details == nil {
+			continue
+		}
+		episode.Title = details.Name
+		episode.Overview = details.Overview
+		episode.StillPath = details.StillPath
+		episode.AirDate = details.AirDate
+		episode.Runtime = details.Runtime
+		episode.Rating = details.VoteAverage
+		if err := s.db.UpdateEpisodeMetadata(episode); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Applied %s episode order to %s (%d episodes)", show.EpisodeOrder, show.Title, len(episodes))
+	return nil
+}
@@ -55,12 +55,18 @@ func (s *Scanner) ScanExtrasSource(source *db.MediaSource) error {
 	}
 
 	log.Printf("Found %d extra files in %s", len(files), source.Name)
+	s.startSourceStatus(source, len(files))
 
 	// Process each file
 	for _, file := range files {
-		if err := s.processExtraFile(file, source); err != nil {
+		if err := s.context().Err(); err != nil {
+			return err
+		}
+		s.setCurrentItem(file)
+		if err := s.ProcessFile(file, source); err != nil {
 			log.Printf("Error processing extra %s: %v", file, err)
 		}
+		s.itemDone()
 	}
 
 	// Update last scan time
@@ -21,7 +21,9 @@ func NewMetadataExtractor(ffprobePath string) *MetadataExtractor {
 	}
 }
 
-// ExtractFileMetadata extracts technical metadata from a media file
+// ExtractFileMetadata extracts technical metadata from a media file. Ripped
+// disc folders are probed through their main title; disc images can't be
+// probed and only get their size.
 func (m *MetadataExtractor) ExtractFileMetadata(filePath string) (*db.MediaFile, error) {
 	// Get file size
 	fileInfo, err := os.Stat(filePath)
@@ -29,17 +31,31 @@ func (m *MetadataExtractor) ExtractFileMetadata(filePath string) (*db.MediaFile,
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	// Get video metadata via ffprobe
-	metadata, err := m.ffprobe.GetMetadata(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ffprobe metadata: %w", err)
-	}
-
 	mediaFile := &db.MediaFile{
 		FilePath: filePath,
 		FileSize: fileInfo.Size(),
 	}
 
+	if ffmpeg.IsDiscImage(filePath) {
+		return mediaFile, nil
+	}
+
+	probePath := filePath
+	if fileInfo.IsDir() {
+		title, err := ffmpeg.MainTitle(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find disc main title: %w", err)
+		}
+		probePath = title.Input
+		mediaFile.FileSize = title.Size
+	}
+
+	// Get video metadata via ffprobe
+	metadata, err := m.ffprobe.GetMetadata(probePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ffprobe metadata: %w", err)
+	}
+
 	// Extract video metadata
 	mediaFile.Duration = metadata.Duration
 
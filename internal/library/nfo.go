@@ -0,0 +1,186 @@
+package library
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// Root elements of Kodi NFO files
+const (
+	nfoMovie   = "movie"
+	nfoTVShow  = "tvshow"
+	nfoEpisode = "episodedetails"
+)
+
+// nfoMetadata is what's read from a Kodi-style NFO sidecar file. Its values
+// are preferred over TMDB's, as they were set by hand.
+type nfoMetadata struct {
+	XMLName       xml.Name
+	Title         string `xml:"title"`
+	OriginalTitle string `xml:"originaltitle"`
+	Year          string `xml:"year"`
+	Premiered     string `xml:"premiered"` // YYYY-MM-DD
+	Aired         string `xml:"aired"`     // YYYY-MM-DD, episodes
+	Plot          string `xml:"plot"`
+	Rating        string `xml:"rating"`
+	Ratings       []struct {
+		Default bool   `xml:"default,attr"`
+		Value   string `xml:"value"`
+	} `xml:"ratings>rating"`
+	UniqueIDs []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"uniqueid"`
+	ID     string   `xml:"id"` // IMDb ID in older NFOs
+	Genres []string `xml:"genre"`
+
+	imdbID string // also set for NFOs holding just an IMDb link
+}
+
+// readNFO reads the NFO at path if it exists and its root is root. NFOs
+// that aren't XML are used for an IMDb ID in them, like an IMDb link.
+func readNFO(path, root string) *nfoMetadata {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var nfo nfoMetadata
+	// Decode reads the first element only, ignoring a link after the XML
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&nfo); err != nil {
+		if id := imdbIDRegex.FindString(string(data)); id != "" {
+			return &nfoMetadata{imdbID: id}
+		}
+		return nil
+	}
+	if !strings.EqualFold(nfo.XMLName.Local, root) {
+		return nil
+	}
+
+	for _, id := range nfo.UniqueIDs {
+		if strings.EqualFold(id.Type, "imdb") {
+			nfo.imdbID = strings.TrimSpace(id.Value)
+		}
+	}
+	if nfo.imdbID == "" {
+		nfo.imdbID = imdbIDRegex.FindString(nfo.ID)
+	}
+	return &nfo
+}
+
+// readMovieNFO reads the NFO of the movie in filePath: "<name>.nfo" next to
+// it, or the "movie.nfo" of its folder. Disc folders have theirs inside.
+func readMovieNFO(filePath string) *nfoMetadata {
+	if nfo := readNFO(nfoPath(filePath), nfoMovie); nfo != nil {
+		return nfo
+	}
+	dir := filepath.Dir(filePath)
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		dir = filePath
+	}
+	return readNFO(filepath.Join(dir, "movie.nfo"), nfoMovie)
+}
+
+// readShowNFO reads the "tvshow.nfo" of the show an episode file is in, from
+// the show's folder or from the season folder inside it
+func readShowNFO(filePath string) *nfoMetadata {
+	dir := filepath.Dir(filePath)
+	if nfo := readNFO(filepath.Join(dir, "tvshow.nfo"), nfoTVShow); nfo != nil {
+		return nfo
+	}
+	return readNFO(filepath.Join(filepath.Dir(dir), "tvshow.nfo"), nfoTVShow)
+}
+
+// readEpisodeNFO reads the "<name>.nfo" of an episode file. Multi-episode
+// files have one entry per episode; only the first is read.
+func readEpisodeNFO(filePath string) *nfoMetadata {
+	return readNFO(nfoPath(filePath), nfoEpisode)
+}
+
+// nfoPath returns the path of the NFO named after a file. Only real
+// extensions are replaced: disc folders like "The.Matrix.1999" have none.
+func nfoPath(filePath string) string {
+	if ext := strings.ToLower(filepath.Ext(filePath)); videoExtensions[ext] || ffmpeg.IsDiscImage(filePath) {
+		filePath = strings.TrimSuffix(filePath, filepath.Ext(filePath))
+	}
+	return filePath + ".nfo"
+}
+
+// year returns the NFO's year, from the premiere or air date if it has no
+// year of its own, or 0 if it has none
+func (n *nfoMetadata) year() int {
+	for _, value := range []string{n.Year, n.Premiered, n.Aired} {
+		if value = strings.TrimSpace(value); len(value) >= 4 {
+			if year, err := strconv.Atoi(value[:4]); err == nil && year > 0 {
+				return year
+			}
+		}
+	}
+	return 0
+}
+
+// rating returns the NFO's default rating, or its first if none is marked
+// default, or 0 if it has none
+func (n *nfoMetadata) rating() float64 {
+	value := n.Rating
+	for i, rating := range n.Ratings {
+		if rating.Default || i == 0 {
+			value = rating.Value
+		}
+	}
+	rating, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return rating
+}
+
+// applyTo overrides metadata with the values the NFO has
+func (n *nfoMetadata) applyTo(metadata *db.TMDBMetadata) {
+	if title := strings.TrimSpace(n.Title); title != "" {
+		metadata.Title = title
+	}
+	if title := strings.TrimSpace(n.OriginalTitle); title != "" {
+		metadata.OriginalTitle = title
+	}
+	if year := n.year(); year > 0 {
+		metadata.Year = year
+	}
+	if plot := strings.TrimSpace(n.Plot); plot != "" {
+		metadata.Overview = plot
+	}
+	if rating := n.rating(); rating > 0 {
+		metadata.Rating = rating
+	}
+	if len(n.Genres) > 0 {
+		metadata.Genres = strings.Join(n.Genres, ", ")
+	}
+	if n.imdbID != "" {
+		metadata.IMDbID = n.imdbID
+	}
+}
+
+// applyToShow overrides a show's metadata with the values the NFO has
+func (n *nfoMetadata) applyToShow(show *db.TVShow) {
+	metadata := db.TMDBMetadata{
+		Title:         show.Title,
+		OriginalTitle: show.OriginalTitle,
+		Year:          show.Year,
+		Overview:      show.Overview,
+		Rating:        show.Rating,
+		Genres:        show.Genres,
+		IMDbID:        show.IMDbID,
+	}
+	n.applyTo(&metadata)
+	show.Title = metadata.Title
+	show.OriginalTitle = metadata.OriginalTitle
+	show.Year = metadata.Year
+	show.Overview = metadata.Overview
+	show.Rating = metadata.Rating
+	show.Genres = metadata.Genres
+	show.IMDbID = metadata.IMDbID
+}
@@ -20,6 +20,11 @@ import (
 // "Game.of.Thrones.1x01.Winter.Is.Coming.mkv" -> Title: "Game of Thrones", Season: 1, Episode: 1, IsTV: true
 // "The.Dark.Knight.(2008).2160p.4K.UHD.HDR.mkv" -> Title: "The Dark Knight", Year: 2008
 // "Stranger.Things.S03E08.The.Battle.of.Starcourt.1080p.WEBRip.x265.mkv" -> Title: "Stranger Things", Season: 3, Episode: 8, IsTV: true
+// "Firefly.S01E01-E02.mkv" -> Title: "Firefly", Season: 1, Episodes: 1, 2, IsTV: true
+// "The.Matrix.1999.1080p.BluRay.x264-SPARKS.mkv" -> Title: "The Matrix", Year: 1999
+// "Spider-Man.2002.mkv" -> Title: "Spider-Man", Year: 2002
+// "[Erai-raws] Show.S01E01 [ABCD1234].mkv" -> Title: "Show", Season: 1, Episode: 1, IsTV: true
+// "The.Daily.Show.2023.05.12.mkv" -> Title: "The Daily Show", AirDate: "2023-05-12", IsTV: true
 type FilenameParser struct {
 	qualityRegex        *regexp.Regexp
 	yearRegex           *regexp.Regexp
@@ -37,7 +42,7 @@ func NewFilenameParser() *FilenameParser {
 	return &FilenameParser{
 		// Quality indicators regex - matches common video quality and format markers
 		// Matches patterns like: 1080p, 720p, BluRay, WEB-DL, x264, AAC, etc.
-		qualityRegex: regexp.MustCompile(`(?i)[\.\s_-]?(1080p|720p|480p|2160p|4k|uhd|hdr|bluray|bdrip|brrip|webrip|web-dl|dvdrip|hdtv|x264|x265|hevc|h264|h265|aac|ac3|dts|5\.1|7\.1|atmos|remastered|extended|directors\.cut|unrated|theatrical|hd)[\.\s_-]?`),
+		qualityRegex: regexp.MustCompile(`(?i)[\.\s_-]?(1080p|720p|480p|2160p|4k|uhd|hdr|bluray|bdrip|brrip|webrip|web-dl|dvdrip|hdtv|xvid|divx|x264|x265|hevc|h264|h265|aac|ac3|dts|5\.1|7\.1|atmos|remastered|extended|directors\.cut|unrated|theatrical|hd)[\.\s_-]?`),
 
 		// Year regex - matches years in various formats (1900-2099)
 		// Handles: (2020), [2020], .2020., _2020_
@@ -82,9 +87,18 @@ type ParseResult struct {
 	// SeasonNumber is the season number for TV shows (0 if not a TV show)
 	SeasonNumber int
 
-	// EpisodeNumber is the episode number for TV shows (0 if not a TV show)
+	// EpisodeNumber is the episode number for TV shows (0 if not a TV show).
+	// For multi-episode files it's the first episode.
 	EpisodeNumber int
 
+	// EpisodeNumbers are all episodes in the file, e.g. 1 and 2 for
+	// S01E01-E02 (nil if not a TV show)
+	EpisodeNumbers []int
+
+	// AirDate is the air date ("YYYY-MM-DD") of a daily show's episode named
+	// by date instead of season and episode ("" otherwise)
+	AirDate string
+
 	// OriginalName is the original filename without extension
 	OriginalName string
 }
@@ -93,7 +107,8 @@ type ParseResult struct {
 // It handles various naming conventions and formats commonly used for media files.
 //
 // The parsing process follows this order:
-// 1. Extract season/episode numbers (determines if it's a TV show)
+// 0. Strip release group tags and bracketed hashes (see stripReleaseTags)
+// 1. Extract season/episode numbers or air date (determines if it's a TV show)
 // 2. Extract IMDb ID (if present)
 // 3. Extract year
 // 4. Clean and extract title
@@ -111,32 +126,39 @@ func (p *FilenameParser) ParseFilename(filePath string) ParseResult {
 	ext := filepath.Ext(filename)
 	filename = strings.TrimSuffix(filename, ext)
 	result.OriginalName = filename
+	filename = stripReleaseTags(filename)
 
 	// Step 1: Check if it's a TV show (S01E01 format)
 	// This must be done FIRST before any cleanup to ensure pattern matching works
-	if matches := p.tvShowRegex.FindStringSubmatch(filename); len(matches) >= 3 {
-		result.IsTV = true
-		result.SeasonNumber, _ = strconv.Atoi(matches[1])
-		result.EpisodeNumber, _ = strconv.Atoi(matches[2])
+	if loc := p.tvShowRegex.FindStringSubmatchIndex(filename); loc != nil {
+		p.setEpisodes(&result, filename, loc)
 
 		// Extract show title (everything before S01E01 pattern)
-		titlePart := p.tvShowRegex.ReplaceAllString(filename, " ")
+		titlePart := p.tvShowRegex.ReplaceAllString(filename[:loc[0]]+" "+filename[loc[1]:], " ")
 		result.Title = p.cleanTitle(titlePart, true)
 		return result
 	}
 
 	// Step 2: Check alternative TV show format (1x01)
-	if matches := p.tvShowAltRegex.FindStringSubmatch(filename); len(matches) >= 3 {
-		result.IsTV = true
-		result.SeasonNumber, _ = strconv.Atoi(matches[1])
-		result.EpisodeNumber, _ = strconv.Atoi(matches[2])
+	if loc := p.tvShowAltRegex.FindStringSubmatchIndex(filename); loc != nil {
+		p.setEpisodes(&result, filename, loc)
 
 		// Extract show title (everything before 1x01 pattern)
-		titlePart := p.tvShowAltRegex.ReplaceAllString(filename, " ")
+		titlePart := p.tvShowAltRegex.ReplaceAllString(filename[:loc[0]]+" "+filename[loc[1]:], " ")
 		result.Title = p.cleanTitle(titlePart, true)
 		return result
 	}
 
+	// Step 2b: Check for a daily show named by air date (2023.05.12)
+	if airDate, start, _ := findAirDate(filename); airDate != "" && start > 0 {
+		result.IsTV = true
+		result.AirDate = airDate
+
+		// Extract show title (everything before the date)
+		result.Title = p.cleanTitle(filename[:start], true)
+		return result
+	}
+
 	// Step 3: Extract IMDb ID if present (highest priority for matching)
 	if matches := p.imdbIDRegex.FindStringSubmatch(filename); len(matches) > 0 {
 		result.IMDbID = matches[1]
@@ -151,6 +173,136 @@ func (p *FilenameParser) ParseFilename(filePath string) ParseResult {
 	return result
 }
 
+// setEpisodes fills in the season and episodes of a TV match, given the
+// submatch indexes of the season and first episode. loc[1] is moved past any
+// further episodes of a multi-episode file.
+func (p *FilenameParser) setEpisodes(result *ParseResult, filename string, loc []int) {
+	result.IsTV = true
+	result.SeasonNumber, _ = strconv.Atoi(filename[loc[2]:loc[3]])
+	first, _ := strconv.Atoi(filename[loc[4]:loc[5]])
+	result.EpisodeNumbers, loc[1] = multiEpisodeNumbers(filename, loc[1], result.SeasonNumber, first)
+	result.EpisodeNumber = first
+}
+
+// episodeSuffixRegex matches an episode following the first one of a
+// multi-episode file: "E02" and "-E02" (S01E01E02, S01E01-E02), "-1x02"
+// (1x01-1x02) and "-02" (S01E01-02)
+var episodeSuffixRegex = regexp.MustCompile(`^(-?)(?:[Ee](\d{1,3})|(\d{1,2})x(\d{1,3})|(\d{1,3}))`)
+
+// maxEpisodesPerFile bounds multi-episode ranges, so numbers after an episode
+// that aren't episodes ("S01E01-99") aren't taken for a whole season
+const maxEpisodesPerFile = 10
+
+// multiEpisodeNumbers returns the episodes of a file named name whose first
+// episode number (first, in season) ends at end, along with where the last
+// episode ends. Ranges ("E01-E03", "E01-03", "1x01-1x03") include the
+// episodes in between; "E01E03" is just 1 and 3. A range ending at its start
+// ("E05-E05") is a single episode.
+func multiEpisodeNumbers(name string, end, season, first int) ([]int, int) {
+	episodes := []int{first}
+	last := first
+	for {
+		m := episodeSuffixRegex.FindStringSubmatch(name[end:])
+		if m == nil {
+			break
+		}
+		// A number running on into letters or digits ("-1080p", "-10bit")
+		// isn't an episode
+		if next := name[end+len(m[0]):]; next != "" && isAlphanumeric(next[0]) {
+			break
+		}
+
+		dash := m[1] == "-"
+		var n int
+		switch {
+		case m[2] != "":
+			n, _ = strconv.Atoi(m[2])
+		case m[4] != "":
+			if s, _ := strconv.Atoi(m[3]); !dash || s != season {
+				return episodes, end
+			}
+			n, _ = strconv.Atoi(m[4])
+		default:
+			if !dash {
+				return episodes, end
+			}
+			n, _ = strconv.Atoi(m[5])
+		}
+		if n < last || n-first >= maxEpisodesPerFile {
+			break
+		}
+
+		if dash {
+			for episode := last + 1; episode <= n; episode++ {
+				episodes = append(episodes, episode)
+			}
+		} else if n > last {
+			episodes = append(episodes, n)
+		}
+		last = n
+		end += len(m[0])
+	}
+	return episodes, end
+}
+
+// releaseGroupRegex matches a release group tag ("-SPARKS") after a year or
+// a quality or source marker. Hyphens elsewhere are left alone, as they're
+// also in titles like "Spider-Man".
+var releaseGroupRegex = regexp.MustCompile(`(?i)((?:19|20)\d{2}|\d{3,4}p|4k|uhd|hdr|bluray|bdrip|brrip|web-?dl|webrip|web|dvdrip|hdtv|xvid|divx|x264|x265|hevc|h264|h265|aac|ac3|dts|atmos|10bit)-[a-z0-9]*[a-z][a-z0-9]*$`)
+
+// keptBracketRegex matches bracket groups that hold a year or an IMDb ID,
+// which are parsed rather than stripped
+var keptBracketRegex = regexp.MustCompile(`^\[(?:(?:19|20)\d{2}|tt\d{7,8})\]$`)
+
+// stripReleaseTags removes what release groups add around a file name
+// without extension: leading and trailing bracket groups like "[Erai-raws]"
+// or a "[ABCD1234]" hash, and a trailing release group tag.
+//
+// Examples:
+// "[Erai-raws] Show - 01 [ABCD1234]" -> "Show - 01"
+// "The.Matrix.1999.1080p.BluRay.x264-SPARKS" -> "The.Matrix.1999.1080p.BluRay.x264"
+// "Spider-Man.2002" -> "Spider-Man.2002"
+func stripReleaseTags(name string) string {
+	for {
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "[") {
+			if end := strings.Index(name, "]"); end >= 0 && !keptBracketRegex.MatchString(name[:end+1]) {
+				name = name[end+1:]
+				continue
+			}
+		}
+		if strings.HasSuffix(name, "]") {
+			if start := strings.LastIndex(name, "["); start >= 0 && !keptBracketRegex.MatchString(name[start:]) {
+				name = name[:start]
+				continue
+			}
+		}
+		return releaseGroupRegex.ReplaceAllString(name, "${1}")
+	}
+}
+
+// replaceSeparatorDashes replaces dashes used as separators with spaces,
+// keeping hyphens between letters as in "Spider-Man" or "X-Men"
+func replaceSeparatorDashes(title string) string {
+	b := []byte(title)
+	for i, c := range b {
+		if c == '-' && (i == 0 || i == len(b)-1 || !isLetter(b[i-1]) || !isLetter(b[i+1])) {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// isLetter reports whether c is an ASCII letter
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// isAlphanumeric reports whether c is an ASCII letter or digit
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
 // extractYear attempts multiple strategies to extract the year from a filename.
 // It looks for years in the range 1900-2099 in various formats.
 //
@@ -165,9 +317,9 @@ func (p *FilenameParser) ParseFilename(filePath string) ParseResult {
 func (p *FilenameParser) extractYear(filename string) int {
 	// Strategy 1: Look for (YYYY) pattern - most common
 	patterns := []string{
-		`\((\d{4})\)`,             // (2020)
-		`\[(\d{4})\]`,             // [2020]
-		`[\s_\.-](\d{4})[\s_\.-]`, // .2020. or _2020_ or -2020-
+		`\((\d{4})\)`,                   // (2020)
+		`\[(\d{4})\]`,                   // [2020]
+		`[\s_\.-](\d{4})(?:[\s_\.-]|$)`, // .2020. or _2020_ or -2020-, or at the end
 	}
 
 	for _, pattern := range patterns {
@@ -207,17 +359,17 @@ func (p *FilenameParser) cleanMovieTitle(filename string, year int, imdbID strin
 	// Remove parenthetical quality info like "(1080p HD)" or "(HD)"
 	title = p.parenQualityRegex.ReplaceAllString(title, " ")
 
-	// Replace common separators with spaces
+	// Replace common separators with spaces, keeping hyphens within words
 	title = strings.ReplaceAll(title, ".", " ")
 	title = strings.ReplaceAll(title, "_", " ")
-	title = strings.ReplaceAll(title, "-", " ")
+	title = replaceSeparatorDashes(title)
 
 	// Remove year from title in various formats
 	if year > 0 {
 		yearStr := strconv.Itoa(year)
 		title = strings.ReplaceAll(title, "("+yearStr+")", " ")
 		title = strings.ReplaceAll(title, "["+yearStr+"]", " ")
-		title = strings.ReplaceAll(title, " "+yearStr+" ", " ")
+		title = strings.ReplaceAll(" "+title+" ", " "+yearStr+" ", " ")
 	}
 
 	// Remove IMDb ID
@@ -229,7 +381,7 @@ func (p *FilenameParser) cleanMovieTitle(filename string, year int, imdbID strin
 }
 
 // cleanTitle performs common cleaning operations on a title:
-// - Normalizes separators to spaces
+// - Normalizes separators to spaces, keeping hyphens within words
 // - Removes multiple spaces
 // - Trims whitespace
 // - Normalizes capitalization
@@ -241,10 +393,10 @@ func (p *FilenameParser) cleanMovieTitle(filename string, year int, imdbID strin
 //
 // Returns the cleaned title string.
 func (p *FilenameParser) cleanTitle(title string, isTVShow bool) string {
-	// Replace dots, underscores, and dashes with spaces
+	// Replace dots, underscores, and dashes between words with spaces
 	title = strings.ReplaceAll(title, ".", " ")
 	title = strings.ReplaceAll(title, "_", " ")
-	title = strings.ReplaceAll(title, "-", " ")
+	title = replaceSeparatorDashes(title)
 
 	// Normalize multiple spaces to single space
 	title = p.multipleSpacesRegex.ReplaceAllString(title, " ")
@@ -0,0 +1,119 @@
+package library
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+func TestParseFilenameReleaseTags(t *testing.T) {
+	tests := []struct {
+		file    string
+		title   string
+		year    int
+		isTV    bool
+		season  int
+		episode int
+	}{
+		{file: "Spider-Man.2002.mkv", title: "Spider-Man", year: 2002},
+		{file: "The.Matrix.1999.1080p.BluRay.x264-SPARKS.mkv", title: "The Matrix", year: 1999},
+		{file: "[Erai-raws] Show.S01E01 [ABCD1234].mkv", title: "Show", isTV: true, season: 1, episode: 1},
+	}
+
+	p := NewFilenameParser()
+	for _, tt := range tests {
+		got := p.ParseFilename(tt.file)
+		if got.Title != tt.title || got.Year != tt.year || got.IsTV != tt.isTV || got.SeasonNumber != tt.season || got.EpisodeNumber != tt.episode {
+			t.Errorf("ParseFilename(%q) = %q (%d), TV %v S%dE%d; want %q (%d), TV %v S%dE%d", tt.file,
+				got.Title, got.Year, got.IsTV, got.SeasonNumber, got.EpisodeNumber,
+				tt.title, tt.year, tt.isTV, tt.season, tt.episode)
+		}
+
+		title, year, mediaType, season, episodes := parseFilename(tt.file)
+		wantType := db.MediaTypeMovie
+		var wantEpisodes []int
+		if tt.isTV {
+			wantType = db.MediaTypeTVShow
+			wantEpisodes = []int{tt.episode}
+		}
+		if title != tt.title || year != tt.year || mediaType != wantType || season != tt.season || fmt.Sprint(episodes) != fmt.Sprint(wantEpisodes) {
+			t.Errorf("parseFilename(%q) = %q, %d, %s, %d, %v; want %q, %d, %s, %d, %v", tt.file,
+				title, year, mediaType, season, episodes,
+				tt.title, tt.year, wantType, tt.season, wantEpisodes)
+		}
+	}
+}
+
+func TestParseFilenameMultiEpisode(t *testing.T) {
+	tests := []struct {
+		file     string
+		title    string
+		season   int
+		episodes []int
+	}{
+		{file: "Firefly.S01E01-E02.mkv", title: "Firefly", season: 1, episodes: []int{1, 2}},
+		{file: "Firefly 1x01-1x02.mkv", title: "Firefly", season: 1, episodes: []int{1, 2}},
+		{file: "Firefly.S01E05-E05.mkv", title: "Firefly", season: 1, episodes: []int{5}},
+		{file: "Firefly.S01E03.mkv", title: "Firefly", season: 1, episodes: []int{3}},
+	}
+
+	p := NewFilenameParser()
+	for _, tt := range tests {
+		got := p.ParseFilename(tt.file)
+		if got.Title != tt.title || !got.IsTV || got.SeasonNumber != tt.season ||
+			got.EpisodeNumber != tt.episodes[0] || fmt.Sprint(got.EpisodeNumbers) != fmt.Sprint(tt.episodes) {
+			t.Errorf("ParseFilename(%q) = %q, TV %v S%d E%d %v; want %q, TV S%d E%d %v", tt.file,
+				got.Title, got.IsTV, got.SeasonNumber, got.EpisodeNumber, got.EpisodeNumbers,
+				tt.title, tt.season, tt.episodes[0], tt.episodes)
+		}
+
+		title, _, mediaType, season, episodes := parseFilename(tt.file)
+		if title != tt.title || mediaType != db.MediaTypeTVShow || season != tt.season || fmt.Sprint(episodes) != fmt.Sprint(tt.episodes) {
+			t.Errorf("parseFilename(%q) = %q, %s, %d, %v; want %q, %s, %d, %v", tt.file,
+				title, mediaType, season, episodes,
+				tt.title, db.MediaTypeTVShow, tt.season, tt.episodes)
+		}
+	}
+}
+
+func TestParseFilenameAirDate(t *testing.T) {
+	tests := []struct {
+		file    string
+		title   string
+		year    int
+		airDate string
+	}{
+		{file: "The.Daily.Show.2023.05.12.mkv", title: "The Daily Show", airDate: "2023-05-12"},
+		{file: "The Daily Show 2023-05-12.mkv", title: "The Daily Show", airDate: "2023-05-12"},
+		{file: "The.Daily.Show.2023.05.12.720p.WEB.x264-GROUP.mkv", title: "The Daily Show", airDate: "2023-05-12"},
+		// A bare year names a movie
+		{file: "Inception.2010.1080p.mkv", title: "Inception", year: 2010},
+		{file: "Heat (1995).mkv", title: "Heat", year: 1995},
+	}
+
+	p := NewFilenameParser()
+	for _, tt := range tests {
+		isTV := tt.airDate != ""
+		got := p.ParseFilename(tt.file)
+		if got.Title != tt.title || got.Year != tt.year || got.IsTV != isTV || got.AirDate != tt.airDate {
+			t.Errorf("ParseFilename(%q) = %q (%d), TV %v, air date %q; want %q (%d), TV %v, air date %q", tt.file,
+				got.Title, got.Year, got.IsTV, got.AirDate,
+				tt.title, tt.year, isTV, tt.airDate)
+		}
+
+		if airDate := parseAirDate(tt.file); airDate != tt.airDate {
+			t.Errorf("parseAirDate(%q) = %q, want %q", tt.file, airDate, tt.airDate)
+		}
+
+		title, year, mediaType, _, _ := parseFilename(tt.file)
+		wantType := db.MediaTypeMovie
+		if isTV {
+			wantType = db.MediaTypeTVShow
+		}
+		if title != tt.title || year != tt.year || mediaType != wantType {
+			t.Errorf("parseFilename(%q) = %q, %d, %s; want %q, %d, %s", tt.file,
+				title, year, mediaType, tt.title, tt.year, wantType)
+		}
+	}
+}
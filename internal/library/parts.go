@@ -0,0 +1,137 @@
+package library
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// moviePartRegex matches the part of a split movie: "CD1", "Disc 2",
+// "Part 1", "Part.2", "pt1"
+var moviePartRegex = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])(cd|dis[ck]|part|pt)[\s._-]?(\d{1,2})(?:[\])]|[\s._-]|$)`)
+
+// moviePartYearRegex matches a year ahead of a part
+var moviePartYearRegex = regexp.MustCompile(`(?:19|20)\d{2}`)
+
+// moviePart finds the part number in a movie's file name without extension,
+// returning where it starts and ends, or 0 if the movie isn't split. CD and
+// disc numbers always count. "Part 2" is also in titles ("Deathly Hallows
+// Part 2"), so it only counts after the year or at the end of the name.
+func moviePart(name string) (part, start, end int) {
+	matches := moviePartRegex.FindAllStringSubmatchIndex(name, -1)
+	if matches == nil {
+		return 0, 0, 0
+	}
+	loc := matches[len(matches)-1]
+	kind := strings.ToLower(name[loc[2]:loc[3]])
+	if kind == "part" || kind == "pt" {
+		atEnd := strings.TrimRight(name[loc[1]:], " ._-") == ""
+		if !atEnd && !moviePartYearRegex.MatchString(name[:loc[2]]) {
+			return 0, 0, 0
+		}
+	}
+	part, _ = strconv.Atoi(name[loc[4]:loc[5]])
+	if part == 0 {
+		return 0, 0, 0
+	}
+	return part, loc[2], loc[5]
+}
+
+// parseMoviePart returns the part number of a file of a split movie, like 2
+// for "Movie (2001) CD2.avi", or 0 if the file holds a whole movie
+func parseMoviePart(filePath string) int {
+	filename := filepath.Base(filePath)
+	if ext := strings.ToLower(filepath.Ext(filename)); videoExtensions[ext] || ffmpeg.IsDiscImage(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	part, _, _ := moviePart(stripReleaseTags(filename))
+	return part
+}
+
+// addMoviePart files a part of a split movie under the movie of its other
+// parts, returning false if there's none yet and the part should be added as
+// a movie. Files are parts of the same movie when they're in the same folder
+// and parse to the same title and year.
+func (s *Scanner) addMoviePart(filePath, title string, year, part int) (bool, error) {
+	existing, err := s.db.GetMediaByFilePath(filePath)
+	if err == nil {
+		parts, err := s.db.GetMediaParts(existing.ID)
+		if err != nil || len(parts) > 0 {
+			return false, err // a split movie's own file
+		}
+	}
+	if _, err := s.db.GetMediaPartByFilePath(filePath); err == nil {
+		return true, nil
+	}
+
+	movieID, err := s.findMoviePartGroup(filePath, title, year)
+	if err != nil || movieID == 0 {
+		return false, err
+	}
+	movie, err := s.db.GetMediaByID(movieID)
+	if err != nil {
+		return false, err
+	}
+
+	// Parts scanned before they were grouped are movies of their own
+	if existing != nil {
+		if _, err := s.db.DeleteMediaByFilePath(filePath); err != nil {
+			return false, err
+		}
+	}
+	if len(movie.Parts) == 0 {
+		if err := s.db.AddMediaPart(&db.MediaPart{
+			MediaID:    movie.ID,
+			PartNumber: parseMoviePart(movie.FilePath),
+			FilePath:   movie.FilePath,
+			FileSize:   movie.FileSize,
+			Duration:   movie.Duration,
+		}); err != nil {
+			return false, err
+		}
+	}
+
+	mediaFile, err := s.metadataExtractor.ExtractFileMetadata(filePath)
+	if err != nil {
+		return false, err
+	}
+	if err := s.db.AddMediaPart(&db.MediaPart{
+		MediaID:    movie.ID,
+		PartNumber: part,
+		FilePath:   filePath,
+		FileSize:   mediaFile.FileSize,
+		Duration:   mediaFile.Duration,
+	}); err != nil {
+		return false, err
+	}
+
+	log.Printf("Added part %d of movie: %s (%d)", part, movie.Title, movie.Year)
+	return true, nil
+}
+
+// findMoviePartGroup returns the ID of the movie that other parts of the
+// split movie in filePath belong to, or 0 if none of them is in the library
+func (s *Scanner) findMoviePartGroup(filePath, title string, year int) (int64, error) {
+	files, err := s.db.GetMovieFilesInDirectory(filepath.Dir(filePath))
+	if err != nil {
+		return 0, err
+	}
+
+	var movieID int64
+	for path, id := range files {
+		if path == filePath || parseMoviePart(path) == 0 {
+			continue
+		}
+		otherTitle, otherYear, _, _, _ := parseFilename(path)
+		// The earliest movie wins if parts were added as movies of their own
+		if otherTitle == title && otherYear == year && (movieID == 0 || id < movieID) {
+			movieID = id
+		}
+	}
+	return movieID, nil
+}
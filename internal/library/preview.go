@@ -0,0 +1,69 @@
+package library
+
+import (
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// ParsePreview is how the scanner would interpret a file's name
+type ParsePreview struct {
+	Path     string       `json:"path"`
+	Type     db.MediaType `json:"type"` // movie, or tvshow for episodes
+	Title    string       `json:"title"`
+	Year     int          `json:"year,omitempty"`
+	Season   int          `json:"season,omitempty"` // omitted for specials, season 0
+	Episode  int          `json:"episode,omitempty"`
+	Episodes []int        `json:"episodes,omitempty"` // every episode of a multi-episode file
+	AirDate  string       `json:"air_date,omitempty"` // daily shows named by date
+	IsTV     bool         `json:"is_tv"`
+	IMDbID   string       `json:"imdb_id,omitempty"`
+}
+
+// PreviewFilename parses filePath the way a scan would, without probing the
+// file or touching the database
+func PreviewFilename(filePath string) ParsePreview {
+	title, year, mediaType, seasonNum, episodeNums := parseFilename(filePath)
+	preview := ParsePreview{
+		Path:   filePath,
+		Type:   mediaType,
+		Title:  title,
+		Year:   year,
+		IsTV:   mediaType == db.MediaTypeTVShow && len(episodeNums) > 0 && episodeNums[0] > 0,
+		IMDbID: parseIMDbID(filePath),
+	}
+	if airDate := parseAirDate(filePath); !preview.IsTV && mediaType == db.MediaTypeTVShow && airDate != "" {
+		preview.AirDate, preview.IsTV = airDate, true
+	}
+	if !preview.IsTV {
+		if showTitle, showYear, special, ok := parseSpecialEpisode(filePath); ok {
+			preview.Type, preview.Title, preview.Year, preview.IsTV = db.MediaTypeTVShow, showTitle, showYear, true
+			seasonNum, episodeNums = specialsSeason, []int{special}
+		}
+	}
+	if preview.IsTV && len(episodeNums) > 0 {
+		preview.Season = seasonNum
+		preview.Episode = episodeNums[0]
+		if len(episodeNums) > 1 {
+			preview.Episodes = episodeNums
+		}
+	}
+	return preview
+}
+
+// PreviewDirectory lists the files a scan of dir would pick up, at most
+// limit of them, and previews how each is parsed. truncated reports whether
+// files were left out.
+func PreviewDirectory(dir string, limit int) (previews []ParsePreview, truncated bool, err error) {
+	files, err := findMediaFiles(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(files) > limit {
+		files, truncated = files[:limit], true
+	}
+
+	previews = make([]ParsePreview, 0, len(files))
+	for _, file := range files {
+		previews = append(previews, PreviewFilename(file))
+	}
+	return previews, truncated, nil
+}
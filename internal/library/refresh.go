@@ -0,0 +1,179 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/tmdb"
+)
+
+// RefreshOptions scopes a batch metadata refresh. Zero values don't filter.
+type RefreshOptions struct {
+	SourceID    int64
+	Type        db.MediaType // movie or tvshow
+	BypassCache bool         // fetch from TMDB even if responses are cached
+}
+
+// TMDBConfigured reports whether metadata can be fetched at all
+func (s *Scanner) TMDBConfigured() bool {
+	return s.tmdb.IsConfigured()
+}
+
+// StartMetadataRefresh re-fetches TMDB metadata for the matching movies and
+// shows in the background, reporting progress through Status. It returns
+// false without starting anything if a scan or refresh is already running.
+func (s *Scanner) StartMetadataRefresh(opts RefreshOptions) bool {
+	if !s.tryStart(JobMetadataRefresh) {
+		return false
+	}
+	if opts.BypassCache {
+		s.mu.Lock()
+		s.jobCtx = tmdb.BypassCache(s.jobCtx)
+		s.mu.Unlock()
+	}
+
+	go func() {
+		defer s.finish()
+		if err := s.refreshAll(opts); errors.Is(err, context.Canceled) {
+			log.Printf("Metadata refresh stopped")
+		} else if err != nil {
+			log.Printf("Metadata refresh error: %v", err)
+		}
+	}()
+	return true
+}
+
+func (s *Scanner) refreshAll(opts RefreshOptions) error {
+	if opts.SourceID != 0 {
+		source, err := s.db.GetMediaSourceByID(opts.SourceID)
+		if err != nil {
+			return err
+		}
+		s.updateStatus(func(status *ScanStatus) {
+			status.SourceID = source.ID
+			status.SourceName = source.Name
+		})
+	}
+
+	// Media rows hold movies and shows from before tv_shows existed
+	mediaIDs, err := s.db.GetMediaIDs(opts.SourceID, opts.Type)
+	if err != nil {
+		return err
+	}
+	var showIDs []int64
+	if opts.Type != db.MediaTypeMovie {
+		if showIDs, err = s.db.GetTVShowIDs(opts.SourceID); err != nil {
+			return err
+		}
+	}
+
+	s.updateStatus(func(status *ScanStatus) {
+		status.FilesFound = len(mediaIDs) + len(showIDs)
+	})
+	log.Printf("Refreshing metadata for %d items", len(mediaIDs)+len(showIDs))
+
+	for _, id := range mediaIDs {
+		if err := s.context().Err(); err != nil {
+			return err
+		}
+		media, err := s.db.GetMediaByID(id)
+		if err == nil {
+			s.setCurrentItem(media.Title)
+			s.fileMu.Lock()
+			s.refreshMetadata(media)
+			s.fileMu.Unlock()
+		}
+		s.itemDone()
+	}
+
+	for _, id := range showIDs {
+		if err := s.context().Err(); err != nil {
+			return err
+		}
+		show, err := s.db.GetTVShowByID(id)
+		if err == nil {
+			s.setCurrentItem(show.Title)
+			s.fileMu.Lock()
+			s.refreshShowMetadata(show)
+			s.fileMu.Unlock()
+		}
+		s.itemDone()
+	}
+
+	s.setCurrentItem("")
+	log.Printf("Metadata refresh complete")
+	return nil
+}
+
+// startSourceStatus reports a source being scanned. Files found add up over
+// the sources of a scan, as they're only known once each source is walked.
+func (s *Scanner) startSourceStatus(source *db.MediaSource, files int) {
+	s.updateStatus(func(status *ScanStatus) {
+		status.SourceID = source.ID
+		status.SourceName = source.Name
+		status.FilesFound += files
+	})
+}
+
+func (s *Scanner) setCurrentItem(name string) {
+	s.updateStatus(func(status *ScanStatus) {
+		status.CurrentFile = name
+	})
+}
+
+func (s *Scanner) itemDone() {
+	s.updateStatus(func(status *ScanStatus) {
+		status.FilesScanned++
+	})
+}
+
+// refreshShowMetadata updates a TV show with TMDB data, looking it up by its
+// TMDB ID when it has one and by title otherwise
+func (s *Scanner) refreshShowMetadata(show *db.TVShow) {
+	if !s.tmdb.IsConfigured() {
+		return
+	}
+
+	tmdbID := show.TMDbID
+	if tmdbID == 0 {
+		result, err := s.tmdb.SearchTVContext(s.context(), show.Title, show.Year)
+		if err != nil || result == nil {
+			return
+		}
+		tmdbID = result.ID
+	}
+
+	details, err := s.tmdb.GetTVDetailsContext(s.context(), tmdbID)
+	if err != nil {
+		log.Printf("TMDB TV details failed for %s: %v", show.Title, err)
+		return
+	}
+
+	updated := *show
+	updated.Title = details.Name
+	updated.OriginalTitle = details.OriginalName
+	updated.Overview = details.Overview
+	updated.Rating = details.VoteAverage
+	updated.Genres = tmdb.GenresToString(details.Genres)
+	updated.TMDbID = details.ID
+	updated.Status = details.Status
+	updated.PosterPath, updated.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
+	if details.ExternalIDs != nil {
+		updated.IMDbID = details.ExternalIDs.IMDbID
+	}
+	if len(details.FirstAirDate) >= 4 {
+		if year, err := strconv.Atoi(details.FirstAirDate[:4]); err == nil {
+			updated.Year = year
+		}
+	}
+
+	if err := s.db.UpdateTVShow(&updated); err != nil {
+		log.Printf("Failed to update metadata for %s: %v", show.Title, err)
+		return
+	}
+	log.Printf("Updated metadata for show: %s (%d)", updated.Title, updated.Year)
+	s.storeCredits(db.MediaRef{Type: db.MediaTypeTVShow, ID: show.ID}, CastCredits(details.Credits))
+}
@@ -1,16 +1,21 @@
 package library
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
 	"github.com/stephencjuliano/media-server/pkg/tmdb"
 )
 
@@ -20,18 +25,50 @@ type Scanner struct {
 	cfg               *config.Config
 	metadataExtractor *MetadataExtractor
 	tmdb              *tmdb.Client
+	images            *tmdb.ImageStore
 	mu                sync.Mutex
 	running           bool
+	status            ScanStatus    // progress of the current or last job, guarded by mu
+	statusChanged     chan struct{} // closed when status changes, guarded by mu
+	fileMu            sync.Mutex    // Serializes file processing between scans and the watcher
+
+	// ctx cancels TMDB requests when the server shuts down; jobCtx, guarded
+	// by mu, also when the running job is stopped
+	ctx       context.Context
+	cancel    context.CancelFunc
+	jobCtx    context.Context
+	jobCancel context.CancelFunc
+
+	// Episode counts per season of shows with absolute episode numbering,
+	// by show ID. Guarded by fileMu and reset by each scan.
+	absoluteSeasons map[int64][]int
+
+	// New files a scan has yet to write, which it inserts in batches (see
+	// batch.go). Guarded by fileMu.
+	batching        bool
+	pendingMovies   []pendingMovie
+	pendingEpisodes []pendingEpisodeFile
+	pendingFiles    map[string]bool
 }
 
-// ScanStatus represents the current scan status
+// Background jobs reported in ScanStatus.Job. Only one runs at a time.
+const (
+	JobScan            = "scan"
+	JobMetadataRefresh = "metadata_refresh"
+	JobCleanup         = "cleanup"
+)
+
+// ScanStatus represents the current scan status. For metadata refreshes the
+// file counts are library items and CurrentFile is the item's title.
 type ScanStatus struct {
 	Running     bool   `json:"running"`
+	Job         string `json:"job,omitempty"`
 	SourceID    int64  `json:"source_id,omitempty"`
 	SourceName  string `json:"source_name,omitempty"`
 	FilesFound  int    `json:"files_found"`
 	FilesScanned int   `json:"files_scanned"`
 	CurrentFile string `json:"current_file,omitempty"`
+	Stopped     bool   `json:"stopped,omitempty"` // the job was stopped before it finished
 }
 
 // Supported video extensions
@@ -48,20 +85,34 @@ var videoExtensions = map[string]bool{
 	".m2ts": true,
 }
 
+// NewTMDBClient creates a TMDB client with the configured rate limit and
+// response cache
+func NewTMDBClient(cfg *config.Config) *tmdb.Client {
+	client := tmdb.NewClient(cfg.TMDbAPIKey, cfg.TMDbRateLimit, cfg.TMDbMaxRetries)
+	if cfg.TMDbCacheTTLHours > 0 {
+		client.EnableCache(cfg.TMDbCacheDir, time.Duration(cfg.TMDbCacheTTLHours)*time.Hour)
+	}
+	return client
+}
+
 // NewScanner creates a new library scanner
 func NewScanner(database *db.DB, cfg *config.Config) *Scanner {
-	tmdbClient := tmdb.NewClient(cfg.TMDbAPIKey)
+	tmdbClient := NewTMDBClient(cfg)
 	if tmdbClient.IsConfigured() {
 		log.Println("TMDB metadata enrichment enabled")
 	} else {
 		log.Println("TMDB API key not configured - metadata enrichment disabled")
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Scanner{
+		ctx:               ctx,
+		cancel:            cancel,
 		db:                database,
 		cfg:               cfg,
 		metadataExtractor: NewMetadataExtractor(cfg.FFmpegPath),
 		tmdb:              tmdbClient,
+		images:            tmdb.NewImageStore(cfg.ImageCacheDir),
 	}
 }
 
@@ -72,32 +123,149 @@ func (s *Scanner) IsRunning() bool {
 	return s.running
 }
 
-// ScanAll scans all enabled media sources
-func (s *Scanner) ScanAll() error {
+// Status returns the progress of the running job, or of the last one
+func (s *Scanner) Status() ScanStatus {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Running = s.running
+	return status
+}
+
+// StatusChanged returns a channel that is closed the next time the job
+// progress changes, including when a job starts or finishes
+func (s *Scanner) StatusChanged() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.statusChanged == nil {
+		s.statusChanged = make(chan struct{})
+	}
+	return s.statusChanged
+}
+
+// notifyStatusLocked wakes StatusChanged waiters. The caller holds mu.
+func (s *Scanner) notifyStatusLocked() {
+	if s.statusChanged != nil {
+		close(s.statusChanged)
+		s.statusChanged = nil
+	}
+}
+
+// updateStatus changes the job progress under the lock
+func (s *Scanner) updateStatus(update func(status *ScanStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.status)
+	s.notifyStatusLocked()
+}
+
+// tryStart marks a job as running, returning false if one already is
+func (s *Scanner) tryStart(job string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.running {
-		s.mu.Unlock()
-		return nil
+		return false
 	}
 	s.running = true
+	s.status = ScanStatus{Job: job}
+	s.jobCtx, s.jobCancel = context.WithCancel(s.ctx)
+	s.notifyStatusLocked()
+	return true
+}
+
+func (s *Scanner) finish() {
+	s.mu.Lock()
+	s.running = false
+	s.status.CurrentFile = ""
+	s.jobCancel()
+	s.jobCtx, s.jobCancel = nil, nil
+	s.notifyStatusLocked()
 	s.mu.Unlock()
+}
+
+// context returns the context for TMDB requests and scan loops: the running
+// job's, or the scanner's when there's none
+func (s *Scanner) context() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobCtx != nil {
+		return s.jobCtx
+	}
+	return s.ctx
+}
+
+// StopJob stops the running scan or metadata refresh after the file or item
+// in progress, cancelling its TMDB requests. It returns false if no job is
+// running.
+func (s *Scanner) StopJob() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return false
+	}
+	s.jobCancel()
+	s.status.Stopped = true
+	s.notifyStatusLocked()
+	return true
+}
 
-	defer func() {
-		s.mu.Lock()
-		s.running = false
-		s.mu.Unlock()
+// Close stops the running job and cancels all TMDB requests, for shutdown
+func (s *Scanner) Close() {
+	s.cancel()
+}
+
+// ScanAll scans all enabled media sources. Files already in the library are
+// only re-probed when they changed on disk, unless force is set.
+func (s *Scanner) ScanAll(force bool) error {
+	if !s.tryStart(JobScan) {
+		return nil
+	}
+	defer s.finish()
+
+	return s.scanAll(force)
+}
+
+// StartScanAll runs ScanAll in the background. It returns false without
+// starting anything if a scan is already in progress.
+func (s *Scanner) StartScanAll(force bool) bool {
+	if !s.tryStart(JobScan) {
+		return false
+	}
+
+	go func() {
+		defer s.finish()
+		if err := s.scanAll(force); errors.Is(err, context.Canceled) {
+			log.Printf("Scan stopped")
+		} else if err != nil {
+			log.Printf("Scan error: %v", err)
+		}
 	}()
+	return true
+}
 
+func (s *Scanner) scanAll(force bool) error {
 	sources, err := s.db.GetAllMediaSources()
 	if err != nil {
 		return err
 	}
 
+	s.backfillDateAdded()
+
+	s.fileMu.Lock()
+	s.absoluteSeasons = nil
+	s.fileMu.Unlock()
+
 	for _, source := range sources {
 		if !source.Enabled {
 			continue
 		}
-		if err := s.ScanSource(source); err != nil {
+		if err := s.context().Err(); err != nil {
+			return err
+		}
+		if err := s.ScanSource(source, force); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
 			log.Printf("Error scanning source %s: %v", source.Name, err)
 		}
 	}
@@ -116,8 +284,10 @@ func isExtrasSource(path string) bool {
 		strings.Contains(lower, "bonus")
 }
 
-// ScanSource scans a single media source
-func (s *Scanner) ScanSource(source *db.MediaSource) error {
+// ScanSource scans a single media source. Files whose size and modification
+// time haven't changed since the last scan are skipped without probing them
+// or looking them up on TMDB; force re-probes and refreshes them all.
+func (s *Scanner) ScanSource(source *db.MediaSource, force bool) error {
 	log.Printf("Scanning source: %s (%s)", source.Name, source.Path)
 
 	// Check if this is an extras source
@@ -134,48 +304,153 @@ func (s *Scanner) ScanSource(source *db.MediaSource) error {
 		return os.ErrInvalid
 	}
 
-	// Find all video files
+	files, err := findMediaFiles(source.Path)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Found %d video files in %s", len(files), source.Name)
+	s.startSourceStatus(source, len(files))
+
+	// New files are written in batches, which a stopped scan still writes
+	s.setBatching(true)
+	defer s.setBatching(false)
+
+	states, err := s.db.GetSourceFileStates(source.ID)
+	if err != nil {
+		return err
+	}
+
+	// Process each file
+	var unchanged int
+	for _, file := range files {
+		if err := s.context().Err(); err != nil {
+			return err
+		}
+		s.setCurrentItem(file)
+		if state, known := states[file]; known {
+			if !force && s.fileUnchanged(file, state) {
+				unchanged++
+				s.itemDone()
+				continue
+			}
+			if err := s.refreshFile(file); err != nil {
+				log.Printf("Error refreshing %s: %v", file, err)
+				s.itemDone()
+				continue
+			}
+		}
+		if err := s.ProcessFile(file, source); err != nil {
+			log.Printf("Error processing %s: %v", file, err)
+		}
+		s.itemDone()
+	}
+	if unchanged > 0 {
+		log.Printf("Skipped %d unchanged files in %s", unchanged, source.Name)
+	}
+	s.setBatching(false)
+
+	// Update last scan time
+	s.db.UpdateMediaSourceLastScan(source.ID)
+
+	return nil
+}
+
+// findMediaFiles lists the video files under root. Ripped discs are listed
+// as their folder, without walking into the disc structure.
+func findMediaFiles(root string) ([]string, error) {
 	var files []string
-	err = filepath.Walk(source.Path, func(path string, info os.FileInfo, err error) error {
+	discs := make(map[string]bool)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
 		}
 		if info.IsDir() {
+			if path != root && ffmpeg.IsDiscStructureDir(info.Name()) {
+				if disc := filepath.Dir(path); !discs[disc] {
+					discs[disc] = true
+					files = append(files, disc)
+				}
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
 		ext := strings.ToLower(filepath.Ext(path))
-		if videoExtensions[ext] {
+		if videoExtensions[ext] || ffmpeg.IsDiscImage(path) {
 			files = append(files, path)
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
+	return files, err
+}
 
-	log.Printf("Found %d video files in %s", len(files), source.Name)
+// ProcessFile adds a single file to the library. It is safe to call while a
+// scan is running: files are processed one at a time, so a scan and the
+// watcher can't both insert the same file. During a scan new files are
+// buffered and written in batches.
+func (s *Scanner) ProcessFile(filePath string, source *db.MediaSource) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
 
-	// Process each file
-	for _, file := range files {
-		if err := s.processFile(file, source); err != nil {
-			log.Printf("Error processing %s: %v", file, err)
-		}
+	if isExtrasSource(source.Path) {
+		return s.processExtraFile(filePath, source)
+	}
+	if s.pendingFiles[filePath] {
+		return nil // waiting for its batch
 	}
+	err := s.processFile(filePath, source)
+	if len(s.pendingFiles) >= scanBatchSize {
+		s.flushPending()
+	}
+	return err
+}
 
-	// Update last scan time
-	s.db.UpdateMediaSourceLastScan(source.ID)
+// RemoveFile drops the library entries of a deleted file, along with seasons
+// and shows it leaves empty. It waits for any file being processed, so a
+// file created and deleted in quick succession doesn't linger.
+func (s *Scanner) RemoveFile(filePath string) (int, error) {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
 
-	return nil
+	s.dropPending(filePath)
+	return s.db.DeleteMediaByFilePath(filePath)
 }
 
 func (s *Scanner) processFile(filePath string, source *db.MediaSource) error {
 	// Parse filename to extract title, year, and season/episode info
-	title, year, mediaType, seasonNum, episodeNum := parseFilename(filePath)
+	title, year, mediaType, seasonNum, episodeNums := parseFilename(filePath)
+
+	// If it's a TV episode with season/episode info, use the TV episode
+	// processor. Season 0 holds the show's specials.
+	if mediaType == db.MediaTypeTVShow && len(episodeNums) > 0 && episodeNums[0] > 0 {
+		return s.processTVEpisode(filePath, source, title, year, seasonNum, episodeNums)
+	}
+
+	// Daily shows are named by air date instead of season and episode
+	if mediaType == db.MediaTypeTVShow && parseAirDate(filePath) != "" {
+		return s.processTVEpisode(filePath, source, title, year, dailySeason, nil)
+	}
+
+	// Specials folders hold season 0 without a season in the file names
+	if showTitle, showYear, special, ok := parseSpecialEpisode(filePath); ok {
+		return s.processTVEpisode(filePath, source, showTitle, showYear, specialsSeason, []int{special})
+	}
+
+	// Sources numbering episodes from the start of the show have no seasons
+	// in their names
+	if source.AbsoluteNumbering && mediaType != db.MediaTypeTVShow {
+		if showTitle, showYear, absolute, ok := parseAbsoluteEpisode(filePath); ok {
+			return s.processTVEpisode(filePath, source, showTitle, showYear, absoluteSeason, []int{absolute})
+		}
+	}
 
-	// If it's a TV episode with season/episode info, use the TV episode processor
-	if mediaType == db.MediaTypeTVShow && seasonNum > 0 && episodeNum > 0 {
-		return s.processTVEpisode(filePath, source, title, year, seasonNum, episodeNum)
+	// Later parts of split movies join the movie of their first part
+	part := parseMoviePart(filePath)
+	if part > 0 {
+		if added, err := s.addMoviePart(filePath, title, year, part); err != nil || added {
+			return err
+		}
 	}
 
 	// Check if already in database (for movies)
@@ -195,6 +470,24 @@ func (s *Scanner) processFile(filePath string, source *db.MediaSource) error {
 		return err
 	}
 
+	// An NFO names the movie better than its file, and its IMDb ID finds it
+	// on TMDB exactly
+	imdbID := parseIMDbID(filePath)
+	nfo := readMovieNFO(filePath)
+	if nfo != nil {
+		var metadata db.TMDBMetadata
+		nfo.applyTo(&metadata)
+		if metadata.Title != "" {
+			title = metadata.Title
+		}
+		if metadata.Year > 0 {
+			year = metadata.Year
+		}
+		if metadata.IMDbID != "" {
+			imdbID = metadata.IMDbID
+		}
+	}
+
 	// Create media entry with basic info (for movies)
 	media := &db.Media{
 		MediaFile: *mediaFile,
@@ -207,12 +500,43 @@ func (s *Scanner) processFile(filePath string, source *db.MediaSource) error {
 	media.SourceID = source.ID
 
 	// Enrich with TMDB metadata if available
-	s.enrichWithTMDB(media, title, year, mediaType)
+	credits := s.enrichWithTMDB(media, title, year, mediaType, imdbID)
+	if nfo != nil {
+		nfo.applyTo(&media.TMDBMetadata)
+	}
+
+	// Scans write new movies in batches. Split movies are grouped by the
+	// parts already in the library, so parts are written right away.
+	if s.batching && part == 0 {
+		s.queueMovie(media, credits)
+		return nil
+	}
 
 	created, err := s.db.CreateMedia(media)
 	if err != nil {
 		return err
 	}
+	if part > 0 {
+		if err := s.db.AddMediaPart(&db.MediaPart{
+			MediaID:    created.ID,
+			PartNumber: part,
+			FilePath:   created.FilePath,
+			FileSize:   created.FileSize,
+			Duration:   created.Duration,
+		}); err != nil {
+			log.Printf("Failed to record part %d of %s: %v", part, created.FilePath, err)
+		}
+	}
+	s.movieAdded(created, credits)
+	return nil
+}
+
+// movieAdded finishes adding a movie once it's in the database
+func (s *Scanner) movieAdded(created *db.Media, credits []db.Credit) {
+	s.storeCredits(db.MediaRef{Type: db.MediaTypeMovie, ID: created.ID}, credits)
+	s.recordDateAdded(db.MediaTypeMovie, created.ID, created.FilePath)
+	s.recordModTime(created.FilePath)
+	s.recordSidecarSubtitles(created.FilePath)
 
 	// Auto-assign to smart sections
 	if err := s.db.AutoAssignMediaToSections(created); err != nil {
@@ -220,14 +544,33 @@ func (s *Scanner) processFile(filePath string, source *db.MediaSource) error {
 	}
 
 	log.Printf("Added movie: %s (%d)", created.Title, created.Year)
-	return nil
 }
 
-// processTVEpisode handles TV show episode files with proper hierarchy
-func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, showTitle string, year, seasonNum, episodeNum int) error {
-	// Check if episode already exists by file path
-	if _, err := s.db.GetEpisodeByFilePath(filePath); err == nil {
-		return nil // Already exists
+// processTVEpisode handles TV show episode files with proper hierarchy. A
+// multi-episode file gets an episode row for each of its episodes, all
+// playing the same file.
+// A seasonNum of absoluteSeason means episodeNums are absolute numbers,
+// which are mapped onto the show's seasons, and dailySeason means the
+// episode is found by the air date in its name. Season 0 holds specials.
+func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, showTitle string, year, seasonNum int, episodeNums []int) error {
+	// Check if episode already exists by file path. Multi-episode files
+	// scanned before their ranges were parsed only have their first episode,
+	// so carry on while any of the others are missing. Absolute numbers can't
+	// be checked before they're mapped, and their files hold one episode.
+	if existing, err := s.db.GetEpisodeByFilePath(filePath); err == nil {
+		complete := true
+		for _, episodeNum := range episodeNums {
+			if seasonNum == absoluteSeason {
+				break
+			}
+			if !s.episodeFileExists(existing.TVShowID, seasonNum, episodeNum, filePath) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return nil // Already exists
+		}
 	}
 
 	// Extract file metadata using the metadata extractor
@@ -237,23 +580,43 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 		return err
 	}
 
+	// A tvshow.nfo names the show better than the file, and its IMDb ID
+	// finds it on TMDB exactly
+	showNFO := readShowNFO(filePath)
+	var showIMDbID string
+	if showNFO != nil {
+		var metadata db.TMDBMetadata
+		showNFO.applyTo(&metadata)
+		if metadata.Title != "" {
+			showTitle = metadata.Title
+		}
+		if metadata.Year > 0 {
+			year = metadata.Year
+		}
+		showIMDbID = metadata.IMDbID
+	}
+
 	// Try to find or create the TV show
 	var show *db.TVShow
 	var tmdbShowID int
 
 	if s.tmdb.IsConfigured() {
-		// Search TMDB for the show
-		result, err := s.tmdb.SearchTV(showTitle, year)
-		if err != nil {
-			log.Printf("TMDB TV search failed for %s: %v", showTitle, err)
-		} else if result != nil {
-			tmdbShowID = result.ID
-
+		tmdbShowID = s.findByIMDbID(showIMDbID, db.MediaTypeTVShow)
+		if tmdbShowID == 0 {
+			// Search TMDB for the show
+			result, err := s.tmdb.SearchTVContext(s.context(), showTitle, year)
+			if err != nil {
+				log.Printf("TMDB TV search failed for %s: %v", showTitle, err)
+			} else if result != nil {
+				tmdbShowID = result.ID
+			}
+		}
+		if tmdbShowID != 0 {
 			// Check if we already have this show by TMDB ID
 			show, err = s.db.GetTVShowByTMDBID(tmdbShowID)
 			if err != nil {
 				// Show doesn't exist, get full details and create it
-				details, err := s.tmdb.GetTVDetails(tmdbShowID)
+				details, err := s.tmdb.GetTVDetailsContext(s.context(), tmdbShowID)
 				if err != nil {
 					log.Printf("TMDB TV details failed for %s: %v", showTitle, err)
 				} else {
@@ -267,16 +630,18 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 						OriginalTitle: details.OriginalName,
 						Year:         showYear,
 						Overview:     details.Overview,
-						PosterPath:   details.PosterPath,
-						BackdropPath: details.BackdropPath,
 						Rating:       details.VoteAverage,
 						Genres:       tmdb.GenresToString(details.Genres),
 						TMDbID:       details.ID,
 						Status:       details.Status,
 					}
+					show.PosterPath, show.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
 					if details.ExternalIDs != nil {
 						show.IMDbID = details.ExternalIDs.IMDbID
 					}
+					if showNFO != nil {
+						showNFO.applyToShow(show)
+					}
 
 					show, err = s.db.CreateTVShow(show)
 					if err != nil {
@@ -284,6 +649,7 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 						return err
 					}
 					log.Printf("Created TV show: %s (TMDB ID: %d)", show.Title, show.TMDbID)
+					s.storeCredits(db.MediaRef{Type: db.MediaTypeTVShow, ID: show.ID}, CastCredits(details.Credits))
 				}
 			}
 		}
@@ -299,6 +665,9 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 				Title: showTitle,
 				Year:  year,
 			}
+			if showNFO != nil {
+				showNFO.applyToShow(show)
+			}
 			show, err = s.db.CreateTVShow(show)
 			if err != nil {
 				log.Printf("Failed to create TV show %s: %v", showTitle, err)
@@ -308,6 +677,28 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 		}
 	}
 
+	// Season and episode numbers follow the show's episode order
+	metadata := s.episodeMetadataFor(show, tmdbShowID)
+
+	if seasonNum == absoluteSeason {
+		var episodeNum int
+		seasonNum, episodeNum = absoluteToSeason(s.seasonLengths(show, metadata), episodeNums[0])
+		log.Printf("Mapped absolute episode %d of %s to S%02dE%02d", episodeNums[0], show.Title, seasonNum, episodeNum)
+		episodeNums = []int{episodeNum}
+	}
+
+	// Episodes TMDB can't place by air date are numbered by the date itself
+	var airDate string
+	if seasonNum == dailySeason {
+		airDate = parseAirDate(filePath)
+		season, episodeNum, ok := metadata.episodeByAirDate(airDate)
+		if !ok {
+			season, episodeNum = dailyEpisodeNumber(airDate)
+		}
+		log.Printf("Mapped %s episode of %s to S%02dE%02d", airDate, show.Title, season, episodeNum)
+		seasonNum, episodeNums = season, []int{episodeNum}
+	}
+
 	// Find or create the season
 	season, err := s.db.GetSeasonByNumber(show.ID, seasonNum)
 	if err != nil {
@@ -315,18 +706,20 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 		var seasonName, seasonOverview, seasonPoster, seasonAirDate string
 		var seasonEpisodeCount int
 
-		if s.tmdb.IsConfigured() && tmdbShowID > 0 {
-			seasonDetails, err := s.tmdb.GetTVSeasonDetails(tmdbShowID, seasonNum)
-			if err == nil && seasonDetails != nil {
+		if s.tmdb.IsConfigured() {
+			seasonDetails := metadata.season(seasonNum)
+			if seasonDetails != nil {
 				seasonName = seasonDetails.Name
 				seasonOverview = seasonDetails.Overview
-				seasonPoster = seasonDetails.PosterPath
+				seasonPoster = s.storeImage(tmdb.PosterSize, seasonDetails.PosterPath)
 				seasonAirDate = seasonDetails.AirDate
 				seasonEpisodeCount = len(seasonDetails.Episodes)
 			}
 		}
 
-		if seasonName == "" {
+		if seasonName == "" && seasonNum == specialsSeason {
+			seasonName = specialsSeasonName
+		} else if seasonName == "" {
 			seasonName = "Season " + strconv.Itoa(seasonNum)
 		}
 
@@ -347,54 +740,111 @@ func (s *Scanner) processTVEpisode(filePath string, source *db.MediaSource, show
 		log.Printf("Created season: %s S%02d", show.Title, seasonNum)
 	}
 
-	// Get episode details from TMDB if available
-	var episodeTitle, episodeOverview, episodeStillPath, episodeAirDate string
-	var episodeRuntime int
-	var episodeRating float64
+	episodeNFO := readEpisodeNFO(filePath)
+	var queued []*db.Episode
+	for _, episodeNum := range episodeNums {
+		if s.episodeFileExists(show.ID, seasonNum, episodeNum, filePath) {
+			continue
+		}
 
-	if s.tmdb.IsConfigured() && tmdbShowID > 0 {
-		episodeDetails, err := s.tmdb.GetTVEpisodeDetails(tmdbShowID, seasonNum, episodeNum)
-		if err == nil && episodeDetails != nil {
-			episodeTitle = episodeDetails.Name
-			episodeOverview = episodeDetails.Overview
-			episodeStillPath = episodeDetails.StillPath
-			episodeAirDate = episodeDetails.AirDate
-			episodeRuntime = episodeDetails.Runtime
-			episodeRating = episodeDetails.VoteAverage
+		// Get episode details from TMDB if available
+		var episodeTitle, episodeOverview, episodeStillPath, episodeAirDate string
+		var episodeRuntime int
+		var episodeRating float64
+
+		if s.tmdb.IsConfigured() {
+			episodeDetails := metadata.episode(seasonNum, episodeNum)
+			if episodeDetails != nil {
+				episodeTitle = episodeDetails.Name
+				episodeOverview = episodeDetails.Overview
+				episodeStillPath = s.storeImage(tmdb.StillSize, episodeDetails.StillPath)
+				episodeAirDate = episodeDetails.AirDate
+				episodeRuntime = episodeDetails.Runtime
+				episodeRating = episodeDetails.VoteAverage
+			}
 		}
-	}
 
-	if episodeTitle == "" {
-		episodeTitle = "Episode " + strconv.Itoa(episodeNum)
-	}
+		// The NFO of a single-episode file wins over TMDB
+		if episodeNFO != nil && len(episodeNums) == 1 {
+			var metadata db.TMDBMetadata
+			episodeNFO.applyTo(&metadata)
+			if metadata.Title != "" {
+				episodeTitle = metadata.Title
+			}
+			if metadata.Overview != "" {
+				episodeOverview = metadata.Overview
+			}
+			if metadata.Rating > 0 {
+				episodeRating = metadata.Rating
+			}
+			if aired := strings.TrimSpace(episodeNFO.Aired); aired != "" {
+				episodeAirDate = aired
+			}
+		}
 
-	// Create the episode record
-	episode := &db.Episode{
-		MediaFile:     *mediaFile,
-		TVShowID:      show.ID,
-		SeasonID:      season.ID,
-		SeasonNumber:  seasonNum,
-		EpisodeNumber: episodeNum,
-		Title:         episodeTitle,
-		Overview:      episodeOverview,
-		StillPath:     episodeStillPath,
-		AirDate:       episodeAirDate,
-		Runtime:       episodeRuntime,
-		Rating:        episodeRating,
-	}
-	episode.SourceID = source.ID
+		if episodeTitle == "" && airDate != "" {
+			episodeTitle = airDate
+		} else if episodeTitle == "" {
+			episodeTitle = "Episode " + strconv.Itoa(episodeNum)
+		}
+		if episodeAirDate == "" {
+			episodeAirDate = airDate
+		}
 
-	_, err = s.db.CreateEpisode(episode)
-	if err != nil {
-		log.Printf("Failed to create episode S%02dE%02d for %s: %v", seasonNum, episodeNum, show.Title, err)
-		return err
-	}
+		// Create the episode record
+		episode := &db.Episode{
+			MediaFile:     *mediaFile,
+			TVShowID:      show.ID,
+			SeasonID:      season.ID,
+			SeasonNumber:  seasonNum,
+			EpisodeNumber: episodeNum,
+			Title:         episodeTitle,
+			Overview:      episodeOverview,
+			StillPath:     episodeStillPath,
+			AirDate:       episodeAirDate,
+			Runtime:       episodeRuntime,
+			Rating:        episodeRating,
+		}
+		episode.SourceID = source.ID
+
+		// Scans write new episodes in batches
+		if s.batching {
+			queued = append(queued, episode)
+			continue
+		}
 
-	log.Printf("Added episode: %s S%02dE%02d - %s", show.Title, seasonNum, episodeNum, episodeTitle)
+		created, err := s.db.CreateEpisode(episode)
+		if err != nil {
+			log.Printf("Failed to create episode S%02dE%02d for %s: %v", seasonNum, episodeNum, show.Title, err)
+			return err
+		}
+		s.episodeAdded(created, show.Title)
+	}
+	if len(queued) > 0 {
+		s.queueEpisodes(filePath, show.Title, queued)
+		return nil
+	}
+	s.recordModTime(filePath)
+	s.recordSidecarSubtitles(filePath)
 	return nil
 }
 
-// refreshMetadata updates an existing media item with TMDB data
+// episodeAdded finishes adding an episode once it's in the database
+func (s *Scanner) episodeAdded(created *db.Episode, showTitle string) {
+	s.recordDateAdded(db.MediaTypeEpisode, created.ID, created.FilePath)
+
+	log.Printf("Added episode: %s S%02dE%02d - %s", showTitle, created.SeasonNumber, created.EpisodeNumber, created.Title)
+}
+
+// episodeFileExists reports whether a show's episode is already in the
+// library for filePath
+func (s *Scanner) episodeFileExists(showID int64, seasonNum, episodeNum int, filePath string) bool {
+	episode, err := s.db.GetEpisodeByNumber(showID, seasonNum, episodeNum)
+	return err == nil && episode.FilePath == filePath
+}
+
+// refreshMetadata updates an existing media item with TMDB data, looking it
+// up by its TMDB ID when it has one and by title otherwise
 func (s *Scanner) refreshMetadata(media *db.Media) {
 	if !s.tmdb.IsConfigured() {
 		return
@@ -413,14 +863,25 @@ func (s *Scanner) refreshMetadata(media *db.Media) {
 
 	// Create a copy to update
 	updated := *media
+	var credits []db.Credit
 
 	if media.Type == db.MediaTypeMovie {
-		result, err := s.tmdb.SearchMovie(title, year)
-		if err != nil || result == nil {
-			return
+		tmdbID := media.TMDbID
+		if tmdbID == 0 && media.IMDbID != "" {
+			tmdbID = s.findByIMDbID(media.IMDbID, media.Type)
+		}
+		if tmdbID == 0 {
+			tmdbID = s.findByIMDbID(parseIMDbID(media.FilePath), media.Type)
+		}
+		if tmdbID == 0 {
+			result, err := s.tmdb.SearchMovieContext(s.context(), title, year)
+			if err != nil || result == nil {
+				return
+			}
+			tmdbID = result.ID
 		}
 
-		details, err := s.tmdb.GetMovieDetails(result.ID)
+		details, err := s.tmdb.GetMovieDetailsContext(s.context(), tmdbID)
 		if err != nil {
 			return
 		}
@@ -428,27 +889,39 @@ func (s *Scanner) refreshMetadata(media *db.Media) {
 		updated.Title = details.Title
 		updated.OriginalTitle = details.OriginalTitle
 		updated.Overview = details.Overview
-		updated.PosterPath = details.PosterPath
-		updated.BackdropPath = details.BackdropPath
 		updated.Rating = details.VoteAverage
 		updated.Runtime = details.Runtime
 		updated.TMDbID = details.ID
 		updated.IMDbID = details.IMDbID
 		updated.Genres = tmdb.GenresToString(details.Genres)
+		updated.PosterPath, updated.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
 
 		if len(details.ReleaseDate) >= 4 {
 			if y, err := strconv.Atoi(details.ReleaseDate[:4]); err == nil {
 				updated.Year = y
 			}
 		}
+		credits = CastCredits(details.Credits)
+
+		// NFO values were set by hand, so they win over TMDB's
+		if nfo := readMovieNFO(media.FilePath); nfo != nil {
+			nfo.applyTo(&updated.TMDBMetadata)
+		}
 
 	} else if media.Type == db.MediaTypeTVShow {
-		result, err := s.tmdb.SearchTV(title, year)
-		if err != nil || result == nil {
-			return
+		tmdbID := media.TMDbID
+		if tmdbID == 0 {
+			tmdbID = s.findByIMDbID(parseIMDbID(media.FilePath), media.Type)
+		}
+		if tmdbID == 0 {
+			result, err := s.tmdb.SearchTVContext(s.context(), title, year)
+			if err != nil || result == nil {
+				return
+			}
+			tmdbID = result.ID
 		}
 
-		details, err := s.tmdb.GetTVDetails(result.ID)
+		details, err := s.tmdb.GetTVDetailsContext(s.context(), tmdbID)
 		if err != nil {
 			return
 		}
@@ -456,13 +929,12 @@ func (s *Scanner) refreshMetadata(media *db.Media) {
 		updated.Title = details.Name
 		updated.OriginalTitle = details.OriginalName
 		updated.Overview = details.Overview
-		updated.PosterPath = details.PosterPath
-		updated.BackdropPath = details.BackdropPath
 		updated.Rating = details.VoteAverage
 		updated.SeasonCount = details.NumberOfSeasons
 		updated.EpisodeCount = details.NumberOfEpisodes
 		updated.TMDbID = details.ID
 		updated.Genres = tmdb.GenresToString(details.Genres)
+		updated.PosterPath, updated.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
 
 		if details.ExternalIDs != nil {
 			updated.IMDbID = details.ExternalIDs.IMDbID
@@ -480,44 +952,51 @@ func (s *Scanner) refreshMetadata(media *db.Media) {
 		log.Printf("Failed to update metadata for %s: %v", title, err)
 	} else {
 		log.Printf("Updated metadata for: %s (%d)", updated.Title, updated.Year)
+		s.storeCredits(db.MediaRef{Type: db.MediaTypeMovie, ID: updated.ID}, credits)
 	}
 }
 
-// enrichWithTMDB fetches and applies metadata from TMDB
-func (s *Scanner) enrichWithTMDB(media *db.Media, title string, year int, mediaType db.MediaType) {
+// enrichWithTMDB fetches and applies metadata from TMDB. An IMDb ID from
+// the file name or its NFO identifies the title exactly; otherwise it's
+// searched for by title and year. A movie's cast is returned to store once
+// it's created.
+func (s *Scanner) enrichWithTMDB(media *db.Media, title string, year int, mediaType db.MediaType, imdbID string) []db.Credit {
 	if !s.tmdb.IsConfigured() {
-		return
+		return nil
 	}
 
 	if mediaType == db.MediaTypeMovie {
-		// Search for movie
-		result, err := s.tmdb.SearchMovie(title, year)
-		if err != nil {
-			log.Printf("TMDB search failed for %s: %v", title, err)
-			return
-		}
-		if result == nil {
-			return
+		tmdbID := s.findByIMDbID(imdbID, mediaType)
+		if tmdbID == 0 {
+			// Search for movie
+			result, err := s.tmdb.SearchMovieContext(s.context(), title, year)
+			if err != nil {
+				log.Printf("TMDB search failed for %s: %v", title, err)
+				return nil
+			}
+			if result == nil {
+				return nil
+			}
+			tmdbID = result.ID
 		}
 
 		// Get detailed info
-		details, err := s.tmdb.GetMovieDetails(result.ID)
+		details, err := s.tmdb.GetMovieDetailsContext(s.context(), tmdbID)
 		if err != nil {
 			log.Printf("TMDB details failed for %s: %v", title, err)
-			return
+			return nil
 		}
 
 		// Apply metadata
 		media.Title = details.Title
 		media.OriginalTitle = details.OriginalTitle
 		media.Overview = details.Overview
-		media.PosterPath = details.PosterPath
-		media.BackdropPath = details.BackdropPath
 		media.Rating = details.VoteAverage
 		media.Runtime = details.Runtime
 		media.TMDbID = details.ID
 		media.IMDbID = details.IMDbID
 		media.Genres = tmdb.GenresToString(details.Genres)
+		media.PosterPath, media.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
 
 		// Extract year from release date
 		if len(details.ReleaseDate) >= 4 {
@@ -525,36 +1004,40 @@ func (s *Scanner) enrichWithTMDB(media *db.Media, title string, year int, mediaT
 				media.Year = y
 			}
 		}
+		return CastCredits(details.Credits)
 
 	} else if mediaType == db.MediaTypeTVShow {
-		// Search for TV show
-		result, err := s.tmdb.SearchTV(title, year)
-		if err != nil {
-			log.Printf("TMDB search failed for %s: %v", title, err)
-			return
-		}
-		if result == nil {
-			return
+		tmdbID := s.findByIMDbID(imdbID, mediaType)
+		if tmdbID == 0 {
+			// Search for TV show
+			result, err := s.tmdb.SearchTVContext(s.context(), title, year)
+			if err != nil {
+				log.Printf("TMDB search failed for %s: %v", title, err)
+				return nil
+			}
+			if result == nil {
+				return nil
+			}
+			tmdbID = result.ID
 		}
 
 		// Get detailed info
-		details, err := s.tmdb.GetTVDetails(result.ID)
+		details, err := s.tmdb.GetTVDetailsContext(s.context(), tmdbID)
 		if err != nil {
 			log.Printf("TMDB details failed for %s: %v", title, err)
-			return
+			return nil
 		}
 
 		// Apply metadata
 		media.Title = details.Name
 		media.OriginalTitle = details.OriginalName
 		media.Overview = details.Overview
-		media.PosterPath = details.PosterPath
-		media.BackdropPath = details.BackdropPath
 		media.Rating = details.VoteAverage
 		media.SeasonCount = details.NumberOfSeasons
 		media.EpisodeCount = details.NumberOfEpisodes
 		media.TMDbID = details.ID
 		media.Genres = tmdb.GenresToString(details.Genres)
+		media.PosterPath, media.BackdropPath = s.storeArtwork(details.PosterPath, details.BackdropPath)
 
 		if details.ExternalIDs != nil {
 			media.IMDbID = details.ExternalIDs.IMDbID
@@ -567,34 +1050,143 @@ func (s *Scanner) enrichWithTMDB(media *db.Media, title string, year int, mediaT
 			}
 		}
 	}
+	return nil
+}
+
+// findByIMDbID returns the TMDB ID of the movie or show with an IMDb ID, or
+// 0 if there's no ID, TMDB doesn't know it or it's the other kind of title
+func (s *Scanner) findByIMDbID(imdbID string, mediaType db.MediaType) int {
+	if imdbID == "" {
+		return 0
+	}
+	result, err := s.tmdb.FindByIMDbIDContext(s.context(), imdbID)
+	if err != nil {
+		log.Printf("TMDB find failed for %s: %v", imdbID, err)
+		return 0
+	}
+	switch {
+	case result == nil:
+		return 0
+	case mediaType == db.MediaTypeMovie && result.Movie != nil:
+		return result.Movie.ID
+	case mediaType == db.MediaTypeTVShow && result.TV != nil:
+		return result.TV.ID
+	}
+	return 0
+}
+
+// maxCastCredits is how many top-billed cast members are stored per title
+const maxCastCredits = 20
+
+// CastCredits converts the top-billed cast in TMDB details to credits. It
+// returns nil if the details came without credits, so stored ones are kept.
+func CastCredits(credits *tmdb.Credits) []db.Credit {
+	if credits == nil {
+		return nil
+	}
+	cast := make([]tmdb.CastMember, len(credits.Cast))
+	copy(cast, credits.Cast)
+	sort.SliceStable(cast, func(i, j int) bool { return cast[i].Order < cast[j].Order })
+	if len(cast) > maxCastCredits {
+		cast = cast[:maxCastCredits]
+	}
+
+	result := make([]db.Credit, len(cast))
+	for i, member := range cast {
+		result[i] = db.Credit{
+			PersonID:    member.ID,
+			Name:        member.Name,
+			Character:   member.Character,
+			ProfilePath: member.ProfilePath,
+			Order:       member.Order,
+		}
+	}
+	return result
+}
+
+// storeCredits replaces the cast of a movie or show. It's best effort like
+// artwork; nil credits leave the stored cast alone.
+func (s *Scanner) storeCredits(ref db.MediaRef, credits []db.Credit) {
+	if credits == nil {
+		return
+	}
+	if err := s.db.ReplaceCredits(ref, credits); err != nil {
+		log.Printf("Failed to store credits for %s: %v", ref, err)
+	}
+}
+
+// storeArtwork downloads a poster and backdrop so they're served locally,
+// returning the paths to store for them (see storeImage)
+func (s *Scanner) storeArtwork(posterPath, backdropPath string) (string, string) {
+	return s.storeImage(tmdb.PosterSize, posterPath), s.storeImage(tmdb.BackdropSize, backdropPath)
+}
+
+// storeImage downloads a TMDB image to the image cache and returns the local
+// /api/images URL to store for it. It's best effort: a failed download is
+// logged and the TMDB path is returned, so clients fall back to TMDB's copy.
+func (s *Scanner) storeImage(size, remotePath string) string {
+	if remotePath == "" {
+		return ""
+	}
+	if _, err := s.images.Fetch(s.context(), size, remotePath); err != nil {
+		log.Printf("Failed to store TMDB image %s: %v", remotePath, err)
+		return remotePath
+	}
+	return tmdb.LocalImageURL(size, remotePath)
 }
 
-// parseFilename extracts title, year, type, and season/episode numbers from filename
-func parseFilename(filePath string) (title string, year int, mediaType db.MediaType, seasonNum int, episodeNum int) {
+// imdbIDRegex matches IMDb IDs in file names, like "tt0084787"; imdbIDTagRegex
+// also matches brackets around them, to strip from titles
+var (
+	imdbIDRegex    = regexp.MustCompile(`tt\d{7,8}`)
+	imdbIDTagRegex = regexp.MustCompile(`[\[({]?tt\d{7,8}[\])}]?`)
+)
+
+// parseIMDbID returns the IMDb ID in a file's name, or "" if it has none
+func parseIMDbID(filePath string) string {
+	return imdbIDRegex.FindString(filepath.Base(filePath))
+}
+
+// parseFilename extracts title, year, type, and season/episode numbers from
+// filename. Multi-episode files (S01E01-E02) yield every episode they hold.
+func parseFilename(filePath string) (title string, year int, mediaType db.MediaType, seasonNum int, episodeNums []int) {
 	filename := filepath.Base(filePath)
-	filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	// Only strip real extensions: disc folders like "The.Matrix.1999" have none
+	if ext := strings.ToLower(filepath.Ext(filename)); videoExtensions[ext] || ffmpeg.IsDiscImage(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	filename = stripReleaseTags(filename)
 
 	// Extract season/episode FIRST before any cleanup
 	// Match S01E01 format (case insensitive)
 	tvRegex := regexp.MustCompile(`(?i)[Ss](\d{1,2})[Ee](\d{1,2})`)
-	tvMatch := tvRegex.FindStringSubmatch(filename)
-	if len(tvMatch) == 3 {
+	if loc := tvRegex.FindStringSubmatchIndex(filename); loc != nil {
 		mediaType = db.MediaTypeTVShow
-		seasonNum, _ = strconv.Atoi(tvMatch[1])
-		episodeNum, _ = strconv.Atoi(tvMatch[2])
+		seasonNum, _ = strconv.Atoi(filename[loc[2]:loc[3]])
+		first, _ := strconv.Atoi(filename[loc[4]:loc[5]])
+		episodeNums, loc[1] = multiEpisodeNumbers(filename, loc[1], seasonNum, first)
 		// Remove pattern from filename for title extraction
-		filename = tvRegex.ReplaceAllString(filename, " ")
+		filename = tvRegex.ReplaceAllString(filename[:loc[0]]+" "+filename[loc[1]:], " ")
 	}
 
 	// Also support 1x01 format
-	if seasonNum == 0 {
+	if mediaType == "" {
 		altRegex := regexp.MustCompile(`(\d{1,2})x(\d{1,2})`)
-		altMatch := altRegex.FindStringSubmatch(filename)
-		if len(altMatch) == 3 {
+		if loc := altRegex.FindStringSubmatchIndex(filename); loc != nil {
+			mediaType = db.MediaTypeTVShow
+			seasonNum, _ = strconv.Atoi(filename[loc[2]:loc[3]])
+			first, _ := strconv.Atoi(filename[loc[4]:loc[5]])
+			episodeNums, loc[1] = multiEpisodeNumbers(filename, loc[1], seasonNum, first)
+			filename = altRegex.ReplaceAllString(filename[:loc[0]]+" "+filename[loc[1]:], " ")
+		}
+	}
+
+	// Daily shows are named by air date, "The.Daily.Show.2023.05.12"; the
+	// show's name comes before it
+	if mediaType == "" {
+		if airDate, start, _ := findAirDate(filename); airDate != "" && start > 0 {
 			mediaType = db.MediaTypeTVShow
-			seasonNum, _ = strconv.Atoi(altMatch[1])
-			episodeNum, _ = strconv.Atoi(altMatch[2])
-			filename = altRegex.ReplaceAllString(filename, " ")
+			filename = filename[:start]
 		}
 	}
 
@@ -603,22 +1195,32 @@ func parseFilename(filePath string) (title string, year int, mediaType db.MediaT
 		mediaType = db.MediaTypeMovie
 	}
 
+	// Parts of split movies ("CD1", "Part 2") are titled like the whole movie
+	if mediaType == db.MediaTypeMovie {
+		if part, start, end := moviePart(filename); part > 0 {
+			filename = filename[:start] + " " + filename[end:]
+		}
+	}
+
+	// IMDb IDs aren't part of the title (see parseIMDbID)
+	filename = imdbIDTagRegex.ReplaceAllString(filename, " ")
+
 	// Remove quality indicators FIRST (before separators become spaces)
 	// This prevents "1080p" from being parsed as year "1080"
-	qualityRegex := regexp.MustCompile(`(?i)[\.\s_-]?(1080p|720p|480p|2160p|4k|uhd|hdr|bluray|bdrip|webrip|web-dl|hdtv|dvdrip|x264|x265|hevc|h264|h265|aac|ac3|dts|HD)[\.\s_-]?`)
+	qualityRegex := regexp.MustCompile(`(?i)[\.\s_-]?(1080p|720p|480p|2160p|4k|uhd|hdr|bluray|bdrip|webrip|web-dl|hdtv|dvdrip|xvid|divx|x264|x265|hevc|h264|h265|aac|ac3|dts|HD)[\.\s_-]?`)
 	filename = qualityRegex.ReplaceAllString(filename, " ")
 
-	// Replace common separators with spaces
+	// Replace common separators with spaces, keeping hyphens within words
 	filename = strings.ReplaceAll(filename, ".", " ")
 	filename = strings.ReplaceAll(filename, "_", " ")
-	filename = strings.ReplaceAll(filename, "-", " ")
+	filename = replaceSeparatorDashes(filename)
 
 	// Remove parenthetical quality/format info like "(1080p HD)" or "(HD)"
 	parenQualityRegex := regexp.MustCompile(`\([^)]*(?:1080|720|480|2160|HD|p)[^)]*\)`)
 	filename = parenQualityRegex.ReplaceAllString(filename, "")
 
 	// Look for year pattern - only match realistic movie years (1900-2099)
-	yearRegex := regexp.MustCompile(`\(?(19\d{2}|20\d{2})\)?`)
+	yearRegex := regexp.MustCompile(`[(\[]?(19\d{2}|20\d{2})[)\]]?`)
 	yearMatch := yearRegex.FindStringSubmatch(filename)
 	if len(yearMatch) > 0 {
 		year, _ = strconv.Atoi(yearMatch[1])
@@ -637,7 +1239,7 @@ func parseFilename(filePath string) (title string, year int, mediaType db.MediaT
 
 	// Remove trailing episode titles for cleaner show name extraction
 	// e.g., "Breaking Bad Pilot" -> "Breaking Bad"
-	if mediaType == db.MediaTypeTVShow && seasonNum > 0 {
+	if mediaType == db.MediaTypeTVShow {
 		// Try to get just the show name by looking for common patterns
 		// This helps with files like "Breaking.Bad.S01E01.Pilot.mkv"
 		words := strings.Fields(title)
@@ -661,3 +1263,85 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// recordDateAdded stores the file's modification time as the item's added
+// date, so "recently added" reflects the file rather than the row and
+// survives rebuilding the database
+func (s *Scanner) recordDateAdded(mediaType db.MediaType, id int64, filePath string) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	if err := s.db.SetDateAdded(mediaType, id, info.ModTime()); err != nil {
+		log.Printf("Failed to record added date for %s: %v", filePath, err)
+	}
+}
+
+// recordModTime stores the file's modification time, so the next scan can
+// tell whether it changed
+func (s *Scanner) recordModTime(filePath string) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	if err := s.db.SetFileModTime(filePath, info.ModTime()); err != nil {
+		log.Printf("Failed to record modification time for %s: %v", filePath, err)
+	}
+}
+
+// fileUnchanged reports whether a file in the library still has its recorded
+// size and modification time. Disc folders are compared by modification time
+// alone, as their recorded size is the main title's. Files scanned before
+// modification times were recorded get theirs now if their size matches.
+func (s *Scanner) fileUnchanged(filePath string, state db.FileState) bool {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	if !info.IsDir() && info.Size() != state.FileSize {
+		return false
+	}
+	if state.ModTime == 0 {
+		if err := s.db.SetFileModTime(filePath, info.ModTime()); err != nil {
+			log.Printf("Failed to record modification time for %s: %v", filePath, err)
+		}
+		return true
+	}
+	return info.ModTime().Unix() == state.ModTime
+}
+
+// refreshFile re-probes a file already in the library that changed on disk
+// and updates the items stored in it
+func (s *Scanner) refreshFile(filePath string) error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	mediaFile, err := s.metadataExtractor.ExtractFileMetadata(filePath)
+	if err != nil {
+		return err
+	}
+	if err := s.db.UpdateFileMetadata(mediaFile); err != nil {
+		return err
+	}
+	s.recordModTime(filePath)
+	s.recordSidecarSubtitles(filePath)
+	return nil
+}
+
+// backfillDateAdded records added dates for items scanned before they were
+// tracked. Files that can't be stat'ed are left for the next pass.
+func (s *Scanner) backfillDateAdded() {
+	files, err := s.db.GetUndatedFiles()
+	if err != nil {
+		log.Printf("Failed to list items without added dates: %v", err)
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	log.Printf("Backfilling added dates for %d items", len(files))
+	for _, f := range files {
+		s.recordDateAdded(f.Type, f.ID, f.FilePath)
+	}
+}
@@ -0,0 +1,68 @@
+package library
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
+)
+
+// specialsSeason is the season TMDB and Kodi keep a show's specials in
+const specialsSeason = 0
+
+// specialsSeasonName names season 0 when TMDB has no name for it
+const specialsSeasonName = "Specials"
+
+var (
+	// specialsFolderRegex matches the folder specials are kept in: "Specials",
+	// "Season 0", "Season 00"
+	specialsFolderRegex = regexp.MustCompile(`(?i)^(?:specials?|season[\s._-]?0+)$`)
+
+	// specialNumberRegex matches the number of a special without a season:
+	// "E01", "Ep 2", "Episode 3", "Special 4", "SP05"
+	specialNumberRegex = regexp.MustCompile(`(?i)(?:^|[\s._-])(?:e|ep|episode|special|sp)[\s._-]?(\d{1,3})(?:[\s._-]|$)`)
+
+	// specialLeadingNumberRegex matches a number starting the name, "01 - Pilot"
+	specialLeadingNumberRegex = regexp.MustCompile(`^(\d{1,3})(?:[\s._-]|$)`)
+)
+
+// parseSpecialEpisode reads the show title, year and episode number of a
+// special in a specials folder without a season in its name, like
+// "Doctor Who (2005)/Specials/The Next Doctor - Special 4.mkv". The show is
+// named by the folder above the specials folder.
+func parseSpecialEpisode(filePath string) (title string, year, episode int, ok bool) {
+	dir := filepath.Dir(filePath)
+	if !specialsFolderRegex.MatchString(strings.TrimSpace(filepath.Base(dir))) {
+		return "", 0, 0, false
+	}
+	showDir := filepath.Dir(dir)
+	if showDir == dir || showDir == "." || showDir == string(filepath.Separator) {
+		return "", 0, 0, false
+	}
+
+	name := filepath.Base(filePath)
+	if ext := strings.ToLower(filepath.Ext(name)); videoExtensions[ext] || ffmpeg.IsDiscImage(name) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name = stripReleaseTags(name)
+
+	m := specialNumberRegex.FindStringSubmatch(name)
+	if m == nil {
+		m = specialLeadingNumberRegex.FindStringSubmatch(name)
+	}
+	if m == nil {
+		return "", 0, 0, false
+	}
+	episode, _ = strconv.Atoi(m[1])
+	if episode == 0 {
+		return "", 0, 0, false
+	}
+
+	title, year, _, _, _ = parseFilename(showDir)
+	if title == "" {
+		return "", 0, 0, false
+	}
+	return title, year, episode, true
+}
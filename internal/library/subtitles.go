@@ -0,0 +1,93 @@
+package library
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stephencjuliano/media-server/internal/db"
+)
+
+// subtitleExtensions are the sidecar subtitle formats picked up next to videos
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".ass": true,
+	".ssa": true,
+	".vtt": true,
+}
+
+// subtitleTags are name parts describing a subtitle that could pass for a
+// language code
+var subtitleTags = map[string]bool{
+	"sdh": true,
+	"cc":  true,
+}
+
+// findSidecarSubtitles lists the subtitle files next to a video that share
+// its name, like "Movie.2019.srt", "Movie.2019.en.srt" or
+// "Movie.2019.en.forced.srt". The language is the first two or three letter
+// part after the video's name.
+func findSidecarSubtitles(videoPath string) []db.ExternalSubtitle {
+	dir := filepath.Dir(videoPath)
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var subtitles []db.ExternalSubtitle
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || !subtitleExtensions[ext] {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if stem != base && !strings.HasPrefix(stem, base+".") {
+			continue
+		}
+
+		subtitle := db.ExternalSubtitle{
+			FilePath: filepath.Join(dir, name),
+			Format:   strings.TrimPrefix(ext, "."),
+		}
+		for _, part := range strings.Split(strings.TrimPrefix(stem, base), ".") {
+			part = strings.ToLower(part)
+			switch {
+			case part == "forced":
+				subtitle.Forced = true
+			case subtitle.Language == "" && isLanguageCode(part):
+				subtitle.Language = part
+			}
+		}
+		subtitles = append(subtitles, subtitle)
+	}
+	return subtitles
+}
+
+// isLanguageCode reports whether a name part looks like an ISO 639 code
+func isLanguageCode(part string) bool {
+	if len(part) < 2 || len(part) > 3 || subtitleTags[part] {
+		return false
+	}
+	for _, r := range part {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// recordSidecarSubtitles stores the subtitle files next to a video for the
+// items it holds. Subtitles added later are picked up once the video changes
+// or by a forced scan.
+func (s *Scanner) recordSidecarSubtitles(filePath string) {
+	if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+		return
+	}
+	if err := s.db.SetExternalSubtitles(filePath, findSidecarSubtitles(filePath)); err != nil {
+		log.Printf("Failed to record subtitles for %s: %v", filePath, err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/stephencjuliano/media-server/internal/config"
 	"github.com/stephencjuliano/media-server/internal/db"
+	"github.com/stephencjuliano/media-server/pkg/ffmpeg"
 )
 
 // Watcher monitors media sources for file changes
@@ -103,7 +104,7 @@ func (w *Watcher) eventLoop() {
 
 func (w *Watcher) handleEvent(event fsnotify.Event) {
 	ext := strings.ToLower(filepath.Ext(event.Name))
-	if !videoExtensions[ext] {
+	if !(videoExtensions[ext] || ffmpeg.IsDiscImage(event.Name)) || ffmpeg.InDiscStructure(event.Name) {
 		return
 	}
 
@@ -114,14 +115,25 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 		sources, _ := w.db.GetAllMediaSources()
 		for _, source := range sources {
 			if strings.HasPrefix(event.Name, source.Path) {
-				go w.scanner.processFile(event.Name, source)
+				go func(path string, source *db.MediaSource) {
+					if err := w.scanner.ProcessFile(path, source); err != nil {
+						log.Printf("Error processing %s: %v", path, err)
+					}
+				}(event.Name, source)
 				break
 			}
 		}
 
 	case event.Op&fsnotify.Remove == fsnotify.Remove:
 		log.Printf("File removed: %s", event.Name)
-		// TODO: Remove from database
+		go func(path string) {
+			removed, err := w.scanner.RemoveFile(path)
+			if err != nil {
+				log.Printf("Error removing %s from library: %v", path, err)
+			} else if removed > 0 {
+				log.Printf("Removed %d library item(s) for %s", removed, path)
+			}
+		}(event.Name)
 
 	case event.Op&fsnotify.Rename == fsnotify.Rename:
 		log.Printf("File renamed: %s", event.Name)
@@ -0,0 +1,166 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Capabilities describes what the installed ffmpeg binary can do
+type Capabilities struct {
+	Available     bool     `json:"available"` // ffmpeg ran; false means transcoding can't work
+	Version       string   `json:"version,omitempty"`
+	VideoEncoders []string `json:"video_encoders"`
+	AudioEncoders []string `json:"audio_encoders"`
+	HWAccels      []string `json:"hwaccels"` // decoders from `ffmpeg -hwaccels`
+}
+
+// hwAccelRequirements maps each hw_accel_type to the -hwaccel decoder and
+// H.264 encoder it uses
+var hwAccelRequirements = map[string]struct{ hwaccel, encoder string }{
+	"videotoolbox": {"videotoolbox", "h264_videotoolbox"},
+	"nvenc":        {"cuda", "h264_nvenc"},
+	"qsv":          {"qsv", "h264_qsv"},
+	"vaapi":        {"vaapi", "h264_vaapi"},
+}
+
+// CheckHWAccel returns an error describing why hwAccelType can't be used
+// with this ffmpeg, or nil if it can. An empty type needs nothing.
+func (c *Capabilities) CheckHWAccel(hwAccelType string) error {
+	if hwAccelType == "" {
+		return nil
+	}
+	required, ok := hwAccelRequirements[hwAccelType]
+	if !ok {
+		return fmt.Errorf("unknown hw_accel_type %q", hwAccelType)
+	}
+	if !c.Available {
+		return fmt.Errorf("ffmpeg is not available")
+	}
+	if !c.HasHWAccel(required.hwaccel) {
+		return fmt.Errorf("ffmpeg has no %s hwaccel", required.hwaccel)
+	}
+	if !c.HasEncoder(required.encoder) {
+		return fmt.Errorf("ffmpeg has no %s encoder", required.encoder)
+	}
+	return nil
+}
+
+// HasHWAccel reports whether ffmpeg lists the named -hwaccel method
+func (c *Capabilities) HasHWAccel(name string) bool {
+	for _, hwaccel := range c.HWAccels {
+		if hwaccel == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEncoder reports whether the named video or audio encoder is available
+func (c *Capabilities) HasEncoder(name string) bool {
+	for _, encoders := range [][]string{c.VideoEncoders, c.AudioEncoders} {
+		for _, encoder := range encoders {
+			if encoder == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usefulEncoders are the encoders the server can make use of; the full ffmpeg
+// list runs to hundreds of entries clients don't care about
+var usefulEncoders = map[string]bool{
+	"libx264": true, "libx265": true,
+	"h264_videotoolbox": true, "hevc_videotoolbox": true,
+	"h264_nvenc": true, "hevc_nvenc": true,
+	"h264_qsv": true, "hevc_qsv": true,
+	"h264_vaapi": true, "hevc_vaapi": true,
+	"aac": true, "libfdk_aac": true, "ac3": true, "eac3": true,
+	"libmp3lame": true, "libopus": true, "flac": true,
+}
+
+// ProbeCapabilities runs `ffmpeg -encoders` and `ffmpeg -hwaccels` to find
+// out which encoders and hardware decoders the binary at ffmpegPath supports
+func ProbeCapabilities(ffmpegPath string) *Capabilities {
+	caps := &Capabilities{VideoEncoders: []string{}, AudioEncoders: []string{}, HWAccels: []string{}}
+
+	output, err := exec.Command(ffmpegPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return caps
+	}
+	caps.Available = true
+
+	if version, err := exec.Command(ffmpegPath, "-hide_banner", "-version").Output(); err == nil {
+		// "ffmpeg version 6.1.1 Copyright ..."
+		fields := strings.Fields(string(version))
+		if len(fields) >= 3 && fields[1] == "version" {
+			caps.Version = fields[2]
+		}
+	}
+
+	for kind, names := range parseEncoders(output) {
+		for _, name := range names {
+			if !usefulEncoders[name] {
+				continue
+			}
+			switch kind {
+			case 'V':
+				caps.VideoEncoders = append(caps.VideoEncoders, name)
+			case 'A':
+				caps.AudioEncoders = append(caps.AudioEncoders, name)
+			}
+		}
+	}
+	sort.Strings(caps.VideoEncoders)
+	sort.Strings(caps.AudioEncoders)
+
+	if output, err := exec.Command(ffmpegPath, "-hide_banner", "-hwaccels").Output(); err == nil {
+		caps.HWAccels = parseHWAccels(output)
+	}
+
+	return caps
+}
+
+// parseHWAccels reads `ffmpeg -hwaccels` output: a "Hardware acceleration
+// methods:" header followed by one method per line
+func parseHWAccels(output []byte) []string {
+	hwaccels := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		hwaccels = append(hwaccels, line)
+	}
+	return hwaccels
+}
+
+// parseEncoders reads `ffmpeg -encoders` output, grouping encoder names by
+// their type flag (V, A or S). The list follows a " ------" separator line,
+// one encoder per line: " V....D libx264  libx264 H.264 / AVC ...".
+func parseEncoders(output []byte) map[byte][]string {
+	encoders := make(map[byte][]string)
+	listing := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !listing {
+			listing = strings.HasPrefix(line, "---")
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		kind := fields[0][0]
+		encoders[kind] = append(encoders[kind], fields[1])
+	}
+	return encoders
+}
@@ -0,0 +1,256 @@
+package ffmpeg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrDiscImage is returned for disc images, which ffmpeg can't read without
+// mounting them first
+var ErrDiscImage = errors.New("disc images must be extracted or mounted before playback")
+
+// concatPrefix marks inputs joined with ffmpeg's concat protocol
+const concatPrefix = "concat:"
+
+// concatListPrefix marks inputs joined with ffmpeg's concat demuxer; InputArgs
+// writes the files to a list for it
+const concatListPrefix = "concat-list:"
+
+// byteConcatExtensions are the containers whose files can be joined byte for
+// byte with the concat protocol: MPEG program and transport streams, as on
+// DVDs. Others, like MKV and MP4, have headers and indexes that only cover
+// their own file.
+var byteConcatExtensions = map[string]bool{".vob": true, ".mpg": true, ".mpeg": true, ".ts": true}
+
+// DiscTitle is the main title of a ripped disc, ready to pass to ffmpeg
+type DiscTitle struct {
+	Input string   // ffmpeg input: a file path, or concat:a|b|... for DVD title sets
+	Files []string // files making up the title, in playback order
+	Size  int64    // combined size of Files
+}
+
+// IsDiscImage reports whether path is a disc image (.iso)
+func IsDiscImage(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".iso")
+}
+
+// IsDiscStructureDir reports whether name is the top folder of a ripped DVD
+// (VIDEO_TS) or Blu-ray (BDMV); the disc itself is its parent directory
+func IsDiscStructureDir(name string) bool {
+	name = strings.ToUpper(name)
+	return name == "VIDEO_TS" || name == "BDMV"
+}
+
+// InDiscStructure reports whether path lies inside a ripped disc structure,
+// where individual files aren't titles of their own
+func InDiscStructure(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if IsDiscStructureDir(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisc reports whether path is a disc image or a directory holding a
+// ripped disc structure
+func IsDisc(path string) bool {
+	if IsDiscImage(path) {
+		return true
+	}
+	return findDiscDir(path, "BDMV") != "" || findDiscDir(path, "VIDEO_TS") != ""
+}
+
+// InputExists reports whether an ffmpeg input exists on disk. Concat inputs
+// exist when all of their parts do.
+func InputExists(input string) bool {
+	for _, part := range concatFiles(input) {
+		if _, err := os.Stat(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// IsConcatInput reports whether input joins several files, so it can't be
+// served as a single file
+func IsConcatInput(input string) bool {
+	return strings.HasPrefix(input, concatPrefix) || strings.HasPrefix(input, concatListPrefix)
+}
+
+// concatFiles returns the files an input plays, in order
+func concatFiles(input string) []string {
+	switch {
+	case strings.HasPrefix(input, concatPrefix):
+		return strings.Split(strings.TrimPrefix(input, concatPrefix), "|")
+	case strings.HasPrefix(input, concatListPrefix):
+		return strings.Split(strings.TrimPrefix(input, concatListPrefix), "|")
+	}
+	return []string{input}
+}
+
+// ConcatInput returns an ffmpeg input playing files one after another, or
+// the file itself if there's only one. MPEG streams (DVD title sets) are
+// joined with the concat protocol; other containers with the concat
+// demuxer, which reads each file's own headers.
+func ConcatInput(files []string) string {
+	if len(files) == 1 {
+		return files[0]
+	}
+	for _, file := range files {
+		if !byteConcatExtensions[strings.ToLower(filepath.Ext(file))] {
+			return concatListPrefix + strings.Join(files, "|")
+		}
+	}
+	return concatPrefix + strings.Join(files, "|")
+}
+
+// InputArgs returns the ffmpeg or ffprobe arguments opening input. Inputs for
+// the concat demuxer get a list file in the temp directory, named by its
+// contents so every session on the same files shares it.
+func InputArgs(input string) []string {
+	if !strings.HasPrefix(input, concatListPrefix) {
+		return []string{"-i", input}
+	}
+
+	files := concatFiles(input)
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	for _, file := range files {
+		list.WriteString("file '" + strings.ReplaceAll(file, "'", `'\''`) + "'\n")
+	}
+	sum := sha256.Sum256([]byte(list.String()))
+	listPath := filepath.Join(os.TempDir(), "media-server-concat", hex.EncodeToString(sum[:8])+".ffconcat")
+
+	err := os.MkdirAll(filepath.Dir(listPath), 0755)
+	if err == nil {
+		err = os.WriteFile(listPath, []byte(list.String()), 0644)
+	}
+	if err != nil {
+		// Joining the bytes at least plays the first part
+		return []string{"-i", concatPrefix + strings.Join(files, "|")}
+	}
+	// The paths are absolute, which the demuxer only allows with -safe 0
+	return []string{"-f", "concat", "-safe", "0", "-i", listPath}
+}
+
+// MainTitle finds the main feature of the disc at path: the largest stream
+// file of a Blu-ray, or the largest title set of a DVD. Disc images return
+// ErrDiscImage.
+func MainTitle(path string) (*DiscTitle, error) {
+	if IsDiscImage(path) {
+		return nil, ErrDiscImage
+	}
+	if dir := findDiscDir(path, "BDMV"); dir != "" {
+		return blurayMainTitle(dir)
+	}
+	if dir := findDiscDir(path, "VIDEO_TS"); dir != "" {
+		return dvdMainTitle(dir)
+	}
+	return nil, os.ErrNotExist
+}
+
+// findDiscDir returns the disc folder named name inside root, matched
+// case-insensitively since rips vary, or "" if there is none
+func findDiscDir(root, name string) string {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.EqualFold(entry.Name(), name) {
+			return filepath.Join(root, entry.Name())
+		}
+	}
+	return ""
+}
+
+// blurayMainTitle picks the largest .m2ts in BDMV/STREAM. The feature is
+// almost always the biggest clip, which avoids parsing .mpls playlists.
+func blurayMainTitle(bdmvDir string) (*DiscTitle, error) {
+	streamDir := findDiscDir(bdmvDir, "STREAM")
+	if streamDir == "" {
+		return nil, os.ErrNotExist
+	}
+	entries, err := os.ReadDir(streamDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var title *DiscTitle
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".m2ts") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if title == nil || info.Size() > title.Size {
+			path := filepath.Join(streamDir, entry.Name())
+			title = &DiscTitle{Input: path, Files: []string{path}, Size: info.Size()}
+		}
+	}
+	if title == nil {
+		return nil, os.ErrNotExist
+	}
+	return title, nil
+}
+
+// vobRegex matches title set VOBs (VTS_01_1.VOB); part 0 is the menu
+var vobRegex = regexp.MustCompile(`(?i)^VTS_(\d{2})_([1-9])\.VOB$`)
+
+// dvdMainTitle picks the title set with the most data and joins its VOBs,
+// which DVDs split at 1GB, into one concat input
+func dvdMainTitle(videoTSDir string) (*DiscTitle, error) {
+	entries, err := os.ReadDir(videoTSDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type vob struct {
+		path string
+		part int
+	}
+	sets := make(map[string][]vob)
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		match := vobRegex.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		part, _ := strconv.Atoi(match[2])
+		sets[match[1]] = append(sets[match[1]], vob{filepath.Join(videoTSDir, entry.Name()), part})
+		sizes[match[1]] += info.Size()
+	}
+
+	var best string
+	for set, size := range sizes {
+		if best == "" || size > sizes[best] || (size == sizes[best] && set < best) {
+			best = set
+		}
+	}
+	if best == "" {
+		return nil, os.ErrNotExist
+	}
+
+	vobs := sets[best]
+	sort.Slice(vobs, func(i, j int) bool { return vobs[i].part < vobs[j].part })
+	title := &DiscTitle{Size: sizes[best]}
+	for _, v := range vobs {
+		title.Files = append(title.Files, v.path)
+	}
+	title.Input = ConcatInput(title.Files)
+	return title, nil
+}
@@ -0,0 +1,160 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DownloadJob is an MP4 a download is served from
+type DownloadJob struct {
+	Key        string // session key, as passed to StopSession
+	Variant    string // transcode profile name, or "remux"
+	InputPath  string
+	OutputPath string
+	Build      func(ctx context.Context, outputPath string) error
+}
+
+// downloadBuild is an MP4 being written for a download. It holds a session
+// slot until ffmpeg exits.
+type downloadBuild struct {
+	job       DownloadJob
+	startTime time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+	err       error // set before done is closed
+	waiters   int   // requests waiting for the file; guarded by sm.mu
+}
+
+// downloadAbandonAfter is how long a download build keeps going once every
+// request waiting for it is gone, so a client retrying after a dropped
+// connection picks it up rather than starting over
+const downloadAbandonAfter = time.Minute
+
+// errDownloadStopped is a build's error when it was stopped or abandoned
+var errDownloadStopped = errors.New("download build stopped")
+
+// BuildDownload writes job.OutputPath unless it already exists, sharing one
+// run between concurrent callers. Builds count against the session limit
+// like video sessions, so ErrTranscodeBusy is returned when no slot is free.
+// It waits for the build to finish or ctx to be done. A build nobody waits
+// for is stopped after downloadAbandonAfter.
+func (sm *SessionManager) BuildDownload(ctx context.Context, job DownloadJob) error {
+	if _, err := os.Stat(job.OutputPath); err == nil {
+		// The modification time is when the file was last served, see
+		// ExpireDownloads
+		now := time.Now()
+		os.Chtimes(job.OutputPath, now, now)
+		return nil
+	}
+
+	sm.mu.Lock()
+	b, running := sm.downloads[job.OutputPath]
+	if !running {
+		if err := sm.reserveSlot(); err != nil {
+			sm.mu.Unlock()
+			return err
+		}
+		buildCtx, cancel := context.WithCancel(context.Background())
+		b = &downloadBuild{job: job, startTime: time.Now(), cancel: cancel, done: make(chan struct{})}
+		sm.downloads[job.OutputPath] = b
+		go sm.runDownload(buildCtx, b)
+	}
+	b.waiters++
+	sm.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+	}
+
+	sm.mu.Lock()
+	b.waiters--
+	abandoned := b.waiters == 0
+	sm.mu.Unlock()
+	if abandoned {
+		time.AfterFunc(sm.abandonAfter, func() {
+			sm.mu.Lock()
+			stop := sm.downloads[job.OutputPath] == b && b.waiters == 0
+			sm.mu.Unlock()
+			if stop {
+				log.Printf("Stopping abandoned download build %s", job.Key)
+				b.cancel()
+			}
+		})
+	}
+	return ctx.Err()
+}
+
+// runDownload runs a download build and releases its slot when it's done
+func (sm *SessionManager) runDownload(ctx context.Context, b *downloadBuild) {
+	defer b.cancel()
+
+	err := b.job.Build(ctx, b.job.OutputPath)
+	if err != nil && ctx.Err() != nil {
+		err = errDownloadStopped
+	} else if err != nil {
+		log.Printf("Download transcode failed for %s: %v", b.job.OutputPath, err)
+	}
+
+	sm.mu.Lock()
+	if sm.downloads[b.job.OutputPath] == b {
+		delete(sm.downloads, b.job.OutputPath)
+	}
+	sm.mu.Unlock()
+	b.err = err
+	close(b.done)
+}
+
+// SetDownloadExpiry makes the reaper delete download MP4s that haven't been
+// served for ttl. Non-positive values keep them.
+func (sm *SessionManager) SetDownloadExpiry(ttl time.Duration) {
+	sm.mu.Lock()
+	sm.downloadTTL = ttl
+	sm.mu.Unlock()
+}
+
+// ExpireDownloads deletes the download MP4s, and partial ones left by a
+// restart, that haven't been served for the download expiry, along with
+// output directories that leaves empty. It returns how many were deleted.
+func (sm *SessionManager) ExpireDownloads() int {
+	sm.mu.RLock()
+	ttl := sm.downloadTTL
+	sm.mu.RUnlock()
+	if ttl <= 0 {
+		return 0
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(sm.outputDir, "*", "download_*.mp4*"))
+	expired := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < ttl {
+			continue
+		}
+
+		// The partial file of a running build is ffmpeg's
+		sm.mu.RLock()
+		_, building := sm.downloads[strings.TrimSuffix(path, ".part")]
+		sm.mu.RUnlock()
+		if building {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to delete expired download %s: %v", path, err)
+			continue
+		}
+		os.Remove(filepath.Dir(path)) // fails unless empty
+		expired++
+	}
+	if expired > 0 {
+		log.Printf("Deleted %d downloads not served for %s", expired, ttl)
+	}
+	return expired
+}
@@ -76,13 +76,12 @@ func NewFFprobe(ffmpegPath string) *FFprobe {
 
 // GetMetadata extracts metadata from a video file
 func (f *FFprobe) GetMetadata(filePath string) (*Metadata, error) {
-	args := []string{
+	args := append([]string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
 		"-show_streams",
-		filePath,
-	}
+	}, InputArgs(filePath)...)
 
 	cmd := exec.Command(f.path, args...)
 	output, err := cmd.Output()
@@ -0,0 +1,130 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SessionProgress is a snapshot of how far a video session has got
+type SessionProgress struct {
+	Running      bool
+	Position     float64 // seconds of the input transcoded, from the start of the file
+	Speed        float64 // encoding speed as a multiple of realtime, 0 until known
+	StartSegment int
+	Segments     int   // segments written since StartSegment
+	Err          error // why the last session failed, when not running
+}
+
+// Progress returns the progress of the running video session for key, or
+// the error of the last one if it failed. It returns false when neither
+// exists.
+func (sm *SessionManager) Progress(key string) (SessionProgress, bool) {
+	sm.mu.RLock()
+	session, running := sm.sessions[key]
+	if !running {
+		session = sm.failed[key]
+	}
+	sm.mu.RUnlock()
+	if session == nil {
+		return SessionProgress{}, false
+	}
+
+	session.mu.RLock()
+	progress := SessionProgress{
+		Running:      running,
+		Position:     session.position,
+		Speed:        session.speed,
+		StartSegment: session.StartSegment,
+		Err:          session.Error,
+	}
+	session.mu.RUnlock()
+	if running {
+		progress.Err = nil
+	}
+	progress.Segments = countSegmentsFrom(session.segmentDirs()[0], session.StartSegment)
+	return progress, true
+}
+
+// progressWriter parses the key=value lines ffmpeg writes with -progress
+// into the session's position and speed
+type progressWriter struct {
+	session *TranscodeSession
+	offset  float64 // seconds skipped by seeking to the start segment
+	buf     []byte
+
+	// Whether ffmpeg's out_time includes -output_ts_offset isn't something
+	// to rely on, so it's decided from the first report
+	sawTime       bool
+	includeOffset bool
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(string(w.buf[:i])), "=")
+		w.buf = w.buf[i+1:]
+
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || us < 0 {
+				continue // N/A before the first frame
+			}
+			seconds := float64(us) / 1e6
+			if !w.sawTime {
+				w.sawTime = true
+				w.includeOffset = w.offset > 0 && seconds >= w.offset-HLSSegmentDuration.Seconds()
+			}
+			if !w.includeOffset {
+				seconds += w.offset
+			}
+			w.session.mu.Lock()
+			w.session.position = seconds
+			w.session.mu.Unlock()
+		case "speed":
+			speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+			if err != nil {
+				continue
+			}
+			w.session.mu.Lock()
+			w.session.speed = speed
+			w.session.mu.Unlock()
+		}
+	}
+	return len(p), nil
+}
+
+// stderrTail keeps the end of ffmpeg's stderr so a failed session can report
+// why, not just its exit status
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// stderrTailSize is how much of stderr is kept
+const stderrTailSize = 4096
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailSize {
+		t.buf = t.buf[len(t.buf)-stderrTailSize:]
+	}
+	t.mu.Unlock()
+	return len(p), nil
+}
+
+// lastLine returns the last non-empty line written, which is where ffmpeg
+// puts its fatal error
+func (t *stderrTail) lastLine() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(t.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -2,83 +2,319 @@ package ffmpeg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 // TranscodeSession represents an active transcoding session
 type TranscodeSession struct {
-	MediaID    int64
-	InputPath  string
-	OutputDir  string
-	Profile    TranscodeProfile
-	StartTime  time.Time
-	Cmd        *exec.Cmd
-	Cancel     context.CancelFunc
-	Done       chan struct{}
-	Error      error
-	mu         sync.RWMutex
+	Key       string // output directory name, e.g. "12" or "episode-12"
+	InputPath string
+	OutputDir string
+	Profile   TranscodeProfile
+	Variants  []string // variant names of an adaptive session, lowest first
+	// StartSegment is the number of the first segment ffmpeg writes; it's
+	// non-zero for sessions started at a seek position
+	StartSegment int
+	StartTime    time.Time
+	Cmd          *exec.Cmd
+	Cancel       context.CancelFunc
+	Done         chan struct{}
+	Error        error
+	lastAccess   time.Time // last client request for the session's output
+	position     float64   // seconds of input transcoded, from ffmpeg's -progress
+	speed        float64   // encoding speed relative to realtime
+	mu           sync.RWMutex
+}
+
+// Touch records a client request for the session's output, keeping the
+// idle reaper away
+func (s *TranscodeSession) Touch() {
+	s.mu.Lock()
+	s.lastAccess = time.Now()
+	s.mu.Unlock()
+}
+
+// idleFor returns how long it has been since a client requested the
+// session's output
+func (s *TranscodeSession) idleFor() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Since(s.lastAccess)
+}
+
+// segmentDirs returns the directories the session writes playlists and
+// segments to
+func (s *TranscodeSession) segmentDirs() []string {
+	if len(s.Variants) == 0 {
+		return []string{s.OutputDir}
+	}
+	dirs := make([]string, len(s.Variants))
+	for i, name := range s.Variants {
+		dirs[i] = filepath.Join(s.OutputDir, name)
+	}
+	return dirs
 }
 
 // SessionManager manages active transcoding sessions
 type SessionManager struct {
-	sessions      map[int64]*TranscodeSession
+	sessions      map[string]*TranscodeSession
+	audioSessions map[string]*TranscodeSession // keyed by "<session key>:<track>"
+	failed        map[string]*TranscodeSession // last video session per key, if it failed
+	downloads     map[string]*downloadBuild    // MP4 downloads being written, by output path
+	abandonAfter  time.Duration                // how long download builds outlive their last request
+	downloadTTL   time.Duration                // delete download MP4s not served for this long (0 = never)
 	mu            sync.RWMutex
 	ffmpegPath    string
 	outputDir     string
 	enableHWAccel bool
 	hwAccelType   string
+	pollInterval  time.Duration // how often waits check for new segment files
+	idleTimeout   time.Duration // stop sessions nobody requested for this long (0 = never)
+	maxSessions   int           // concurrent video sessions (0 = unlimited)
+	reaperOnce    sync.Once
 }
 
+// ErrTranscodeBusy is returned when starting a session would exceed the
+// concurrent session limit and no running session is idle enough to evict
+var ErrTranscodeBusy = errors.New("too many concurrent transcodes")
+
+// evictableIdle is how long a session must go without requests before a new
+// session may evict it to stay under the limit. Players buffer ahead, so
+// shorter gaps are normal during playback.
+const evictableIdle = time.Minute
+
+// HLSSegmentDuration is the target length of every HLS segment we produce
+const HLSSegmentDuration = 4 * time.Second
+
 // NewSessionManager creates a new session manager
 func NewSessionManager(ffmpegPath, outputDir string, enableHWAccel bool, hwAccelType string) *SessionManager {
 	return &SessionManager{
-		sessions:      make(map[int64]*TranscodeSession),
+		sessions:      make(map[string]*TranscodeSession),
+		audioSessions: make(map[string]*TranscodeSession),
+		failed:        make(map[string]*TranscodeSession),
+		downloads:     make(map[string]*downloadBuild),
+		abandonAfter:  downloadAbandonAfter,
 		ffmpegPath:    ffmpegPath,
 		outputDir:     outputDir,
 		enableHWAccel: enableHWAccel,
 		hwAccelType:   hwAccelType,
+		pollInterval:  HLSSegmentDuration / 8,
 	}
 }
 
-// GetOrStartSession returns an existing session or starts a new one
-func (sm *SessionManager) GetOrStartSession(mediaID int64, inputPath string, profile TranscodeProfile) (*TranscodeSession, error) {
+// SetPollInterval changes how often segment waits check the output directory.
+// Non-positive values keep the default of an eighth of a segment.
+func (sm *SessionManager) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		sm.pollInterval = interval
+	}
+}
+
+// SetIdleTimeout makes the reaper stop sessions no client has requested
+// anything from for timeout, deleting their playlists and segments.
+// Non-positive values disable reaping.
+func (sm *SessionManager) SetIdleTimeout(timeout time.Duration) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	sm.idleTimeout = timeout
+	sm.mu.Unlock()
+}
 
-	// Check for existing session
-	if session, exists := sm.sessions[mediaID]; exists {
-		return session, nil
+// StartReaper starts the background goroutine that reaps idle sessions and
+// expired downloads. It runs for the life of the process; later calls do
+// nothing.
+func (sm *SessionManager) StartReaper() {
+	sm.reaperOnce.Do(func() {
+		go func() {
+			for range time.Tick(reaperInterval) {
+				sm.ReapIdleSessions()
+				sm.ExpireDownloads()
+			}
+		}()
+	})
+}
+
+// reaperInterval is how often the reaper looks for idle sessions
+const reaperInterval = 30 * time.Second
+
+// ReapIdleSessions stops the video and audio sessions that have been idle
+// for the idle timeout and deletes their playlists and segments, returning
+// how many were stopped. Sessions that haven't written a segment yet are
+// left alone: they're slow to start, not abandoned.
+func (sm *SessionManager) ReapIdleSessions() int {
+	type candidate struct {
+		sessions map[string]*TranscodeSession
+		key      string
+		session  *TranscodeSession
 	}
 
-	// Check if transcode already completed
-	outputPath := filepath.Join(sm.outputDir, fmt.Sprintf("%d", mediaID))
-	manifestPath := filepath.Join(outputPath, "manifest.m3u8")
+	sm.mu.RLock()
+	timeout := sm.idleTimeout
+	var candidates []candidate
+	if timeout > 0 {
+		for key, s := range sm.sessions {
+			if s.idleFor() >= timeout {
+				candidates = append(candidates, candidate{sm.sessions, key, s})
+			}
+		}
+		for key, s := range sm.audioSessions {
+			if s.idleFor() >= timeout {
+				candidates = append(candidates, candidate{sm.audioSessions, key, s})
+			}
+		}
+	}
+	sm.mu.RUnlock()
 
-	// If manifest exists and has ENDLIST, transcode is complete
-	if data, err := os.ReadFile(manifestPath); err == nil {
-		if containsEndList(string(data)) {
-			return nil, nil // Already complete, no active session needed
+	reaped := 0
+	for _, c := range candidates {
+		// Counting touches the disk, so it's done outside the lock
+		if countSegmentsFrom(c.session.segmentDirs()[0], c.session.StartSegment) == 0 {
+			continue
+		}
+
+		sm.mu.Lock()
+		stillIdle := c.sessions[c.key] == c.session && c.session.idleFor() >= timeout
+		sm.mu.Unlock()
+		if !stillIdle {
+			continue
+		}
+
+		// The session's goroutine removes it from the map once ffmpeg exits
+		c.session.Cancel()
+		<-c.session.Done
+
+		// Leave the output alone if a client restarted the transcode meanwhile
+		sm.mu.Lock()
+		if _, restarted := c.sessions[c.key]; !restarted {
+			for _, dir := range c.session.segmentDirs() {
+				removeHLSOutput(dir)
+			}
+		}
+		sm.mu.Unlock()
+		log.Printf("Stopped idle transcode %s after %s", c.key, timeout)
+		reaped++
+	}
+	return reaped
+}
+
+// removeHLSOutput deletes the playlist and segments in an output directory,
+// and the directory itself if nothing else (subtitles, downloads) is left
+func removeHLSOutput(dir string) {
+	os.Remove(filepath.Join(dir, "manifest.m3u8"))
+	segments, _ := filepath.Glob(filepath.Join(dir, "segment*.ts"))
+	for _, segment := range segments {
+		os.Remove(segment)
+	}
+	os.Remove(dir) // fails unless empty
+}
+
+// SetMaxSessions limits how many video sessions (single-profile or
+// adaptive) and download builds run at once. Non-positive values remove the
+// limit. Audio renditions are cheap and aren't counted.
+func (sm *SessionManager) SetMaxSessions(max int) {
+	sm.mu.Lock()
+	sm.maxSessions = max
+	sm.mu.Unlock()
+}
+
+// reserveSlot makes room for a new video session or download build,
+// evicting the least recently requested session idle for evictableIdle if
+// the limit is reached. Download builds are never evicted. The caller holds
+// sm.mu.
+func (sm *SessionManager) reserveSlot() error {
+	if sm.maxSessions <= 0 || len(sm.sessions)+len(sm.downloads) < sm.maxSessions {
+		return nil
+	}
+
+	var lru *TranscodeSession
+	lruKey := ""
+	for key, s := range sm.sessions {
+		if s.idleFor() < evictableIdle {
+			continue
+		}
+		if lru == nil || s.idleFor() > lru.idleFor() {
+			lru, lruKey = s, key
+		}
+	}
+	if lru == nil {
+		return ErrTranscodeBusy
+	}
+
+	// Its output is kept so the evicted stream can resume where it stopped
+	log.Printf("Evicting idle transcode %s to stay within %d sessions", lruKey, sm.maxSessions)
+	delete(sm.sessions, lruKey)
+	lru.Cancel()
+	return nil
+}
+
+// seekAheadSegments is how far past a session's latest segment a request
+// may be and still wait for that session rather than restart ffmpeg at the
+// requested segment
+const seekAheadSegments = 5
+
+// GetOrStartSession returns the session that will produce segment
+// startSegment of key, starting one if needed. A running session is reused
+// when it has written or will soon write that segment; otherwise (the
+// player seeked elsewhere) ffmpeg is restarted at startSegment. It returns
+// nil when the transcode already completed, and ErrTranscodeBusy when the
+// session limit is reached.
+func (sm *SessionManager) GetOrStartSession(key string, inputPath string, profile TranscodeProfile, startSegment int) (*TranscodeSession, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	outputPath := filepath.Join(sm.outputDir, key)
+
+	if session, exists := sm.sessions[key]; exists {
+		latest := session.StartSegment + countSegmentsFrom(outputPath, session.StartSegment) - 1
+		if startSegment >= session.StartSegment && startSegment <= latest+seekAheadSegments {
+			session.Touch()
+			return session, nil
+		}
+
+		// Its slot is reused by the restarted session
+		log.Printf("Restarting transcode %s at segment %d (was at %d)", key, startSegment, latest)
+		delete(sm.sessions, key)
+		session.Cancel()
+	} else {
+		// If manifest exists and has ENDLIST, transcode is complete
+		manifestPath := filepath.Join(outputPath, "manifest.m3u8")
+		segmentPath := filepath.Join(outputPath, fmt.Sprintf("segment%d.ts", startSegment))
+		if data, err := os.ReadFile(manifestPath); err == nil && containsEndList(string(data)) {
+			if _, err := os.Stat(segmentPath); err == nil {
+				return nil, nil // Already complete, no active session needed
+			}
+		}
+
+		if err := sm.reserveSlot(); err != nil {
+			return nil, err
 		}
 	}
 
 	// Start new session
-	session, err := sm.startSession(mediaID, inputPath, profile)
+	session, err := sm.startSession(key, inputPath, profile, startSegment)
 	if err != nil {
 		return nil, err
 	}
 
-	sm.sessions[mediaID] = session
+	sm.sessions[key] = session
 	return session, nil
 }
 
-func (sm *SessionManager) startSession(mediaID int64, inputPath string, profile TranscodeProfile) (*TranscodeSession, error) {
-	outputPath := filepath.Join(sm.outputDir, fmt.Sprintf("%d", mediaID))
+// startSession starts ffmpeg at segment startSegment. Keyframes are forced
+// at every segment boundary so segment numbers map to fixed times, and
+// seeked output keeps the timestamps it would have had from the start.
+func (sm *SessionManager) startSession(key string, inputPath string, profile TranscodeProfile, startSegment int) (*TranscodeSession, error) {
+	outputPath := filepath.Join(sm.outputDir, key)
 
 	// Create output directory
 	if err := os.MkdirAll(outputPath, 0755); err != nil {
@@ -88,118 +324,492 @@ func (sm *SessionManager) startSession(mediaID int64, inputPath string, profile
 	manifestPath := filepath.Join(outputPath, "manifest.m3u8")
 	segmentPath := filepath.Join(outputPath, "segment%d.ts")
 
-	args := []string{}
+	// Hardware acceleration. Subtitles are burned in on frames in system
+	// memory, where VAAPI doesn't keep them, so those sessions use software.
+	var args []string
+	videoCodec := "libx264"
+	scaleFilter := fmt.Sprintf("scale=%d:%d", profile.Width, profile.Height)
+	if profile.BurnSubtitle == nil || sm.hwAccelType != "vaapi" {
+		args = sm.hwAccelArgs()
+		videoCodec = sm.videoEncoder()
+		scaleFilter = sm.scaleFilter(profile)
+	}
+	softwareEncode := videoCodec == "libx264"
 
-	// Hardware acceleration
-	if sm.enableHWAccel {
-		switch sm.hwAccelType {
-		case "videotoolbox":
-			args = append(args, "-hwaccel", "videotoolbox")
-		case "nvenc":
-			args = append(args, "-hwaccel", "cuda")
-		case "vaapi":
-			args = append(args, "-hwaccel", "vaapi", "-hwaccel_output_format", "vaapi")
-		case "qsv":
-			args = append(args, "-hwaccel", "qsv")
-		}
+	// Input, seeked to the start segment
+	offset := strconv.Itoa(startSegment * int(HLSSegmentDuration/time.Second))
+	if startSegment > 0 {
+		args = append(args, "-ss", offset)
 	}
+	args = append(args, InputArgs(inputPath)...)
 
-	// Input
-	args = append(args, "-i", inputPath)
+	// Video filters: burned-in subtitles go before scaling so they're drawn
+	// at the source resolution
+	videoMap := "0:v:0"
+	switch burn := profile.BurnSubtitle; {
+	case burn == nil:
+		args = append(args, "-vf", scaleFilter)
+	case ImageSubtitleCodecs[burn.Codec]:
+		args = append(args, "-filter_complex",
+			fmt.Sprintf("[0:v:0][0:s:%d]overlay,%s[v]", burn.Index, scaleFilter))
+		videoMap = "[v]"
+	default:
+		// The subtitles filter reads the file itself, so a seeked input's
+		// timestamps are shifted to match it and back
+		filter := fmt.Sprintf("subtitles=filename=%s:si=%d", filterPath(inputPath), burn.Index)
+		if startSegment > 0 {
+			filter = fmt.Sprintf("setpts=PTS+%s/TB,%s,setpts=PTS-%s/TB", offset, filter, offset)
+		}
+		args = append(args, "-vf", filter+","+scaleFilter)
+	}
+	args = append(args, "-map", videoMap, "-map", fmt.Sprintf("0:a:%d?", profile.AudioTrack))
 
 	// Video encoding
-	videoCodec := "libx264"
-	scaleFilter := fmt.Sprintf("scale=%d:%d", profile.Width, profile.Height)
+	args = append(args, "-c:v", videoCodec)
+	args = append(args, profile.VideoRateArgs(!softwareEncode)...)
+	args = append(args, profile.VideoFormatArgs(videoCodec)...)
+
+	// Add preset for software encoding
+	if softwareEncode {
+		args = append(args, "-preset", profile.Preset)
+	}
+
+	args = append(args, "-force_key_frames", "expr:gte(t,n_forced*"+hlsTimeArg()+")")
+
+	// Audio encoding
+	args = append(args, profile.AudioArgs()...)
+
+	// Seeked sessions write a playlist of their own segments only.
+	// temp_file renames segments into place once they're complete, so
+	// GetSegment never serves one ffmpeg is still writing.
+	hlsFlags := "independent_segments+temp_file+append_list"
+	if startSegment > 0 {
+		hlsFlags = "independent_segments+temp_file"
+		args = append(args, "-output_ts_offset", offset)
+	}
+
+	// HLS settings for live/progressive output
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", hlsTimeArg(), // short segments for faster start
+		"-hls_list_size", "0", // Keep all segments in playlist
+		"-hls_flags", hlsFlags,
+		"-hls_segment_type", "mpegts",
+		"-start_number", strconv.Itoa(startSegment),
+		"-hls_segment_filename", segmentPath,
+		"-progress", "pipe:1", // progress reports on stdout
+		"-y", // Overwrite
+		manifestPath,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, sm.ffmpegPath, args...)
+
+	// Capture stderr for debugging, keeping the end for error reports
+	tail := &stderrTail{}
+	cmd.Stderr = io.MultiWriter(os.Stderr, tail)
+
+	session := &TranscodeSession{
+		Key:          key,
+		InputPath:    inputPath,
+		OutputDir:    outputPath,
+		Profile:      profile,
+		StartSegment: startSegment,
+		StartTime:    time.Now(),
+		lastAccess:   time.Now(),
+		Cmd:          cmd,
+		Cancel:       cancel,
+		Done:         make(chan struct{}),
+	}
+	cmd.Stdout = &progressWriter{session: session, offset: float64(startSegment) * HLSSegmentDuration.Seconds()}
+	delete(sm.failed, key)
+
+	// Start transcoding in background
+	go func() {
+		defer close(session.Done)
+		defer func() {
+			sm.mu.Lock()
+			if sm.sessions[key] == session {
+				delete(sm.sessions, key)
+			}
+			sm.mu.Unlock()
+		}()
+
+		log.Printf("Starting live transcode for media %s with profile %s at segment %d", key, profile.Name, startSegment)
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return // stopped, not failed
+			}
+			if line := tail.lastLine(); line != "" {
+				err = fmt.Errorf("%w: %s", err, line)
+			}
+			session.mu.Lock()
+			session.Error = err
+			session.mu.Unlock()
+
+			sm.mu.Lock()
+			sm.failed[key] = session
+			sm.mu.Unlock()
+			log.Printf("Transcode error for media %s: %v", key, err)
+			return
+		}
+
+		log.Printf("Transcode complete for media %s", key)
+	}()
 
+	return session, nil
+}
+
+// ImageSubtitleCodecs are the bitmap subtitle codecs, which can only be shown
+// by burning them into the video
+var ImageSubtitleCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// filterPath escapes a file path for use as a filter option value inside a
+// filter graph
+func filterPath(path string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(path)
+	return "'" + strings.ReplaceAll(escaped, "'", `'\''`) + "'"
+}
+
+// hwAccelArgs returns the hardware decoding arguments, if enabled
+func (sm *SessionManager) hwAccelArgs() []string {
+	if !sm.enableHWAccel {
+		return nil
+	}
+	switch sm.hwAccelType {
+	case "videotoolbox":
+		return []string{"-hwaccel", "videotoolbox"}
+	case "nvenc":
+		return []string{"-hwaccel", "cuda"}
+	case "vaapi":
+		return []string{"-hwaccel", "vaapi", "-hwaccel_output_format", "vaapi"}
+	case "qsv":
+		return []string{"-hwaccel", "qsv"}
+	}
+	return nil
+}
+
+// videoEncoder returns the H.264 encoder for the hardware acceleration type
+func (sm *SessionManager) videoEncoder() string {
 	if sm.enableHWAccel {
 		switch sm.hwAccelType {
 		case "videotoolbox":
-			videoCodec = "h264_videotoolbox"
+			return "h264_videotoolbox"
 		case "nvenc":
-			videoCodec = "h264_nvenc"
+			return "h264_nvenc"
 		case "vaapi":
-			videoCodec = "h264_vaapi"
-			scaleFilter = fmt.Sprintf("scale_vaapi=w=%d:h=%d", profile.Width, profile.Height)
+			return "h264_vaapi"
 		case "qsv":
-			videoCodec = "h264_qsv"
+			return "h264_qsv"
 		}
 	}
+	return "libx264"
+}
+
+// scaleFilter returns the filter scaling video to the profile's resolution
+func (sm *SessionManager) scaleFilter(profile TranscodeProfile) string {
+	if sm.enableHWAccel && sm.hwAccelType == "vaapi" {
+		return profile.VAAPIScaleFilter()
+	}
+	return fmt.Sprintf("scale=%d:%d", profile.Width, profile.Height)
+}
+
+// hasAudio reports whether inputPath has an audio stream. Inputs that can't
+// be probed are assumed to have one.
+func (sm *SessionManager) hasAudio(inputPath string) bool {
+	metadata, err := NewFFprobe(sm.ffmpegPath).GetMetadata(inputPath)
+	return err != nil || len(metadata.AudioTracks) > 0
+}
+
+// StartAdaptiveSession returns the running adaptive session for key or starts
+// one: a single ffmpeg process encoding every profile (lowest first) as its
+// own HLS variant under VariantOutputDir. It returns nil when all variants
+// have already been transcoded, and ErrTranscodeBusy when the session limit
+// is reached.
+func (sm *SessionManager) StartAdaptiveSession(key string, inputPath string, profiles []TranscodeProfile) (*TranscodeSession, error) {
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no profiles for adaptive session")
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if session, exists := sm.sessions[key]; exists {
+		session.Touch()
+		return session, nil
+	}
+
+	complete := true
+	for _, profile := range profiles {
+		data, err := os.ReadFile(filepath.Join(sm.VariantOutputDir(key, profile.Name), "manifest.m3u8"))
+		if err != nil || !containsEndList(string(data)) {
+			complete = false
+			break
+		}
+	}
+	if complete {
+		return nil, nil
+	}
+
+	if err := sm.reserveSlot(); err != nil {
+		return nil, err
+	}
+
+	outputPath := filepath.Join(sm.outputDir, key)
+	for _, profile := range profiles {
+		if err := os.MkdirAll(sm.VariantOutputDir(key, profile.Name), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	args := sm.hwAccelArgs()
+	args = append(args, InputArgs(inputPath)...)
+
+	// Decode once and split the video into one scaled output per variant
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(profiles))
+	for i := range profiles {
+		fmt.Fprintf(&filter, "[v%d]", i)
+	}
+	for i, profile := range profiles {
+		fmt.Fprintf(&filter, ";[v%d]%s[v%dout]", i, sm.scaleFilter(profile), i)
+	}
+	args = append(args, "-filter_complex", filter.String())
+
+	videoCodec := sm.videoEncoder()
+	softwareEncode := !sm.enableHWAccel || sm.hwAccelType == ""
+	// Screen recordings and some extras have no audio to map
+	withAudio := sm.hasAudio(inputPath)
+
+	streamMap := make([]string, len(profiles))
+	names := make([]string, len(profiles))
+	for i, profile := range profiles {
+		args = append(args, "-map", fmt.Sprintf("[v%dout]", i))
+		if withAudio {
+			args = append(args, "-map", "0:a:0?")
+		}
+
+		video := []string{"-c:v", videoCodec}
+		video = append(video, profile.VideoRateArgs(!softwareEncode)...)
+		video = append(video, profile.VideoFormatArgs(videoCodec)...)
+		if softwareEncode {
+			video = append(video, "-preset", profile.Preset)
+		}
+		args = append(args, streamSpecificArgs(video, "v", i)...)
+		if withAudio {
+			args = append(args, streamSpecificArgs(profile.AudioArgs(), "a", i)...)
+			streamMap[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, profile.Name)
+		} else {
+			streamMap[i] = fmt.Sprintf("v:%d,name:%s", i, profile.Name)
+		}
+		names[i] = profile.Name
+	}
 
 	args = append(args,
-		"-c:v", videoCodec,
-		"-vf", scaleFilter,
-		"-b:v", profile.VideoBitrate,
+		"-f", "hls",
+		"-hls_time", hlsTimeArg(),
+		"-hls_list_size", "0",
+		"-hls_flags", "independent_segments+temp_file", // see startSession
+		"-hls_segment_type", "mpegts",
+		"-var_stream_map", strings.Join(streamMap, " "),
+		"-hls_segment_filename", filepath.Join(outputPath, "%v", "segment%d.ts"),
+		"-y",
+		filepath.Join(outputPath, "%v", "manifest.m3u8"),
 	)
 
-	// Add preset for software encoding
-	if !sm.enableHWAccel || sm.hwAccelType == "" {
-		args = append(args, "-preset", profile.Preset)
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, sm.ffmpegPath, args...)
+	cmd.Stderr = os.Stderr
+
+	session := &TranscodeSession{
+		Key:        key,
+		InputPath:  inputPath,
+		OutputDir:  outputPath,
+		Profile:    profiles[len(profiles)-1],
+		Variants:   names,
+		StartTime:  time.Now(),
+		lastAccess: time.Now(),
+		Cmd:        cmd,
+		Cancel:     cancel,
+		Done:       make(chan struct{}),
 	}
 
-	// Audio encoding
-	args = append(args,
+	go func() {
+		defer close(session.Done)
+		defer func() {
+			sm.mu.Lock()
+			if sm.sessions[key] == session {
+				delete(sm.sessions, key)
+			}
+			sm.mu.Unlock()
+		}()
+
+		log.Printf("Starting adaptive transcode for media %s with %d variants", key, len(profiles))
+
+		if err := cmd.Run(); err != nil {
+			session.mu.Lock()
+			session.Error = err
+			session.mu.Unlock()
+			log.Printf("Adaptive transcode error for media %s: %v", key, err)
+			return
+		}
+
+		log.Printf("Adaptive transcode complete for media %s", key)
+	}()
+
+	sm.sessions[key] = session
+	return session, nil
+}
+
+// streamSpecificArgs scopes flag/value argument pairs to output stream index
+// of the given type, e.g. "-b:v" becomes "-b:v:1" and "-crf" "-crf:v:1"
+func streamSpecificArgs(args []string, streamType string, index int) []string {
+	scoped := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		flag := args[i]
+		if flag == "-af" {
+			flag = "-filter:a"
+		}
+		if strings.Contains(flag, ":") {
+			flag = fmt.Sprintf("%s:%d", flag, index)
+		} else {
+			flag = fmt.Sprintf("%s:%s:%d", flag, streamType, index)
+		}
+		scoped = append(scoped, flag, args[i+1])
+	}
+	return scoped
+}
+
+// VariantOutputDir returns the directory holding one variant of an adaptive
+// session
+func (sm *SessionManager) VariantOutputDir(key string, name string) string {
+	return filepath.Join(sm.outputDir, key, name)
+}
+
+// WaitForVariantSegments waits for initial segments of an adaptive variant
+func (sm *SessionManager) WaitForVariantSegments(key string, name string, minSegments int, timeout time.Duration) error {
+	return sm.waitForSegmentFiles(sm.VariantOutputDir(key, name), minSegments, timeout)
+}
+
+// GetOrStartAudioSession returns an existing audio rendition session or starts
+// an audio-only HLS transcode of the given audio track
+func (sm *SessionManager) GetOrStartAudioSession(key string, inputPath string, trackIndex int, bitrate string) (*TranscodeSession, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sessionKey := fmt.Sprintf("%s:%d", key, trackIndex)
+	if session, exists := sm.audioSessions[sessionKey]; exists {
+		session.Touch()
+		return session, nil
+	}
+
+	outputPath := sm.AudioOutputDir(key, trackIndex)
+	manifestPath := filepath.Join(outputPath, "manifest.m3u8")
+
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		if containsEndList(string(data)) {
+			return nil, nil
+		}
+	}
+
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	args := append(InputArgs(inputPath),
+		"-map", fmt.Sprintf("0:a:%d", trackIndex),
+		"-vn",
 		"-c:a", "aac",
-		"-b:a", profile.AudioBitrate,
+		"-b:a", bitrate,
 		"-ac", "2",
-	)
-
-	// HLS settings for live/progressive output
-	args = append(args,
 		"-f", "hls",
-		"-hls_time", "4",           // 4 second segments for faster start
-		"-hls_list_size", "0",       // Keep all segments in playlist
-		"-hls_flags", "independent_segments+append_list",
+		"-hls_time", hlsTimeArg(),
+		"-hls_list_size", "0",
 		"-hls_segment_type", "mpegts",
-		"-hls_segment_filename", segmentPath,
-		"-y", // Overwrite
+		"-hls_segment_filename", filepath.Join(outputPath, "segment%d.ts"),
+		"-y",
 		manifestPath,
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cmd := exec.CommandContext(ctx, sm.ffmpegPath, args...)
-
-	// Capture stderr for debugging
 	cmd.Stderr = os.Stderr
 
 	session := &TranscodeSession{
-		MediaID:   mediaID,
-		InputPath: inputPath,
-		OutputDir: outputPath,
-		Profile:   profile,
-		StartTime: time.Now(),
-		Cmd:       cmd,
-		Cancel:    cancel,
-		Done:      make(chan struct{}),
+		Key:        key,
+		InputPath:  inputPath,
+		OutputDir:  outputPath,
+		StartTime:  time.Now(),
+		lastAccess: time.Now(),
+		Cmd:        cmd,
+		Cancel:     cancel,
+		Done:       make(chan struct{}),
 	}
 
-	// Start transcoding in background
 	go func() {
 		defer close(session.Done)
 		defer func() {
 			sm.mu.Lock()
-			delete(sm.sessions, mediaID)
+			if sm.audioSessions[sessionKey] == session {
+				delete(sm.audioSessions, sessionKey)
+			}
 			sm.mu.Unlock()
 		}()
 
-		log.Printf("Starting live transcode for media %d with profile %s", mediaID, profile.Name)
+		log.Printf("Starting audio transcode for media %s track %d", key, trackIndex)
 
 		if err := cmd.Run(); err != nil {
 			session.mu.Lock()
 			session.Error = err
 			session.mu.Unlock()
-			log.Printf("Transcode error for media %d: %v", mediaID, err)
+			log.Printf("Audio transcode error for media %s track %d: %v", key, trackIndex, err)
 			return
 		}
 
-		log.Printf("Transcode complete for media %d", mediaID)
+		log.Printf("Audio transcode complete for media %s track %d", key, trackIndex)
 	}()
 
+	sm.audioSessions[sessionKey] = session
 	return session, nil
 }
 
+// AudioOutputDir returns the directory holding an audio rendition's playlist and segments
+func (sm *SessionManager) AudioOutputDir(key string, trackIndex int) string {
+	return filepath.Join(sm.outputDir, key, fmt.Sprintf("audio_%d", trackIndex))
+}
+
+// WaitForAudioSegments waits for initial segments of an audio rendition
+func (sm *SessionManager) WaitForAudioSegments(key string, trackIndex int, minSegments int, timeout time.Duration) error {
+	return sm.waitForSegmentFiles(sm.AudioOutputDir(key, trackIndex), minSegments, timeout)
+}
+
 // WaitForSegments waits for initial segments to be available
-func (sm *SessionManager) WaitForSegments(mediaID int64, minSegments int, timeout time.Duration) error {
-	outputPath := filepath.Join(sm.outputDir, fmt.Sprintf("%d", mediaID))
+func (sm *SessionManager) WaitForSegments(key string, minSegments int, timeout time.Duration) error {
+	return sm.waitForSegmentFiles(filepath.Join(sm.outputDir, key), minSegments, timeout)
+}
+
+// WaitForFile waits until path exists or the timeout passes, returning
+// whether it appeared
+func (sm *SessionManager) WaitForFile(path string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(sm.pollInterval)
+	}
+}
+
+func (sm *SessionManager) waitForSegmentFiles(outputPath string, minSegments int, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 
 	for time.Now().Before(deadline) {
@@ -215,62 +825,198 @@ func (sm *SessionManager) WaitForSegments(mediaID int64, minSegments int, timeou
 			return nil
 		}
 
-		time.Sleep(500 * time.Millisecond)
+		time.Sleep(sm.pollInterval)
 	}
 
 	return fmt.Errorf("timeout waiting for segments")
 }
 
+func hlsTimeArg() string {
+	return fmt.Sprintf("%d", int(HLSSegmentDuration/time.Second))
+}
+
 // GetSession returns an active session if one exists
-func (sm *SessionManager) GetSession(mediaID int64) *TranscodeSession {
+func (sm *SessionManager) GetSession(key string) *TranscodeSession {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	return sm.sessions[mediaID]
+	return sm.sessions[key]
 }
 
-// StopSession stops a transcoding session
-func (sm *SessionManager) StopSession(mediaID int64) {
+// StopSession stops a transcoding session or download build
+func (sm *SessionManager) StopSession(key string) {
 	sm.mu.Lock()
-	session, exists := sm.sessions[mediaID]
+	session, exists := sm.sessions[key]
 	if exists {
-		delete(sm.sessions, mediaID)
+		delete(sm.sessions, key)
+	}
+	var downloads []*downloadBuild
+	for outputPath, b := range sm.downloads {
+		if b.job.Key == key {
+			downloads = append(downloads, b)
+			delete(sm.downloads, outputPath)
+		}
+	}
+	var audioSessions []*TranscodeSession
+	prefix := key + ":"
+	for audioKey, s := range sm.audioSessions {
+		if strings.HasPrefix(audioKey, prefix) {
+			audioSessions = append(audioSessions, s)
+			delete(sm.audioSessions, audioKey)
+		}
 	}
 	sm.mu.Unlock()
 
 	if session != nil {
 		session.Cancel()
 	}
+	for _, s := range audioSessions {
+		s.Cancel()
+	}
+	for _, b := range downloads {
+		b.cancel()
+	}
 }
 
-// StopAllSessions stops all active sessions
+// StopAllSessions stops all active sessions and download builds
 func (sm *SessionManager) StopAllSessions() {
 	sm.mu.Lock()
 	sessions := make([]*TranscodeSession, 0, len(sm.sessions))
 	for _, s := range sm.sessions {
 		sessions = append(sessions, s)
 	}
-	sm.sessions = make(map[int64]*TranscodeSession)
+	for _, s := range sm.audioSessions {
+		sessions = append(sessions, s)
+	}
+	downloads := sm.downloads
+	sm.sessions = make(map[string]*TranscodeSession)
+	sm.audioSessions = make(map[string]*TranscodeSession)
+	sm.downloads = make(map[string]*downloadBuild)
 	sm.mu.Unlock()
 
 	for _, s := range sessions {
 		s.Cancel()
 	}
+	for _, b := range downloads {
+		b.cancel()
+	}
 }
 
 // IsTranscoding checks if a media item is currently being transcoded
-func (sm *SessionManager) IsTranscoding(mediaID int64) bool {
+func (sm *SessionManager) IsTranscoding(key string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	_, exists := sm.sessions[key]
+	return exists
+}
+
+// GetAudioSession returns an active audio rendition session if one exists
+func (sm *SessionManager) GetAudioSession(key string, trackIndex int) *TranscodeSession {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.audioSessions[fmt.Sprintf("%s:%d", key, trackIndex)]
+}
+
+// ActiveSessionCount returns the number of running video and audio
+// transcodes and download builds
+func (sm *SessionManager) ActiveSessionCount() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.sessions) + len(sm.audioSessions) + len(sm.downloads)
+}
+
+// IsAudioTranscoding checks if an audio rendition is currently being transcoded
+func (sm *SessionManager) IsAudioTranscoding(key string, trackIndex int) bool {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	_, exists := sm.sessions[mediaID]
+	_, exists := sm.audioSessions[fmt.Sprintf("%s:%d", key, trackIndex)]
 	return exists
 }
 
+// SessionInfo is a snapshot of a running transcode, for monitoring
+type SessionInfo struct {
+	Key        string    // session key, as passed to StopSession
+	Profile    string    // transcode profile name, or "remux" for downloads; empty for audio renditions
+	AudioTrack int       // audio track of an audio rendition, -1 for video sessions and downloads
+	Download   bool      // an MP4 download build rather than an HLS session
+	InputPath  string    // ffmpeg input
+	StartTime  time.Time // when ffmpeg was started
+	Segments   int       // segments written so far; 0 for downloads
+}
+
+// ListSessions returns the running video and audio transcodes and download
+// builds, oldest first
+func (sm *SessionManager) ListSessions() []SessionInfo {
+	sm.mu.RLock()
+	infos := make([]SessionInfo, 0, len(sm.sessions)+len(sm.audioSessions)+len(sm.downloads))
+	dirs := make([]string, 0, cap(infos))
+	starts := make([]int, 0, cap(infos))
+	for _, s := range sm.sessions {
+		infos = append(infos, SessionInfo{
+			Key:        s.Key,
+			Profile:    s.Profile.Name,
+			AudioTrack: -1,
+			InputPath:  s.InputPath,
+			StartTime:  s.StartTime,
+		})
+		dir := s.OutputDir
+		if len(s.Variants) > 0 {
+			dir = filepath.Join(dir, s.Variants[0])
+		}
+		dirs = append(dirs, dir)
+		starts = append(starts, s.StartSegment)
+	}
+	for audioKey, s := range sm.audioSessions {
+		track := -1
+		if i := strings.LastIndex(audioKey, ":"); i >= 0 {
+			fmt.Sscanf(audioKey[i+1:], "%d", &track)
+		}
+		infos = append(infos, SessionInfo{
+			Key:        s.Key,
+			AudioTrack: track,
+			InputPath:  s.InputPath,
+			StartTime:  s.StartTime,
+		})
+		dirs = append(dirs, s.OutputDir)
+		starts = append(starts, 0)
+	}
+	for _, b := range sm.downloads {
+		infos = append(infos, SessionInfo{
+			Key:        b.job.Key,
+			Profile:    b.job.Variant,
+			AudioTrack: -1,
+			Download:   true,
+			InputPath:  b.job.InputPath,
+			StartTime:  b.startTime,
+		})
+	}
+	sm.mu.RUnlock()
+
+	// Count segments outside the lock; it touches the disk
+	for i := range infos {
+		if !infos[i].Download {
+			infos[i].Segments = countSegmentsFrom(dirs[i], starts[i])
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartTime.Before(infos[j].StartTime) })
+	return infos
+}
+
 // GetAvailableSegments returns the count of available segments
-func (sm *SessionManager) GetAvailableSegments(mediaID int64) int {
-	outputPath := filepath.Join(sm.outputDir, fmt.Sprintf("%d", mediaID))
+func (sm *SessionManager) GetAvailableSegments(key string) int {
+	return countSegments(filepath.Join(sm.outputDir, key))
+}
+
+// countSegments counts the consecutive segment files in an output directory
+func countSegments(outputPath string) int {
+	return countSegmentsFrom(outputPath, 0)
+}
+
+// countSegmentsFrom counts the consecutive segment files in an output
+// directory starting at segment number start
+func countSegmentsFrom(outputPath string, start int) int {
 	count := 0
 
-	for i := 0; i < 10000; i++ {
+	for i := start; i < start+10000; i++ {
 		segmentPath := filepath.Join(outputPath, fmt.Sprintf("segment%d.ts", i))
 		if _, err := os.Stat(segmentPath); os.IsNotExist(err) {
 			break
@@ -0,0 +1,264 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newTestSessionManager returns a session manager whose "ffmpeg" runs until
+// it's stopped without writing anything
+func newTestSessionManager(t *testing.T) *SessionManager {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	fake := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(fake, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatalf("write fake ffmpeg: %v", err)
+	}
+	sm := NewSessionManager(fake, filepath.Join(dir, "transcode"), false, "")
+	t.Cleanup(sm.StopAllSessions)
+	return sm
+}
+
+func TestGetOrStartSessionRejectsPastLimit(t *testing.T) {
+	sm := newTestSessionManager(t)
+	sm.SetMaxSessions(2)
+
+	for _, key := range []string{"1", "2"} {
+		if _, err := sm.GetOrStartSession(key, "/media/"+key+".mkv", Profiles["720p"], 0); err != nil {
+			t.Fatalf("GetOrStartSession(%s): %v", key, err)
+		}
+	}
+
+	if _, err := sm.GetOrStartSession("3", "/media/3.mkv", Profiles["720p"], 0); !errors.Is(err, ErrTranscodeBusy) {
+		t.Fatalf("third session: err = %v, want ErrTranscodeBusy", err)
+	}
+	if sm.IsTranscoding("3") {
+		t.Error("rejected session is running")
+	}
+
+	// Requests for a running session still succeed at the limit
+	if _, err := sm.GetOrStartSession("1", "/media/1.mkv", Profiles["720p"], 0); err != nil {
+		t.Errorf("running session at the limit: %v", err)
+	}
+}
+
+func TestGetOrStartSessionEvictsIdleSession(t *testing.T) {
+	sm := newTestSessionManager(t)
+	sm.SetMaxSessions(2)
+
+	sessions := make(map[string]*TranscodeSession)
+	for _, key := range []string{"1", "2"} {
+		session, err := sm.GetOrStartSession(key, "/media/"+key+".mkv", Profiles["720p"], 0)
+		if err != nil {
+			t.Fatalf("GetOrStartSession(%s): %v", key, err)
+		}
+		sessions[key] = session
+	}
+
+	// Nobody has requested session 1 for a while
+	sessions["1"].mu.Lock()
+	sessions["1"].lastAccess = time.Now().Add(-2 * evictableIdle)
+	sessions["1"].mu.Unlock()
+
+	if _, err := sm.GetOrStartSession("3", "/media/3.mkv", Profiles["720p"], 0); err != nil {
+		t.Fatalf("third session with one idle: %v", err)
+	}
+	if sm.IsTranscoding("1") {
+		t.Error("idle session 1 is still running")
+	}
+	if !sm.IsTranscoding("2") || !sm.IsTranscoding("3") {
+		t.Error("sessions 2 and 3 should be running")
+	}
+}
+
+func TestBuildDownloadCountsAgainstLimit(t *testing.T) {
+	sm := newTestSessionManager(t)
+	sm.SetMaxSessions(2)
+	dir := t.TempDir()
+
+	if _, err := sm.GetOrStartSession("1", "/media/1.mkv", Profiles["720p"], 0); err != nil {
+		t.Fatalf("GetOrStartSession(1): %v", err)
+	}
+
+	// A download build takes the second slot until it finishes
+	release := make(chan struct{})
+	started := make(chan struct{})
+	first := make(chan error, 1)
+	go func() {
+		first <- sm.BuildDownload(context.Background(), DownloadJob{
+			Key:        "a",
+			OutputPath: filepath.Join(dir, "a.mp4"),
+			Build: func(ctx context.Context, outputPath string) error {
+				close(started)
+				<-release
+				return os.WriteFile(outputPath, nil, 0644)
+			},
+		})
+	}()
+	<-started
+
+	if _, err := sm.GetOrStartSession("2", "/media/2.mkv", Profiles["720p"], 0); !errors.Is(err, ErrTranscodeBusy) {
+		t.Errorf("session with a download building: err = %v, want ErrTranscodeBusy", err)
+	}
+	built := false
+	err := sm.BuildDownload(context.Background(), DownloadJob{
+		Key:        "b",
+		OutputPath: filepath.Join(dir, "b.mp4"),
+		Build: func(ctx context.Context, outputPath string) error {
+			built = true
+			return nil
+		},
+	})
+	if !errors.Is(err, ErrTranscodeBusy) || built {
+		t.Errorf("second download: err = %v, built %v; want ErrTranscodeBusy without building", err, built)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	if _, err := sm.GetOrStartSession("2", "/media/2.mkv", Profiles["720p"], 0); err != nil {
+		t.Errorf("session after the download finished: %v", err)
+	}
+}
+
+// blockingDownload returns a download job whose build runs until it's
+// stopped, closing started when it begins and stopped when it returns
+func blockingDownload(dir, key string) (job DownloadJob, started, stopped chan struct{}) {
+	started, stopped = make(chan struct{}), make(chan struct{})
+	job = DownloadJob{
+		Key:        key,
+		Variant:    "720p",
+		InputPath:  "/media/" + key + ".mkv",
+		OutputPath: filepath.Join(dir, key+".mp4"),
+		Build: func(ctx context.Context, outputPath string) error {
+			close(started)
+			<-ctx.Done()
+			close(stopped)
+			return ctx.Err()
+		},
+	}
+	return job, started, stopped
+}
+
+func TestBuildDownloadStopsWhenAbandoned(t *testing.T) {
+	sm := newTestSessionManager(t)
+	sm.abandonAfter = 10 * time.Millisecond
+	job, started, stopped := blockingDownload(t.TempDir(), "12-download-720p")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- sm.BuildDownload(ctx, job) }()
+	<-started
+
+	sessions := sm.ListSessions()
+	if len(sessions) != 1 || !sessions[0].Download || sessions[0].Key != job.Key || sessions[0].Profile != "720p" {
+		t.Errorf("ListSessions() = %+v, want the download build", sessions)
+	}
+
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("BuildDownload after the client left: err = %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("abandoned build is still running")
+	}
+	if n := sm.ActiveSessionCount(); n != 0 {
+		t.Errorf("ActiveSessionCount() = %d after the build stopped, want 0", n)
+	}
+}
+
+func TestStopSessionStopsDownload(t *testing.T) {
+	sm := newTestSessionManager(t)
+	job, started, stopped := blockingDownload(t.TempDir(), "12-download-720p")
+
+	result := make(chan error, 1)
+	go func() { result <- sm.BuildDownload(context.Background(), job) }()
+	<-started
+
+	sm.StopSession(job.Key)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped build is still running")
+	}
+	if err := <-result; err == nil {
+		t.Error("BuildDownload of a stopped build succeeded")
+	}
+	if sessions := sm.ListSessions(); len(sessions) != 0 {
+		t.Errorf("ListSessions() = %+v after stopping, want none", sessions)
+	}
+}
+
+func TestExpireDownloads(t *testing.T) {
+	sm := newTestSessionManager(t)
+	sm.SetDownloadExpiry(time.Hour)
+
+	write := func(path string, age time.Duration) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	oldOnly := filepath.Join(sm.outputDir, "1", "download_720p.mp4")
+	oldPart := filepath.Join(sm.outputDir, "1", "download_1080p.mp4.part")
+	oldWithSegments := filepath.Join(sm.outputDir, "2", "download_remux.mp4")
+	segment := filepath.Join(sm.outputDir, "2", "segment0.ts")
+	fresh := filepath.Join(sm.outputDir, "3", "download_480p.mp4")
+	served := filepath.Join(sm.outputDir, "4", "download_480p.mp4")
+	write(oldOnly, 2*time.Hour)
+	write(oldPart, 2*time.Hour)
+	write(oldWithSegments, 2*time.Hour)
+	write(segment, 2*time.Hour)
+	write(fresh, time.Minute)
+	write(served, 2*time.Hour)
+
+	// Serving a cached download counts as using it
+	err := sm.BuildDownload(context.Background(), DownloadJob{
+		Key:        "4-download-480p",
+		OutputPath: served,
+		Build: func(ctx context.Context, outputPath string) error {
+			t.Error("cached download was rebuilt")
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildDownload of a cached file: %v", err)
+	}
+
+	if n := sm.ExpireDownloads(); n != 3 {
+		t.Errorf("ExpireDownloads() = %d, want 3", n)
+	}
+	for _, path := range []string{oldOnly, oldPart, oldWithSegments, filepath.Dir(oldOnly)} {
+		if exists(path) {
+			t.Errorf("%s was kept, want it deleted", path)
+		}
+	}
+	for _, path := range []string{segment, fresh, served} {
+		if !exists(path) {
+			t.Errorf("%s was deleted, want it kept", path)
+		}
+	}
+}
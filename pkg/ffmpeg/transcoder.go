@@ -3,10 +3,13 @@ package ffmpeg
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // TranscodeProfile defines transcoding settings
@@ -17,6 +20,17 @@ type TranscodeProfile struct {
 	VideoBitrate string
 	AudioBitrate string
 	Preset     string
+	CRF        int // constant quality (0 = target bitrate mode); VideoBitrate becomes the cap
+	SampleRate int  // audio output sample rate in Hz (0 = keep the source rate)
+	Normalize  bool // even out loudness with the loudnorm filter
+	AudioTrack int  // audio stream to encode, counted among the audio streams (AudioTrack.Index)
+	// BurnSubtitle is drawn into the video when set. Image tracks are
+	// overlaid, text tracks rendered with the subtitles filter.
+	BurnSubtitle *SubtitleTrack
+	// PixelFormat of the output video ("" keeps the source format). 8-bit
+	// yuv420p is what every H.264 decoder handles; 10-bit and 4:4:4 sources
+	// fail in libx264's default profiles without it.
+	PixelFormat string
 }
 
 // Common transcoding profiles
@@ -28,6 +42,7 @@ var Profiles = map[string]TranscodeProfile{
 		VideoBitrate: "8M",
 		AudioBitrate: "192k",
 		Preset:       "fast",
+		PixelFormat:  "yuv420p",
 	},
 	"720p": {
 		Name:         "720p",
@@ -36,6 +51,7 @@ var Profiles = map[string]TranscodeProfile{
 		VideoBitrate: "4M",
 		AudioBitrate: "128k",
 		Preset:       "fast",
+		PixelFormat:  "yuv420p",
 	},
 	"480p": {
 		Name:         "480p",
@@ -44,9 +60,115 @@ var Profiles = map[string]TranscodeProfile{
 		VideoBitrate: "1.5M",
 		AudioBitrate: "128k",
 		Preset:       "fast",
+		PixelFormat:  "yuv420p",
 	},
 }
 
+// VideoRateArgs returns the ffmpeg rate-control arguments for the profile.
+// With a CRF set, the encoder runs in constant-quality mode capped at
+// VideoBitrate; otherwise it targets VideoBitrate. Hardware encoders don't
+// share libx264's CRF scale, so they always use bitrate mode.
+func (p TranscodeProfile) VideoRateArgs(hwAccel bool) []string {
+	if p.CRF <= 0 || hwAccel {
+		return []string{"-b:v", p.VideoBitrate}
+	}
+
+	args := []string{"-crf", strconv.Itoa(p.CRF)}
+	if maxRate := parseBitrate(p.VideoBitrate); maxRate > 0 {
+		args = append(args,
+			"-maxrate", p.VideoBitrate,
+			"-bufsize", strconv.FormatInt(maxRate*2, 10),
+		)
+	}
+	return args
+}
+
+// VideoFormatArgs returns the pixel format and matching H.264 profile/level
+// arguments for encoder. VAAPI frames stay on the GPU, so they're converted
+// in the scale filter instead (see VAAPIScaleFilter).
+func (p TranscodeProfile) VideoFormatArgs(encoder string) []string {
+	if p.PixelFormat == "" || encoder == "h264_vaapi" {
+		return nil
+	}
+
+	args := []string{"-pix_fmt", p.PixelFormat}
+	if p.PixelFormat != "yuv420p" {
+		return args // profile is left for the encoder to pick
+	}
+	args = append(args, "-profile:v", "high")
+	if encoder == "libx264" {
+		level := "4.1"
+		if p.Height > 1080 {
+			level = "5.1"
+		}
+		args = append(args, "-level:v", level)
+	}
+	return args
+}
+
+// VAAPIScaleFilter returns the scale_vaapi filter for the profile, converting
+// to the GPU's 8-bit format when a pixel format is set
+func (p TranscodeProfile) VAAPIScaleFilter() string {
+	filter := fmt.Sprintf("scale_vaapi=w=%d:h=%d", p.Width, p.Height)
+	if p.PixelFormat != "" {
+		filter += ":format=nv12"
+	}
+	return filter
+}
+
+// loudnormFilter targets the EBU R128 levels most streaming services use
+const loudnormFilter = "loudnorm=I=-16:TP=-1.5:LRA=11"
+
+// AudioArgs returns the ffmpeg arguments for the stereo AAC audio output
+func (p TranscodeProfile) AudioArgs() []string {
+	args := []string{
+		"-c:a", "aac",
+		"-b:a", p.AudioBitrate,
+		"-ac", "2",
+	}
+	if p.Normalize {
+		args = append(args, "-af", loudnormFilter)
+	}
+	// loudnorm resamples to 192kHz internally, so it needs an explicit
+	// output rate to get back to something AAC handles well
+	if p.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+	} else if p.Normalize {
+		args = append(args, "-ar", "48000")
+	}
+	return args
+}
+
+// Bandwidth returns the combined video and audio bitrate in bits per second,
+// as advertised in #EXT-X-STREAM-INF
+func (p TranscodeProfile) Bandwidth() int64 {
+	return parseBitrate(p.VideoBitrate) + parseBitrate(p.AudioBitrate)
+}
+
+// parseBitrate converts an ffmpeg bitrate string like "8M" or "192k" to bits per second
+func parseBitrate(bitrate string) int64 {
+	bitrate = strings.TrimSpace(bitrate)
+	if bitrate == "" {
+		return 0
+	}
+
+	multiplier := 1.0
+	switch bitrate[len(bitrate)-1] {
+	case 'k', 'K':
+		multiplier = 1000
+		bitrate = bitrate[:len(bitrate)-1]
+	case 'm', 'M':
+		multiplier = 1000000
+		bitrate = bitrate[:len(bitrate)-1]
+	}
+
+	value, err := strconv.ParseFloat(bitrate, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(value * multiplier)
+}
+
 // Transcoder handles video transcoding
 type Transcoder struct {
 	ffmpegPath    string
@@ -66,8 +188,8 @@ func NewTranscoder(ffmpegPath, outputDir string, enableHWAccel bool, hwAccelType
 }
 
 // TranscodeToHLS transcodes a video to HLS format
-func (t *Transcoder) TranscodeToHLS(ctx context.Context, inputPath string, mediaID int64, profile TranscodeProfile) error {
-	outputPath := filepath.Join(t.outputDir, fmt.Sprintf("%d", mediaID))
+func (t *Transcoder) TranscodeToHLS(ctx context.Context, inputPath string, key string, profile TranscodeProfile) error {
+	outputPath := filepath.Join(t.outputDir, key)
 
 	// Create output directory
 	if err := os.MkdirAll(outputPath, 0755); err != nil {
@@ -77,24 +199,55 @@ func (t *Transcoder) TranscodeToHLS(ctx context.Context, inputPath string, media
 	manifestPath := filepath.Join(outputPath, "manifest.m3u8")
 	segmentPath := filepath.Join(outputPath, "segment%d.ts")
 
-	args := []string{}
+	args := t.hwAccelArgs()
 
-	// Hardware acceleration
-	if t.enableHWAccel {
-		switch t.hwAccelType {
-		case "videotoolbox":
-			args = append(args, "-hwaccel", "videotoolbox")
-		case "nvenc":
-			args = append(args, "-hwaccel", "cuda")
-		case "qsv":
-			args = append(args, "-hwaccel", "qsv")
-		}
+	// Input
+	args = append(args, InputArgs(inputPath)...)
+
+	// Video and audio encoding
+	args = append(args, t.encodeArgs(profile)...)
+
+	// HLS settings
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", "10",
+		"-hls_list_size", "0",
+		"-hls_segment_filename", segmentPath,
+		manifestPath,
+	)
+
+	cmd := exec.CommandContext(ctx, t.ffmpegPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Printf("Starting transcode for media %s with profile %s", key, profile.Name)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("transcoding failed: %w", err)
 	}
 
-	// Input
-	args = append(args, "-i", inputPath)
+	log.Printf("Transcode complete for media %s", key)
+	return nil
+}
+
+// hwAccelArgs returns the decoder flags for the configured hardware acceleration
+func (t *Transcoder) hwAccelArgs() []string {
+	if !t.enableHWAccel {
+		return nil
+	}
+	switch t.hwAccelType {
+	case "videotoolbox":
+		return []string{"-hwaccel", "videotoolbox"}
+	case "nvenc":
+		return []string{"-hwaccel", "cuda"}
+	case "qsv":
+		return []string{"-hwaccel", "qsv"}
+	}
+	return nil
+}
 
-	// Video encoding
+// encodeArgs returns the H.264/AAC encoding arguments for a profile
+func (t *Transcoder) encodeArgs(profile TranscodeProfile) []string {
 	videoCodec := "libx264"
 	if t.enableHWAccel {
 		switch t.hwAccelType {
@@ -107,58 +260,101 @@ func (t *Transcoder) TranscodeToHLS(ctx context.Context, inputPath string, media
 		}
 	}
 
-	args = append(args,
+	args := []string{
 		"-c:v", videoCodec,
 		"-vf", fmt.Sprintf("scale=%d:%d", profile.Width, profile.Height),
-		"-b:v", profile.VideoBitrate,
-		"-preset", profile.Preset,
-	)
+	}
+	args = append(args, profile.VideoRateArgs(t.enableHWAccel)...)
+	args = append(args, profile.VideoFormatArgs(videoCodec)...)
+	args = append(args, "-preset", profile.Preset)
 
-	// Audio encoding
-	args = append(args,
-		"-c:a", "aac",
-		"-b:a", profile.AudioBitrate,
-		"-ac", "2",
-	)
+	return append(args, profile.AudioArgs()...)
+}
 
-	// HLS settings
+// TranscodeToMP4 transcodes a video to a single progressive MP4 at outputPath
+func (t *Transcoder) TranscodeToMP4(ctx context.Context, inputPath, outputPath string, profile TranscodeProfile) error {
+	args := t.hwAccelArgs()
+	args = append(args, InputArgs(inputPath)...)
+	args = append(args, "-map", "0:v:0", "-map", "0:a:0?")
+	args = append(args, t.encodeArgs(profile)...)
+	return t.writeMP4(ctx, args, outputPath)
+}
+
+// RemuxToMP4 copies the video stream into an MP4 at outputPath, converting
+// the audio to AAC unless it already is
+func (t *Transcoder) RemuxToMP4(ctx context.Context, inputPath, outputPath string, audioCodec string) error {
+	args := append(InputArgs(inputPath), "-map", "0:v:0", "-map", "0:a:0?", "-c:v", "copy")
+	if audioCodec == "aac" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "192k", "-ac", "2")
+	}
+	return t.writeMP4(ctx, args, outputPath)
+}
+
+// RemuxFrom copies the streams of inputPath from startSeconds onwards to w
+// without re-encoding, as fragmented MP4 or Matroska (format "mp4" or
+// "matroska") so the output can be written progressively. The copy starts
+// at the keyframe before startSeconds.
+func (t *Transcoder) RemuxFrom(ctx context.Context, inputPath string, startSeconds int, format string, w io.Writer) error {
+	args := append([]string{"-ss", strconv.Itoa(startSeconds)}, InputArgs(inputPath)...)
 	args = append(args,
-		"-f", "hls",
-		"-hls_time", "10",
-		"-hls_list_size", "0",
-		"-hls_segment_filename", segmentPath,
-		manifestPath,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-c", "copy",
 	)
+	if format == "mp4" {
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+	}
+	args = append(args, "-f", format, "pipe:1")
 
 	cmd := exec.CommandContext(ctx, t.ffmpegPath, args...)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = w
 	cmd.Stderr = os.Stderr
 
-	log.Printf("Starting transcode for media %d with profile %s", mediaID, profile.Name)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("remux failed: %w", err)
+	}
+	return nil
+}
+
+// writeMP4 runs ffmpeg with the given input/codec args, writing a faststart
+// MP4. Output goes to a temporary file that is renamed into place on success
+// so a partial file is never mistaken for a finished one.
+func (t *Transcoder) writeMP4(ctx context.Context, args []string, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	tmpPath := outputPath + ".part"
+	args = append(args, "-movflags", "+faststart", "-f", "mp4", "-y", tmpPath)
+
+	cmd := exec.CommandContext(ctx, t.ffmpegPath, args...)
+	cmd.Stderr = os.Stderr
+
+	log.Printf("Writing MP4 %s", outputPath)
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("transcoding failed: %w", err)
+		os.Remove(tmpPath)
+		return fmt.Errorf("mp4 transcode failed: %w", err)
 	}
 
-	log.Printf("Transcode complete for media %d", mediaID)
-	return nil
+	return os.Rename(tmpPath, outputPath)
 }
 
 // ExtractSubtitles extracts subtitles from a video file to VTT format
-func (t *Transcoder) ExtractSubtitles(inputPath string, mediaID int64, trackIndex int, language string) error {
-	outputPath := filepath.Join(t.outputDir, fmt.Sprintf("%d", mediaID))
+func (t *Transcoder) ExtractSubtitles(inputPath string, key string, trackIndex int, language string) error {
+	outputPath := filepath.Join(t.outputDir, key)
 	if err := os.MkdirAll(outputPath, 0755); err != nil {
 		return err
 	}
 
 	subtitlePath := filepath.Join(outputPath, fmt.Sprintf("subtitle_%s.vtt", language))
 
-	args := []string{
-		"-i", inputPath,
+	args := append(InputArgs(inputPath),
 		"-map", fmt.Sprintf("0:s:%d", trackIndex),
 		"-c:s", "webvtt",
 		subtitlePath,
-	}
+	)
 
 	cmd := exec.Command(t.ffmpegPath, args...)
 	if err := cmd.Run(); err != nil {
@@ -169,22 +365,21 @@ func (t *Transcoder) ExtractSubtitles(inputPath string, mediaID int64, trackInde
 }
 
 // GenerateThumbnail creates a thumbnail image from a video
-func (t *Transcoder) GenerateThumbnail(inputPath string, mediaID int64, seekSeconds int) error {
-	outputPath := filepath.Join(t.outputDir, fmt.Sprintf("%d", mediaID))
+func (t *Transcoder) GenerateThumbnail(inputPath string, key string, seekSeconds int) error {
+	outputPath := filepath.Join(t.outputDir, key)
 	if err := os.MkdirAll(outputPath, 0755); err != nil {
 		return err
 	}
 
 	thumbnailPath := filepath.Join(outputPath, "thumbnail.jpg")
 
-	args := []string{
-		"-ss", fmt.Sprintf("%d", seekSeconds),
-		"-i", inputPath,
+	args := append([]string{"-ss", fmt.Sprintf("%d", seekSeconds)}, InputArgs(inputPath)...)
+	args = append(args,
 		"-vframes", "1",
 		"-vf", "scale=320:-1",
 		"-q:v", "2",
 		thumbnailPath,
-	}
+	)
 
 	cmd := exec.Command(t.ffmpegPath, args...)
 	if err := cmd.Run(); err != nil {
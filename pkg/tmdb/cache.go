@@ -0,0 +1,149 @@
+package tmdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCache keeps TMDB responses as JSON files named by a hash of the
+// endpoint and parameters. Files older than ttl are refetched.
+type diskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// EnableCache caches responses under dir for ttl, so rescans and restarts
+// don't look up already matched titles again
+func (c *Client) EnableCache(dir string, ttl time.Duration) {
+	c.cache = &diskCache{dir: dir, ttl: ttl}
+}
+
+type bypassCacheKey struct{}
+
+// BypassCache returns a context whose requests skip cached responses. The
+// fresh responses still replace the cached ones.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func (d *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached response for key, if there's one younger than ttl
+func (d *diskCache) get(key string) ([]byte, bool) {
+	path := d.path(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > d.ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// put caches a response, writing it to a temporary file first so readers
+// never see a partial one
+func (d *diskCache) put(key string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+// cacheKey is the endpoint and parameters of a request URL, without the
+// API key
+func cacheKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Del("api_key")
+	return u.Path + "?" + query.Encode()
+}
+
+// getJSON decodes the response of a GET request into v, from the cache when
+// it has a fresh copy. Only successful responses with results are cached:
+// a search finding nothing may find the title once TMDB adds it.
+func (c *Client) getJSON(ctx context.Context, url string, v any) error {
+	key := cacheKey(url)
+	if c.cache != nil && ctx.Value(bypassCacheKey{}) == nil {
+		if data, ok := c.cache.get(key); ok && json.Unmarshal(data, v) == nil {
+			return nil
+		}
+	}
+
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDB API error: %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+
+	if c.cache != nil && !emptyResponse(data) {
+		if err := c.cache.put(key, data); err != nil {
+			log.Printf("Failed to cache TMDB response: %v", err)
+		}
+	}
+	return nil
+}
+
+// emptyResponse reports whether a response has nothing worth caching: a
+// list with no results, a find with no matches, or an object without an ID
+func emptyResponse(data []byte) bool {
+	var response struct {
+		ID           json.RawMessage    `json:"id"`
+		Results      *[]json.RawMessage `json:"results"`
+		MovieResults *[]json.RawMessage `json:"movie_results"`
+		TVResults    *[]json.RawMessage `json:"tv_results"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return true
+	}
+	if response.Results != nil {
+		return len(*response.Results) == 0
+	}
+	if response.MovieResults != nil || response.TVResults != nil {
+		return (response.MovieResults == nil || len(*response.MovieResults) == 0) &&
+			(response.TVResults == nil || len(*response.TVResults) == 0)
+	}
+	return len(response.ID) == 0 || string(response.ID) == "null"
+}
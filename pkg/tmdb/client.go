@@ -1,11 +1,13 @@
 package tmdb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,15 +19,22 @@ const baseURL = "https://api.themoviedb.org/3"
 type Client struct {
 	apiKey     string
 	httpClient *http.Client
+	limiter    *rateLimiter
+	maxRetries int
+	cache      *diskCache // nil unless EnableCache was called
 }
 
-// NewClient creates a new TMDB client
-func NewClient(apiKey string) *Client {
+// NewClient creates a new TMDB client sending at most requestsPerSecond
+// requests (0 for no limit) and retrying rate limited or failed requests up
+// to maxRetries times
+func NewClient(apiKey string, requestsPerSecond float64, maxRetries int) *Client {
 	return &Client{
 		apiKey: apiKey,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		limiter:    newRateLimiter(requestsPerSecond),
+		maxRetries: maxRetries,
 	}
 }
 
@@ -53,17 +62,18 @@ type MovieSearchResult = MovieResult
 
 // MovieDetails represents detailed movie info
 type MovieDetails struct {
-	ID            int     `json:"id"`
-	Title         string  `json:"title"`
-	OriginalTitle string  `json:"original_title"`
-	Overview      string  `json:"overview"`
-	ReleaseDate   string  `json:"release_date"`
-	PosterPath    string  `json:"poster_path"`
-	BackdropPath  string  `json:"backdrop_path"`
-	VoteAverage   float64 `json:"vote_average"`
-	Runtime       int     `json:"runtime"`
-	IMDbID        string  `json:"imdb_id"`
-	Genres        []Genre `json:"genres"`
+	ID            int      `json:"id"`
+	Title         string   `json:"title"`
+	OriginalTitle string   `json:"original_title"`
+	Overview      string   `json:"overview"`
+	ReleaseDate   string   `json:"release_date"`
+	PosterPath    string   `json:"poster_path"`
+	BackdropPath  string   `json:"backdrop_path"`
+	VoteAverage   float64  `json:"vote_average"`
+	Runtime       int      `json:"runtime"`
+	IMDbID        string   `json:"imdb_id"`
+	Genres        []Genre  `json:"genres"`
+	Credits       *Credits `json:"credits,omitempty"`
 }
 
 // TVResult represents a TV show search result
@@ -98,6 +108,16 @@ type TVDetails struct {
 	Genres          []Genre  `json:"genres"`
 	Status          string   `json:"status"` // Returning Series, Ended, Canceled, etc.
 	ExternalIDs     *ExternalIDs `json:"external_ids,omitempty"`
+	Credits         *Credits     `json:"credits,omitempty"`
+	Seasons         []SeasonSummary `json:"seasons"`
+}
+
+// SeasonSummary represents a season in TV show details
+type SeasonSummary struct {
+	SeasonNumber int    `json:"season_number"`
+	Name         string `json:"name"`
+	AirDate      string `json:"air_date"`
+	EpisodeCount int    `json:"episode_count"`
 }
 
 // Genre represents a genre
@@ -106,6 +126,21 @@ type Genre struct {
 	Name string `json:"name"`
 }
 
+// Credits is the cast of a movie or show, fetched with its details
+type Credits struct {
+	Cast []CastMember `json:"cast"`
+}
+
+// CastMember is an actor and the character they play. Order is their
+// billing, lowest first.
+type CastMember struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Character   string `json:"character"`
+	ProfilePath string `json:"profile_path"`
+	Order       int    `json:"order"`
+}
+
 // ExternalIDs contains external IDs like IMDB
 type ExternalIDs struct {
 	IMDbID string `json:"imdb_id"`
@@ -153,6 +188,11 @@ type searchResponse struct {
 
 // SearchMovieWithResults returns all matching movies for manual selection
 func (c *Client) SearchMovieWithResults(title string, year int) ([]MovieSearchResult, error) {
+	return c.SearchMovieWithResultsContext(context.Background(), title, year)
+}
+
+// SearchMovieWithResultsContext is SearchMovieWithResults with a context for cancellation
+func (c *Client) SearchMovieWithResultsContext(ctx context.Context, title string, year int) ([]MovieSearchResult, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
@@ -164,21 +204,10 @@ func (c *Client) SearchMovieWithResults(title string, year int) ([]MovieSearchRe
 		params.Set("year", strconv.Itoa(year))
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/search/movie?%s", baseURL, params.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
-	}
-
 	var result struct {
 		Results []MovieSearchResult `json:"results"`
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/search/movie?%s", baseURL, params.Encode()), &result); err != nil {
 		return nil, err
 	}
 
@@ -187,7 +216,12 @@ func (c *Client) SearchMovieWithResults(title string, year int) ([]MovieSearchRe
 
 // SearchMovie searches for movies by title and optional year, returning the best match
 func (c *Client) SearchMovie(title string, year int) (*MovieResult, error) {
-	results, err := c.SearchMovieWithResults(title, year)
+	return c.SearchMovieContext(context.Background(), title, year)
+}
+
+// SearchMovieContext is SearchMovie with a context for cancellation
+func (c *Client) SearchMovieContext(ctx context.Context, title string, year int) (*MovieResult, error) {
+	results, err := c.SearchMovieWithResultsContext(ctx, title, year)
 	if err != nil {
 		return nil, err
 	}
@@ -197,22 +231,17 @@ func (c *Client) SearchMovie(title string, year int) (*MovieResult, error) {
 
 // GetMovieDetails fetches detailed movie info by TMDB ID
 func (c *Client) GetMovieDetails(tmdbID int) (*MovieDetails, error) {
+	return c.GetMovieDetailsContext(context.Background(), tmdbID)
+}
+
+// GetMovieDetailsContext is GetMovieDetails with a context for cancellation
+func (c *Client) GetMovieDetailsContext(ctx context.Context, tmdbID int) (*MovieDetails, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/movie/%d?api_key=%s", baseURL, tmdbID, c.apiKey))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
-	}
-
 	var details MovieDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/movie/%d?api_key=%s&append_to_response=credits", baseURL, tmdbID, c.apiKey), &details); err != nil {
 		return nil, err
 	}
 
@@ -221,6 +250,11 @@ func (c *Client) GetMovieDetails(tmdbID int) (*MovieDetails, error) {
 
 // SearchTVWithResults returns all matching TV shows for manual selection
 func (c *Client) SearchTVWithResults(title string, year int) ([]TVSearchResult, error) {
+	return c.SearchTVWithResultsContext(context.Background(), title, year)
+}
+
+// SearchTVWithResultsContext is SearchTVWithResults with a context for cancellation
+func (c *Client) SearchTVWithResultsContext(ctx context.Context, title string, year int) ([]TVSearchResult, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
@@ -232,21 +266,10 @@ func (c *Client) SearchTVWithResults(title string, year int) ([]TVSearchResult,
 		params.Set("first_air_date_year", strconv.Itoa(year))
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/search/tv?%s", baseURL, params.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
-	}
-
 	var result struct {
 		Results []TVSearchResult `json:"results"`
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/search/tv?%s", baseURL, params.Encode()), &result); err != nil {
 		return nil, err
 	}
 
@@ -255,7 +278,12 @@ func (c *Client) SearchTVWithResults(title string, year int) ([]TVSearchResult,
 
 // SearchTV searches for TV shows by title, returning the best match
 func (c *Client) SearchTV(title string, year int) (*TVResult, error) {
-	results, err := c.SearchTVWithResults(title, year)
+	return c.SearchTVContext(context.Background(), title, year)
+}
+
+// SearchTVContext is SearchTV with a context for cancellation
+func (c *Client) SearchTVContext(ctx context.Context, title string, year int) (*TVResult, error) {
+	results, err := c.SearchTVWithResultsContext(ctx, title, year)
 	if err != nil {
 		return nil, err
 	}
@@ -265,74 +293,278 @@ func (c *Client) SearchTV(title string, year int) (*TVResult, error) {
 
 // GetTVDetails fetches detailed TV show info by TMDB ID
 func (c *Client) GetTVDetails(tmdbID int) (*TVDetails, error) {
+	return c.GetTVDetailsContext(context.Background(), tmdbID)
+}
+
+// GetTVDetailsContext is GetTVDetails with a context for cancellation
+func (c *Client) GetTVDetailsContext(ctx context.Context, tmdbID int) (*TVDetails, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/tv/%d?api_key=%s&append_to_response=external_ids", baseURL, tmdbID, c.apiKey))
-	if err != nil {
+	var details TVDetails
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/tv/%d?api_key=%s&append_to_response=external_ids,credits", baseURL, tmdbID, c.apiKey), &details); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
+	return &details, nil
+}
+
+// GetTVSeasonDetails fetches detailed season info by TMDB show ID and season number
+func (c *Client) GetTVSeasonDetails(showID int, seasonNum int) (*SeasonDetails, error) {
+	return c.GetTVSeasonDetailsContext(context.Background(), showID, seasonNum)
+}
+
+// GetTVSeasonDetailsContext is GetTVSeasonDetails with a context for cancellation
+func (c *Client) GetTVSeasonDetailsContext(ctx context.Context, showID int, seasonNum int) (*SeasonDetails, error) {
+	if !c.IsConfigured() {
+		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	var details TVDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+	var details SeasonDetails
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/tv/%d/season/%d?api_key=%s", baseURL, showID, seasonNum, c.apiKey), &details); err != nil {
 		return nil, err
 	}
 
 	return &details, nil
 }
 
-// GetTVSeasonDetails fetches detailed season info by TMDB show ID and season number
-func (c *Client) GetTVSeasonDetails(showID int, seasonNum int) (*SeasonDetails, error) {
+// GetTVEpisodeDetails fetches detailed episode info by TMDB show ID, season and episode number
+func (c *Client) GetTVEpisodeDetails(showID int, seasonNum int, episodeNum int) (*EpisodeDetails, error) {
+	return c.GetTVEpisodeDetailsContext(context.Background(), showID, seasonNum, episodeNum)
+}
+
+// GetTVEpisodeDetailsContext is GetTVEpisodeDetails with a context for cancellation
+func (c *Client) GetTVEpisodeDetailsContext(ctx context.Context, showID int, seasonNum int, episodeNum int) (*EpisodeDetails, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/tv/%d/season/%d?api_key=%s", baseURL, showID, seasonNum, c.apiKey))
+	var details EpisodeDetails
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/tv/%d/season/%d/episode/%d?api_key=%s", baseURL, showID, seasonNum, episodeNum, c.apiKey), &details); err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
+
+// GetEpisodeByAirDate finds the episode of a show that aired on airDate
+// ("YYYY-MM-DD"), for daily shows named by date. It returns nil if no
+// episode aired that day. Specials aren't searched.
+func (c *Client) GetEpisodeByAirDate(showID int, airDate string) (*EpisodeDetails, error) {
+	return c.GetEpisodeByAirDateContext(context.Background(), showID, airDate)
+}
+
+// GetEpisodeByAirDateContext is GetEpisodeByAirDate with a context for cancellation
+func (c *Client) GetEpisodeByAirDateContext(ctx context.Context, showID int, airDate string) (*EpisodeDetails, error) {
+	details, err := c.GetTVDetailsContext(ctx, showID)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
+	// Episodes air after their season premieres, so the episode is in the
+	// last season to premiere by then. The one before it is searched too in
+	// case the premiere date is off.
+	var seasons []SeasonSummary
+	for _, season := range details.Seasons {
+		if season.SeasonNumber > 0 && season.AirDate != "" && season.AirDate <= airDate {
+			seasons = append(seasons, season)
+		}
+	}
+	sort.Slice(seasons, func(i, j int) bool { return seasons[i].AirDate > seasons[j].AirDate })
+	if len(seasons) > 2 {
+		seasons = seasons[:2]
 	}
 
-	var details SeasonDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+	for _, season := range seasons {
+		seasonDetails, err := c.GetTVSeasonDetailsContext(ctx, showID, season.SeasonNumber)
+		if err != nil {
+			return nil, err
+		}
+		for _, episode := range seasonDetails.Episodes {
+			if episode.AirDate == airDate {
+				return &EpisodeDetails{
+					ID:            episode.ID,
+					EpisodeNumber: episode.EpisodeNumber,
+					SeasonNumber:  season.SeasonNumber,
+					Name:          episode.Name,
+					Overview:      episode.Overview,
+					StillPath:     episode.StillPath,
+					AirDate:       episode.AirDate,
+					Runtime:       episode.Runtime,
+					VoteAverage:   episode.VoteAverage,
+				}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// FindResult is what an external ID resolves to on TMDB. At most one of
+// Movie and TV is set.
+type FindResult struct {
+	Movie *MovieResult
+	TV    *TVResult
+}
+
+// FindByIMDbID looks up the movie or TV show with an IMDb ID ("tt0084787").
+// It returns nil if TMDB doesn't know the ID.
+func (c *Client) FindByIMDbID(imdbID string) (*FindResult, error) {
+	return c.FindByIMDbIDContext(context.Background(), imdbID)
+}
+
+// FindByIMDbIDContext is FindByIMDbID with a context for cancellation
+func (c *Client) FindByIMDbIDContext(ctx context.Context, imdbID string) (*FindResult, error) {
+	if !c.IsConfigured() {
+		return nil, fmt.Errorf("TMDB API key not configured")
+	}
+
+	var result struct {
+		MovieResults []MovieResult `json:"movie_results"`
+		TVResults    []TVResult    `json:"tv_results"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/find/%s?api_key=%s&external_source=imdb_id", baseURL, url.PathEscape(imdbID), c.apiKey), &result); err != nil {
 		return nil, err
 	}
 
-	return &details, nil
+	switch {
+	case len(result.MovieResults) > 0:
+		return &FindResult{Movie: &result.MovieResults[0]}, nil
+	case len(result.TVResults) > 0:
+		return &FindResult{TV: &result.TVResults[0]}, nil
+	}
+	return nil, nil
 }
 
-// GetTVEpisodeDetails fetches detailed episode info by TMDB show ID, season and episode number
-func (c *Client) GetTVEpisodeDetails(showID int, seasonNum int, episodeNum int) (*EpisodeDetails, error) {
+// Episode group types. Groups list a show's episodes in an alternate order,
+// such as the order of its DVD release.
+const (
+	EpisodeGroupOriginalAirDate = 1
+	EpisodeGroupAbsolute        = 2
+	EpisodeGroupDVD             = 3
+	EpisodeGroupDigital         = 4
+	EpisodeGroupStoryArc        = 5
+	EpisodeGroupProduction      = 6
+	EpisodeGroupTV              = 7
+)
+
+// EpisodeGroupSummary describes one of a show's episode groups
+type EpisodeGroupSummary struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Type         int    `json:"type"`
+	EpisodeCount int    `json:"episode_count"`
+	GroupCount   int    `json:"group_count"`
+}
+
+// EpisodeGroup is a show's episodes in an alternate order, split into
+// groups that take the place of seasons
+type EpisodeGroup struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Type        int                 `json:"type"`
+	Groups      []EpisodeGroupEntry `json:"groups"`
+}
+
+// EpisodeGroupEntry is one group of an episode group, e.g. a DVD volume
+type EpisodeGroupEntry struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Order    int                   `json:"order"`
+	Episodes []EpisodeGroupEpisode `json:"episodes"`
+}
+
+// EpisodeGroupEpisode is an episode within a group. It keeps its aired
+// season and episode numbers; Order is its 0-based position in the group.
+type EpisodeGroupEpisode struct {
+	EpisodeDetails
+	Order int `json:"order"`
+}
+
+// Group returns the group standing in for season number season, or nil
+func (g *EpisodeGroup) Group(season int) *EpisodeGroupEntry {
+	for i := range g.Groups {
+		if g.Groups[i].Order == season {
+			return &g.Groups[i]
+		}
+	}
+	return nil
+}
+
+// Episode returns the episode numbered season/episode in this ordering, or
+// nil. Absolute orderings number episodes across all groups, so the season
+// is ignored for them.
+func (g *EpisodeGroup) Episode(season, episode int) *EpisodeDetails {
+	if g.Type == EpisodeGroupAbsolute {
+		groups := make([]EpisodeGroupEntry, len(g.Groups))
+		copy(groups, g.Groups)
+		sort.SliceStable(groups, func(i, j int) bool { return groups[i].Order < groups[j].Order })
+
+		for _, group := range groups {
+			if details := group.episode(episode); details != nil {
+				return details
+			}
+			episode -= len(group.Episodes)
+		}
+		return nil
+	}
+
+	if group := g.Group(season); group != nil {
+		return group.episode(episode)
+	}
+	return nil
+}
+
+// episode returns the group's 1-based nth episode
+func (e *EpisodeGroupEntry) episode(n int) *EpisodeDetails {
+	for i := range e.Episodes {
+		if e.Episodes[i].Order == n-1 {
+			return &e.Episodes[i].EpisodeDetails
+		}
+	}
+	return nil
+}
+
+// GetEpisodeGroups lists the alternate episode orderings of a show
+func (c *Client) GetEpisodeGroups(showID int) ([]EpisodeGroupSummary, error) {
+	return c.GetEpisodeGroupsContext(context.Background(), showID)
+}
+
+// GetEpisodeGroupsContext is GetEpisodeGroups with a context for cancellation
+func (c *Client) GetEpisodeGroupsContext(ctx context.Context, showID int) ([]EpisodeGroupSummary, error) {
 	if !c.IsConfigured() {
 		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	resp, err := c.httpClient.Get(fmt.Sprintf("%s/tv/%d/season/%d/episode/%d?api_key=%s", baseURL, showID, seasonNum, episodeNum, c.apiKey))
-	if err != nil {
+	var result struct {
+		Results []EpisodeGroupSummary `json:"results"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/tv/%d/episode_groups?api_key=%s", baseURL, showID, c.apiKey), &result); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("TMDB API error: %d", resp.StatusCode)
+	return result.Results, nil
+}
+
+// GetEpisodeGroup fetches an episode group with all of its episodes
+func (c *Client) GetEpisodeGroup(groupID string) (*EpisodeGroup, error) {
+	return c.GetEpisodeGroupContext(context.Background(), groupID)
+}
+
+// GetEpisodeGroupContext is GetEpisodeGroup with a context for cancellation
+func (c *Client) GetEpisodeGroupContext(ctx context.Context, groupID string) (*EpisodeGroup, error) {
+	if !c.IsConfigured() {
+		return nil, fmt.Errorf("TMDB API key not configured")
 	}
 
-	var details EpisodeDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+	var group EpisodeGroup
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/tv/episode_group/%s?api_key=%s", baseURL, url.PathEscape(groupID), c.apiKey), &group); err != nil {
 		return nil, err
 	}
 
-	return &details, nil
+	return &group, nil
 }
 
 // FindBestMovieMatch scores and ranks search results to find the best match
@@ -0,0 +1,156 @@
+package tmdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageBaseURL serves TMDB artwork; the path segment after it picks the size
+const ImageBaseURL = "https://image.tmdb.org/t/p/"
+
+// ImageSizes are the TMDB image widths that can be fetched
+var ImageSizes = map[string]bool{
+	"w92": true, "w154": true, "w185": true, "w300": true, "w342": true,
+	"w500": true, "w780": true, "w1280": true, "original": true,
+}
+
+// Sizes the scanner stores each kind of artwork at
+const (
+	PosterSize   = "w500"
+	BackdropSize = "w1280"
+	StillSize    = "w300"
+)
+
+// LocalImagePrefix starts the URLs the server serves cached images at,
+// /api/images/<size>/<file>
+const LocalImagePrefix = "/api/images/"
+
+// LocalImageURL returns the URL the server serves its copy of a TMDB image at
+func LocalImageURL(size, remotePath string) string {
+	return LocalImagePrefix + size + "/" + strings.TrimPrefix(remotePath, "/")
+}
+
+// RemoteImagePath returns the TMDB path of an image stored either as a TMDB
+// path ("/abc.jpg") or as a local URL from LocalImageURL
+func RemoteImagePath(path string) string {
+	if !strings.HasPrefix(path, LocalImagePrefix) {
+		return path
+	}
+	return "/" + filepath.Base(path)
+}
+
+// ImageStore keeps local copies of TMDB images under dir/<size>/, named like
+// their TMDB path, so artwork keeps working when TMDB is unreachable. Each
+// image is downloaded once, however many items share it.
+type ImageStore struct {
+	dir        string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // closed when the download of a path finishes
+}
+
+// NewImageStore creates an image store under dir
+func NewImageStore(dir string) *ImageStore {
+	return &ImageStore{
+		dir:        dir,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		inflight:   make(map[string]chan struct{}),
+	}
+}
+
+// Path returns where an image is stored locally. It returns false for
+// unknown sizes and for paths that aren't a TMDB image path ("/abc.jpg").
+func (s *ImageStore) Path(size, remotePath string) (string, bool) {
+	name := strings.TrimPrefix(remotePath, "/")
+	if !ImageSizes[size] || name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	return filepath.Join(s.dir, size, name), true
+}
+
+// Fetch returns the local copy of an image, downloading it first if it
+// isn't stored yet. Concurrent fetches of one image share a download.
+func (s *ImageStore) Fetch(ctx context.Context, size, remotePath string) (string, error) {
+	path, ok := s.Path(size, remotePath)
+	if !ok {
+		return "", fmt.Errorf("invalid TMDB image %s at size %s", remotePath, size)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	s.mu.Lock()
+	if done, ok := s.inflight[path]; ok {
+		s.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("download of %s failed", remotePath)
+		}
+		return path, nil
+	}
+	done := make(chan struct{})
+	s.inflight[path] = done
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.inflight, path)
+		s.mu.Unlock()
+		close(done)
+	}()
+
+	if err := s.download(ctx, ImageBaseURL+size+"/"+filepath.Base(path), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// download writes an image to path, through a temporary file so a failed
+// download never leaves a partial image behind
+func (s *ImageStore) download(ctx context.Context, url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TMDB image error: %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("TMDB image has content type %q", contentType)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.ReadFrom(resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,158 @@
+package tmdb
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Retried requests back off exponentially from retryBaseDelay, up to
+// retryMaxDelay, unless TMDB says how long to wait with Retry-After
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// rateLimiter is a token bucket shared by all of a client's requests. A nil
+// limiter doesn't limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// Set when TMDB answers 429, so every request waits out the
+	// Retry-After, not just the one that got it
+	pausedUntil time.Time
+}
+
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	burst := max(requestsPerSecond, 1)
+	return &rateLimiter{rate: requestsPerSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a request may be sent or ctx is done. Tokens are
+// reserved before sleeping (the bucket can go negative), so waiters go in
+// arrival order.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	if paused := l.pausedUntil.Sub(now); paused > delay {
+		delay = paused
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, delay)
+}
+
+// pause holds back all requests for d
+func (l *rateLimiter) pause(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.mu.Unlock()
+}
+
+// get sends a rate limited GET request, retrying with backoff when TMDB
+// answers 429 or a 5xx error. The last response is returned if retries run
+// out.
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !retryable(resp.StatusCode) || attempt >= c.maxRetries {
+			return resp, nil
+		}
+
+		delay := retryDelay(resp, attempt)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			c.limiter.pause(delay)
+		}
+		log.Printf("TMDB returned %d, retrying in %s", resp.StatusCode, delay.Round(time.Millisecond))
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for d, returning early with ctx's error if it's done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable reports whether a request that got status is worth retrying
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryDelay is how long to wait before retrying a request: TMDB's
+// Retry-After if it sent one, else exponential backoff with jitter so
+// concurrent requests don't retry in lockstep
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return capDelay(time.Duration(seconds) * time.Second)
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return capDelay(max(time.Until(at), 0))
+		}
+	}
+
+	delay := retryMaxDelay
+	if attempt < 8 {
+		delay = capDelay(retryBaseDelay << attempt)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// capDelay limits a retry delay to retryMaxDelay
+func capDelay(d time.Duration) time.Duration {
+	if d > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return d
+}